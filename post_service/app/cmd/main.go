@@ -10,9 +10,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jackc/pgx"
 	"github.com/juicyluv/sueta/post_service/app/config"
+	"github.com/juicyluv/sueta/post_service/app/internal/post"
+	"github.com/juicyluv/sueta/post_service/app/internal/post/db"
 	"github.com/juicyluv/sueta/post_service/app/internal/server"
 	"github.com/juicyluv/sueta/post_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -27,6 +31,10 @@ var (
 // @host localhost:8080
 // @BasePath /api
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
 func main() {
 	flag.Parse()
 	logger.Init()
@@ -42,6 +50,31 @@ func main() {
 
 	logger.Info("connecting to database")
 
+	connConfig, err := pgx.ParseConnectionString(cfg.DB_URL)
+	if err != nil {
+		logger.Fatalf("invalid postgres url: %v", err)
+	}
+
+	conn, err := pgx.Connect(connConfig)
+	if err != nil {
+		logger.Fatalf("cannot connect to postgres: %v", err)
+	}
+	logger.Info("connected to database")
+
+	if err := db.Migrate(context.Background(), conn); err != nil {
+		logger.Fatalf("cannot migrate postgres: %v", err)
+	}
+
+	postStorage := db.NewStorage(conn)
+	postService := post.NewService(postStorage, logger)
+
+	// API keys are stored by user service, so only access tokens are accepted here.
+	authorizer := auth.NewMiddleware(cfg.Auth.AccessSecret, nil)
+
+	postHandler := post.NewHandler(logger, postService, authorizer)
+	postHandler.Register(router)
+	logger.Info("initialized post routes")
+
 	logger.Info("starting the server")
 	srv := server.NewServer(cfg, router, &logger)
 
@@ -61,8 +94,10 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
-		// TODO: disconnect to postgres
-		logger.Info("closed mongo database connection")
+		if err := conn.Close(); err != nil {
+			logger.Errorf("failed closing postgres: %v", err)
+		}
+		logger.Info("closed postgres database connection")
 		cancel()
 	}()
 
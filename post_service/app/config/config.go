@@ -19,6 +19,11 @@ type Config struct {
 	} `yaml:"http" env-required:"true"`
 	// DB represents configuration for database.
 	DB_URL string `env:"POSTGRES_URL" env-required:"true"`
+	// Auth represents configuration for verifying access tokens
+	// issued by user service.
+	Auth struct {
+		AccessSecret string `env:"JWT_ACCESS_SECRET" env-required:"true"`
+	}
 }
 
 var instance *Config
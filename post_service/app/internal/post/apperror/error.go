@@ -17,6 +17,10 @@ var (
 	// ErrNoRows is used when no rows returned from storage.
 	ErrNoRows = errors.New("no rows")
 
+	// ErrVersionConflict is used when the post has been changed since
+	// the requested version, so the change would overwrite another one.
+	ErrVersionConflict = errors.New("post has been changed by another request, please fetch it again")
+
 	// ErrValidationFailed is used when input validation failed.
 	ErrValidationFailed = errors.New("input validation failed. please, provide valid values")
 )
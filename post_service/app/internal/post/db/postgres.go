@@ -2,22 +2,32 @@ package db
 
 import (
 	"context"
+	_ "embed"
+	"errors"
+	"fmt"
 
 	"github.com/jackc/pgx"
 	"github.com/juicyluv/sueta/post_service/app/internal/post"
+	"github.com/juicyluv/sueta/post_service/app/internal/post/apperror"
 	"github.com/juicyluv/sueta/post_service/app/pkg/logger"
 )
 
-// Check whether db implements user storage interface.
+// schema creates posts and comments tables if they don't exist.
+//
+//go:embed postgres.sql
+var schema string
+
+// Check whether db implements post storage interface.
 var _ post.Storage = &db{}
 
-// db implementes user storage interface.
+// db implementes post storage interface.
 type db struct {
 	logger     logger.Logger
 	connection *pgx.Conn
 }
 
-// New Storage returns a new user storage instance.
+// New Storage returns a new post storage instance.
+// Call Migrate before using it.
 func NewStorage(storage *pgx.Conn) post.Storage {
 	return &db{
 		logger:     logger.GetLogger(),
@@ -25,67 +35,213 @@ func NewStorage(storage *pgx.Conn) post.Storage {
 	}
 }
 
+// Migrate creates posts and comments tables if they don't exist.
+func Migrate(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.ExecEx(ctx, schema, nil); err != nil {
+		return fmt.Errorf("cannot migrate posts schema: %w", err)
+	}
+	return nil
+}
+
+const (
+	postColumns    = `id, title, content, user_id, created_at, updated_at, version`
+	commentColumns = `id, content, user_id, verified, created_at, updated_at`
+)
+
+// scanner is implemented by pgx.Row and pgx.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
 // Create inserts a new row in the database.
-// It uses InsertOne behind the scenes.
-// Returns an error on failure or inserted user uuid on success.
+// Returns an error on failure or inserted post uuid on success.
 func (d *db) Create(ctx context.Context, post *post.Post) (string, error) {
-	// result, err := d.collection.InsertOne(ctx, user)
-	// if err != nil {
-	// 	e := fmt.Errorf("cannot insert user in database: %w", err)
-	// 	d.logger.Warn(e)
-	// 	return "", e
-	// }
-
-	return "", nil
+	query := `
+		INSERT INTO posts (title, content, user_id, created_at, updated_at, version)
+		VALUES ($1, $2, $3, $4, $5, 0)
+		RETURNING id`
+
+	var id string
+	err := d.connection.QueryRowEx(ctx, query, nil,
+		post.Title,
+		post.Content,
+		post.UserUUID,
+		post.CreatedAt,
+		post.UpdatedAt,
+	).Scan(&id)
+	if err != nil {
+		e := fmt.Errorf("cannot insert post in database: %w", err)
+		d.logger.Warn(e)
+		return "", e
+	}
+
+	return id, nil
 }
 
-// FindById finds the user by given uuid.
-// Returns user instance on success, but on failure
-// returns an error or No Rows Error if there's no user with given uuid.
+// FindById finds the post by given uuid with its comments.
+// Returns post instance on success, but on failure
+// returns an error or No Rows Error if there's no post with given uuid.
 func (d *db) FindById(ctx context.Context, uuid string) (*post.Post, error) {
-	// var post *post.Post
+	query := `SELECT ` + postColumns + ` FROM posts WHERE id = $1`
 
-	// ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	// defer cancel()
+	p, err := scanPost(d.connection.QueryRowEx(ctx, query, nil, uuid))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
 
-	// result := d.collection.FindOne(ctx, filter)
-	// if result.Err() != nil {
-	// 	if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-	// 		return nil, apperror.ErrNoRows
-	// 	}
-	// 	d.logger.Error(result.Err())
-	// 	return nil, fmt.Errorf("failed to execute query: %w", err)
-	// }
+	p.Comments, err = d.listComments(ctx, `SELECT `+commentColumns+` FROM comments WHERE post_id = $1 ORDER BY created_at`, uuid)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, nil
+	return p, nil
 }
 
-// UpdatePartially updates the user with new provided values.
-// Returns an error if something went wrong or No Rows error if
-// there's no user with given uuid.
+// UpdatePartially updates the post with new provided values
+// if its version hasn't changed and increments the version.
+// Returns an error if something went wrong, No Rows error if
+// there's no post with given uuid or Version Conflict error if
+// the version has changed.
 func (d *db) UpdatePartially(ctx context.Context, post *post.Post) error {
-	// result, err := d.collection.UpdateOne(ctx, filter, query)
-	// if err != nil {
-	// 	if errors.Is(err, mongo.ErrNoDocuments) {
-	// 		return apperror.ErrNoRows
-	// 	}
-	// 	d.logger.Warn("failed to execute query: %w", err)
-	// 	return err
-	// }
+	query := `
+		UPDATE posts SET title = $2, content = $3, user_id = $4, updated_at = $5, version = version + 1
+		WHERE id = $1 AND version = $6`
+
+	tag, err := d.connection.ExecEx(ctx, query, nil,
+		post.UUID,
+		post.Title,
+		post.Content,
+		post.UserUUID,
+		post.UpdatedAt,
+		post.Version,
+	)
+	if err != nil {
+		d.logger.Warnf("failed to execute query: %v", err)
+		return fmt.Errorf("cannot update post: %w", err)
+	}
 
+	if tag.RowsAffected() == 0 {
+		return d.conflictOrNoRows(ctx, post.UUID)
+	}
+
+	post.Version++
 	return nil
 }
 
-// Delete deletes the user row with given uuid. Returns an error on failure.
-// Returns ErrNoRows if there's no such user with given uuid.
-func (d *db) Delete(ctx context.Context, uuid string) error {
-	// result := d.collection.FindOneAndDelete(ctx, filter)
-	// if err := result.Err(); err != nil {
-	// 	if errors.Is(err, mongo.ErrNoDocuments) {
-	// 		return apperror.ErrNoRows
-	// 	}
-	// 	return fmt.Errorf("cannot delete user: %v", err)
-	// }
+// Delete deletes the post row with given uuid. Returns an error on failure.
+// Returns ErrNoRows if there's no such post with given uuid and
+// ErrVersionConflict if version is given and it has changed.
+func (d *db) Delete(ctx context.Context, uuid string, version *int64) error {
+	query := `DELETE FROM posts WHERE id = $1 AND ($2::bigint IS NULL OR version = $2)`
+
+	tag, err := d.connection.ExecEx(ctx, query, nil, uuid, version)
+	if err != nil {
+		return fmt.Errorf("cannot delete post: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return d.conflictOrNoRows(ctx, uuid)
+	}
 
 	return nil
 }
+
+// ListByUser finds posts written by the user with given uuid, oldest first.
+// Comments of the posts are not loaded. Returns an error on failure.
+func (d *db) ListByUser(ctx context.Context, userUUID string) ([]post.Post, error) {
+	query := `SELECT ` + postColumns + ` FROM posts WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := d.connection.QueryEx(ctx, query, nil, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []post.Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode post: %w", err)
+		}
+		posts = append(posts, *p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read posts: %w", err)
+	}
+
+	return posts, nil
+}
+
+// ListCommentsByUser finds comments written by the user with given uuid,
+// oldest first. Returns an error on failure.
+func (d *db) ListCommentsByUser(ctx context.Context, userUUID string) ([]post.Comment, error) {
+	return d.listComments(ctx, `SELECT `+commentColumns+` FROM comments WHERE user_id = $1 ORDER BY created_at`, userUUID)
+}
+
+// listComments returns comments selected by given query.
+func (d *db) listComments(ctx context.Context, query string, args ...interface{}) ([]post.Comment, error) {
+	rows, err := d.connection.QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []post.Comment
+	for rows.Next() {
+		var c post.Comment
+		if err := rows.Scan(&c.UUID, &c.Content, &c.UserUUID, &c.Verified, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("cannot decode comment: %w", err)
+		}
+		c.CreatedAt = c.CreatedAt.UTC()
+		c.UpdatedAt = c.UpdatedAt.UTC()
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read comments: %w", err)
+	}
+
+	return comments, nil
+}
+
+// conflictOrNoRows tells why a conditional change has matched nothing.
+// Returns Version Conflict error if the post exists and No Rows error
+// if it doesn't.
+func (d *db) conflictOrNoRows(ctx context.Context, uuid string) error {
+	var exists bool
+	err := d.connection.QueryRowEx(ctx, `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1)`, nil, uuid).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("cannot check post existence: %v", err)
+	}
+
+	if exists {
+		return apperror.ErrVersionConflict
+	}
+	return apperror.ErrNoRows
+}
+
+// scanPost decodes a row selected with postColumns.
+func scanPost(row scanner) (*post.Post, error) {
+	var p post.Post
+	err := row.Scan(
+		&p.UUID,
+		&p.Title,
+		&p.Content,
+		&p.UserUUID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p.CreatedAt = p.CreatedAt.UTC()
+	p.UpdatedAt = p.UpdatedAt.UTC()
+
+	return &p, nil
+}
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS posts (
+    id         TEXT PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    title      TEXT NOT NULL,
+    content    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    version    BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS posts_user_id_idx ON posts (user_id);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id         TEXT PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    post_id    TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    content    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    verified   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id);
+CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments (user_id);
@@ -11,42 +11,79 @@ import (
 	"github.com/juicyluv/sueta/post_service/app/internal/handler"
 	"github.com/juicyluv/sueta/post_service/app/internal/post/apperror"
 	"github.com/juicyluv/sueta/post_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/etag"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
 	"github.com/julienschmidt/httprouter"
 )
 
 const (
-	postURL  = "/api/post"
-	postsURL = "/api/posts/:uuid"
+	postsURL = "/api/posts"
+	postURL  = "/api/posts/:uuid"
+	// contentURL is requested by user service on personal data export.
+	contentURL = "/api/users/:uuid/content"
 )
 
 type Handler struct {
 	logger      logger.Logger
 	postService Service
+	authorizer  *auth.Middleware
 }
 
-func NewHandler(logger logger.Logger, postService Service) handler.Handling {
+func NewHandler(logger logger.Logger, postService Service, authorizer *auth.Middleware) handler.Handling {
 	return &Handler{
 		logger:      logger,
 		postService: postService,
+		authorizer:  authorizer,
 	}
 }
 
 // Register registers new routes for router.
+// Posts can be modified by their authors, admins are allowed
+// to modify any post and moderators are allowed to delete them.
+// Content of the user is available only with content export token
+// issued by user service for the user.
 func (h *Handler) Register(router *httprouter.Router) {
+	authenticated := auth.Rule{}
+	contentExport := auth.Rule{Owner: auth.OwnerParam("uuid"), Token: token.ContentExport}
+	authorOrAdmin := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}, Owner: h.postAuthor}
+	authorOrStaff := auth.Rule{Roles: []auth.Role{auth.RoleAdmin, auth.RoleModerator}, Owner: h.postAuthor}
+
 	router.HandlerFunc(http.MethodGet, postURL, h.GetPost)
-	router.HandlerFunc(http.MethodPost, postsURL, h.CreatePost)
-	router.HandlerFunc(http.MethodPatch, postURL, h.UpdatePostPartially)
-	router.HandlerFunc(http.MethodDelete, postURL, h.DeletePost)
+	router.HandlerFunc(http.MethodPost, postsURL, h.authorizer.Authorize(authenticated, h.CreatePost))
+	router.HandlerFunc(http.MethodPatch, postURL, h.authorizer.Authorize(authorOrAdmin, h.UpdatePostPartially))
+	router.HandlerFunc(http.MethodDelete, postURL, h.authorizer.Authorize(authorOrStaff, h.DeletePost))
+	router.HandlerFunc(http.MethodGet, contentURL, h.authorizer.Authorize(contentExport, h.GetUserContent))
+}
+
+// postAuthor returns uuid of the user who wrote requested post.
+func (h *Handler) postAuthor(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	post, err := h.postService.GetById(r.Context(), params.ByName("uuid"))
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return "", auth.ErrNoOwner
+		}
+		return "", err
+	}
+
+	if post == nil {
+		return "", auth.ErrNoOwner
+	}
+
+	return post.UserUUID, nil
 }
 
 // GetPost godoc
 // @Summary Show post information
-// @Description Get post by uuid.
+// @Description Get post by uuid. ETag header holds version of the post, send it in If-Match header to update or delete the post only if it hasn't been changed since.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param uuid path string true "Post id"
 // @Success 200 {object} Post
+// @Header 200 {string} ETag "Version of the post"
 // @Failure 404 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
 // @Router /posts/{uuid} [get]
@@ -67,6 +104,12 @@ func (h *Handler) GetPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if post == nil {
+		h.NotFound(w)
+		return
+	}
+
+	etag.Set(w, post.Version)
 	h.JSON(w, http.StatusOK, post)
 }
 
@@ -80,6 +123,7 @@ func (h *Handler) GetPost(w http.ResponseWriter, r *http.Request) {
 // @Success 201 {object} internal.CreatePostResponse
 // @Failure 400 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
 // @Router /posts [post]
 func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("CREATE POST")
@@ -90,6 +134,11 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Post is always written on behalf of authenticated user.
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		input.UserUUID = claims.Subject
+	}
+
 	if err := input.Validate(); err != nil {
 		h.BadRequest(w, err.Error(), apperror.ErrValidationFailed.Error())
 		return
@@ -106,16 +155,19 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 
 // UpdatePostPartially godoc
 // @Summary Update post
-// @Description Partially update the post with provided current password.
+// @Description Partially update the post with provided current password. With If-Match header the post is updated only if its version is the given ETag. The post is never updated if it has been changed concurrently.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param uuid path string true "Post id"
+// @Param If-Match header string false "ETag of the post"
 // @Param input body post.UpdatePostDTO true "JSON input"
 // @Success 200
 // @Failure 400 {object} apperror.AppError
 // @Failure 404 {object} apperror.AppError
+// @Failure 412 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
 // @Router /posts/{uuid} [patch]
 func (h *Handler) UpdatePostPartially(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("UPDATE POST PARTIALLY")
@@ -129,18 +181,35 @@ func (h *Handler) UpdatePostPartially(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only admins are allowed to change the author of the post.
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && input.UserUUID != nil {
+		if !auth.HasRole(claims, auth.RoleAdmin) {
+			h.Error(w, http.StatusForbidden, "access denied", "only admins are allowed to change the author")
+			return
+		}
+	}
+
 	if err := input.Validate(); err != nil {
 		h.BadRequest(w, err.Error(), apperror.ErrValidationFailed.Error())
 		return
 	}
 
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		h.BadRequest(w, err.Error(), "please, send ETag of the post")
+		return
+	}
+
 	input.UUID = uuid
+	input.Version = version
 
-	err := h.postService.UpdatePartially(r.Context(), &input)
+	err = h.postService.UpdatePartially(r.Context(), &input)
 	if err != nil {
 		switch err {
 		case apperror.ErrNoRows:
 			h.NotFound(w)
+		case apperror.ErrVersionConflict:
+			h.Error(w, http.StatusPreconditionFailed, err.Error(), "")
 		default:
 			h.InternalError(w, err.Error(), "")
 		}
@@ -152,14 +221,18 @@ func (h *Handler) UpdatePostPartially(w http.ResponseWriter, r *http.Request) {
 
 // DeletePost godoc
 // @Summary Delete post
-// @Description Delete the post by uuid.
+// @Description Delete the post by uuid. With If-Match header the post is deleted only if its version is the given ETag.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param uuid path string true "Post id"
+// @Param If-Match header string false "ETag of the post"
 // @Success 200
+// @Failure 400 {object} apperror.AppError
 // @Failure 404 {object} apperror.AppError
+// @Failure 412 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
 // @Router /posts/{uuid} [delete]
 func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("DELETE POST")
@@ -167,12 +240,22 @@ func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 	uuid := params.ByName("uuid")
 
-	err := h.postService.Delete(r.Context(), uuid)
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		h.BadRequest(w, err.Error(), "please, send ETag of the post")
+		return
+	}
+
+	err = h.postService.Delete(r.Context(), uuid, version)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNoRows) {
 			h.NotFound(w)
 			return
 		}
+		if errors.Is(err, apperror.ErrVersionConflict) {
+			h.Error(w, http.StatusPreconditionFailed, err.Error(), "")
+			return
+		}
 		h.InternalError(w, err.Error(), "something went wrong on the server side")
 		return
 	}
@@ -180,6 +263,33 @@ func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetUserContent godoc
+// @Summary Show content of the user
+// @Description Get posts and comments written by the user. Used by user service to export personal data, so only content export token of the user is accepted.
+// @Tags posts
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200 {object} UserContent
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/content [get]
+func (h *Handler) GetUserContent(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("GET USER CONTENT")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	content, err := h.postService.GetUserContent(r.Context(), uuid)
+	if err != nil {
+		h.InternalError(w, err.Error(), "")
+		return
+	}
+
+	h.JSON(w, http.StatusOK, content)
+}
+
 // JSON encodes to JSON format given data and sends a response
 // to the client with a given http code and encoded data.
 func (h *Handler) JSON(w http.ResponseWriter, code int, data interface{}) {
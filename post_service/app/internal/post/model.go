@@ -1,6 +1,8 @@
 package post
 
 import (
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 )
@@ -10,9 +12,11 @@ type Post struct {
 	Title     string    `json:"title"`
 	Content   string    `json:"content"`
 	UserUUID  string    `json:"userId"`
-	CreatedAt string    `json:"createdAt"`
-	UpdatedAt string    `json:"updatedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 	Comments  []Comment `json:"comments"`
+	// Version is incremented on every change of the post.
+	Version int64 `json:"version"`
 }
 
 type CreatePostDTO struct {
@@ -47,6 +51,7 @@ func (p *CreatePostDTO) Validate() error {
 
 type UpdatePostDTO struct {
 	UUID     string  `json:"id"`
+	Version  *int64  `json:"-"`
 	Title    *string `json:"title"`
 	Content  *string `json:"content"`
 	UserUUID *string `json:"userId"`
@@ -71,10 +76,17 @@ func (p *UpdatePostDTO) Validate() error {
 }
 
 type Comment struct {
-	UUID      string `json:"id"`
-	Content   string `json:"content"`
-	UserUUID  string `json:"userId"`
-	Verified  bool   `json:"verified"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
+	UUID      string    `json:"id"`
+	Content   string    `json:"content"`
+	UserUUID  string    `json:"userId"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UserContent is everything written by the user. It's requested
+// by user service to export personal data of the user.
+type UserContent struct {
+	Posts    []Post    `json:"posts"`
+	Comments []Comment `json:"comments"`
 }
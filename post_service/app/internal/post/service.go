@@ -15,7 +15,8 @@ type Service interface {
 	Create(ctx context.Context, post *CreatePostDTO) (string, error)
 	GetById(ctx context.Context, uuid string) (*Post, error)
 	UpdatePartially(ctx context.Context, user *UpdatePostDTO) error
-	Delete(ctx context.Context, uuid string) error
+	Delete(ctx context.Context, uuid string, version *int64) error
+	GetUserContent(ctx context.Context, userUUID string) (*UserContent, error)
 }
 
 type service struct {
@@ -36,12 +37,13 @@ func NewService(storage Storage, logger logger.Logger) Service {
 // and try to insert the user. Returns inserted UUID or an error
 // on failure.
 func (s *service) Create(ctx context.Context, input *CreatePostDTO) (string, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
 	post := &Post{
 		Title:     input.Title,
 		Content:   input.Content,
 		UserUUID:  input.UserUUID,
-		CreatedAt: time.Now().UTC().Format("2006/01/02"),
-		UpdatedAt: time.Now().UTC().Format("2006/01/02"),
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
 	id, err := s.storage.Create(ctx, post)
@@ -73,7 +75,9 @@ func (s *service) GetById(ctx context.Context, uuid string) (*Post, error) {
 // If there is no user with such id, returns No Rows error.
 // Then passwords will be compared. If it don't match, returns
 // Wrong Password error. Then updates the user. If something went wrong,
-// returns an error and nil if everything is OK.
+// returns an error and nil if everything is OK. If version is given and
+// it isn't the current one, or the post has been changed since it was read,
+// returns Version Conflict error.
 func (s *service) UpdatePartially(ctx context.Context, post *UpdatePostDTO) error {
 	p, err := s.GetById(ctx, post.UUID)
 	if err != nil {
@@ -83,6 +87,10 @@ func (s *service) UpdatePartially(ctx context.Context, post *UpdatePostDTO) erro
 		return err
 	}
 
+	if post.Version != nil && *post.Version != p.Version {
+		return apperror.ErrVersionConflict
+	}
+
 	if post.Title != nil {
 		p.Title = *post.Title
 	}
@@ -95,21 +103,27 @@ func (s *service) UpdatePartially(ctx context.Context, post *UpdatePostDTO) erro
 		p.UserUUID = *post.UserUUID
 	}
 
+	p.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
 	err = s.storage.UpdatePartially(ctx, p)
 	if err != nil {
-		s.logger.Warnf("failed to update the post: %v", err)
+		if !errors.Is(err, apperror.ErrVersionConflict) {
+			s.logger.Warnf("failed to update the post: %v", err)
+		}
 		return err
 	}
 
 	return nil
 }
 
-// Delete tries to delete the user with provided uuid.
-// Returns an error on failure or nil if query has been executed.
-func (s *service) Delete(ctx context.Context, uuid string) error {
-	err := s.storage.Delete(ctx, uuid)
+// Delete tries to delete the user with provided uuid. If version is given,
+// the post is deleted only if it's the current one, Version Conflict error
+// is returned otherwise. Returns an error on failure or nil if query
+// has been executed.
+func (s *service) Delete(ctx context.Context, uuid string, version *int64) error {
+	err := s.storage.Delete(ctx, uuid, version)
 	if err != nil {
-		if !errors.Is(err, apperror.ErrNoRows) {
+		if !errors.Is(err, apperror.ErrNoRows) && !errors.Is(err, apperror.ErrVersionConflict) {
 			s.logger.Warnf("failed to delete the post: %v", err)
 		}
 		return err
@@ -117,3 +131,30 @@ func (s *service) Delete(ctx context.Context, uuid string) error {
 
 	return nil
 }
+
+// GetUserContent returns posts and comments written by the user
+// with given uuid. Lists are empty rather than nil if the user
+// hasn't written anything.
+func (s *service) GetUserContent(ctx context.Context, userUUID string) (*UserContent, error) {
+	posts, err := s.storage.ListByUser(ctx, userUUID)
+	if err != nil {
+		s.logger.Warnf("failed to list posts of the user: %v", err)
+		return nil, err
+	}
+
+	comments, err := s.storage.ListCommentsByUser(ctx, userUUID)
+	if err != nil {
+		s.logger.Warnf("failed to list comments of the user: %v", err)
+		return nil, err
+	}
+
+	content := &UserContent{Posts: posts, Comments: comments}
+	if content.Posts == nil {
+		content.Posts = []Post{}
+	}
+	if content.Comments == nil {
+		content.Comments = []Comment{}
+	}
+
+	return content, nil
+}
@@ -6,6 +6,16 @@ import "context"
 type Storage interface {
 	Create(ctx context.Context, post *Post) (string, error)
 	FindById(ctx context.Context, uuid string) (*Post, error)
+	// UpdatePartially updates the post only if the stored version is still
+	// the version of given post and increments it. Returns Version Conflict
+	// error otherwise.
 	UpdatePartially(ctx context.Context, post *Post) error
-	Delete(ctx context.Context, uuid string) error
+	// Delete deletes the post. If version isn't nil, the post is deleted
+	// only if it's the stored version, otherwise Version Conflict error
+	// is returned.
+	Delete(ctx context.Context, uuid string, version *int64) error
+	// ListByUser returns posts written by the user.
+	ListByUser(ctx context.Context, userUUID string) ([]Post, error)
+	// ListCommentsByUser returns comments written by the user to any post.
+	ListCommentsByUser(ctx context.Context, userUUID string) ([]Comment, error)
 }
@@ -10,14 +10,29 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx"
 	"github.com/juicyluv/sueta/user_service/app/config"
 	"github.com/juicyluv/sueta/user_service/app/internal"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditdb "github.com/juicyluv/sueta/user_service/app/internal/audit/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/export"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	oidcdb "github.com/juicyluv/sueta/user_service/app/internal/oidc/db"
 	"github.com/juicyluv/sueta/user_service/app/internal/server"
 	"github.com/juicyluv/sueta/user_service/app/internal/user"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/migrations"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
 	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
 	"github.com/julienschmidt/httprouter"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
@@ -31,6 +46,10 @@ var (
 // @host localhost:8080
 // @BasePath /api
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
 func main() {
 	flag.Parse()
 	logger.Init()
@@ -44,30 +63,249 @@ func main() {
 	router := httprouter.New()
 	logger.Info("initialized httprouter")
 
-	logger.Info("connecting to database")
+	connectCtx, connectCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer connectCancel()
+
+	var err error
+	var mailer mail.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer = mail.NewSMTPMailer(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	case "outbox":
+		mailer, err = mail.NewOutbox(cfg.Mail.OutboxDir)
+		if err != nil {
+			logger.Fatalf("cannot initialize mail outbox: %v", err)
+		}
+	default:
+		logger.Fatalf("unknown mail driver: %s", cfg.Mail.Driver)
+	}
+	logger.Infof("initialized %s mailer", cfg.Mail.Driver)
+
+	tokenManager := token.NewManager(cfg.Auth.Issuer, cfg.Auth.AccessSecret, cfg.Auth.RefreshSecret, map[token.Type]time.Duration{
+		token.Access:        time.Duration(cfg.Auth.AccessTokenTTL) * time.Minute,
+		token.Refresh:       time.Duration(cfg.Auth.RefreshTokenTTL) * time.Hour,
+		token.Verification:  time.Duration(cfg.Auth.VerificationTokenTTL) * time.Hour,
+		token.PasswordReset: time.Duration(cfg.Auth.PasswordResetTokenTTL) * time.Minute,
+		token.TwoFactor:     time.Duration(cfg.Auth.TwoFactorTokenTTL) * time.Minute,
+		token.EmailChange:   time.Duration(cfg.Auth.EmailChangeTokenTTL) * time.Hour,
+		// Content export token lives as long as the request to post service.
+		token.ContentExport: time.Minute,
+	})
 
-	mongoCtx, mongoCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer mongoCancel()
+	hasher, err := password.New(password.Params{
+		Algorithm:  cfg.PasswordHash.Algorithm,
+		BcryptCost: cfg.PasswordHash.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      cfg.PasswordHash.Argon2Memory,
+			Iterations:  cfg.PasswordHash.Argon2Iterations,
+			Parallelism: cfg.PasswordHash.Argon2Parallelism,
+		},
+	})
+	if err != nil {
+		logger.Fatalf("cannot initialize password hasher: %v", err)
+	}
+	logger.Infof("initialized %s password hasher", cfg.PasswordHash.Algorithm)
 
-	mongoClient, err := mongo.NewMongoClient(mongoCtx, cfg.DB.Database, cfg.DB.URL)
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordPolicy.MinLength, cfg.PasswordPolicy.MaxLength, cfg.PasswordPolicy.Blocklist)
 	if err != nil {
-		logger.Fatalf("cannot connect to mongodb: %v", err)
+		logger.Fatalf("cannot initialize password policy: %v", err)
+	}
+
+	var attemptStore lockout.Store
+	var redisClient *redis.Client
+	switch cfg.Lockout.Store {
+	case "redis":
+		opts, err := redis.ParseURL(cfg.Lockout.RedisDSN)
+		if err != nil {
+			logger.Fatalf("invalid redis dsn: %v", err)
+		}
+		redisClient = redis.NewClient(opts)
+		if err := redisClient.Ping(connectCtx).Err(); err != nil {
+			logger.Fatalf("cannot connect to redis: %v", err)
+		}
+		attemptStore = lockout.NewRedisStore(redisClient)
+	case "memory":
+		attemptStore = lockout.NewMemoryStore()
+	default:
+		logger.Fatalf("unknown lockout store: %s", cfg.Lockout.Store)
+	}
+	logger.Infof("initialized %s lockout store", cfg.Lockout.Store)
+
+	lockoutPolicy := lockout.Policy{
+		Window:      time.Duration(cfg.Lockout.Window) * time.Minute,
+		Threshold:   cfg.Lockout.Threshold,
+		BaseDelay:   time.Duration(cfg.Lockout.BaseDelay) * time.Second,
+		MaxAttempts: cfg.Lockout.MaxAttempts,
+		Lockout:     time.Duration(cfg.Lockout.Duration) * time.Minute,
+	}
+	emailLimiter := lockout.NewLimiter(attemptStore, "login:email:", lockoutPolicy)
+
+	lockoutPolicy.Threshold = cfg.Lockout.IPThreshold
+	lockoutPolicy.MaxAttempts = cfg.Lockout.IPMaxAttempts
+	ipLimiter := lockout.NewLimiter(attemptStore, "login:ip:", lockoutPolicy)
+
+	logger.Info("connecting to database")
+
+	var userStorage user.Storage
+	var tokenStorage user.TokenStorage
+	var sessionStorage user.SessionStorage
+	var apiKeyStorage user.APIKeyStorage
+	var oidcStorage oidc.Storage
+	var auditStorage audit.Storage
+	var mongoClient *mongodriver.Database
+	var postgresPool *pgx.ConnPool
+	switch cfg.Storage.Driver {
+	case "postgres":
+		postgresPool, err = postgres.NewPostgresClient(cfg.Postgres.URL, cfg.Postgres.MaxConnections)
+		if err != nil {
+			logger.Fatalf("cannot connect to postgres: %v", err)
+		}
+		logger.Info("connected to database")
+
+		// Report mode only logs schema drift without changing anything.
+		if cfg.Storage.IndexMode == db.IndexModeReport {
+			checks := []func(context.Context, *pgx.ConnPool) ([]string, error){
+				db.CheckPostgres, oidcdb.CheckPostgres, auditdb.CheckPostgres,
+			}
+			for _, check := range checks {
+				drift, err := check(connectCtx, postgresPool)
+				if err != nil {
+					logger.Fatalf("cannot check postgres schema: %v", err)
+				}
+				for _, d := range drift {
+					logger.Warnf("schema drift: %s", d)
+				}
+			}
+		} else {
+			schemas := []func(context.Context, *pgx.ConnPool) error{
+				db.MigratePostgres, oidcdb.MigratePostgres, auditdb.MigratePostgres,
+			}
+			for _, migrate := range schemas {
+				if err := migrate(connectCtx, postgresPool); err != nil {
+					logger.Fatalf("cannot migrate postgres schema: %v", err)
+				}
+			}
+		}
+		userStorage = db.NewPostgresStorage(postgresPool)
+		tokenStorage = db.NewPostgresTokenStorage(postgresPool)
+		sessionStorage = db.NewPostgresSessionStorage(postgresPool)
+		apiKeyStorage = db.NewPostgresAPIKeyStorage(postgresPool)
+		oidcStorage = oidcdb.NewPostgresStorage(postgresPool)
+		auditStorage = auditdb.NewPostgresStorage(postgresPool)
+	case "mongo":
+		mongoClient, err = mongo.NewMongoClient(connectCtx, cfg.DB.Database, cfg.DB.URL)
+		if err != nil {
+			logger.Fatalf("cannot connect to mongodb: %v", err)
+		}
+		logger.Info("connected to database")
+
+		// Nothing is changed before pending migrations are checked,
+		// indexes of users collection are created by migrations too.
+		migrator, err := migrations.New(mongoClient, cfg.DB.Collection, cfg.DB.MigrationCollection)
+		if err != nil {
+			logger.Fatalf("cannot initialize user migrations: %v", err)
+		}
+		pending, err := migrator.Pending(connectCtx)
+		if err != nil {
+			logger.Fatalf("cannot check user migrations: %v", err)
+		}
+		if pending > 0 {
+			if !cfg.Storage.AllowPendingMigrations {
+				logger.Fatalf("%d user migrations are pending, run usersctl migrate up", pending)
+			}
+			logger.Warnf("%d user migrations are pending", pending)
+		}
+		// Pending migrations may be allowed, but not without unique indexes.
+		if err := db.RequireIndexes(connectCtx, mongoClient, cfg.DB.Collection); err != nil {
+			logger.Fatalf("cannot use users collection: %v", err)
+		}
+		drift, err := db.CheckIndexes(connectCtx, mongoClient, cfg.DB.Collection)
+		if err != nil {
+			logger.Fatalf("cannot check user indexes: %v", err)
+		}
+		for _, d := range drift {
+			logger.Warnf("index drift: %s", d)
+		}
+		userStorage = db.NewStorage(mongoClient, cfg.DB.Collection)
+		tokenStorage = db.NewTokenStorage(mongoClient, cfg.DB.TokenCollection)
+		sessionStorage = db.NewSessionStorage(mongoClient, cfg.DB.SessionCollection)
+		apiKeyStorage = db.NewAPIKeyStorage(mongoClient, cfg.DB.APIKeyCollection)
+		oidcStorage = oidcdb.NewStorage(mongoClient, oidcdb.Collections{
+			Clients: cfg.DB.OIDCClients,
+			Codes:   cfg.DB.OIDCCodes,
+			Keys:    cfg.DB.OIDCKeys,
+		})
+
+		if cfg.Storage.IndexMode != db.IndexModeReport {
+			if err := auditdb.CreateIndexes(connectCtx, mongoClient, cfg.DB.AuditCollection); err != nil {
+				logger.Fatalf("cannot create audit indexes: %v", err)
+			}
+		}
+		auditStorage = auditdb.NewStorage(mongoClient, cfg.DB.AuditCollection)
+	default:
+		logger.Fatalf("unknown storage driver: %s", cfg.Storage.Driver)
 	}
-	logger.Info("connected to database")
+	logger.Infof("initialized %s user storage", cfg.Storage.Driver)
+
+	auditService := audit.NewService(auditStorage, logger)
+	userService := user.NewService(userStorage, tokenStorage, sessionStorage, apiKeyStorage, auditService, mailer, tokenManager, hasher, passwordPolicy, logger)
+
+	purgeCtx, purgeCancel := context.WithCancel(context.Background())
+	go user.RunPurge(purgeCtx, userService,
+		time.Duration(cfg.Purge.Retention)*24*time.Hour,
+		time.Duration(cfg.Purge.Interval)*time.Minute,
+		logger)
+	logger.Infof("purging users deleted more than %d days ago", cfg.Purge.Retention)
+
+	authService := user.NewAuthService(userService, tokenStorage, sessionStorage, apiKeyStorage, auditService, tokenManager, emailLimiter, ipLimiter, logger)
 
-	userStorage := db.NewStorage(mongoClient, cfg.DB.Collection)
-	userService := user.NewService(userStorage, logger)
+	authorizer := auth.NewMiddleware(cfg.Auth.AccessSecret, authService)
 
-	userHandler := user.NewHandler(logger, userService)
+	userHandler := user.NewHandler(logger, userService, authService, authorizer)
 	userHandler.Register(router)
 	logger.Info("initialized user routes")
 
+	oidcService := oidc.NewService(oidcStorage, userService, oidc.Params{
+		Issuer:      cfg.OIDC.Issuer,
+		CodeTTL:     time.Duration(cfg.OIDC.CodeTTL) * time.Second,
+		TokenTTL:    time.Duration(cfg.OIDC.TokenTTL) * time.Minute,
+		KeyRotation: time.Duration(cfg.OIDC.KeyRotation) * time.Hour,
+	}, logger)
+
+	rotationCtx, rotationCancel := context.WithCancel(context.Background())
+	go oidc.RunKeyRotation(rotationCtx, oidcService, time.Duration(cfg.OIDC.RotationInterval)*time.Minute, logger)
+
+	oidcHandler := oidc.NewHandler(logger, oidcService, authService, authorizer)
+	oidcHandler.Register(router)
+	logger.Info("initialized oidc routes")
+
+	auditHandler := audit.NewHandler(logger, auditService, authorizer)
+	auditHandler.Register(router)
+	logger.Info("initialized audit routes")
+
+	exportStore, err := export.NewStore(cfg.Export.Dir)
+	if err != nil {
+		logger.Fatalf("cannot initialize export store: %v", err)
+	}
+	postClient := export.NewPostClient(cfg.Export.PostServiceURL, tokenManager, time.Minute)
+	exportService := export.NewService(exportStore, userService, authService, auditService, postClient, export.Params{
+		TTL:     time.Duration(cfg.Export.TTL) * time.Hour,
+		Timeout: time.Duration(cfg.Export.Timeout) * time.Minute,
+	}, logger)
+
+	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
+	go export.RunCleanup(cleanupCtx, exportService, time.Duration(cfg.Export.CleanupInterval)*time.Minute, logger)
+
+	exportHandler := export.NewHandler(logger, exportService, authorizer)
+	exportHandler.Register(router)
+	logger.Info("initialized export routes")
+
 	logger.Info("initializing swagger documentation")
 	internal.InitSwagger(router)
 	logger.Info("initialized swagger documentation")
 
 	logger.Info("starting the server")
-	srv := server.NewServer(cfg, router, &logger)
+	srv := server.NewServer(cfg, audit.Middleware(router), &logger)
 
 	quit := make(chan os.Signal, 1)
 	signals := []os.Signal{syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM}
@@ -82,13 +320,28 @@ func main() {
 
 	<-quit
 	logger.Warn("shutting down the server")
+	purgeCancel()
+	rotationCancel()
+	cleanupCancel()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
-		if err := mongoClient.Client().Disconnect(ctx); err != nil {
-			logger.Errorf("failed closing mongo: %v", err)
+		if mongoClient != nil {
+			if err := mongoClient.Client().Disconnect(ctx); err != nil {
+				logger.Errorf("failed closing mongo: %v", err)
+			}
+			logger.Info("closed mongo database connection")
+		}
+		if postgresPool != nil {
+			postgresPool.Close()
+			logger.Info("closed postgres database connection")
+		}
+		if redisClient != nil {
+			if err := redisClient.Close(); err != nil {
+				logger.Errorf("failed closing redis: %v", err)
+			}
+			logger.Info("closed redis connection")
 		}
-		logger.Info("closed mongo database connection")
 		cancel()
 	}()
 
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+)
+
+// selector holds flags choosing the user a command works with.
+type selector struct {
+	id    *string
+	email *string
+}
+
+// newFlagSet returns flags of the command with -id and -email
+// flags selecting the user.
+func newFlagSet(name string) (*flag.FlagSet, *selector) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return fs, &selector{
+		id:    fs.String("id", "", "uuid of the user"),
+		email: fs.String("email", "", "email of the user"),
+	}
+}
+
+// find returns the selected user. Exactly one of -id
+// and -email must be set.
+func (s *selector) find(ctx context.Context, env *environment) (*user.User, error) {
+	switch {
+	case *s.id != "" && *s.email != "":
+		return nil, errors.New("either -id or -email must be set, not both")
+	case *s.id != "":
+		return env.users.GetById(ctx, *s.id)
+	case *s.email != "":
+		return env.users.GetByEmail(ctx, *s.email)
+	default:
+		return nil, errors.New("-id or -email is required")
+	}
+}
+
+// passwordFlags holds flags providing a password. Reading the password
+// from stdin keeps it out of shell history and process list.
+type passwordFlags struct {
+	password *string
+	stdin    *bool
+}
+
+func newPasswordFlags(fs *flag.FlagSet) *passwordFlags {
+	return &passwordFlags{
+		password: fs.String("password", "", "new password"),
+		stdin:    fs.Bool("password-stdin", false, "read the password from the first line of stdin"),
+	}
+}
+
+// read returns the password from flags or stdin.
+// Returns an empty password if none is given.
+func (p *passwordFlags) read() (string, error) {
+	if !*p.stdin {
+		return *p.password, nil
+	}
+
+	if *p.password != "" {
+		return "", errors.New("either -password or -password-stdin must be set, not both")
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("cannot read password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// userAction runs a command changing the selected user
+// and returns the user after the change.
+func userAction(ctx context.Context, env *environment, name string, args []string, action func(u *user.User) error) (result, error) {
+	fs, sel := newFlagSet(name)
+	fs.Parse(args)
+
+	u, err := sel.find(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := action(u); err != nil {
+		return nil, err
+	}
+
+	return findByID(ctx, env, u.UUID)
+}
+
+// findByID returns the user with given uuid as command result.
+func findByID(ctx context.Context, env *environment, uuid string) (result, error) {
+	u, err := env.users.GetById(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return userResult{u}, nil
+}
+
+func runFind(ctx context.Context, env *environment, args []string) (result, error) {
+	return userAction(ctx, env, "find", args, func(u *user.User) error {
+		return nil
+	})
+}
+
+// runCreate creates a user the same way registration does, so the
+// password is checked against password policy and verification email
+// is sent. Role is set and email is verified afterwards if requested.
+func runCreate(ctx context.Context, env *environment, args []string) (result, error) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user (required)")
+	username := fs.String("username", "", "username of the user (required)")
+	role := fs.String("role", string(auth.RoleUser), "role of the user")
+	verified := fs.Bool("verified", false, "mark email as verified")
+	passwords := newPasswordFlags(fs)
+	fs.Parse(args)
+
+	pass, err := passwords.read()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &user.CreateUserDTO{
+		Email:          *email,
+		Username:       *username,
+		Password:       pass,
+		RepeatPassword: pass,
+	}
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	roleInput := &user.SetRoleDTO{Role: auth.Role(*role)}
+	if err := roleInput.Validate(); err != nil {
+		return nil, err
+	}
+
+	id, err := env.users.Create(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if roleInput.Role != auth.RoleUser {
+		roleInput.UUID = id
+		if err := env.users.SetRole(ctx, roleInput); err != nil {
+			return nil, fmt.Errorf("user %s is created, but role isn't set: %w", id, err)
+		}
+	}
+
+	if *verified {
+		if err := env.users.ForceVerify(ctx, id); err != nil {
+			return nil, fmt.Errorf("user %s is created, but isn't verified: %w", id, err)
+		}
+	}
+
+	return findByID(ctx, env, id)
+}
+
+func runPromote(ctx context.Context, env *environment, args []string) (result, error) {
+	fs, sel := newFlagSet("promote")
+	role := fs.String("role", string(auth.RoleAdmin), "new role of the user")
+	fs.Parse(args)
+
+	input := &user.SetRoleDTO{Role: auth.Role(*role)}
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	u, err := sel.find(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	input.UUID = u.UUID
+	if err := env.users.SetRole(ctx, input); err != nil {
+		return nil, err
+	}
+
+	return findByID(ctx, env, u.UUID)
+}
+
+func runVerify(ctx context.Context, env *environment, args []string) (result, error) {
+	return userAction(ctx, env, "verify", args, func(u *user.User) error {
+		return env.users.ForceVerify(ctx, u.UUID)
+	})
+}
+
+// runResetPassword sets given password or, with -send-email,
+// sends password reset email, so the user chooses the password.
+func runResetPassword(ctx context.Context, env *environment, args []string) (result, error) {
+	fs, sel := newFlagSet("reset-password")
+	sendEmail := fs.Bool("send-email", false, "send password reset email instead of setting the password")
+	passwords := newPasswordFlags(fs)
+	fs.Parse(args)
+
+	pass, err := passwords.read()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *sendEmail && pass != "":
+		return nil, errors.New("either a password or -send-email must be set, not both")
+	case !*sendEmail && pass == "":
+		return nil, errors.New("-password, -password-stdin or -send-email is required")
+	}
+
+	u, err := sel.find(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if *sendEmail {
+		if err := env.users.ForgotPassword(ctx, u.Email); err != nil {
+			return nil, err
+		}
+		return messageResult{UUID: u.UUID, Message: "password reset email is sent to " + u.Email}, nil
+	}
+
+	if err := env.users.SetPassword(ctx, u.UUID, pass); err != nil {
+		return nil, err
+	}
+	return messageResult{UUID: u.UUID, Message: "password is changed, the user is logged out everywhere"}, nil
+}
+
+func runLock(ctx context.Context, env *environment, args []string) (result, error) {
+	return userAction(ctx, env, "lock", args, func(u *user.User) error {
+		return env.users.Lock(ctx, u.UUID)
+	})
+}
+
+func runUnlock(ctx context.Context, env *environment, args []string) (result, error) {
+	return userAction(ctx, env, "unlock", args, func(u *user.User) error {
+		return env.users.Unlock(ctx, u.UUID)
+	})
+}
+
+func runDelete(ctx context.Context, env *environment, args []string) (result, error) {
+	fs, sel := newFlagSet("delete")
+	fs.Parse(args)
+
+	u, err := sel.find(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := env.users.Delete(ctx, u.UUID, nil); err != nil {
+		return nil, err
+	}
+
+	return messageResult{UUID: u.UUID, Message: "user " + u.UUID + " is deleted"}, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/config"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditdb "github.com/juicyluv/sueta/user_service/app/internal/audit/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/migrations"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// environment holds dependencies shared by commands. They're built
+// from the service config the same way the service builds them,
+// so commands behave like requests made to the service.
+type environment struct {
+	cfg     *config.Config
+	logger  logger.Logger
+	storage user.Storage
+	hasher  password.Hasher
+	users   user.Service
+
+	mongoClient  *mongodriver.Database
+	postgresPool *pgx.ConnPool
+}
+
+// newEnvironment connects to the storages configured for user service.
+// Schema and indexes are checked the same way the service does
+// at startup. Pending migrations are checked by checkMigrations.
+func newEnvironment(ctx context.Context, cfg *config.Config) (*environment, error) {
+	env := &environment{cfg: cfg, logger: logger.GetLogger()}
+	if err := env.init(ctx); err != nil {
+		env.close()
+		return nil, err
+	}
+	return env, nil
+}
+
+func (env *environment) init(ctx context.Context) error {
+	cfg := env.cfg
+
+	var err error
+	env.hasher, err = password.New(password.Params{
+		Algorithm:  cfg.PasswordHash.Algorithm,
+		BcryptCost: cfg.PasswordHash.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      cfg.PasswordHash.Argon2Memory,
+			Iterations:  cfg.PasswordHash.Argon2Iterations,
+			Parallelism: cfg.PasswordHash.Argon2Parallelism,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot initialize password hasher: %w", err)
+	}
+
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordPolicy.MinLength, cfg.PasswordPolicy.MaxLength, cfg.PasswordPolicy.Blocklist)
+	if err != nil {
+		return fmt.Errorf("cannot initialize password policy: %w", err)
+	}
+
+	var mailer mail.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer = mail.NewSMTPMailer(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	case "outbox":
+		mailer, err = mail.NewOutbox(cfg.Mail.OutboxDir)
+		if err != nil {
+			return fmt.Errorf("cannot initialize mail outbox: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown mail driver: %s", cfg.Mail.Driver)
+	}
+
+	tokenManager := token.NewManager(cfg.Auth.Issuer, cfg.Auth.AccessSecret, cfg.Auth.RefreshSecret, map[token.Type]time.Duration{
+		token.Access:        time.Duration(cfg.Auth.AccessTokenTTL) * time.Minute,
+		token.Refresh:       time.Duration(cfg.Auth.RefreshTokenTTL) * time.Hour,
+		token.Verification:  time.Duration(cfg.Auth.VerificationTokenTTL) * time.Hour,
+		token.PasswordReset: time.Duration(cfg.Auth.PasswordResetTokenTTL) * time.Minute,
+		token.TwoFactor:     time.Duration(cfg.Auth.TwoFactorTokenTTL) * time.Minute,
+		token.EmailChange:   time.Duration(cfg.Auth.EmailChangeTokenTTL) * time.Hour,
+	})
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var tokenStorage user.TokenStorage
+	var sessionStorage user.SessionStorage
+	var apiKeyStorage user.APIKeyStorage
+	var auditStorage audit.Storage
+	var drift []string
+	switch cfg.Storage.Driver {
+	case "postgres":
+		env.postgresPool, err = postgres.NewPostgresClient(cfg.Postgres.URL, cfg.Postgres.MaxConnections)
+		if err != nil {
+			return fmt.Errorf("cannot connect to postgres: %w", err)
+		}
+		if cfg.Storage.IndexMode == db.IndexModeReport {
+			checks := []func(context.Context, *pgx.ConnPool) ([]string, error){
+				db.CheckPostgres, auditdb.CheckPostgres,
+			}
+			for _, check := range checks {
+				found, err := check(connectCtx, env.postgresPool)
+				if err != nil {
+					return fmt.Errorf("cannot check postgres schema: %w", err)
+				}
+				drift = append(drift, found...)
+			}
+		} else {
+			for _, migrate := range []func(context.Context, *pgx.ConnPool) error{db.MigratePostgres, auditdb.MigratePostgres} {
+				if err := migrate(connectCtx, env.postgresPool); err != nil {
+					return fmt.Errorf("cannot migrate postgres schema: %w", err)
+				}
+			}
+		}
+		env.storage = db.NewPostgresStorage(env.postgresPool)
+		tokenStorage = db.NewPostgresTokenStorage(env.postgresPool)
+		sessionStorage = db.NewPostgresSessionStorage(env.postgresPool)
+		apiKeyStorage = db.NewPostgresAPIKeyStorage(env.postgresPool)
+		auditStorage = auditdb.NewPostgresStorage(env.postgresPool)
+	case "mongo":
+		env.mongoClient, err = mongo.NewMongoClient(connectCtx, cfg.DB.Database, cfg.DB.URL)
+		if err != nil {
+			return fmt.Errorf("cannot connect to mongodb: %w", err)
+		}
+
+		drift, err = db.CheckIndexes(connectCtx, env.mongoClient, cfg.DB.Collection)
+		if err != nil {
+			return fmt.Errorf("cannot check user indexes: %w", err)
+		}
+		env.storage = db.NewStorage(env.mongoClient, cfg.DB.Collection)
+		tokenStorage = db.NewTokenStorage(env.mongoClient, cfg.DB.TokenCollection)
+		sessionStorage = db.NewSessionStorage(env.mongoClient, cfg.DB.SessionCollection)
+		apiKeyStorage = db.NewAPIKeyStorage(env.mongoClient, cfg.DB.APIKeyCollection)
+
+		if cfg.Storage.IndexMode != db.IndexModeReport {
+			if err := auditdb.CreateIndexes(connectCtx, env.mongoClient, cfg.DB.AuditCollection); err != nil {
+				return fmt.Errorf("cannot create audit indexes: %w", err)
+			}
+		}
+		auditStorage = auditdb.NewStorage(env.mongoClient, cfg.DB.AuditCollection)
+	default:
+		return fmt.Errorf("unknown storage driver: %s", cfg.Storage.Driver)
+	}
+
+	for _, d := range drift {
+		env.logger.Warnf("schema drift: %s", d)
+	}
+
+	auditService := audit.NewService(auditStorage, env.logger)
+	env.users = user.NewService(env.storage, tokenStorage, sessionStorage, apiKeyStorage, auditService, mailer, tokenManager, env.hasher, passwordPolicy, env.logger)
+
+	return nil
+}
+
+// checkMigrations returns an error if migrations of mongo storage
+// are pending, unless the config allows them like it does for the service,
+// or if unique indexes created by migrations are missing.
+func (env *environment) checkMigrations(ctx context.Context) error {
+	if env.cfg.Storage.Driver != "mongo" {
+		return nil
+	}
+
+	migrator, err := migrations.New(env.mongoClient, env.cfg.DB.Collection, env.cfg.DB.MigrationCollection)
+	if err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot check user migrations: %w", err)
+	}
+	if pending > 0 {
+		if !env.cfg.Storage.AllowPendingMigrations {
+			return fmt.Errorf("%d user migrations are pending, run usersctl migrate up", pending)
+		}
+		env.logger.Warnf("%d user migrations are pending", pending)
+	}
+	if err := db.RequireIndexes(ctx, env.mongoClient, env.cfg.DB.Collection); err != nil {
+		return fmt.Errorf("cannot use users collection: %w", err)
+	}
+	return nil
+}
+
+// close disconnects from the storages.
+func (env *environment) close() {
+	if env.postgresPool != nil {
+		env.postgresPool.Close()
+	}
+	if env.mongoClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := env.mongoClient.Client().Disconnect(ctx); err != nil {
+			env.logger.Warnf("cannot disconnect from mongodb: %v", err)
+		}
+	}
+}
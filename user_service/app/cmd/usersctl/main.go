@@ -0,0 +1,129 @@
+// Command usersctl manages users of user service from the command line.
+// It works with the storages configured for the service, so it's run
+// with the same config file and .env file. Changes are made through
+// user service and recorded to audit log like changes made by requests.
+//
+// Usage:
+//
+//	usersctl [-config-path path] [-output text|json] <command> [flags]
+//
+// Run usersctl <command> -h to see flags of the command.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/juicyluv/sueta/user_service/app/config"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+var (
+	configPath = flag.String("config-path", "app/config/config.yml", "path for application configuration file")
+	output     = flag.String("output", outputText, "output format: text or json")
+)
+
+// command is a subcommand of usersctl. Run gets arguments following
+// the command name and returns the result to print.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, env *environment, args []string) (result, error)
+}
+
+var commands = []command{
+	{"find", "show the user found by id or email", runFind},
+	{"create", "create a user", runCreate},
+	{"promote", "change role of the user, admin by default", runPromote},
+	{"verify", "mark email of the user as verified", runVerify},
+	{"reset-password", "set a new password or send password reset email", runResetPassword},
+	{"lock", "lock the user and log it out everywhere", runLock},
+	{"unlock", "unlock the user", runUnlock},
+	{"delete", "delete the user, it can be restored until purged", runDelete},
+	{"import", "import users from CSV or JSON Lines file", runImport},
+	{"export", "export users to CSV or JSON Lines file", runExport},
+	{"migrate", "apply, revert or list storage migrations: up, down or status", runMigrate},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: usersctl [-config-path path] [-output text|json] <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 || !validOutput(*output) {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "usersctl: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	logger.Init()
+	cfg := config.Get(*configPath, ".env")
+
+	// Interrupted commands stop at the next storage call, import
+	// keeps its checkpoint then, so it can be resumed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Audit events of the run share request id, so they can be told
+	// apart from changes made through the API.
+	id, err := token.NewID()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usersctl: %v\n", err)
+		os.Exit(1)
+	}
+	ctx = audit.ContextWithRequest(ctx, audit.Request{ID: "usersctl-" + id})
+
+	env, err := newEnvironment(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usersctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Migrations are applied by the migrate command, others need
+	// unique indexes and documents the storage expects.
+	if cmd.name != "migrate" {
+		err = env.checkMigrations(ctx)
+	}
+
+	var res result
+	if err == nil {
+		res, err = cmd.run(ctx, env, flag.Args()[1:])
+	}
+	if res != nil {
+		if err := printResult(os.Stdout, *output, res); err != nil {
+			fmt.Fprintf(os.Stderr, "usersctl: cannot print result: %v\n", err)
+		}
+	}
+	env.close()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usersctl %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
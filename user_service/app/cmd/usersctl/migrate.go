@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/migrations"
+)
+
+// runMigrate applies, reverts or lists migrations of mongo storage.
+// Postgres schema is migrated at startup, so it has no migrations.
+func runMigrate(ctx context.Context, env *environment, args []string) (result, error) {
+	if len(args) == 0 {
+		return nil, errors.New("up, down or status is required")
+	}
+
+	if env.cfg.Storage.Driver != "mongo" {
+		return nil, errors.New("only mongo storage has migrations, postgres schema is migrated at startup")
+	}
+
+	migrator, err := migrations.New(env.mongoClient, env.cfg.DB.Collection, env.cfg.DB.MigrationCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	switch args[0] {
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+		to := fs.Int("to", 0, "version to migrate to (default all pending)")
+		fs.Parse(args[1:])
+
+		done, err := migrator.Up(ctx, *to)
+		return migrationsResult{Action: "applied", Migrations: names(done)}, err
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of the latest migrations to revert")
+		fs.Parse(args[1:])
+
+		if *steps < 1 {
+			return nil, errors.New("steps must be positive")
+		}
+
+		done, err := migrator.Down(ctx, *steps)
+		return migrationsResult{Action: "reverted", Migrations: names(done)}, err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return statusResult{Migrations: statuses}, nil
+	default:
+		return nil, fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
+
+// names returns names of given migrations prefixed with their versions.
+func names(done []migrations.Migration) []string {
+	result := make([]string, len(done))
+	for i := range done {
+		result[i] = done[i].String()
+	}
+	return result
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/migrations"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/transfer"
+)
+
+// Output formats of command results.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// result is printed once a command succeeds. It's encoded
+// as JSON with -output json, otherwise its text is printed.
+type result interface {
+	writeText(w io.Writer) error
+}
+
+// printResult writes the result in given format.
+func printResult(w io.Writer, format string, r result) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+	return r.writeText(w)
+}
+
+// userResult is the user a command has found or changed.
+type userResult struct {
+	*user.User
+}
+
+func (r userResult) writeText(w io.Writer) error {
+	lockedAt, lastLoginAt := "-", "-"
+	if r.LockedAt != nil {
+		lockedAt = r.LockedAt.Format(time.RFC3339)
+	}
+	if r.LastLoginAt != nil {
+		lastLoginAt = r.LastLoginAt.Format(time.RFC3339)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "uuid:\t%s\n", r.UUID)
+	fmt.Fprintf(tw, "email:\t%s\n", r.Email)
+	fmt.Fprintf(tw, "username:\t%s\n", r.Username)
+	fmt.Fprintf(tw, "role:\t%s\n", r.Role)
+	fmt.Fprintf(tw, "verified:\t%t\n", r.Verified)
+	fmt.Fprintf(tw, "registered at:\t%s\n", r.RegisteredAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "updated at:\t%s\n", r.UpdatedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "last login:\t%s\n", lastLoginAt)
+	fmt.Fprintf(tw, "locked at:\t%s\n", lockedAt)
+	return tw.Flush()
+}
+
+// messageResult reports an action which has no user to show.
+type messageResult struct {
+	UUID    string `json:"uuid,omitempty"`
+	Message string `json:"message"`
+}
+
+func (r messageResult) writeText(w io.Writer) error {
+	_, err := fmt.Fprintln(w, r.Message)
+	return err
+}
+
+// importResult summarizes import.
+type importResult struct {
+	*transfer.Result
+	DryRun bool   `json:"dryRun"`
+	Report string `json:"report,omitempty"`
+}
+
+func (r importResult) writeText(w io.Writer) error {
+	verb := "imported"
+	if r.DryRun {
+		verb = "checked"
+	}
+
+	_, err := fmt.Fprintf(w, "%s %d rows: %d created, %d updated, %d skipped, %d resumed, %d invalid, %d failed\n",
+		verb, r.Rows, r.Created, r.Updated, r.Skipped, r.Resumed, r.Invalid, r.Failed)
+	if err == nil && r.Report != "" {
+		_, err = fmt.Fprintf(w, "see %s for details\n", r.Report)
+	}
+	return err
+}
+
+// exportResult summarizes export.
+type exportResult struct {
+	File  string `json:"file"`
+	Users int    `json:"users"`
+}
+
+func (r exportResult) writeText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "exported %d users to %s\n", r.Users, r.File)
+	return err
+}
+
+// migrationsResult lists migrations applied or reverted by a command.
+type migrationsResult struct {
+	Action     string   `json:"action"`
+	Migrations []string `json:"migrations"`
+}
+
+func (r migrationsResult) writeText(w io.Writer) error {
+	if len(r.Migrations) == 0 {
+		_, err := fmt.Fprintf(w, "no migrations %s\n", r.Action)
+		return err
+	}
+
+	for _, name := range r.Migrations {
+		if _, err := fmt.Fprintf(w, "%s %s\n", r.Action, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusResult lists known and applied migrations.
+type statusResult struct {
+	Migrations []migrations.Status `json:"migrations"`
+}
+
+func (r statusResult) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "VERSION\tNAME\tSTATUS\n")
+	for _, m := range r.Migrations {
+		status := "pending"
+		if m.AppliedAt != nil {
+			status = "applied at " + m.AppliedAt.Format(time.RFC3339)
+		}
+		if m.Changed {
+			status += ", changed since"
+		}
+		if m.Unknown {
+			status += ", unknown to this version"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	return tw.Flush()
+}
+
+// validOutput reports whether given output format is known.
+func validOutput(format string) bool {
+	return format == outputText || format == outputJSON
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/transfer"
+)
+
+// runImport imports users from a file. Progress is kept in a checkpoint
+// file next to the input, so running the same command again after
+// a crash continues where import stopped. Rows which aren't imported
+// are appended to the report file.
+func runImport(ctx context.Context, env *environment, args []string) (result, error) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("file", "", "path of the file to import (required)")
+	format := fs.String("format", "", "file format: csv or jsonl (default by file extension)")
+	batch := fs.Int("batch", transfer.DefaultBatchSize, "number of users inserted at once")
+	policy := fs.String("on-duplicate", string(transfer.PolicySkip), "what to do with registered emails: skip, fail or update")
+	dryRun := fs.Bool("dry-run", false, "validate the file and look for duplicates without writing anything")
+	report := fs.String("report", "", "path of the JSON Lines report of rows which weren't imported (default <file>.report.jsonl)")
+	checkpoint := fs.String("checkpoint", "", "path of the file keeping import progress (default <file>.progress)")
+	fs.Parse(args)
+
+	if *file == "" {
+		fs.Usage()
+		return nil, errors.New("file is required")
+	}
+	if *format == "" {
+		*format = formatOf(*file)
+	}
+	if *report == "" {
+		*report = *file + ".report.jsonl"
+	}
+	if *checkpoint == "" {
+		*checkpoint = *file + ".progress"
+	}
+
+	in, err := os.Open(*file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	// Report is appended to, so it covers all runs of resumed import.
+	out, err := os.OpenFile(*report, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open report: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := os.Stat(*checkpoint); err == nil && !*dryRun {
+		env.logger.Infof("resuming import from %s", *checkpoint)
+	}
+
+	result, err := transfer.NewImporter(env.storage, env.users, env.hasher).Import(ctx, in, transfer.Options{
+		Format:     *format,
+		BatchSize:  *batch,
+		Policy:     transfer.Policy(*policy),
+		DryRun:     *dryRun,
+		Checkpoint: *checkpoint,
+		Report:     out,
+	})
+	if result == nil {
+		return nil, err
+	}
+
+	res := importResult{Result: result, DryRun: *dryRun}
+	if result.Invalid+result.Skipped+result.Failed > 0 {
+		res.Report = *report
+	}
+
+	if err != nil && !*dryRun {
+		return res, fmt.Errorf("%w (run the same command to resume)", err)
+	}
+	return res, err
+}
+
+// runExport exports all users to a file, password hashes included.
+func runExport(ctx context.Context, env *environment, args []string) (result, error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	file := fs.String("file", "", "path of the file to write (required)")
+	format := fs.String("format", "", "file format: csv or jsonl (default by file extension)")
+	fs.Parse(args)
+
+	if *file == "" {
+		fs.Usage()
+		return nil, errors.New("file is required")
+	}
+	if *format == "" {
+		*format = formatOf(*file)
+	}
+
+	out, err := os.OpenFile(*file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	written, err := transfer.Export(ctx, env.storage, out, *format)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return exportResult{File: *file, Users: written}, nil
+}
+
+// formatOf guesses file format by its extension.
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return transfer.FormatJSONL
+	default:
+		return transfer.FormatCSV
+	}
+}
@@ -17,12 +17,122 @@ type Config struct {
 		ReadTimeout    int    `yaml:"readTimeout" env-default:"20"`
 		WriteTimeout   int    `yaml:"writeTimeout" env-default:"20"`
 	} `yaml:"http" env-required:"true"`
+	// Storage represents configuration of user storage. Driver is either
+	// mongo or postgres. Refresh tokens, sessions, API keys, OpenID Connect
+	// data and audit log are stored with the same driver.
+	// IndexMode is either apply or report. Report mode only logs
+	// schema drift at startup without changing anything. Indexes of
+	// mongo users collection are created by migrations in both modes.
+	// Service refuses to start with pending migrations of mongo storage
+	// unless AllowPendingMigrations is set.
+	Storage struct {
+		Driver                 string `yaml:"driver" env-default:"mongo"`
+		IndexMode              string `yaml:"indexMode" env-default:"apply"`
+		AllowPendingMigrations bool   `yaml:"allowPendingMigrations" env-default:"false"`
+	} `yaml:"storage"`
 	// DB represents configuration for database.
 	DB struct {
-		URL        string `env:"MONGO_URL" env-required:"true"`
-		Database   string `yaml:"database" env-required:"true"`
-		Collection string `yaml:"collection" env-required:"true"`
+		URL                 string `env:"MONGO_URL" env-required:"true"`
+		Database            string `yaml:"database" env-required:"true"`
+		Collection          string `yaml:"collection" env-required:"true"`
+		TokenCollection     string `yaml:"tokenCollection" env-default:"refresh_tokens"`
+		SessionCollection   string `yaml:"sessionCollection" env-default:"sessions"`
+		APIKeyCollection    string `yaml:"apiKeyCollection" env-default:"api_keys"`
+		OIDCClients         string `yaml:"oidcClientCollection" env-default:"oidc_clients"`
+		OIDCCodes           string `yaml:"oidcCodeCollection" env-default:"oidc_codes"`
+		OIDCKeys            string `yaml:"oidcKeyCollection" env-default:"oidc_keys"`
+		AuditCollection     string `yaml:"auditCollection" env-default:"audit_events"`
+		MigrationCollection string `yaml:"migrationCollection" env-default:"users_migrations"`
 	} `yaml:"mongo" env-required:"true"`
+	// Postgres represents configuration for postgres database.
+	Postgres struct {
+		URL            string `env:"POSTGRES_URL"`
+		MaxConnections int    `yaml:"maxConnections" env-default:"10"`
+	} `yaml:"postgres"`
+	// Auth represents configuration for authentication tokens.
+	Auth struct {
+		AccessSecret          string `env:"JWT_ACCESS_SECRET" env-required:"true"`
+		RefreshSecret         string `env:"JWT_REFRESH_SECRET" env-required:"true"`
+		Issuer                string `yaml:"issuer" env-default:"sueta"`
+		AccessTokenTTL        int    `yaml:"accessTokenTTL" env-default:"15"`
+		RefreshTokenTTL       int    `yaml:"refreshTokenTTL" env-default:"720"`
+		VerificationTokenTTL  int    `yaml:"verificationTokenTTL" env-default:"24"`
+		PasswordResetTokenTTL int    `yaml:"passwordResetTokenTTL" env-default:"30"`
+		TwoFactorTokenTTL     int    `yaml:"twoFactorTokenTTL" env-default:"5"`
+		EmailChangeTokenTTL   int    `yaml:"emailChangeTokenTTL" env-default:"24"`
+	} `yaml:"auth"`
+	// Mail represents configuration for sending emails. Driver is either
+	// smtp or outbox. Outbox driver writes emails to files instead of sending them.
+	Mail struct {
+		Driver    string `yaml:"driver" env-default:"outbox"`
+		OutboxDir string `yaml:"outboxDir" env-default:"outbox"`
+		From      string `yaml:"from" env-default:"noreply@sueta.local"`
+		Host      string `yaml:"host" env-default:"localhost"`
+		Port      int    `yaml:"port" env-default:"587"`
+		Username  string `env:"SMTP_USERNAME"`
+		Password  string `env:"SMTP_PASSWORD"`
+	} `yaml:"mail"`
+	// Lockout represents configuration for brute-force protection of login.
+	// Store is either memory or redis. Redis store is required when multiple
+	// instances are running.
+	Lockout struct {
+		Store         string `yaml:"store" env-default:"memory"`
+		RedisDSN      string `env:"REDIS_DSN"`
+		Window        int    `yaml:"window" env-default:"15"`
+		Threshold     int    `yaml:"threshold" env-default:"5"`
+		BaseDelay     int    `yaml:"baseDelay" env-default:"1"`
+		MaxAttempts   int    `yaml:"maxAttempts" env-default:"10"`
+		Duration      int    `yaml:"duration" env-default:"15"`
+		IPThreshold   int    `yaml:"ipThreshold" env-default:"20"`
+		IPMaxAttempts int    `yaml:"ipMaxAttempts" env-default:"100"`
+	} `yaml:"lockout"`
+	// PasswordHash represents configuration for password hashing.
+	// Algorithm is either bcrypt or argon2id. Passwords hashed with
+	// other algorithm or cost are hashed again on successful login.
+	PasswordHash struct {
+		Algorithm         string `yaml:"algorithm" env-default:"bcrypt"`
+		BcryptCost        int    `yaml:"bcryptCost" env-default:"10"`
+		Argon2Memory      uint32 `yaml:"argon2Memory" env-default:"65536"`
+		Argon2Iterations  uint32 `yaml:"argon2Iterations" env-default:"3"`
+		Argon2Parallelism uint8  `yaml:"argon2Parallelism" env-default:"2"`
+	} `yaml:"passwordHash"`
+	// PasswordPolicy represents configuration for accepted passwords.
+	// Length is counted in characters. Passwords listed in Blocklist file
+	// are rejected, see password.NewPolicy for the file format.
+	PasswordPolicy struct {
+		MinLength int    `yaml:"minLength" env-default:"8"`
+		MaxLength int    `yaml:"maxLength" env-default:"64"`
+		Blocklist string `yaml:"blocklist"`
+	} `yaml:"passwordPolicy"`
+	// Purge represents configuration for purging deleted users.
+	// Deleted users are kept for Retention days and can be restored
+	// by an admin until then. Purge runs every Interval minutes.
+	Purge struct {
+		Retention int `yaml:"retention" env-default:"30"`
+		Interval  int `yaml:"interval" env-default:"60"`
+	} `yaml:"purge"`
+	// OIDC represents configuration for OpenID Connect provider. Issuer is
+	// a public URL of the service. Signing keys are rotated every KeyRotation
+	// hours, rotation is checked every RotationInterval minutes.
+	OIDC struct {
+		Issuer           string `yaml:"issuer" env-default:"http://localhost:8080"`
+		CodeTTL          int    `yaml:"codeTTL" env-default:"60"`
+		TokenTTL         int    `yaml:"tokenTTL" env-default:"60"`
+		KeyRotation      int    `yaml:"keyRotation" env-default:"720"`
+		RotationInterval int    `yaml:"rotationInterval" env-default:"60"`
+	} `yaml:"oidc"`
+	// Export represents configuration for personal data export. Archives
+	// are kept in Dir for TTL hours, expired ones are removed every
+	// CleanupInterval minutes. Export fails if it isn't done within
+	// Timeout minutes. Posts and comments are requested from post service
+	// at PostServiceURL.
+	Export struct {
+		Dir             string `yaml:"dir" env-default:"exports"`
+		TTL             int    `yaml:"ttl" env-default:"24"`
+		Timeout         int    `yaml:"timeout" env-default:"10"`
+		CleanupInterval int    `yaml:"cleanupInterval" env-default:"60"`
+		PostServiceURL  string `yaml:"postServiceURL" env:"POST_SERVICE_URL" env-default:"http://localhost:8081"`
+	} `yaml:"export"`
 }
 
 var instance *Config
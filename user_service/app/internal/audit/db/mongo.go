@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check whether db implements audit storage interface.
+var _ audit.Storage = &db{}
+
+// db implementes audit storage interface.
+type db struct {
+	logger     logger.Logger
+	collection *mongo.Collection
+}
+
+// NewStorage returns a new audit storage instance.
+func NewStorage(storage *mongo.Database, collection string) audit.Storage {
+	return &db{
+		logger:     logger.GetLogger(),
+		collection: storage.Collection(collection),
+	}
+}
+
+// CreateIndexes creates indexes audit events are listed by,
+// if they don't exist yet.
+func CreateIndexes(ctx context.Context, storage *mongo.Database, collection string) error {
+	_, err := storage.Collection(collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create audit indexes: %w", err)
+	}
+	return nil
+}
+
+// Append inserts a new event in the database.
+// Returns an error on failure.
+func (d *db) Append(ctx context.Context, event *audit.Event) error {
+	if _, err := d.collection.InsertOne(ctx, event); err != nil {
+		e := fmt.Errorf("cannot insert audit event in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// List returns events matching given filter ordered by time and id.
+func (d *db) List(ctx context.Context, filter *audit.Filter) ([]audit.Event, error) {
+	conditions := bson.A{}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, bson.M{"time": bson.M{"$gte": filter.From}})
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, bson.M{"time": bson.M{"$lt": filter.To}})
+	}
+
+	if filter.Actor != "" {
+		conditions = append(conditions, bson.M{"actorId": filter.Actor})
+	}
+
+	if filter.Target != "" {
+		conditions = append(conditions, bson.M{"targetId": filter.Target})
+	}
+
+	if filter.After != nil {
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"time": bson.M{"$gt": filter.After.Time}},
+			bson.M{"time": filter.After.Time, "_id": bson.M{"$gt": filter.After.ID}},
+		}})
+	}
+
+	query := bson.M{}
+	if len(conditions) > 0 {
+		query["$and"] = conditions
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := d.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var events []audit.Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+
+	return events, nil
+}
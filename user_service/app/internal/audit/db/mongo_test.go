@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit/storagetest"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+)
+
+// TestStorage runs storage contract tests against MongoDB
+// available at MONGO_URL. Skipped if it's not set.
+func TestStorage(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+
+	logger.Init()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	database, err := mongo.NewMongoClient(ctx, "sueta_test", mongoURL)
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %v", err)
+	}
+	defer database.Client().Disconnect(context.Background())
+
+	storagetest.Run(t, func(t *testing.T) (audit.Storage, func() error) {
+		collection := fmt.Sprintf("audit_events_%d", time.Now().UnixNano())
+		if err := db.CreateIndexes(context.Background(), database, collection); err != nil {
+			t.Fatal(err)
+		}
+
+		teardown := func() error {
+			return database.Collection(collection).Drop(context.Background())
+		}
+
+		return db.NewStorage(database, collection), teardown
+	})
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+)
+
+// schema creates audit table if it doesn't exist.
+// Updates and deletes of the table are rejected by a trigger.
+//
+//go:embed postgres.sql
+var schema string
+
+// Check whether postgresDB implements audit storage interface.
+var _ audit.Storage = &postgresDB{}
+
+// postgresDB implements audit storage interface on postgres.
+type postgresDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresStorage returns a new postgres audit storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresStorage(pool *pgx.ConnPool) audit.Storage {
+	return &postgresDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+// MigratePostgres creates audit table if it doesn't exist.
+func MigratePostgres(ctx context.Context, pool *pgx.ConnPool) error {
+	if _, err := pool.ExecEx(ctx, schema, nil); err != nil {
+		return fmt.Errorf("cannot migrate audit schema: %w", err)
+	}
+	return nil
+}
+
+// CheckPostgres reports audit tables which are missing
+// without changing anything.
+func CheckPostgres(ctx context.Context, pool *pgx.ConnPool) ([]string, error) {
+	missing, err := postgres.MissingTables(ctx, pool, "audit_events")
+	if err != nil {
+		return nil, err
+	}
+
+	drift := make([]string, len(missing))
+	for i, table := range missing {
+		drift[i] = fmt.Sprintf("table %s missing", table)
+	}
+	return drift, nil
+}
+
+// Append inserts a new event row.
+// Returns an error on failure.
+func (d *postgresDB) Append(ctx context.Context, event *audit.Event) error {
+	query := `
+		INSERT INTO audit_events (id, time, action, actor_id, target_id, fields, ip, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	fields := event.Fields
+	if fields == nil {
+		fields = []string{}
+	}
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		event.ID,
+		event.Time,
+		string(event.Action),
+		event.Actor,
+		event.Target,
+		fields,
+		event.IP,
+		event.RequestID,
+	)
+	if err != nil {
+		e := fmt.Errorf("cannot insert audit event in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// List returns events matching given filter ordered by time and id.
+func (d *postgresDB) List(ctx context.Context, filter *audit.Filter) ([]audit.Event, error) {
+	var conditions []string
+	var args []interface{}
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "time >= "+arg(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "time < "+arg(filter.To))
+	}
+
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor_id = "+arg(filter.Actor))
+	}
+
+	if filter.Target != "" {
+		conditions = append(conditions, "target_id = "+arg(filter.Target))
+	}
+
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(time, id) > (%s, %s)", arg(filter.After.Time), arg(filter.After.ID)))
+	}
+
+	query := `SELECT id, time, action, actor_id, target_id, fields, ip, request_id FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY time, id"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := d.pool.QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var events []audit.Event
+	for rows.Next() {
+		var e audit.Event
+		var action string
+		if err := rows.Scan(&e.ID, &e.Time, &action, &e.Actor, &e.Target, &e.Fields, &e.IP, &e.RequestID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		e.Action = audit.Action(action)
+		e.Time = e.Time.UTC()
+		if len(e.Fields) == 0 {
+			e.Fields = nil
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return events, nil
+}
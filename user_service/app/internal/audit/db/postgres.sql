@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id         TEXT PRIMARY KEY,
+    time       TIMESTAMPTZ NOT NULL,
+    action     TEXT NOT NULL,
+    actor_id   TEXT NOT NULL DEFAULT '',
+    target_id  TEXT NOT NULL,
+    fields     TEXT[] NOT NULL DEFAULT '{}',
+    ip         TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_time ON audit_events (time, id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_time ON audit_events (actor_id, time, id);
+CREATE INDEX IF NOT EXISTS audit_events_target_time ON audit_events (target_id, time, id);
+
+-- Audit log is append-only.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events can not be changed';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/handler"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	eventsURL = "/api/audit"
+	exportURL = "/api/audit/export"
+)
+
+// Handler handles requests specified to audit log.
+type Handler struct {
+	logger     logger.Logger
+	service    Service
+	authorizer *auth.Middleware
+}
+
+// NewHandler returns a new audit Handler instance.
+func NewHandler(logger logger.Logger, service Service, authorizer *auth.Middleware) handler.Handling {
+	return &Handler{
+		logger:     logger,
+		service:    service,
+		authorizer: authorizer,
+	}
+}
+
+// Register registers new routes for router.
+// Audit log is available for admins only.
+func (h *Handler) Register(router *httprouter.Router) {
+	adminOnly := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}}
+
+	router.HandlerFunc(http.MethodGet, eventsURL, h.authorizer.Authorize(adminOnly, h.ListEvents))
+	router.HandlerFunc(http.MethodGet, exportURL, h.authorizer.Authorize(adminOnly, h.Export))
+}
+
+// ListEvents godoc
+// @Summary Query audit log
+// @Description Get a page of audit events, oldest first. Pass nextCursor of the previous page as cursor to get the next one. Available for admins only.
+// @Tags audit
+// @Produce json
+// @Param from query string false "Events at or after the time" example(2022-02-01T00:00:00Z)
+// @Param to query string false "Events before the time" example(2022-03-01T00:00:00Z)
+// @Param actor query string false "Id of the user who made changes"
+// @Param target query string false "Id of the changed user"
+// @Param limit query int false "Page size" minimum(1) maximum(500) default(50)
+// @Param cursor query string false "Page cursor"
+// @Success 200 {object} EventPage
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /audit [get]
+func (h *Handler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LIST AUDIT EVENTS")
+
+	input, ok := h.readFilter(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := h.service.List(r.Context(), input)
+	if err != nil {
+		if errors.Is(err, apperror.ErrInvalidCursor) {
+			h.JSON(w, http.StatusBadRequest, apperror.BadRequestError(err.Error(), "please, pass nextCursor of the previous page"))
+			return
+		}
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+		return
+	}
+
+	h.JSON(w, http.StatusOK, page)
+}
+
+// Export godoc
+// @Summary Export audit log
+// @Description Download all audit events matching filters as JSON lines, oldest first. Available for admins only.
+// @Tags audit
+// @Produce json
+// @Param from query string false "Events at or after the time" example(2022-02-01T00:00:00Z)
+// @Param to query string false "Events before the time" example(2022-03-01T00:00:00Z)
+// @Param actor query string false "Id of the user who made changes"
+// @Param target query string false "Id of the changed user"
+// @Success 200 {string} string "JSON lines of AuditEvent"
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /audit/export [get]
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("EXPORT AUDIT EVENTS")
+
+	input, ok := h.readFilter(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+	// Status is sent with the first event, so failure
+	// in the middle can only be logged.
+	if err := h.service.Export(r.Context(), input, w); err != nil {
+		h.logger.Errorf("failed to export audit events: %v", err)
+	}
+}
+
+// readFilter reads and validates audit log filters from query string.
+// Responses with Bad Request if filters are invalid.
+func (h *Handler) readFilter(w http.ResponseWriter, r *http.Request) (*ListEventsDTO, bool) {
+	query := r.URL.Query()
+	input := ListEventsDTO{
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+		Actor:  query.Get("actor"),
+		Target: query.Get("target"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		input.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			h.JSON(w, http.StatusBadRequest, apperror.BadRequestError("limit: must be an integer.", "input validation failed. please, provide valid values"))
+			return nil, false
+		}
+	}
+
+	if err := input.Validate(); err != nil {
+		h.JSON(w, http.StatusBadRequest, apperror.BadRequestError(err.Error(), "input validation failed. please, provide valid values"))
+		return nil, false
+	}
+
+	return &input, true
+}
+
+// JSON encodes to JSON format given data and sends a response
+// to the client with a given http code and encoded data.
+func (h *Handler) JSON(w http.ResponseWriter, code int, data interface{}) {
+	obj, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(obj)
+}
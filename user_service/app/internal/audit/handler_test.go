@@ -0,0 +1,372 @@
+package audit_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditmemory "github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/juicyluv/sueta/user_service/app/pkg/totp"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testAccessSecret = "access-secret"
+	testAdminID      = "62056f8cf21b83383a5ae7fa"
+)
+
+// testApp serves user and audit routes behind audit middleware.
+type testApp struct {
+	handler http.Handler
+	users   user.Service
+	tokens  *token.Manager
+}
+
+// NewTestApp returns user and audit handlers with in-memory storages.
+func NewTestApp(t *testing.T) *testApp {
+	logger.Init()
+	l := logger.GetLogger()
+
+	outbox, err := mail.NewOutbox(t.TempDir())
+	assert.NoError(t, err)
+
+	hasher, err := password.New(password.Params{
+		Algorithm:  password.Bcrypt,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	assert.NoError(t, err)
+
+	policy, err := password.NewPolicy(6, 24, "")
+	assert.NoError(t, err)
+
+	tokens := token.NewManager("sueta-test", testAccessSecret, "refresh-secret", map[token.Type]time.Duration{
+		token.Access:       time.Minute,
+		token.Refresh:      time.Hour,
+		token.Verification: time.Hour,
+	})
+
+	auditService := audit.NewService(auditmemory.NewStorage(), l)
+	tokenStorage := memory.NewTokenStorage()
+	sessionStorage := memory.NewSessionStorage()
+	apiKeyStorage := memory.NewAPIKeyStorage()
+	users := user.NewService(memory.NewStorage(), tokenStorage, sessionStorage, apiKeyStorage, auditService, outbox, tokens, hasher, policy, l)
+
+	limiter := lockout.NewLimiter(lockout.NewMemoryStore(), "login:", lockout.Policy{
+		Window:      time.Minute,
+		Threshold:   10,
+		BaseDelay:   time.Second,
+		MaxAttempts: 10,
+		Lockout:     time.Minute,
+	})
+	authService := user.NewAuthService(users, tokenStorage, sessionStorage, apiKeyStorage, auditService, tokens, limiter, limiter, l)
+	authorizer := auth.NewMiddleware(testAccessSecret, authService)
+
+	router := httprouter.New()
+	user.NewHandler(l, users, authService, authorizer).Register(router)
+	audit.NewHandler(l, auditService, authorizer).Register(router)
+
+	return &testApp{
+		handler: audit.Middleware(router),
+		users:   users,
+		tokens:  tokens,
+	}
+}
+
+// serve sends a request with optional bearer token and request id.
+func (a *testApp) serve(t *testing.T, method, url, bearer, requestID string, body interface{}) *httptest.ResponseRecorder {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		assert.NoError(t, json.NewEncoder(buf).Encode(body))
+	}
+
+	req, err := http.NewRequest(method, url, buf)
+	assert.NoError(t, err)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	if requestID != "" {
+		req.Header.Set(audit.RequestIDHeader, requestID)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+
+	a.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// accessToken returns access token of the user with given id and role.
+func (a *testApp) accessToken(t *testing.T, id string, role auth.Role) string {
+	pair, err := a.tokens.NewPair(token.Identity{UUID: id, Email: "test@mail.com", Role: string(role)}, "refresh-id", "family-id")
+	assert.NoError(t, err)
+	return pair.AccessToken
+}
+
+// events queries audit log with given parameters as admin.
+func (a *testApp) events(t *testing.T, params url.Values) *audit.EventPage {
+	rec := a.serve(t, http.MethodGet, "/api/audit?"+params.Encode(), a.accessToken(t, testAdminID, auth.RoleAdmin), "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var page audit.EventPage
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	return &page
+}
+
+func TestHandler_AuditTrail(t *testing.T) {
+	app := NewTestApp(t)
+	start := time.Now().UTC().Add(-time.Second)
+
+	userID, err := app.users.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	userToken := app.accessToken(t, userID, auth.RoleUser)
+	oldPassword, newPassword := "qwerty", "qwerty2"
+	newEmail, sameUsername := "new@mail.com", "test"
+
+	rec := app.serve(t, http.MethodPatch, "/api/users/"+userID, userToken, "update-request", &user.UpdateUserDTO{
+		Email:       &newEmail,
+		Username:    &sameUsername,
+		OldPassword: &oldPassword,
+		NewPassword: &newPassword,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "update-request", rec.Header().Get(audit.RequestIDHeader))
+
+	adminToken := app.accessToken(t, testAdminID, auth.RoleAdmin)
+
+	rec = app.serve(t, http.MethodDelete, "/api/users/"+userID, adminToken, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	deleteRequestID := rec.Header().Get(audit.RequestIDHeader)
+	assert.NotEmpty(t, deleteRequestID, "request id is generated if missing")
+
+	// Failed changes aren't recorded.
+	rec = app.serve(t, http.MethodDelete, "/api/users/"+userID, adminToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	page := app.events(t, nil)
+	assert.Len(t, page.Events, 2)
+	assert.Empty(t, page.NextCursor)
+
+	update := page.Events[0]
+	assert.Equal(t, audit.ActionUserUpdate, update.Action)
+	assert.Equal(t, userID, update.Actor)
+	assert.Equal(t, userID, update.Target)
+	assert.Equal(t, []string{"password"}, update.Fields, "only names of changed fields are recorded")
+	assert.Equal(t, "192.0.2.1", update.IP)
+	assert.Equal(t, "update-request", update.RequestID)
+	assert.False(t, update.Time.Before(start))
+
+	deletion := page.Events[1]
+	assert.Equal(t, audit.ActionUserDelete, deletion.Action)
+	assert.Equal(t, testAdminID, deletion.Actor)
+	assert.Equal(t, userID, deletion.Target)
+	assert.Equal(t, deleteRequestID, deletion.RequestID)
+
+	// Values are never recorded.
+	raw := app.serve(t, http.MethodGet, "/api/audit", adminToken, "", nil).Body.String()
+	assert.NotContains(t, raw, newEmail)
+	assert.NotContains(t, raw, newPassword)
+
+	testCases := []struct {
+		name     string
+		params   url.Values
+		expected []audit.Action
+	}{
+		{"actor", url.Values{"actor": {testAdminID}}, []audit.Action{audit.ActionUserDelete}},
+		{"unknown actor", url.Values{"actor": {"unknown"}}, []audit.Action{}},
+		{"target", url.Values{"target": {userID}}, []audit.Action{audit.ActionUserUpdate, audit.ActionUserDelete}},
+		{"unknown target", url.Values{"target": {testAdminID}}, []audit.Action{}},
+		{"from", url.Values{"from": {start.Format(time.RFC3339)}}, []audit.Action{audit.ActionUserUpdate, audit.ActionUserDelete}},
+		{"future", url.Values{"from": {start.Add(time.Hour).Format(time.RFC3339)}}, []audit.Action{}},
+		{"to", url.Values{"to": {start.Format(time.RFC3339)}}, []audit.Action{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page := app.events(t, tc.params)
+
+			actions := make([]audit.Action, 0, len(page.Events))
+			for _, e := range page.Events {
+				actions = append(actions, e.Action)
+			}
+			assert.Equal(t, tc.expected, actions)
+		})
+	}
+
+	first := app.events(t, url.Values{"limit": {"1"}})
+	assert.Len(t, first.Events, 1)
+	assert.NotEmpty(t, first.NextCursor)
+
+	second := app.events(t, url.Values{"limit": {"1"}, "cursor": {first.NextCursor}})
+	assert.Equal(t, []audit.Event{deletion}, second.Events)
+	assert.Empty(t, second.NextCursor)
+}
+
+func TestHandler_SecurityEvents(t *testing.T) {
+	app := NewTestApp(t)
+
+	userID, err := app.users.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+	userURL := "/api/users/" + userID
+
+	verification, err := app.tokens.NewToken(token.Verification, userID, "test@mail.com", "")
+	assert.NoError(t, err)
+	rec := app.serve(t, http.MethodPost, userURL+"/verify", "", "", &user.VerifyEmailDTO{Token: verification})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.serve(t, http.MethodPost, "/api/auth/login", "", "", &user.LoginDTO{Email: "test@mail.com", Password: "qwerty"})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var login user.LoginResult
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&login))
+	userToken := login.AccessToken
+
+	rec = app.serve(t, http.MethodPost, userURL+"/2fa", userToken, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var enrollment user.TwoFactorEnrollment
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&enrollment))
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	rec = app.serve(t, http.MethodPost, userURL+"/2fa/confirm", userToken, "", &user.TwoFactorCodeDTO{Code: code})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var recovery user.RecoveryCodes
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&recovery))
+
+	rec = app.serve(t, http.MethodPost, userURL+"/2fa/disable", userToken, "", &user.DisableTwoFactorDTO{Password: "qwerty", Code: recovery.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.serve(t, http.MethodPost, userURL+"/keys", userToken, "", &user.CreateAPIKeyDTO{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: 1})
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var key user.CreatedAPIKey
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&key))
+
+	rec = app.serve(t, http.MethodDelete, userURL+"/keys/"+key.ID, userToken, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = app.serve(t, http.MethodGet, userURL+"/sessions", userToken, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var sessions []user.Session
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+	if assert.Len(t, sessions, 1) {
+		rec = app.serve(t, http.MethodDelete, userURL+"/sessions/"+sessions[0].ID, userToken, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	page := app.events(t, url.Values{"target": {userID}})
+	actions := make([]audit.Action, 0, len(page.Events))
+	for _, e := range page.Events {
+		// Email is verified by the link, so there's no signed in actor.
+		if e.Action != audit.ActionUserVerify {
+			assert.Equal(t, userID, e.Actor)
+		}
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []audit.Action{
+		audit.ActionUserVerify,
+		audit.ActionTwoFactorEnable,
+		audit.ActionTwoFactorDisable,
+		audit.ActionAPIKeyCreate,
+		audit.ActionAPIKeyRevoke,
+		audit.ActionSessionRevoke,
+	}, actions)
+
+	// Secrets are never recorded.
+	raw := app.serve(t, http.MethodGet, "/api/audit", app.accessToken(t, testAdminID, auth.RoleAdmin), "", nil).Body.String()
+	assert.NotContains(t, raw, key.Key)
+	assert.NotContains(t, raw, enrollment.Secret)
+}
+
+func TestHandler_Export(t *testing.T) {
+	app := NewTestApp(t)
+
+	for i := 0; i < 3; i++ {
+		id, err := app.users.Create(context.Background(), &user.CreateUserDTO{
+			Email:          "test" + string(rune('a'+i)) + "@mail.com",
+			Username:       "test" + string(rune('a'+i)),
+			Password:       "qwerty",
+			RepeatPassword: "qwerty",
+		})
+		assert.NoError(t, err)
+
+		rec := app.serve(t, http.MethodPatch, "/api/users/"+id+"/role", app.accessToken(t, testAdminID, auth.RoleAdmin), "", &user.SetRoleDTO{Role: auth.RoleModerator})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec := app.serve(t, http.MethodGet, "/api/audit/export?actor="+testAdminID, app.accessToken(t, testAdminID, auth.RoleAdmin), "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+	var events []audit.Event
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var e audit.Event
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	assert.Len(t, events, 3)
+	for _, e := range events {
+		assert.Equal(t, audit.ActionUserRole, e.Action)
+		assert.Equal(t, []string{"role"}, e.Fields)
+	}
+}
+
+func TestHandler_InvalidRequests(t *testing.T) {
+	app := NewTestApp(t)
+	adminToken := app.accessToken(t, testAdminID, auth.RoleAdmin)
+
+	testCases := []struct {
+		name         string
+		url          string
+		bearer       string
+		expectedCode int
+	}{
+		{"no access token", "/api/audit", "", http.StatusUnauthorized},
+		{"not admin", "/api/audit", app.accessToken(t, "62056f8cf21b83383a5ae7fb", auth.RoleUser), http.StatusForbidden},
+		{"export not admin", "/api/audit/export", app.accessToken(t, "62056f8cf21b83383a5ae7fb", auth.RoleModerator), http.StatusForbidden},
+		{"invalid from", "/api/audit?from=2022-02-01", adminToken, http.StatusBadRequest},
+		{"invalid to", "/api/audit/export?to=yesterday", adminToken, http.StatusBadRequest},
+		{"invalid limit", "/api/audit?limit=many", adminToken, http.StatusBadRequest},
+		{"too large limit", "/api/audit?limit=501", adminToken, http.StatusBadRequest},
+		{"invalid cursor", "/api/audit?cursor=invalid", adminToken, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.serve(t, http.MethodGet, tc.url, tc.bearer, "", nil)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	// Invalid request ids are replaced.
+	rec := app.serve(t, http.MethodGet, "/api/audit", adminToken, "bad id\n", nil)
+	assert.NotEqual(t, "bad id\n", rec.Header().Get(audit.RequestIDHeader))
+	assert.NotEmpty(t, rec.Header().Get(audit.RequestIDHeader))
+}
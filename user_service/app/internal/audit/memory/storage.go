@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+)
+
+// Check whether storage implements audit storage interface.
+var _ audit.Storage = &storage{}
+
+// storage implements audit storage interface in memory.
+// It's suitable for a single instance and tests.
+type storage struct {
+	mu     sync.RWMutex
+	events []audit.Event
+}
+
+// NewStorage returns a new in-memory audit storage instance.
+func NewStorage() audit.Storage {
+	return &storage{}
+}
+
+// Append stores a copy of given event.
+func (s *storage) Append(ctx context.Context, event *audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := *event
+	e.Fields = append([]string(nil), event.Fields...)
+	s.events = append(s.events, e)
+	return nil
+}
+
+// List returns events matching given filter ordered by time and id.
+func (s *storage) List(ctx context.Context, filter *audit.Filter) ([]audit.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []audit.Event
+	for _, e := range s.events {
+		if matches(&e, filter) {
+			e.Fields = append([]string(nil), e.Fields...)
+			events = append(events, e)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return less(&events[i], events[j].Time, events[j].ID)
+	})
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+
+	return events, nil
+}
+
+// matches reports whether the event matches given filter.
+func matches(e *audit.Event, filter *audit.Filter) bool {
+	if !filter.From.IsZero() && e.Time.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !e.Time.Before(filter.To) {
+		return false
+	}
+	if filter.Actor != "" && e.Actor != filter.Actor {
+		return false
+	}
+	if filter.Target != "" && e.Target != filter.Target {
+		return false
+	}
+	if filter.After != nil && !less(&audit.Event{Time: filter.After.Time, ID: filter.After.ID}, e.Time, e.ID) {
+		return false
+	}
+	return true
+}
+
+// less reports whether the event goes before the event with given time and id.
+func less(e *audit.Event, t time.Time, id string) bool {
+	if !e.Time.Equal(t) {
+		return e.Time.Before(t)
+	}
+	return e.ID < id
+}
@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (audit.Storage, func() error) {
+		return memory.NewStorage(), func() error { return nil }
+	})
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const (
+	// DefaultListLimit is a page size used if limit is not specified.
+	DefaultListLimit = 50
+	// MaxListLimit is a maximum page size.
+	MaxListLimit = 500
+)
+
+// Action describes what has been done to the target.
+type Action string
+
+// Actions recorded in audit log.
+const (
+	ActionUserUpdate    Action = "user.update"
+	ActionUserDelete    Action = "user.delete"
+	ActionUserRestore   Action = "user.restore"
+	ActionUserRole      Action = "user.role"
+	ActionPasswordReset Action = "user.password_reset"
+	ActionUserVerify    Action = "user.verify"
+	ActionUserLock      Action = "user.lock"
+	ActionUserUnlock    Action = "user.unlock"
+	ActionUserImport    Action = "user.import"
+	// Security settings of the user.
+	ActionTwoFactorEnable  Action = "user.two_factor_enable"
+	ActionTwoFactorDisable Action = "user.two_factor_disable"
+	ActionAPIKeyCreate     Action = "user.api_key_create"
+	ActionAPIKeyRevoke     Action = "user.api_key_revoke"
+	ActionSessionRevoke    Action = "user.session_revoke"
+)
+
+// Event represents a single record of audit log. Fields contain names
+// of changed fields only, values are never recorded. Actor is empty
+// if the change has been made by an unauthenticated request.
+type Event struct {
+	ID        string    `json:"id" bson:"_id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	Time      time.Time `json:"time" bson:"time"`
+	Action    Action    `json:"action" bson:"action" example:"user.update"`
+	Actor     string    `json:"actorId,omitempty" bson:"actorId,omitempty" example:"62056f8cf21b83383a5ae7fa"`
+	Target    string    `json:"targetId" bson:"targetId" example:"62056f8cf21b83383a5ae7fa"`
+	Fields    []string  `json:"fields,omitempty" bson:"fields,omitempty" example:"email"`
+	IP        string    `json:"ip,omitempty" bson:"ip,omitempty" example:"127.0.0.1"`
+	RequestID string    `json:"requestId,omitempty" bson:"requestId,omitempty" example:"c0ffee254729296a45a3885639ac7b10"`
+} // @name AuditEvent
+
+// ListEventsDTO is used to query audit log page by page.
+// Times are in RFC 3339 format.
+type ListEventsDTO struct {
+	From   string
+	To     string
+	Actor  string
+	Target string
+	Limit  int
+	Cursor string
+}
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (l *ListEventsDTO) Validate() error {
+	return validation.ValidateStruct(
+		l,
+		validation.Field(&l.From, validation.Date(time.RFC3339)),
+		validation.Field(&l.To, validation.Date(time.RFC3339)),
+		validation.Field(&l.Limit, validation.Min(0), validation.Max(MaxListLimit)),
+	)
+}
+
+// Filter describes which events storage should list. Zero values mean
+// no filtering. From is inclusive and To is exclusive. Events are ordered
+// by time and then by id. If After is set, only events following
+// the cursor are listed.
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	Actor  string
+	Target string
+	After  *Cursor
+	Limit  int
+}
+
+// Cursor points to the last event of the previous page.
+type Cursor struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+// EventPage is a page of audit events.
+type EventPage struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"nextCursor,omitempty" example:"eyJ0IjoiMjAyMi0wMi0xMFQxMDowMDowMFoifQ"`
+} // @name AuditEventPage
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// RequestIDHeader is a header request id is read from and written to.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches request ids accepted from clients or proxies.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Request describes the request a change has been made by.
+type Request struct {
+	ID string
+	IP string
+}
+
+type requestKey struct{}
+
+// Middleware stores request id and client IP in the request context,
+// so they are recorded with audit events. Request id is taken from
+// X-Request-ID header if it's valid, otherwise a new one is generated.
+// The id is sent back in the same header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			generated, err := token.NewID()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			id = generated
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := ContextWithRequest(r.Context(), Request{ID: id, IP: auth.ClientIP(r)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ContextWithRequest returns a copy of ctx carrying given request.
+func ContextWithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// RequestFromContext returns request stored by Middleware.
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	request, ok := ctx.Value(requestKey{}).(Request)
+	return request, ok
+}
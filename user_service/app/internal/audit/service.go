@@ -0,0 +1,205 @@
+// Package audit implements append-only security audit log of changes
+// made to user accounts. Events record who changed what, but never
+// values of changed fields.
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// exportBatch is how many events are read from storage at once on export.
+const exportBatch = 500
+
+// Recorder records events to audit log.
+type Recorder interface {
+	Record(ctx context.Context, event *Event) error
+}
+
+// Service describes audit log functionality.
+type Service interface {
+	Recorder
+	List(ctx context.Context, input *ListEventsDTO) (*EventPage, error)
+	Export(ctx context.Context, input *ListEventsDTO, w io.Writer) error
+}
+
+type service struct {
+	logger  logger.Logger
+	storage Storage
+}
+
+// NewService returns a new instance that implements Service interface.
+func NewService(storage Storage, logger logger.Logger) Service {
+	return &service{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+// Record appends given event to audit log. Id and time of the event are
+// set here. Actor is taken from access token claims, request id and client
+// IP from the request stored by Middleware, unless they are set already.
+func (s *service) Record(ctx context.Context, event *Event) error {
+	suffix, err := token.NewID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	e := *event
+	// Ids start with the time in nanoseconds, so events recorded
+	// within the same millisecond keep their order.
+	e.ID = fmt.Sprintf("%016x%s", now.UnixNano(), suffix[:16])
+	// Stored times are truncated by databases, so cursors
+	// are built from times every storage keeps exactly.
+	e.Time = now.Truncate(time.Millisecond)
+
+	if claims, ok := auth.ClaimsFromContext(ctx); ok && e.Actor == "" {
+		e.Actor = claims.Subject
+	}
+
+	if request, ok := RequestFromContext(ctx); ok {
+		if e.RequestID == "" {
+			e.RequestID = request.ID
+		}
+		if e.IP == "" {
+			e.IP = request.IP
+		}
+	}
+
+	if err := s.storage.Append(ctx, &e); err != nil {
+		s.logger.Warnf("failed to append audit event: %v", err)
+		return err
+	}
+
+	*event = e
+	return nil
+}
+
+// List returns a page of audit events matching given filters,
+// oldest first. Returns Invalid Cursor error if cursor is malformed.
+func (s *service) List(ctx context.Context, input *ListEventsDTO) (*EventPage, error) {
+	filter, err := newFilter(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more event is requested to know whether there's a next page.
+	limit := filter.Limit
+	filter.Limit++
+
+	events, err := s.storage.List(ctx, filter)
+	if err != nil {
+		s.logger.Warnf("failed to list audit events: %v", err)
+		return nil, err
+	}
+
+	page := &EventPage{Events: events}
+
+	if len(events) > limit {
+		page.Events = events[:limit]
+		last := page.Events[limit-1]
+		page.NextCursor = encodeCursor(&Cursor{Time: last.Time, ID: last.ID})
+	}
+
+	if page.Events == nil {
+		page.Events = []Event{}
+	}
+
+	return page, nil
+}
+
+// Export writes all audit events matching given filters to w as JSON
+// lines, oldest first. Limit and cursor of the input are ignored.
+// Events are read in batches, so the whole log isn't kept in memory.
+func (s *service) Export(ctx context.Context, input *ListEventsDTO, w io.Writer) error {
+	filter, err := newFilter(&ListEventsDTO{From: input.From, To: input.To, Actor: input.Actor, Target: input.Target})
+	if err != nil {
+		return err
+	}
+	filter.Limit = exportBatch
+
+	enc := json.NewEncoder(w)
+	for {
+		events, err := s.storage.List(ctx, filter)
+		if err != nil {
+			s.logger.Warnf("failed to list audit events: %v", err)
+			return err
+		}
+
+		for i := range events {
+			if err := enc.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < exportBatch {
+			return nil
+		}
+
+		last := events[len(events)-1]
+		filter.After = &Cursor{Time: last.Time, ID: last.ID}
+	}
+}
+
+// newFilter converts validated input into storage filter.
+func newFilter(input *ListEventsDTO) (*Filter, error) {
+	filter := &Filter{
+		Actor:  input.Actor,
+		Target: input.Target,
+		Limit:  input.Limit,
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultListLimit
+	}
+
+	if input.From != "" {
+		filter.From, _ = time.Parse(time.RFC3339, input.From)
+	}
+
+	if input.To != "" {
+		filter.To, _ = time.Parse(time.RFC3339, input.To)
+	}
+
+	if input.Cursor != "" {
+		cursor, err := decodeCursor(input.Cursor)
+		if err != nil {
+			return nil, apperror.ErrInvalidCursor
+		}
+		filter.After = cursor
+	}
+
+	return filter, nil
+}
+
+// encodeCursor encodes given cursor into opaque string.
+func encodeCursor(cursor *Cursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes cursor encoded with encodeCursor.
+func decodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,11 @@
+package audit
+
+import "context"
+
+// Storage describes audit log storage functionality.
+// Audit log is append-only, so events can't be changed or deleted.
+type Storage interface {
+	Append(ctx context.Context, event *Event) error
+	// List returns events matching given filter ordered by time and id.
+	List(ctx context.Context, filter *Filter) ([]Event, error)
+}
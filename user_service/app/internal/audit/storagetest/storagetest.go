@@ -0,0 +1,156 @@
+// Package storagetest provides a contract test suite for audit.Storage
+// implementations, so every implementation behaves the same way.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewStorage returns a new empty storage and a function that cleans it up.
+type NewStorage func(t *testing.T) (audit.Storage, func() error)
+
+// Run runs the contract test suite against storages returned by newStorage.
+// Every test gets its own storage.
+func Run(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage audit.Storage)
+	}{
+		{"Append", testAppend},
+		{"ListFilters", testListFilters},
+		{"ListAfter", testListAfter},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+// baseTime is a time events are created at.
+var baseTime = time.Date(2022, 2, 10, 10, 0, 0, 0, time.UTC)
+
+// newEvent returns an event of given actor which happened i minutes after baseTime.
+func newEvent(i int, actor string) *audit.Event {
+	return &audit.Event{
+		ID:        fmt.Sprintf("event-%02d", i),
+		Time:      baseTime.Add(time.Duration(i) * time.Minute),
+		Action:    audit.ActionUserUpdate,
+		Actor:     actor,
+		Target:    "target",
+		Fields:    []string{"email", "password"},
+		IP:        "192.0.2.1",
+		RequestID: fmt.Sprintf("request-%d", i),
+	}
+}
+
+// appendEvents appends given events and fails the test on error.
+func appendEvents(t *testing.T, storage audit.Storage, events ...*audit.Event) {
+	for _, e := range events {
+		assert.NoError(t, storage.Append(context.Background(), e))
+	}
+}
+
+// ids returns ids of given events.
+func ids(events []audit.Event) []string {
+	result := make([]string, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.ID)
+	}
+	return result
+}
+
+func testAppend(t *testing.T, storage audit.Storage) {
+	event := newEvent(1, "actor")
+	appendEvents(t, storage, event)
+
+	// Stored event doesn't change with the appended one.
+	event.Fields[0] = "changed"
+
+	events, err := storage.List(context.Background(), &audit.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	expected := *newEvent(1, "actor")
+	assert.Equal(t, expected.ID, events[0].ID)
+	assert.True(t, expected.Time.Equal(events[0].Time))
+	assert.Equal(t, expected.Action, events[0].Action)
+	assert.Equal(t, expected.Actor, events[0].Actor)
+	assert.Equal(t, expected.Target, events[0].Target)
+	assert.Equal(t, expected.Fields, events[0].Fields)
+	assert.Equal(t, expected.IP, events[0].IP)
+	assert.Equal(t, expected.RequestID, events[0].RequestID)
+
+	anonymous := newEvent(2, "")
+	anonymous.Fields = nil
+	appendEvents(t, storage, anonymous)
+
+	events, err = storage.List(context.Background(), &audit.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Empty(t, events[1].Actor)
+	assert.Empty(t, events[1].Fields)
+}
+
+func testListFilters(t *testing.T, storage audit.Storage) {
+	// Events are appended out of order.
+	other := newEvent(2, "user")
+	other.Target = "other"
+	appendEvents(t, storage,
+		newEvent(3, "admin"),
+		newEvent(1, "admin"),
+		other,
+		newEvent(4, "user"),
+	)
+
+	testCases := []struct {
+		name     string
+		filter   audit.Filter
+		expected []string
+	}{
+		{"all", audit.Filter{}, []string{"event-01", "event-02", "event-03", "event-04"}},
+		{"from inclusive", audit.Filter{From: baseTime.Add(2 * time.Minute)}, []string{"event-02", "event-03", "event-04"}},
+		{"to exclusive", audit.Filter{To: baseTime.Add(3 * time.Minute)}, []string{"event-01", "event-02"}},
+		{"actor", audit.Filter{Actor: "admin"}, []string{"event-01", "event-03"}},
+		{"actor and range", audit.Filter{Actor: "user", From: baseTime.Add(3 * time.Minute)}, []string{"event-04"}},
+		{"limit", audit.Filter{Limit: 2}, []string{"event-01", "event-02"}},
+		{"unknown actor", audit.Filter{Actor: "unknown"}, []string{}},
+		{"target", audit.Filter{Target: "other"}, []string{"event-02"}},
+		{"actor and target", audit.Filter{Actor: "user", Target: "target"}, []string{"event-04"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := storage.List(context.Background(), &tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(events))
+		})
+	}
+}
+
+func testListAfter(t *testing.T, storage audit.Storage) {
+	// Events with the same time are ordered by id.
+	same := newEvent(1, "actor")
+	same.ID = "event-00"
+	appendEvents(t, storage, newEvent(2, "actor"), newEvent(1, "actor"), same)
+
+	events, err := storage.List(context.Background(), &audit.Filter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"event-00"}, ids(events))
+
+	after := &audit.Cursor{Time: events[0].Time, ID: events[0].ID}
+	events, err = storage.List(context.Background(), &audit.Filter{After: after})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"event-01", "event-02"}, ids(events))
+}
@@ -0,0 +1,69 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// PostClient requests content written by the user from post service.
+type PostClient interface {
+	UserContent(ctx context.Context, identity token.Identity) (*Content, error)
+}
+
+type postClient struct {
+	baseURL string
+	tokens  *token.Manager
+	client  *http.Client
+}
+
+// NewPostClient returns a new client of post service available at baseURL.
+// Requests are made with content export token issued for the user whose
+// content is requested. Post service accepts it only on the content route,
+// so it can't be used to act as the user if it leaks.
+func NewPostClient(baseURL string, tokens *token.Manager, timeout time.Duration) PostClient {
+	return &postClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		tokens:  tokens,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// UserContent returns posts and comments written by given user.
+// Returns an error if post service responded with unexpected status.
+func (c *postClient) UserContent(ctx context.Context, identity token.Identity) (*Content, error) {
+	exportToken, err := c.tokens.NewToken(token.ContentExport, identity.UUID, identity.Email, "")
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/users/%s/content", c.baseURL, url.PathEscape(identity.UUID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+exportToken)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot request post service: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("post service responded with status %d", res.StatusCode)
+	}
+
+	var content Content
+	if err := json.NewDecoder(res.Body).Decode(&content); err != nil {
+		return nil, fmt.Errorf("cannot decode post service response: %w", err)
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,168 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/handler"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	exportURL   = "/api/users/:uuid/export"
+	jobURL      = "/api/users/:uuid/export/:id"
+	downloadURL = "/api/users/:uuid/export/:id/download"
+)
+
+// Handler handles requests specified to personal data export.
+type Handler struct {
+	logger     logger.Logger
+	service    Service
+	authorizer *auth.Middleware
+}
+
+// NewHandler returns a new export Handler instance.
+func NewHandler(logger logger.Logger, service Service, authorizer *auth.Middleware) handler.Handling {
+	return &Handler{
+		logger:     logger,
+		service:    service,
+		authorizer: authorizer,
+	}
+}
+
+// Register registers new routes for router.
+// Users are allowed to export their own data only,
+// admins are allowed to export data of any user.
+func (h *Handler) Register(router *httprouter.Router) {
+	ownerOrAdmin := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}, Owner: auth.OwnerParam("uuid")}
+
+	router.HandlerFunc(http.MethodGet, exportURL, h.authorizer.Authorize(ownerOrAdmin, h.StartExport))
+	router.HandlerFunc(http.MethodGet, jobURL, h.authorizer.Authorize(ownerOrAdmin, h.GetExport))
+	router.HandlerFunc(http.MethodGet, downloadURL, h.authorizer.Authorize(ownerOrAdmin, h.DownloadExport))
+}
+
+// StartExport godoc
+// @Summary Export personal data
+// @Description Start export of everything stored about the user, including sessions, API keys, security settings, audit events, posts and comments. Secrets and hashes are left out. Export runs in background, poll the returned job until it's done and download the archive then. If an export of the user is in progress already, it's returned instead.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 202 {object} Job
+// @Header 202 {string} Location "URL of the job"
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/export [get]
+func (h *Handler) StartExport(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("START EXPORT")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	job, err := h.service.Start(r.Context(), params.ByName("uuid"))
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/users/%s/export/%s", job.UserUUID, job.ID))
+	h.JSON(w, http.StatusAccepted, job)
+}
+
+// GetExport godoc
+// @Summary Show export status
+// @Description Get personal data export job. Archive can be downloaded once status is done, until expiresAt.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param id path string true "Export job id"
+// @Success 200 {object} Job
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/export/{id} [get]
+func (h *Handler) GetExport(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("GET EXPORT")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	job, err := h.service.Get(r.Context(), params.ByName("uuid"), params.ByName("id"))
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+
+	h.JSON(w, http.StatusOK, job)
+}
+
+// DownloadExport godoc
+// @Summary Download exported data
+// @Description Download zip archive of JSON files with personal data of the user.
+// @Tags users
+// @Produce application/zip
+// @Param uuid path string true "User id"
+// @Param id path string true "Export job id"
+// @Success 200 {file} file "Zip archive"
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 409 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/export/{id}/download [get]
+func (h *Handler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("DOWNLOAD EXPORT")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	archive, err := h.service.Open(r.Context(), params.ByName("uuid"), params.ByName("id"))
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="personal-data.zip"`)
+
+	if _, err := io.Copy(w, archive); err != nil {
+		h.logger.Errorf("failed to send export archive: %v", err)
+	}
+}
+
+// error responses with status code matching given error.
+func (h *Handler) error(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, apperror.ErrNoRows):
+		h.JSON(w, http.StatusNotFound, apperror.ErrNotFound)
+	case errors.Is(err, apperror.ErrInvalidUUID):
+		h.JSON(w, http.StatusBadRequest, apperror.BadRequestError(err.Error(), ""))
+	case errors.Is(err, apperror.ErrExportNotReady):
+		h.JSON(w, http.StatusConflict, apperror.NewAppError(http.StatusConflict, err.Error(), "please, wait until export is done or start a new one"))
+	default:
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+	}
+}
+
+// JSON encodes to JSON format given data and sends a response
+// to the client with a given http code and encoded data.
+func (h *Handler) JSON(w http.ResponseWriter, code int, data interface{}) {
+	obj, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(obj)
+}
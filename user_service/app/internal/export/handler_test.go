@@ -0,0 +1,364 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditmemory "github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/export"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testAccessSecret = "access-secret"
+	testAdminID      = "62056f8cf21b83383a5ae7fa"
+	testOtherID      = "62056f8cf21b83383a5ae7fb"
+	testPosts        = `[{"id":"1","title":"hello","userId":"%s"}]`
+)
+
+// testApp serves export routes with post service replaced by a fake.
+type testApp struct {
+	router   *httprouter.Router
+	service  export.Service
+	storage  user.Storage
+	sessions user.SessionStorage
+	users    user.Service
+	auth     user.AuthService
+	tokens   *token.Manager
+	userID   string
+}
+
+// postServer returns a fake post service responding with given status.
+// Requests wait until release is closed. Content export token must be
+// issued for the user whose content is requested.
+func postServer(t *testing.T, status int, release <-chan struct{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+
+		claims, err := token.Verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), testAccessSecret, token.ContentExport)
+		if err != nil || r.URL.Path != "/api/users/"+claims.Subject+"/content" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(`{"posts":` + strings.Replace(testPosts, "%s", claims.Subject, 1) + `,"comments":null}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// NewTestApp returns export handler with in-memory user storage
+// and one registered user.
+func NewTestApp(t *testing.T, postURL string, ttl time.Duration) *testApp {
+	logger.Init()
+	l := logger.GetLogger()
+
+	outbox, err := mail.NewOutbox(t.TempDir())
+	assert.NoError(t, err)
+
+	hasher, err := password.New(password.Params{
+		Algorithm:  password.Bcrypt,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	assert.NoError(t, err)
+
+	policy, err := password.NewPolicy(6, 24, "")
+	assert.NoError(t, err)
+
+	tokens := token.NewManager("sueta-test", testAccessSecret, "refresh-secret", map[token.Type]time.Duration{
+		token.Access:        time.Minute,
+		token.Refresh:       time.Hour,
+		token.Verification:  time.Hour,
+		token.ContentExport: time.Minute,
+	})
+
+	storage, tokenStorage, sessionStorage := memory.NewStorage(), memory.NewTokenStorage(), memory.NewSessionStorage()
+	apiKeyStorage := memory.NewAPIKeyStorage()
+	auditor := audit.NewService(auditmemory.NewStorage(), l)
+	users := user.NewService(storage, tokenStorage, sessionStorage, apiKeyStorage, auditor, outbox, tokens, hasher, policy, l)
+
+	limiter := lockout.NewLimiter(lockout.NewMemoryStore(), "login:", lockout.Policy{
+		Window:      time.Minute,
+		Threshold:   10,
+		BaseDelay:   time.Second,
+		MaxAttempts: 10,
+		Lockout:     time.Minute,
+	})
+	authService := user.NewAuthService(users, tokenStorage, sessionStorage, apiKeyStorage, auditor, tokens, limiter, limiter, l)
+
+	userID, err := users.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	store, err := export.NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	service := export.NewService(store, users, authService, auditor, export.NewPostClient(postURL, tokens, time.Minute), export.Params{
+		TTL:     ttl,
+		Timeout: time.Minute,
+	}, l)
+
+	router := httprouter.New()
+	export.NewHandler(l, service, auth.NewMiddleware(testAccessSecret, nil)).Register(router)
+
+	return &testApp{
+		router:   router,
+		service:  service,
+		storage:  storage,
+		sessions: sessionStorage,
+		users:    users,
+		auth:     authService,
+		tokens:   tokens,
+		userID:   userID,
+	}
+}
+
+// get sends GET request with access token of the user with given id and role.
+func (a *testApp) get(t *testing.T, url, id string, role auth.Role) *httptest.ResponseRecorder {
+	pair, err := a.tokens.NewPair(token.Identity{UUID: id, Email: "test@mail.com", Role: string(role)}, "refresh-id", "family-id")
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	rec := httptest.NewRecorder()
+
+	a.router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// start starts export as the user and returns the job.
+func (a *testApp) start(t *testing.T) *export.Job {
+	rec := a.get(t, "/api/users/"+a.userID+"/export", a.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	var job export.Job
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+	assert.Equal(t, "/api/users/"+a.userID+"/export/"+job.ID, rec.Header().Get("Location"))
+	return &job
+}
+
+// wait polls the job until it's no longer in progress.
+func (a *testApp) wait(t *testing.T, id string) *export.Job {
+	var job *export.Job
+	assert.Eventually(t, func() bool {
+		var err error
+		job, err = a.service.Get(context.Background(), a.userID, id)
+		return err == nil && job.Status != export.StatusPending && job.Status != export.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+// seedSecurity gives the user of the app a session, an API key,
+// two-factor enrollment, pending email change and an audit event.
+// Returns secrets which must not be exported.
+func (a *testApp) seedSecurity(t *testing.T) []string {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	assert.NoError(t, a.sessions.Create(ctx, &user.Session{
+		ID:         "session-id",
+		UserUUID:   a.userID,
+		UserAgent:  "test-agent",
+		IP:         "192.0.2.1",
+		ExpiresAt:  now.Add(time.Hour),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}))
+
+	key, err := a.auth.CreateAPIKey(ctx, &user.CreateAPIKeyDTO{UUID: a.userID, Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: 1})
+	assert.NoError(t, err)
+
+	enrollment, err := a.users.EnrollTwoFactor(ctx, a.userID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.storage.SetPendingEmail(ctx, a.userID, &user.PendingEmail{
+		Email:     "pending@mail.com",
+		ID:        "pending-change-id",
+		ExpiresAt: now.Add(time.Hour),
+	}))
+
+	assert.NoError(t, a.users.ForceVerify(ctx, a.userID))
+
+	return []string{key.Key, key.Hash, enrollment.Secret, "pending-change-id"}
+}
+
+func TestHandler_Export(t *testing.T) {
+	release := make(chan struct{})
+	app := NewTestApp(t, postServer(t, http.StatusOK, release).URL, time.Hour)
+	secrets := app.seedSecurity(t)
+
+	job := app.start(t)
+	assert.Equal(t, app.userID, job.UserUUID)
+	assert.Equal(t, export.StatusPending, job.Status)
+
+	// Export in progress is returned instead of starting another one.
+	assert.Equal(t, job.ID, app.start(t).ID)
+
+	jobURL := "/api/users/" + app.userID + "/export/" + job.ID
+
+	rec := app.get(t, jobURL+"/download", app.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	close(release)
+	done := app.wait(t, job.ID)
+	assert.Equal(t, export.StatusDone, done.Status)
+	if assert.NotNil(t, done.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *done.ExpiresAt, time.Minute)
+	}
+
+	rec = app.get(t, jobURL, testAdminID, auth.RoleAdmin)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = app.get(t, jobURL+"/download", app.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	assert.NoError(t, err)
+
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		files[f.Name], err = io.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+	}
+
+	var exported user.User
+	assert.NoError(t, json.Unmarshal(files["user.json"], &exported))
+	assert.Equal(t, app.userID, exported.UUID)
+	assert.Equal(t, "test@mail.com", exported.Email)
+	assert.NotContains(t, string(files["user.json"]), "password")
+
+	var posts []map[string]string
+	assert.NoError(t, json.Unmarshal(files["posts.json"], &posts))
+	if assert.Len(t, posts, 1) {
+		assert.Equal(t, "hello", posts[0]["title"])
+		assert.Equal(t, app.userID, posts[0]["userId"])
+	}
+
+	assert.JSONEq(t, "[]", string(files["comments.json"]))
+
+	var security export.Security
+	assert.NoError(t, json.Unmarshal(files["security.json"], &security))
+	assert.Equal(t, export.TwoFactor{Enrolled: true}, security.TwoFactor)
+	if assert.NotNil(t, security.PendingEmail) {
+		assert.Equal(t, "pending@mail.com", security.PendingEmail.Email)
+	}
+
+	var sessions []user.Session
+	assert.NoError(t, json.Unmarshal(files["sessions.json"], &sessions))
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "test-agent", sessions[0].UserAgent)
+	}
+
+	var keys []user.APIKey
+	assert.NoError(t, json.Unmarshal(files["api_keys.json"], &keys))
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "ci", keys[0].Name)
+	}
+
+	var events []audit.Event
+	assert.NoError(t, json.Unmarshal(files["audit_events.json"], &events))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, audit.ActionAPIKeyCreate, events[0].Action)
+		assert.Equal(t, audit.ActionUserVerify, events[1].Action)
+		for _, event := range events {
+			assert.Equal(t, app.userID, event.Target)
+		}
+	}
+
+	// Secrets and hashes are redacted.
+	for name, data := range files {
+		for _, secret := range secrets {
+			assert.NotContains(t, string(data), secret, name)
+		}
+	}
+
+	// A new export is started once the previous one is done.
+	next := app.start(t)
+	assert.NotEqual(t, job.ID, next.ID)
+	app.wait(t, next.ID)
+
+	testCases := []struct {
+		name         string
+		url          string
+		id           string
+		role         auth.Role
+		expectedCode int
+	}{
+		{"other user", "/api/users/" + app.userID + "/export", testOtherID, auth.RoleUser, http.StatusForbidden},
+		{"other user job", jobURL, testOtherID, auth.RoleModerator, http.StatusForbidden},
+		{"job of another user", "/api/users/" + testOtherID + "/export/" + job.ID, testOtherID, auth.RoleUser, http.StatusNotFound},
+		{"unknown job", "/api/users/" + app.userID + "/export/9f86d081884c7d659a2feaa0c55ad015", app.userID, auth.RoleUser, http.StatusNotFound},
+		{"malformed job id", "/api/users/" + app.userID + "/export/..%2f..%2fsecret", app.userID, auth.RoleUser, http.StatusNotFound},
+		{"unknown user", "/api/users/" + testOtherID + "/export", testAdminID, auth.RoleAdmin, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := app.get(t, tc.url, tc.id, tc.role)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestHandler_ExportFailed(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	app := NewTestApp(t, postServer(t, http.StatusInternalServerError, release).URL, time.Hour)
+
+	job := app.wait(t, app.start(t).ID)
+	assert.Equal(t, export.StatusFailed, job.Status)
+	assert.Contains(t, job.Error, "500")
+
+	rec := app.get(t, "/api/users/"+app.userID+"/export/"+job.ID+"/download", app.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestService_RemoveExpired(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	app := NewTestApp(t, postServer(t, http.StatusOK, release).URL, 0)
+
+	job := app.wait(t, app.start(t).ID)
+	assert.Equal(t, export.StatusExpired, job.Status, "archive expires right away")
+
+	rec := app.get(t, "/api/users/"+app.userID+"/export/"+job.ID+"/download", app.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	removed, err := app.service.RemoveExpired(context.Background(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	rec = app.get(t, "/api/users/"+app.userID+"/export/"+job.ID, app.userID, auth.RoleUser)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
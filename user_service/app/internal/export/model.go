@@ -0,0 +1,93 @@
+package export
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+)
+
+// Status describes a stage of export job.
+type Status string
+
+const (
+	// StatusPending is a status of the job waiting to be run.
+	StatusPending Status = "pending"
+	// StatusRunning is a status of the job collecting data.
+	StatusRunning Status = "running"
+	// StatusDone is a status of the job whose archive can be downloaded.
+	StatusDone Status = "done"
+	// StatusFailed is a status of the job which couldn't collect data.
+	StatusFailed Status = "failed"
+	// StatusExpired is a status of the done job whose archive has been removed.
+	StatusExpired Status = "expired"
+)
+
+// Job is an export of everything stored about the user. The archive
+// can be downloaded once the job is done, until it expires.
+type Job struct {
+	ID          string     `json:"id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	UserUUID    string     `json:"userId" example:"6205151b67f8792099abb78e"`
+	Status      Status     `json:"status" example:"done"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+} // @name ExportJob
+
+// inProgress reports whether the job is still collecting data.
+func (j *Job) inProgress() bool {
+	return j.Status == StatusPending || j.Status == StatusRunning
+}
+
+// expired reports whether the archive of the job has expired at given time.
+func (j *Job) expired(now time.Time) bool {
+	return j.ExpiresAt != nil && !now.Before(*j.ExpiresAt)
+}
+
+// Security is the state of security settings of the user. Secrets,
+// hashes of recovery codes and ids carried by confirmation tokens
+// are left out, so the archive can't be used to take over the account.
+type Security struct {
+	TwoFactor    TwoFactor     `json:"twoFactor"`
+	PendingEmail *PendingEmail `json:"pendingEmail"`
+}
+
+// TwoFactor is the state of two-factor authentication of the user.
+type TwoFactor struct {
+	Enrolled          bool `json:"enrolled"`
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// PendingEmail is a requested email change waiting for confirmation.
+type PendingEmail struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// newSecurity returns security settings of given user.
+func newSecurity(u *user.User) *Security {
+	security := &Security{}
+	if u.TwoFactor != nil {
+		security.TwoFactor = TwoFactor{
+			Enrolled:          true,
+			Enabled:           u.TwoFactor.Enabled,
+			RecoveryCodesLeft: len(u.TwoFactor.RecoveryCodes),
+		}
+	}
+	if u.PendingEmail != nil {
+		security.PendingEmail = &PendingEmail{
+			Email:     u.PendingEmail.Email,
+			ExpiresAt: u.PendingEmail.ExpiresAt,
+		}
+	}
+	return security
+}
+
+// Content is everything the user has written in post service.
+// It's exported as is, so user service doesn't depend on post models.
+type Content struct {
+	Posts    json.RawMessage `json:"posts"`
+	Comments json.RawMessage `json:"comments"`
+}
@@ -0,0 +1,336 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// Service describes personal data export functionality.
+type Service interface {
+	Start(ctx context.Context, uuid string) (*Job, error)
+	Get(ctx context.Context, uuid, id string) (*Job, error)
+	Open(ctx context.Context, uuid, id string) (io.ReadCloser, error)
+	RemoveExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// Params configures export jobs. Archives are kept for TTL
+// and the job fails if it isn't done within Timeout.
+type Params struct {
+	TTL     time.Duration
+	Timeout time.Duration
+}
+
+type service struct {
+	logger  logger.Logger
+	store   *Store
+	users   user.Service
+	auth    user.AuthService
+	auditor audit.Service
+	posts   PostClient
+	params  Params
+
+	// mu serializes starting of jobs, so the user
+	// has at most one job in progress.
+	mu sync.Mutex
+}
+
+// NewService returns a new instance that implements Service interface.
+// Sessions and API keys are read through auth, events targeting the user
+// through auditor.
+func NewService(store *Store, users user.Service, auth user.AuthService, auditor audit.Service, posts PostClient, params Params, logger logger.Logger) Service {
+	return &service{
+		logger:  logger,
+		store:   store,
+		users:   users,
+		auth:    auth,
+		auditor: auditor,
+		posts:   posts,
+		params:  params,
+	}
+}
+
+// Start starts export of personal data of the user with given uuid
+// in background and returns the job to poll. If the user already has
+// a job in progress, the job is returned instead of starting another one.
+// Returns No Rows error if there's no user with given uuid.
+func (s *service) Start(ctx context.Context, uuid string) (*Job, error) {
+	if _, err := s.users.GetById(ctx, uuid); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for i := range jobs {
+		// Jobs of a stopped server are in progress forever, so they're ignored after timeout.
+		if jobs[i].UserUUID == uuid && jobs[i].inProgress() && now.Sub(jobs[i].CreatedAt) < s.params.Timeout {
+			return &jobs[i], nil
+		}
+	}
+
+	id, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        id,
+		UserUUID:  uuid,
+		Status:    StatusPending,
+		CreatedAt: now,
+	}
+	if err := s.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	started := *job
+	go s.run(job)
+
+	return &started, nil
+}
+
+// Get returns the job with given id of the user with given uuid.
+// Done job is reported as expired once its archive has expired.
+// Returns No Rows error if there's no such job.
+func (s *service) Get(ctx context.Context, uuid, id string) (*Job, error) {
+	job, err := s.store.Find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.UserUUID != uuid {
+		return nil, apperror.ErrNoRows
+	}
+
+	if job.Status == StatusDone && job.expired(time.Now()) {
+		job.Status = StatusExpired
+	}
+
+	return job, nil
+}
+
+// Open opens the archive of the job with given id of the user with given uuid.
+// Returns Export Not Ready error if the job isn't done or has expired.
+func (s *service) Open(ctx context.Context, uuid, id string) (io.ReadCloser, error) {
+	job, err := s.Get(ctx, uuid, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status != StatusDone {
+		return nil, apperror.ErrExportNotReady
+	}
+
+	return s.store.OpenArchive(job.ID)
+}
+
+// RemoveExpired removes jobs with archives expired at given time
+// and failed jobs older than archive lifetime. Returns number of
+// removed jobs.
+func (s *service) RemoveExpired(ctx context.Context, now time.Time) (int, error) {
+	jobs, err := s.store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, job := range jobs {
+		stale := job.Status == StatusFailed && now.Sub(job.CreatedAt) >= s.params.TTL
+		if !job.expired(now) && !stale {
+			continue
+		}
+
+		if err := s.store.Remove(job.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// RunCleanup removes expired exports every interval until ctx is done.
+// The first cleanup runs immediately.
+func RunCleanup(ctx context.Context, service Service, interval time.Duration, logger logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := service.RemoveExpired(ctx, time.Now())
+		if err != nil {
+			logger.Errorf("failed to remove expired exports: %v", err)
+		} else if removed > 0 {
+			logger.Infof("removed %d expired exports", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run collects data of the job and writes the archive.
+// The job is saved as done or failed when it's over.
+func (s *service) run(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.params.Timeout)
+	defer cancel()
+
+	job.Status = StatusRunning
+	if err := s.store.Save(job); err != nil {
+		s.logger.Warnf("failed to save export job: %v", err)
+	}
+
+	err := s.store.WriteArchive(job.ID, func(w io.Writer) error {
+		return s.writeArchive(ctx, job.UserUUID, w)
+	})
+
+	now := time.Now().UTC()
+	if err != nil {
+		s.logger.Errorf("failed to export data of user %s: %v", job.UserUUID, err)
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		expiresAt := now.Add(s.params.TTL)
+		job.Status = StatusDone
+		job.CompletedAt = &now
+		job.ExpiresAt = &expiresAt
+	}
+
+	if err := s.store.Save(job); err != nil {
+		s.logger.Errorf("failed to save export job: %v", err)
+	}
+}
+
+// writeArchive writes zip archive with the user document, security
+// settings, sessions, API keys and audit events of the user and content
+// written by the user in post service. Secrets and hashes are left out
+// by JSON encoding of the models.
+func (s *service) writeArchive(ctx context.Context, uuid string, w io.Writer) error {
+	u, err := s.users.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	userJSON, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("cannot encode user: %w", err)
+	}
+
+	securityJSON, err := json.Marshal(newSecurity(u))
+	if err != nil {
+		return fmt.Errorf("cannot encode security settings: %w", err)
+	}
+
+	sessions, err := s.auth.ListSessions(ctx, u.UUID, "")
+	if err != nil {
+		return err
+	}
+	sessionsJSON, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("cannot encode sessions: %w", err)
+	}
+
+	keys, err := s.auth.ListAPIKeys(ctx, u.UUID)
+	if err != nil {
+		return err
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("cannot encode API keys: %w", err)
+	}
+
+	eventsJSON, err := s.auditEvents(ctx, u.UUID)
+	if err != nil {
+		return err
+	}
+
+	content, err := s.posts.UserContent(ctx, token.Identity{UUID: u.UUID, Email: u.Email, Role: string(u.Role)})
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"user.json", userJSON},
+		{"security.json", securityJSON},
+		{"sessions.json", sessionsJSON},
+		{"api_keys.json", keysJSON},
+		{"audit_events.json", eventsJSON},
+		{"posts.json", content.Posts},
+		{"comments.json", content.Comments},
+	}
+
+	for _, file := range files {
+		if err := writeJSONFile(archive, file.name, file.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// auditEvents returns JSON list of audit events targeting the user
+// with given uuid, oldest first.
+func (s *service) auditEvents(ctx context.Context, uuid string) ([]byte, error) {
+	var lines bytes.Buffer
+	if err := s.auditor.Export(ctx, &audit.ListEventsDTO{Target: uuid}, &lines); err != nil {
+		return nil, err
+	}
+
+	events := []json.RawMessage{}
+	decoder := json.NewDecoder(&lines)
+	for decoder.More() {
+		var event json.RawMessage
+		if err := decoder.Decode(&event); err != nil {
+			return nil, fmt.Errorf("cannot decode audit events: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	return json.Marshal(events)
+}
+
+// writeJSONFile adds a file with given indented JSON to the archive.
+// Missing data is written as an empty list.
+func writeJSONFile(archive *zip.Writer, name string, data []byte) error {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		data = []byte("[]")
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return fmt.Errorf("cannot format %s: %w", name, err)
+	}
+	indented.WriteByte('\n')
+
+	f, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("cannot add %s to archive: %w", name, err)
+	}
+
+	_, err = f.Write(indented.Bytes())
+	return err
+}
@@ -0,0 +1,162 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// idPattern matches ids of export jobs, so ids taken
+// from requests can't point outside of store directory.
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Store keeps export jobs and their archives in a local directory.
+// Job is saved as <id>.json next to <id>.zip archive, so jobs and
+// archives survive restarts. Files are written to temporary files
+// and renamed, so readers never see them half written.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a new Store keeping files in given directory.
+// The directory is created if it doesn't exist.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create export directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save creates or replaces given job.
+func (s *Store) Save(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("cannot encode export job: %w", err)
+	}
+
+	return s.write(s.path(job.ID, ".json"), func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// Find returns the job with given id.
+// Returns No Rows error if there's no such job.
+func (s *Store) Find(id string) (*Job, error) {
+	if !idPattern.MatchString(id) {
+		return nil, apperror.ErrNoRows
+	}
+
+	b, err := os.ReadFile(s.path(id, ".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("cannot read export job: %w", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, fmt.Errorf("cannot decode export job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// List returns all stored jobs.
+func (s *Store) List() ([]Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read export directory: %w", err)
+	}
+
+	var jobs []Job
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !idPattern.MatchString(id) || id == entry.Name() {
+			continue
+		}
+
+		job, err := s.Find(id)
+		if err != nil {
+			// Job might have been removed in the meantime.
+			if errors.Is(err, apperror.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// WriteArchive writes the archive of the job with given id
+// by calling write. The archive is kept only if write succeeds.
+func (s *Store) WriteArchive(id string, write func(w io.Writer) error) error {
+	return s.write(s.path(id, ".zip"), write)
+}
+
+// OpenArchive opens the archive of the job with given id.
+// Returns No Rows error if there's no such archive.
+func (s *Store) OpenArchive(id string) (*os.File, error) {
+	if !idPattern.MatchString(id) {
+		return nil, apperror.ErrNoRows
+	}
+
+	f, err := os.Open(s.path(id, ".zip"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("cannot open export archive: %w", err)
+	}
+
+	return f, nil
+}
+
+// Remove removes the job with given id and its archive.
+func (s *Store) Remove(id string) error {
+	for _, ext := range []string{".zip", ".json"} {
+		if err := os.Remove(s.path(id, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot remove export file: %w", err)
+		}
+	}
+	return nil
+}
+
+// path returns path of the file of the job with given id and extension.
+func (s *Store) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// write writes a file at given path by calling write on a temporary
+// file, which is renamed on success and removed on failure.
+func (s *Store) write(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create export file: %w", err)
+	}
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
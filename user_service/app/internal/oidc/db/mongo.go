@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check whether db implements OpenID Connect storage interface.
+var _ oidc.Storage = &db{}
+
+// Collections contains names of collections OpenID Connect data is stored in.
+type Collections struct {
+	Clients string
+	Codes   string
+	Keys    string
+}
+
+// db implementes OpenID Connect storage interface.
+type db struct {
+	logger  logger.Logger
+	clients *mongo.Collection
+	codes   *mongo.Collection
+	keys    *mongo.Collection
+}
+
+// NewStorage returns a new OpenID Connect storage instance.
+func NewStorage(storage *mongo.Database, collections Collections) oidc.Storage {
+	return &db{
+		logger:  logger.GetLogger(),
+		clients: storage.Collection(collections.Clients),
+		codes:   storage.Collection(collections.Codes),
+		keys:    storage.Collection(collections.Keys),
+	}
+}
+
+// CreateClient inserts a new client in the database.
+// Returns an error on failure.
+func (d *db) CreateClient(ctx context.Context, client *oidc.Client) error {
+	if _, err := d.clients.InsertOne(ctx, client); err != nil {
+		e := fmt.Errorf("cannot insert client in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindClient finds the client by given id.
+// Returns No Rows error if there's no client with given id.
+func (d *db) FindClient(ctx context.Context, id string) (*oidc.Client, error) {
+	result := d.clients.FindOne(ctx, bson.M{"_id": id})
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var client oidc.Client
+	if err := result.Decode(&client); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	return &client, nil
+}
+
+// CreateCode inserts a new authorization code in the database.
+// Returns an error on failure.
+func (d *db) CreateCode(ctx context.Context, code *oidc.AuthCode) error {
+	if _, err := d.codes.InsertOne(ctx, code); err != nil {
+		return fmt.Errorf("cannot insert authorization code in database: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeCode finds and deletes the code with given hash in one operation,
+// so concurrent requests can't use the same code.
+// Returns No Rows error if there's no code with given hash.
+func (d *db) ConsumeCode(ctx context.Context, hash string) (*oidc.AuthCode, error) {
+	result := d.codes.FindOneAndDelete(ctx, bson.M{"_id": hash})
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var code oidc.AuthCode
+	if err := result.Decode(&code); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredCodes deletes codes expired before given time.
+// Returns number of deleted codes.
+func (d *db) DeleteExpiredCodes(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.codes.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete expired authorization codes: %w", err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+// CreateKey inserts a new signing key in the database.
+// Returns an error on failure.
+func (d *db) CreateKey(ctx context.Context, key *oidc.SigningKey) error {
+	if _, err := d.keys.InsertOne(ctx, key); err != nil {
+		return fmt.Errorf("cannot insert signing key in database: %w", err)
+	}
+
+	return nil
+}
+
+// ListKeys returns signing keys, newest first.
+func (d *db) ListKeys(ctx context.Context) ([]oidc.SigningKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := d.keys.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var keys []oidc.SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteKeys deletes keys created before given time.
+// Returns number of deleted keys.
+func (d *db) DeleteKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.keys.DeleteMany(ctx, bson.M{"createdAt": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete signing keys: %w", err)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,55 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/storagetest"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+)
+
+// TestStorage runs storage contract tests against MongoDB
+// available at MONGO_URL. Skipped if it's not set.
+func TestStorage(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+
+	logger.Init()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	database, err := mongo.NewMongoClient(ctx, "sueta_test", mongoURL)
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %v", err)
+	}
+	defer database.Client().Disconnect(context.Background())
+
+	storagetest.Run(t, func(t *testing.T) (oidc.Storage, func() error) {
+		suffix := time.Now().UnixNano()
+		collections := db.Collections{
+			Clients: fmt.Sprintf("oidc_clients_%d", suffix),
+			Codes:   fmt.Sprintf("oidc_codes_%d", suffix),
+			Keys:    fmt.Sprintf("oidc_keys_%d", suffix),
+		}
+
+		teardown := func() error {
+			for _, name := range []string{collections.Clients, collections.Codes, collections.Keys} {
+				if err := database.Collection(name).Drop(context.Background()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		return db.NewStorage(database, collections), teardown
+	})
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+)
+
+// schema creates OpenID Connect tables if they don't exist.
+//
+//go:embed postgres.sql
+var schema string
+
+// Check whether postgresDB implements OpenID Connect storage interface.
+var _ oidc.Storage = &postgresDB{}
+
+// postgresDB implements OpenID Connect storage interface on postgres.
+type postgresDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresStorage returns a new postgres OpenID Connect storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresStorage(pool *pgx.ConnPool) oidc.Storage {
+	return &postgresDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+// MigratePostgres creates OpenID Connect tables if they don't exist.
+func MigratePostgres(ctx context.Context, pool *pgx.ConnPool) error {
+	if _, err := pool.ExecEx(ctx, schema, nil); err != nil {
+		return fmt.Errorf("cannot migrate oidc schema: %w", err)
+	}
+	return nil
+}
+
+// CheckPostgres reports OpenID Connect tables which are missing
+// without changing anything.
+func CheckPostgres(ctx context.Context, pool *pgx.ConnPool) ([]string, error) {
+	missing, err := postgres.MissingTables(ctx, pool, "oidc_clients", "oidc_codes", "oidc_keys")
+	if err != nil {
+		return nil, err
+	}
+
+	drift := make([]string, len(missing))
+	for i, table := range missing {
+		drift[i] = fmt.Sprintf("table %s missing", table)
+	}
+	return drift, nil
+}
+
+// CreateClient inserts a new client row.
+// Returns an error on failure.
+func (d *postgresDB) CreateClient(ctx context.Context, client *oidc.Client) error {
+	query := `
+		INSERT INTO oidc_clients (id, name, secret_hash, redirect_uris, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		client.RedirectURIs,
+		client.CreatedAt,
+	)
+	if err != nil {
+		e := fmt.Errorf("cannot insert client in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindClient finds the client by given id.
+// Returns No Rows error if there's no client with given id.
+func (d *postgresDB) FindClient(ctx context.Context, id string) (*oidc.Client, error) {
+	query := `SELECT id, name, secret_hash, redirect_uris, created_at FROM oidc_clients WHERE id = $1`
+
+	var client oidc.Client
+	err := d.pool.QueryRowEx(ctx, query, nil, id).Scan(
+		&client.ID,
+		&client.Name,
+		&client.SecretHash,
+		&client.RedirectURIs,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return &client, nil
+}
+
+// CreateCode inserts a new authorization code row.
+// Returns an error on failure.
+func (d *postgresDB) CreateCode(ctx context.Context, code *oidc.AuthCode) error {
+	query := `
+		INSERT INTO oidc_codes (hash, client_id, redirect_uri, user_id, scope, nonce, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		code.Hash,
+		code.ClientID,
+		code.RedirectURI,
+		code.UserUUID,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot insert authorization code in database: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeCode deletes the code with given hash and returns it,
+// so concurrent requests can't use the same code.
+// Returns No Rows error if there's no code with given hash.
+func (d *postgresDB) ConsumeCode(ctx context.Context, hash string) (*oidc.AuthCode, error) {
+	query := `
+		DELETE FROM oidc_codes WHERE hash = $1
+		RETURNING hash, client_id, redirect_uri, user_id, scope, nonce, code_challenge, expires_at`
+
+	var code oidc.AuthCode
+	err := d.pool.QueryRowEx(ctx, query, nil, hash).Scan(
+		&code.Hash,
+		&code.ClientID,
+		&code.RedirectURI,
+		&code.UserUUID,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredCodes deletes codes expired before given time.
+// Returns number of deleted codes.
+func (d *postgresDB) DeleteExpiredCodes(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := d.pool.ExecEx(ctx, `DELETE FROM oidc_codes WHERE expires_at < $1`, nil, before)
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete expired authorization codes: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// CreateKey inserts a new signing key row.
+// Returns an error on failure.
+func (d *postgresDB) CreateKey(ctx context.Context, key *oidc.SigningKey) error {
+	query := `INSERT INTO oidc_keys (id, private_key, created_at) VALUES ($1, $2, $3)`
+
+	if _, err := d.pool.ExecEx(ctx, query, nil, key.ID, key.PrivateKey, key.CreatedAt); err != nil {
+		return fmt.Errorf("cannot insert signing key in database: %w", err)
+	}
+
+	return nil
+}
+
+// ListKeys returns signing keys, newest first.
+func (d *postgresDB) ListKeys(ctx context.Context) ([]oidc.SigningKey, error) {
+	rows, err := d.pool.QueryEx(ctx, `SELECT id, private_key, created_at FROM oidc_keys ORDER BY created_at DESC`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []oidc.SigningKey
+	for rows.Next() {
+		var key oidc.SigningKey
+		if err := rows.Scan(&key.ID, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteKeys deletes keys created before given time.
+// Returns number of deleted keys.
+func (d *postgresDB) DeleteKeys(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := d.pool.ExecEx(ctx, `DELETE FROM oidc_keys WHERE created_at < $1`, nil, before)
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete signing keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
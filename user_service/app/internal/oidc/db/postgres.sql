@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS oidc_clients (
+    id            TEXT PRIMARY KEY,
+    name          TEXT NOT NULL,
+    secret_hash   TEXT NOT NULL DEFAULT '',
+    redirect_uris TEXT[] NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_codes (
+    hash           TEXT PRIMARY KEY,
+    client_id      TEXT NOT NULL,
+    redirect_uri   TEXT NOT NULL,
+    user_id        TEXT NOT NULL,
+    scope          TEXT NOT NULL,
+    nonce          TEXT NOT NULL DEFAULT '',
+    code_challenge TEXT NOT NULL,
+    expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oidc_keys (
+    id          TEXT PRIMARY KEY,
+    private_key TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
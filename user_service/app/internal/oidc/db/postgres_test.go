@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/storagetest"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPostgresStorage runs storage contract tests against PostgreSQL
+// available at POSTGRES_URL. Skipped if it's not set.
+func TestPostgresStorage(t *testing.T) {
+	postgresURL := os.Getenv("POSTGRES_URL")
+	if postgresURL == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+
+	logger.Init()
+
+	pool, err := postgres.NewPostgresClient(postgresURL, 5)
+	if err != nil {
+		t.Fatalf("cannot connect to postgres: %v", err)
+	}
+	defer pool.Close()
+
+	if err := db.MigratePostgres(context.Background(), pool); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrated schema has no drift.
+	drift, err := db.CheckPostgres(context.Background(), pool)
+	assert.NoError(t, err)
+	assert.Empty(t, drift)
+
+	teardown := func() error {
+		_, err := pool.Exec(`TRUNCATE oidc_clients, oidc_codes, oidc_keys`)
+		return err
+	}
+
+	storagetest.Run(t, func(t *testing.T) (oidc.Storage, func() error) {
+		return db.NewPostgresStorage(pool), teardown
+	})
+}
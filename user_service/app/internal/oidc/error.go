@@ -0,0 +1,32 @@
+package oidc
+
+// OAuth 2.0 error codes.
+const (
+	ErrCodeInvalidRequest          = "invalid_request"
+	ErrCodeInvalidClient           = "invalid_client"
+	ErrCodeInvalidGrant            = "invalid_grant"
+	ErrCodeInvalidScope            = "invalid_scope"
+	ErrCodeInvalidToken            = "invalid_token"
+	ErrCodeAccessDenied            = "access_denied"
+	ErrCodeUnsupportedResponseType = "unsupported_response_type"
+	ErrCodeUnsupportedGrantType    = "unsupported_grant_type"
+)
+
+// Error is OAuth 2.0 error response.
+type Error struct {
+	Code        string `json:"error" example:"invalid_grant"`
+	Description string `json:"error_description,omitempty" example:"authorization code is invalid or expired"`
+} // @name OAuthError
+
+// Error returns a string representation of an error.
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// newError returns a new OAuth error with given code and description.
+func newError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
@@ -0,0 +1,483 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/handler"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	discoveryURL = "/.well-known/openid-configuration"
+	jwksURL      = "/api/oauth/jwks"
+	authorizeURL = "/api/oauth/authorize"
+	loginURL     = "/api/oauth/login"
+	tokenURL     = "/api/oauth/token"
+	userInfoURL  = "/api/oauth/userinfo"
+	clientsURL   = "/api/oauth/clients"
+
+	// sessionCookie keeps the user logged in at the provider,
+	// so the user logs in once for several clients.
+	sessionCookie = "sueta_oidc_session"
+)
+
+// Handler handles requests specified to OpenID Connect provider.
+type Handler struct {
+	logger      logger.Logger
+	service     Service
+	authService user.AuthService
+	authorizer  *auth.Middleware
+}
+
+// NewHandler returns a new OpenID Connect Handler instance.
+// Users log in to the provider with authService.
+func NewHandler(logger logger.Logger, service Service, authService user.AuthService, authorizer *auth.Middleware) handler.Handling {
+	return &Handler{
+		logger:      logger,
+		service:     service,
+		authService: authService,
+		authorizer:  authorizer,
+	}
+}
+
+// Register registers new routes for router.
+// Users authorize clients after logging in to the provider
+// with a password, bearer tokens and API keys are not accepted.
+// Only admins can register clients.
+func (h *Handler) Register(router *httprouter.Router) {
+	adminOnly := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}}
+
+	router.HandlerFunc(http.MethodGet, discoveryURL, h.Discovery)
+	router.HandlerFunc(http.MethodGet, jwksURL, h.Keys)
+	router.HandlerFunc(http.MethodGet, authorizeURL, h.Authorize)
+	router.HandlerFunc(http.MethodPost, authorizeURL, h.Consent)
+	router.HandlerFunc(http.MethodPost, loginURL, h.Login)
+	router.HandlerFunc(http.MethodPost, tokenURL, h.Token)
+	router.HandlerFunc(http.MethodGet, userInfoURL, h.UserInfo)
+	router.HandlerFunc(http.MethodPost, userInfoURL, h.UserInfo)
+	router.HandlerFunc(http.MethodPost, clientsURL, h.authorizer.Authorize(adminOnly, h.RegisterClient))
+}
+
+// Discovery responses with OpenID Provider metadata. It's served
+// outside of API base path as the specification requires.
+func (h *Handler) Discovery(w http.ResponseWriter, r *http.Request) {
+	h.JSON(w, http.StatusOK, h.service.Discovery())
+}
+
+// Keys godoc
+// @Summary JSON Web Key Set
+// @Description Get public keys ID and access tokens are signed with. Keys are rotated, so the set may contain several keys.
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} JWKS
+// @Failure 500 {object} apperror.AppError
+// @Router /oauth/jwks [get]
+func (h *Handler) Keys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.Keys(r.Context())
+	if err != nil {
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+		return
+	}
+
+	h.JSON(w, http.StatusOK, keys)
+}
+
+// Authorize godoc
+// @Summary Authorize client
+// @Description Start authorization of the client. The user who hasn't logged in to the provider gets login page, the logged in user gets consent page. Invalid requests are redirected to redirect_uri with OAuth error. Only code response type with S256 PKCE is supported.
+// @Tags oidc
+// @Produce html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client id"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string true "Must contain openid"
+// @Param state query string false "Opaque value returned to the client"
+// @Param nonce query string false "Value passed to ID token"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {string} string "Login or consent page"
+// @Success 302
+// @Failure 400 {object} Error
+// @Failure 401 {object} Error
+// @Failure 500 {object} apperror.AppError
+// @Router /oauth/authorize [get]
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("OAUTH AUTHORIZE")
+
+	query := r.URL.Query()
+	input := AuthorizeDTO{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	uuid, loggedIn := h.session(r)
+	input.UUID = uuid
+
+	consent, err := h.service.Consent(r.Context(), &input)
+	if err != nil {
+		h.oauthError(w, err)
+		return
+	}
+
+	if consent.Redirect != "" {
+		http.Redirect(w, r, consent.Redirect, http.StatusFound)
+		return
+	}
+
+	if !loggedIn {
+		h.page(w, http.StatusOK, "login.html", &loginPage{
+			Action:  loginURL,
+			Client:  consent.ClientName,
+			Request: r.URL.RawQuery,
+		})
+		return
+	}
+
+	h.page(w, http.StatusOK, "consent.html", &consentPage{
+		Action: authorizeURL,
+		Client: consent.ClientName,
+		Scopes: consent.Scopes,
+		Token:  consent.Token,
+	})
+}
+
+// Consent godoc
+// @Summary Decide on authorization
+// @Description Allow or deny the client access on behalf of the logged in user and redirect to redirect_uri with authorization code or access_denied error. Posted by consent page.
+// @Tags oidc
+// @Accept x-www-form-urlencoded
+// @Param consent formData string true "Consent token from consent page"
+// @Param decision formData string true "allow or deny"
+// @Success 302
+// @Failure 400 {object} Error
+// @Failure 401 {object} Error
+// @Failure 500 {object} apperror.AppError
+// @Router /oauth/authorize [post]
+func (h *Handler) Consent(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("OAUTH CONSENT")
+
+	uuid, ok := h.session(r)
+	if !ok {
+		h.JSON(w, http.StatusUnauthorized, newError(ErrCodeAccessDenied, "the user has not logged in"))
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.oauthError(w, newError(ErrCodeInvalidRequest, "request body must be form encoded"))
+		return
+	}
+
+	redirect, err := h.service.Authorize(r.Context(), &ConsentDTO{
+		UUID:  uuid,
+		Token: r.PostForm.Get("consent"),
+		Allow: r.PostForm.Get("decision") == "allow",
+	})
+	if err != nil {
+		h.oauthError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Login godoc
+// @Summary Log in to the provider
+// @Description Log in with email and password, and then with the second factor if it's enabled, and return to the authorization request. The user is logged in to the provider only, no tokens accepted by the API are issued. Posted by login page, which is shown again on failure.
+// @Tags oidc
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param request formData string true "Query of the authorization request"
+// @Param email formData string false "Email"
+// @Param password formData string false "Password"
+// @Param challenge formData string false "Challenge token from login page"
+// @Param code formData string false "One-time or recovery code"
+// @Success 303
+// @Failure 400 {string} string "Login page"
+// @Failure 401 {string} string "Login page"
+// @Failure 403 {string} string "Login page"
+// @Failure 429 {string} string "Login page"
+// @Router /oauth/login [post]
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("OAUTH LOGIN")
+
+	if err := r.ParseForm(); err != nil {
+		h.oauthError(w, newError(ErrCodeInvalidRequest, "request body must be form encoded"))
+		return
+	}
+
+	request, err := url.ParseQuery(r.PostForm.Get("request"))
+	if err != nil {
+		h.oauthError(w, newError(ErrCodeInvalidRequest, "request must be a query of authorization request"))
+		return
+	}
+
+	page := &loginPage{
+		Action:  loginURL,
+		Request: request.Encode(),
+	}
+
+	// Users log in to the provider only, so neither user session
+	// nor tokens accepted by the API are issued.
+	var u *user.User
+	if challenge := r.PostForm.Get("challenge"); challenge != "" {
+		page.Challenge = challenge
+		u, err = h.authService.AuthenticateTwoFactor(r.Context(), &user.TwoFactorLoginDTO{
+			ChallengeToken: challenge,
+			Code:           r.PostForm.Get("code"),
+			IP:             auth.ClientIP(r),
+			UserAgent:      r.UserAgent(),
+		})
+	} else {
+		input := user.LoginDTO{
+			Email:     r.PostForm.Get("email"),
+			Password:  r.PostForm.Get("password"),
+			IP:        auth.ClientIP(r),
+			UserAgent: r.UserAgent(),
+		}
+		if err := input.Validate(); err != nil {
+			page.Error = "Please, provide valid email and password."
+			h.page(w, http.StatusBadRequest, "login.html", page)
+			return
+		}
+
+		var authn *user.Authentication
+		authn, err = h.authService.Authenticate(r.Context(), &input)
+		if err == nil && authn.TwoFactorRequired {
+			page.Challenge = authn.ChallengeToken
+			h.page(w, http.StatusOK, "login.html", page)
+			return
+		}
+		if err == nil {
+			u = authn.User
+		}
+	}
+
+	if err != nil {
+		var retry *apperror.RetryError
+		code := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, apperror.ErrWrongPassword):
+			page.Error = "Wrong email or password."
+		case errors.Is(err, apperror.ErrInvalidCode):
+			page.Error = "Wrong code."
+		case errors.Is(err, apperror.ErrInvalidToken):
+			page.Challenge = ""
+			page.Error = "Login has expired, please, log in again."
+		case errors.Is(err, apperror.ErrAccountLocked):
+			code = http.StatusForbidden
+			page.Error = "The account is locked, please, contact support."
+		case errors.As(err, &retry):
+			code = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+			page.Error = "Too many attempts, please, try again later."
+		default:
+			h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+			return
+		}
+		h.page(w, code, "login.html", page)
+		return
+	}
+
+	session, err := h.service.StartSession(r.Context(), u)
+	if err != nil {
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/api/oauth",
+		MaxAge:   int(sessionTTL.Seconds()),
+		Secure:   strings.HasPrefix(h.service.Discovery().Issuer, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authorizeURL+"?"+request.Encode(), http.StatusSeeOther)
+}
+
+// session returns uuid of the user logged in to the provider.
+// Only provider session tokens issued by Login are accepted.
+func (h *Handler) session(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	uuid, err := h.service.Session(r.Context(), cookie.Value)
+	if err != nil {
+		return "", false
+	}
+
+	return uuid, true
+}
+
+// Token godoc
+// @Summary Exchange authorization code
+// @Description Exchange authorization code for ID and access tokens. Confidential clients authenticate with HTTP Basic or client_secret parameter.
+// @Tags oidc
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "Must be authorization_code"
+// @Param code formData string true "Authorization code"
+// @Param redirect_uri formData string true "Redirect URI used to get the code"
+// @Param client_id formData string false "Client id"
+// @Param client_secret formData string false "Client secret"
+// @Param code_verifier formData string true "PKCE code verifier"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} Error
+// @Failure 401 {object} Error
+// @Failure 500 {object} apperror.AppError
+// @Router /oauth/token [post]
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("OAUTH TOKEN")
+
+	if err := r.ParseForm(); err != nil {
+		h.oauthError(w, newError(ErrCodeInvalidRequest, "request body must be form encoded"))
+		return
+	}
+
+	input := TokenDTO{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}
+
+	if id, secret, ok := r.BasicAuth(); ok {
+		input.ClientID, input.ClientSecret = id, secret
+	}
+
+	// Tokens must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+
+	tokens, err := h.service.Exchange(r.Context(), &input)
+	if err != nil {
+		h.oauthError(w, err)
+		return
+	}
+
+	h.JSON(w, http.StatusOK, tokens)
+}
+
+// UserInfo godoc
+// @Summary Get user claims
+// @Description Get claims about the user with access token issued by token endpoint.
+// @Tags oidc
+// @Produce json
+// @Success 200 {object} UserInfo
+// @Failure 401 {object} Error
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /oauth/userinfo [get]
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("OAUTH USERINFO")
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		h.oauthError(w, newError(ErrCodeInvalidToken, "missing access token"))
+		return
+	}
+
+	info, err := h.service.UserInfo(r.Context(), strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		h.oauthError(w, err)
+		return
+	}
+
+	h.JSON(w, http.StatusOK, info)
+}
+
+// RegisterClient godoc
+// @Summary Register client
+// @Description Register an application which authenticates users with OpenID Connect. Client secret is shown only once. Public clients have no secret. Available for admins only.
+// @Tags oidc
+// @Accept json
+// @Produce json
+// @Param input body oidc.CreateClientDTO true "JSON input"
+// @Success 201 {object} RegisteredClient
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /oauth/clients [post]
+func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("REGISTER OAUTH CLIENT")
+
+	var input CreateClientDTO
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		h.JSON(w, http.StatusBadRequest, apperror.BadRequestError(err.Error(), "invalid request body"))
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.JSON(w, http.StatusBadRequest, apperror.BadRequestError(err.Error(), "input validation failed. please, provide valid values"))
+		return
+	}
+
+	client, err := h.service.RegisterClient(r.Context(), &input)
+	if err != nil {
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+		return
+	}
+
+	h.JSON(w, http.StatusCreated, client)
+}
+
+// oauthError responses with OAuth error in JSON format. Invalid client
+// and invalid token errors are sent with 401 Unauthorized status code,
+// other OAuth errors with 400 Bad Request. Unexpected errors are
+// internal server errors.
+func (h *Handler) oauthError(w http.ResponseWriter, err error) {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		h.JSON(w, http.StatusInternalServerError, apperror.InternalError(err.Error(), ""))
+		return
+	}
+
+	code := http.StatusBadRequest
+	switch oauthErr.Code {
+	case ErrCodeInvalidClient:
+		code = http.StatusUnauthorized
+	case ErrCodeInvalidToken:
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+
+	h.JSON(w, code, oauthErr)
+}
+
+// JSON encodes to JSON format given data and sends a response
+// to the client with a given http code and encoded data.
+func (h *Handler) JSON(w http.ResponseWriter, code int, data interface{}) {
+	obj, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(obj)
+}
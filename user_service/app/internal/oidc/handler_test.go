@@ -0,0 +1,784 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditmemory "github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	oidcmemory "github.com/juicyluv/sueta/user_service/app/internal/oidc/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/juicyluv/sueta/user_service/app/pkg/totp"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testAccessSecret = "access-secret"
+	testRedirectURI  = "https://wiki.sueta.local/callback"
+	testVerifier     = "dBjftJeZ4CVP-mJ92K9mhfV6bqwIsE8GpaDpxoAV4i0"
+)
+
+// provider is OpenID Connect provider served by httptest server.
+type provider struct {
+	server  *httptest.Server
+	client  *http.Client
+	service oidc.Service
+	users   user.Service
+	auth    user.AuthService
+	tokens  *token.Manager
+	userID  string
+
+	// session is the session cookie of the user, set by the first
+	// authorization request.
+	session *http.Cookie
+}
+
+// NewTestProvider starts the provider with in-memory storages and one user.
+func NewTestProvider(t *testing.T) *provider {
+	logger.Init()
+	l := logger.GetLogger()
+
+	router := httprouter.New()
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	outbox, err := mail.NewOutbox(t.TempDir())
+	assert.NoError(t, err)
+
+	hasher, err := password.New(password.Params{
+		Algorithm:  password.Bcrypt,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	assert.NoError(t, err)
+
+	policy, err := password.NewPolicy(6, 24, "")
+	assert.NoError(t, err)
+
+	tokens := token.NewManager("sueta-test", testAccessSecret, "refresh-secret", map[token.Type]time.Duration{
+		token.Access:       time.Minute,
+		token.Refresh:      time.Hour,
+		token.Verification: time.Hour,
+		token.TwoFactor:    time.Minute,
+	})
+
+	tokenStorage, sessionStorage, apiKeyStorage := memory.NewTokenStorage(), memory.NewSessionStorage(), memory.NewAPIKeyStorage()
+	auditor := audit.NewService(auditmemory.NewStorage(), l)
+	users := user.NewService(memory.NewStorage(), tokenStorage, sessionStorage, apiKeyStorage, auditor, outbox, tokens, hasher, policy, l)
+
+	limiter := lockout.NewLimiter(lockout.NewMemoryStore(), "login:", lockout.Policy{
+		Window:      time.Minute,
+		Threshold:   10,
+		BaseDelay:   time.Second,
+		MaxAttempts: 10,
+		Lockout:     time.Minute,
+	})
+	authService := user.NewAuthService(users, tokenStorage, sessionStorage, apiKeyStorage, auditor, tokens, limiter, limiter, l)
+
+	userID, err := users.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	service := oidc.NewService(oidcmemory.NewStorage(), users, oidc.Params{
+		Issuer:      server.URL,
+		CodeTTL:     time.Minute,
+		TokenTTL:    time.Hour,
+		KeyRotation: 24 * time.Hour,
+	}, l)
+	oidc.NewHandler(l, service, authService, auth.NewMiddleware(testAccessSecret, authService)).Register(router)
+
+	return &provider{
+		server:  server,
+		service: service,
+		users:   users,
+		auth:    authService,
+		tokens:  tokens,
+		userID:  userID,
+		client: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// accessToken returns user service access token of the user with given role.
+func (p *provider) accessToken(t *testing.T, id string, role auth.Role) string {
+	pair, err := p.tokens.NewPair(token.Identity{UUID: id, Email: "test@mail.com", Role: string(role)}, "refresh-id", "family-id")
+	assert.NoError(t, err)
+	return pair.AccessToken
+}
+
+// do sends a request with optional bearer token and returns the response.
+func (p *provider) do(t *testing.T, method, path, bearer, contentType string, body string) *http.Response {
+	req, err := http.NewRequest(method, p.server.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := p.client.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+// registerClient registers a client with test redirect URI.
+func (p *provider) registerClient(t *testing.T, public bool) *oidc.RegisteredClient {
+	body, err := json.Marshal(&oidc.CreateClientDTO{Name: "wiki", RedirectURIs: []string{testRedirectURI}, Public: public})
+	assert.NoError(t, err)
+
+	res := p.do(t, http.MethodPost, "/api/oauth/clients", p.accessToken(t, "62056f8cf21b83383a5ae7fa", auth.RoleAdmin), "application/json", string(body))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var client oidc.RegisteredClient
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&client))
+	return &client
+}
+
+// send sends a request with given session cookie, if any, and form
+// as a body, if any, and returns the response.
+func (p *provider) send(t *testing.T, method, path string, session *http.Cookie, form url.Values) *http.Response {
+	req, err := http.NewRequest(method, p.server.URL+path, strings.NewReader(form.Encode()))
+	assert.NoError(t, err)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if session != nil {
+		req.AddCookie(session)
+	}
+
+	res, err := p.client.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+// authorizeURL returns authorization request URL with given parameters
+// overriding defaults.
+func authorizeURL(clientID string, override url.Values) string {
+	sum := sha256.Sum256([]byte(testVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range override {
+		params[key] = values
+	}
+
+	return "/api/oauth/authorize?" + params.Encode()
+}
+
+// login logs the user in at the provider with given form
+// and returns the response.
+func (p *provider) login(t *testing.T, clientID string, form url.Values) *http.Response {
+	form.Set("request", strings.TrimPrefix(authorizeURL(clientID, nil), "/api/oauth/authorize?"))
+	return p.send(t, http.MethodPost, "/api/oauth/login", nil, form)
+}
+
+// sessionCookie returns session cookie set by the response.
+func sessionCookie(t *testing.T, res *http.Response) *http.Cookie {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "sueta_oidc_session" {
+			return cookie
+		}
+	}
+	t.Fatal("session cookie is not set")
+	return nil
+}
+
+// authorize sends authorization request with given parameters overriding
+// defaults as the logged in user and returns the response.
+func (p *provider) authorize(t *testing.T, clientID string, override url.Values) *http.Response {
+	if p.session == nil {
+		res := p.login(t, clientID, url.Values{"email": {"test@mail.com"}, "password": {"qwerty"}})
+		assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+		p.session = sessionCookie(t, res)
+	}
+
+	return p.send(t, http.MethodGet, authorizeURL(clientID, override), p.session, nil)
+}
+
+var consentToken = regexp.MustCompile(`name="consent" value="([^"]+)"`)
+
+// consent returns consent token from consent page.
+func consent(t *testing.T, res *http.Response) string {
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	match := consentToken.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("consent page is expected, got %s", body)
+	}
+	return html.UnescapeString(string(match[1]))
+}
+
+// decide posts decision on the consent as the logged in user
+// and returns redirect location.
+func (p *provider) decide(t *testing.T, token, decision string) *url.URL {
+	res := p.send(t, http.MethodPost, "/api/oauth/authorize", p.session, url.Values{"consent": {token}, "decision": {decision}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	return location
+}
+
+// code requests authorization code and returns it.
+func (p *provider) code(t *testing.T, clientID string) string {
+	location := p.decide(t, consent(t, p.authorize(t, clientID, nil)), "allow")
+	return location.Query().Get("code")
+}
+
+// exchange exchanges the code at token endpoint.
+func (p *provider) exchange(t *testing.T, form url.Values) *http.Response {
+	return p.do(t, http.MethodPost, "/api/oauth/token", "", "application/x-www-form-urlencoded", form.Encode())
+}
+
+func TestHandler_Discovery(t *testing.T) {
+	p := NewTestProvider(t)
+
+	res := p.do(t, http.MethodGet, "/.well-known/openid-configuration", "", "", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var discovery oidc.Discovery
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&discovery))
+	assert.Equal(t, p.server.URL, discovery.Issuer)
+	assert.Equal(t, p.server.URL+"/api/oauth/authorize", discovery.AuthorizationEndpoint)
+	assert.Equal(t, p.server.URL+"/api/oauth/token", discovery.TokenEndpoint)
+	assert.Equal(t, p.server.URL+"/api/oauth/userinfo", discovery.UserInfoEndpoint)
+	assert.Equal(t, p.server.URL+"/api/oauth/jwks", discovery.JWKSURI)
+	assert.Equal(t, []string{"S256"}, discovery.CodeChallengeMethodsSupported)
+}
+
+func TestHandler_RegisterClient(t *testing.T) {
+	p := NewTestProvider(t)
+
+	client := p.registerClient(t, false)
+	assert.NotEmpty(t, client.ID)
+	assert.NotEmpty(t, client.Secret)
+
+	public := p.registerClient(t, true)
+	assert.Empty(t, public.Secret)
+
+	res := p.do(t, http.MethodPost, "/api/oauth/clients", p.accessToken(t, p.userID, auth.RoleUser), "application/json",
+		`{"name":"wiki","redirectUris":["https://wiki.sueta.local/callback"]}`)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = p.do(t, http.MethodPost, "/api/oauth/clients", p.accessToken(t, "62056f8cf21b83383a5ae7fa", auth.RoleAdmin), "application/json",
+		`{"name":"wiki","redirectUris":["not a url"]}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestHandler_AuthorizationCodeFlow(t *testing.T) {
+	p := NewTestProvider(t)
+	client := p.registerClient(t, false)
+
+	res := p.exchange(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {p.code(t, client.ID)},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+		"code_verifier": {testVerifier},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+
+	var tokens oidc.TokenResponse
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Equal(t, "openid email profile", tokens.Scope)
+
+	// ID token is verified with a key from JWKS endpoint.
+	res = p.do(t, http.MethodGet, "/api/oauth/jwks", "", "", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var jwks oidc.JWKS
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&jwks))
+	assert.Len(t, jwks.Keys, 1)
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokens.IDToken, claims, func(tok *jwt.Token) (interface{}, error) {
+		assert.Equal(t, "RS256", tok.Method.Alg())
+		return publicKey(t, &jwks, tok.Header["kid"].(string)), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, p.server.URL, claims["iss"])
+	assert.Equal(t, p.userID, claims["sub"])
+	assert.True(t, claims.VerifyAudience(client.ID, true))
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, "test@mail.com", claims["email"])
+	assert.Equal(t, false, claims["email_verified"])
+	assert.Equal(t, "test", claims["preferred_username"])
+
+	res = p.do(t, http.MethodGet, "/api/oauth/userinfo", tokens.AccessToken, "", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var info oidc.UserInfo
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+	assert.Equal(t, oidc.UserInfo{Subject: p.userID, Email: "test@mail.com", Username: "test"}, info)
+
+	invalidTokens := map[string]string{
+		"ID token":                  tokens.IDToken,
+		"user service access token": p.accessToken(t, p.userID, auth.RoleUser),
+		"session token":             p.session.Value,
+		"malformed token":           "malformed",
+	}
+	for name, tok := range invalidTokens {
+		res = p.do(t, http.MethodGet, "/api/oauth/userinfo", tok, "", "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, name)
+	}
+
+	// Neither tokens nor codes issued before the user is locked can be used.
+	code := p.code(t, client.ID)
+	assert.NoError(t, p.users.Lock(context.Background(), p.userID))
+
+	res = p.do(t, http.MethodGet, "/api/oauth/userinfo", tokens.AccessToken, "", "")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = p.exchange(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+		"code_verifier": {testVerifier},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	var e oidc.Error
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&e))
+	assert.Equal(t, "invalid_grant", e.Code)
+}
+
+func TestHandler_Token(t *testing.T) {
+	p := NewTestProvider(t)
+	client := p.registerClient(t, false)
+	public := p.registerClient(t, true)
+
+	usedCode := p.code(t, client.ID)
+	res := p.exchange(t, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {usedCode},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+		"code_verifier": {testVerifier},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	testCases := []struct {
+		name          string
+		form          func(code string) url.Values
+		basicAuth     bool
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name: "public client",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {testRedirectURI},
+					"client_id":     {public.ID},
+					"code_verifier": {testVerifier},
+				}
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "basic auth",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {testRedirectURI},
+					"code_verifier": {testVerifier},
+				}
+			},
+			basicAuth:    true,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "used code",
+			form: func(string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {usedCode},
+					"redirect_uri":  {testRedirectURI},
+					"client_id":     {client.ID},
+					"client_secret": {client.Secret},
+					"code_verifier": {testVerifier},
+				}
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			name: "wrong code verifier",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {testRedirectURI},
+					"client_id":     {client.ID},
+					"client_secret": {client.Secret},
+					"code_verifier": {"wrong"},
+				}
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			name: "wrong redirect uri",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {"https://evil.example/callback"},
+					"client_id":     {client.ID},
+					"client_secret": {client.Secret},
+					"code_verifier": {testVerifier},
+				}
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			name: "missing client secret",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {testRedirectURI},
+					"client_id":     {client.ID},
+					"code_verifier": {testVerifier},
+				}
+			},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+		{
+			name: "unknown client",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {code},
+					"redirect_uri":  {testRedirectURI},
+					"client_id":     {"unknown"},
+					"code_verifier": {testVerifier},
+				}
+			},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+		{
+			name: "unsupported grant type",
+			form: func(code string) url.Values {
+				return url.Values{
+					"grant_type": {"password"},
+					"client_id":  {client.ID},
+				}
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "unsupported_grant_type",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientID := client.ID
+			if tc.name == "public client" {
+				clientID = public.ID
+			}
+
+			req, err := http.NewRequest(http.MethodPost, p.server.URL+"/api/oauth/token", strings.NewReader(tc.form(p.code(t, clientID)).Encode()))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.basicAuth {
+				req.SetBasicAuth(client.ID, client.Secret)
+			}
+
+			res, err := p.client.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, res.StatusCode)
+			if tc.expectedError != "" {
+				var e oidc.Error
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&e))
+				assert.Equal(t, tc.expectedError, e.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_Authorize(t *testing.T) {
+	p := NewTestProvider(t)
+	client := p.registerClient(t, false)
+
+	// Invalid client or redirect uri must not redirect.
+	res := p.authorize(t, "unknown", nil)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = p.authorize(t, client.ID, url.Values{"redirect_uri": {"https://evil.example/callback"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+
+	testCases := []struct {
+		name          string
+		override      url.Values
+		expectedError string
+	}{
+		{"unsupported response type", url.Values{"response_type": {"token"}}, "unsupported_response_type"},
+		{"missing openid scope", url.Values{"scope": {"email"}}, "invalid_scope"},
+		{"missing code challenge", url.Values{"code_challenge": {""}}, "invalid_request"},
+		{"plain code challenge", url.Values{"code_challenge_method": {"plain"}}, "invalid_request"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := p.authorize(t, client.ID, tc.override)
+			assert.Equal(t, http.StatusFound, res.StatusCode)
+
+			location, err := url.Parse(res.Header.Get("Location"))
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(location.String(), testRedirectURI))
+			assert.Equal(t, tc.expectedError, location.Query().Get("error"))
+			assert.Equal(t, "xyz", location.Query().Get("state"))
+			assert.Empty(t, location.Query().Get("code"))
+		})
+	}
+
+	// Access is denied if the user declines.
+	location := p.decide(t, consent(t, p.authorize(t, client.ID, nil)), "deny")
+	assert.True(t, strings.HasPrefix(location.String(), testRedirectURI))
+	assert.Equal(t, "access_denied", location.Query().Get("error"))
+	assert.Empty(t, location.Query().Get("code"))
+
+	token := consent(t, p.authorize(t, client.ID, nil))
+
+	// Consent is accepted only from the user it was asked of.
+	res = p.send(t, http.MethodPost, "/api/oauth/authorize", nil, url.Values{"consent": {token}, "decision": {"allow"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+
+	otherID, err := p.users.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "other@mail.com",
+		Username:       "other",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, otherID)
+	other := sessionCookie(t, p.login(t, client.ID, url.Values{"email": {"other@mail.com"}, "password": {"qwerty"}}))
+
+	res = p.send(t, http.MethodPost, "/api/oauth/authorize", other, url.Values{"consent": {token}, "decision": {"allow"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Location"))
+
+	res = p.send(t, http.MethodPost, "/api/oauth/authorize", p.session, url.Values{"consent": {"forged"}, "decision": {"allow"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestHandler_Login(t *testing.T) {
+	p := NewTestProvider(t)
+	client := p.registerClient(t, false)
+	ctx := context.Background()
+
+	// The user who hasn't logged in gets login page, bearer tokens
+	// and API keys don't authenticate the user.
+	key, err := p.auth.CreateAPIKey(ctx, &user.CreateAPIKeyDTO{UUID: p.userID, Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: 1})
+	assert.NoError(t, err)
+
+	notLoggedIn := map[string]*http.Response{
+		"anonymous":               p.send(t, http.MethodGet, authorizeURL(client.ID, nil), nil, nil),
+		"access token":            p.do(t, http.MethodGet, authorizeURL(client.ID, nil), p.accessToken(t, p.userID, auth.RoleUser), "", ""),
+		"API key":                 p.do(t, http.MethodGet, authorizeURL(client.ID, nil), key.Key, "", ""),
+		"API key as session":      p.send(t, http.MethodGet, authorizeURL(client.ID, nil), &http.Cookie{Name: "sueta_oidc_session", Value: key.Key}, nil),
+		"access token as session": p.send(t, http.MethodGet, authorizeURL(client.ID, nil), &http.Cookie{Name: "sueta_oidc_session", Value: p.accessToken(t, p.userID, auth.RoleUser)}, nil),
+		"malformed session":       p.send(t, http.MethodGet, authorizeURL(client.ID, nil), &http.Cookie{Name: "sueta_oidc_session", Value: "malformed"}, nil),
+	}
+	for name, res := range notLoggedIn {
+		assert.Equal(t, http.StatusOK, res.StatusCode, name)
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"), name)
+		assert.Equal(t, "DENY", res.Header.Get("X-Frame-Options"), name)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "Log in to continue to wiki", name)
+		assert.Contains(t, string(body), `action="/api/oauth/login"`, name)
+		assert.NotContains(t, string(body), `name="consent"`, name)
+	}
+
+	// API key doesn't decide on consent either.
+	token := consent(t, p.authorize(t, client.ID, nil))
+	res := p.send(t, http.MethodPost, "/api/oauth/authorize", &http.Cookie{Name: "sueta_oidc_session", Value: key.Key}, url.Values{"consent": {token}, "decision": {"allow"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = p.login(t, client.ID, url.Values{"email": {"test@mail.com"}, "password": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Empty(t, res.Cookies())
+
+	res = p.login(t, client.ID, url.Values{"email": {"not an email"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// The user returns to the authorization request after login.
+	res = p.login(t, client.ID, url.Values{"email": {"test@mail.com"}, "password": {"qwerty"}})
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	assert.Equal(t, authorizeURL(client.ID, nil), res.Header.Get("Location"))
+	session := sessionCookie(t, res)
+	assert.True(t, session.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+
+	// The second factor is required once it's enabled.
+	enrollment, err := p.users.EnrollTwoFactor(ctx, p.userID)
+	assert.NoError(t, err)
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	recovery, err := p.users.ConfirmTwoFactor(ctx, &user.TwoFactorCodeDTO{UUID: p.userID, Code: code})
+	assert.NoError(t, err)
+
+	res = p.login(t, client.ID, url.Values{"email": {"test@mail.com"}, "password": {"qwerty"}})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Cookies())
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	match := regexp.MustCompile(`name="challenge" value="([^"]+)"`).FindSubmatch(body)
+	if !assert.NotNil(t, match) {
+		return
+	}
+	challenge := html.UnescapeString(string(match[1]))
+
+	res = p.login(t, client.ID, url.Values{"challenge": {challenge}, "code": {"000000"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Empty(t, res.Cookies())
+
+	res = p.login(t, client.ID, url.Values{"challenge": {challenge}, "code": {recovery.RecoveryCodes[0]}})
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	p.session = sessionCookie(t, res)
+	assert.NotEmpty(t, p.code(t, client.ID))
+
+	// Login to the provider leaves no user session behind.
+	sessions, err := p.auth.ListSessions(ctx, p.userID, "")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// Locked user and the user who has changed password
+	// are asked to log in again.
+	loggedOut := func(name string) {
+		res := p.send(t, http.MethodGet, authorizeURL(client.ID, nil), p.session, nil)
+		assert.Equal(t, http.StatusOK, res.StatusCode, name)
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), `action="/api/oauth/login"`, name)
+	}
+
+	assert.NoError(t, p.users.Lock(ctx, p.userID))
+	loggedOut("locked")
+
+	assert.NoError(t, p.users.Unlock(ctx, p.userID))
+	consent(t, p.send(t, http.MethodGet, authorizeURL(client.ID, nil), p.session, nil))
+
+	assert.NoError(t, p.users.SetPassword(ctx, p.userID, "changed-qwerty"))
+	loggedOut("password changed")
+}
+
+func TestService_RotateKeys(t *testing.T) {
+	logger.Init()
+	storage := oidcmemory.NewStorage()
+	ctx := context.Background()
+
+	params := oidc.Params{Issuer: "http://localhost", TokenTTL: time.Hour, KeyRotation: time.Hour}
+	service := oidc.NewService(storage, nil, params, logger.GetLogger())
+
+	assert.NoError(t, service.RotateKeys(ctx))
+	assert.NoError(t, service.RotateKeys(ctx))
+
+	jwks, err := service.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, jwks.Keys, 1, "key is not rotated before rotation period")
+
+	// The previous key is published until tokens signed with it expire.
+	params.KeyRotation = 0
+	service = oidc.NewService(storage, nil, params, logger.GetLogger())
+	assert.NoError(t, service.RotateKeys(ctx))
+
+	jwks, err = service.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, jwks.Keys, 2)
+
+	keys, err := storage.ListKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[0].ID, jwks.Keys[0].KeyID, "the newest key is listed first")
+
+	params.TokenTTL = 0
+	service = oidc.NewService(storage, nil, params, logger.GetLogger())
+	assert.NoError(t, service.RotateKeys(ctx))
+
+	jwks, err = service.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, jwks.Keys, 1, "expired keys are deleted")
+}
+
+// publicKey returns RSA public key with given id from JWKS.
+func publicKey(t *testing.T, jwks *oidc.JWKS, kid string) *rsa.PublicKey {
+	for _, key := range jwks.Keys {
+		if key.KeyID != kid {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.Modulus)
+		assert.NoError(t, err)
+		e, err := base64.RawURLEncoding.DecodeString(key.Exponent)
+		assert.NoError(t, err)
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	t.Fatalf("key %s is not found in JWKS", kid)
+	return nil
+}
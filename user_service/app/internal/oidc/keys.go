@@ -0,0 +1,219 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// keyBits is a size of generated RSA keys.
+const keyBits = 2048
+
+// Keys returns public keys of all signing keys which are not deleted yet,
+// so tokens signed with the previous keys can still be verified.
+func (s *service) Keys(ctx context.Context) (*JWKS, error) {
+	keys, err := s.storage.ListKeys(ctx)
+	if err != nil {
+		s.logger.Warnf("failed to list signing keys: %v", err)
+		return nil, err
+	}
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		private, err := s.parseKey(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		jwks.Keys = append(jwks.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			KeyID:     key.ID,
+			Modulus:   base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+		})
+	}
+
+	return jwks, nil
+}
+
+// RotateKeys generates a new signing key if the newest one is older than
+// key rotation period. Keys are deleted once tokens signed with them have
+// expired. Expired authorization codes are deleted too.
+func (s *service) RotateKeys(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	keys, err := s.storage.ListKeys(ctx)
+	if err != nil {
+		s.logger.Warnf("failed to list signing keys: %v", err)
+		return err
+	}
+
+	if len(keys) == 0 || !now.Before(keys[0].CreatedAt.Add(s.params.KeyRotation)) {
+		if err := s.generateKey(ctx, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.storage.DeleteKeys(ctx, now.Add(-s.params.KeyRotation-s.params.TokenTTL)); err != nil {
+		s.logger.Warnf("failed to delete old signing keys: %v", err)
+		return err
+	}
+
+	if _, err := s.storage.DeleteExpiredCodes(ctx, now); err != nil {
+		s.logger.Warnf("failed to delete expired authorization codes: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RunKeyRotation rotates signing keys of the service every interval
+// until ctx is done. The first rotation runs immediately.
+func RunKeyRotation(ctx context.Context, service Service, interval time.Duration, logger logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := service.RotateKeys(ctx); err != nil {
+			logger.Errorf("failed to rotate signing keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// generateKey generates and stores a new signing key.
+func (s *service) generateKey(ctx context.Context, now time.Time) error {
+	private, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return fmt.Errorf("cannot generate signing key: %w", err)
+	}
+
+	id, err := token.NewID()
+	if err != nil {
+		return err
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(private),
+	})
+
+	key := &SigningKey{ID: id, PrivateKey: string(encoded), CreatedAt: now}
+	if err := s.storage.CreateKey(ctx, key); err != nil {
+		s.logger.Warnf("failed to store signing key: %v", err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.parsed[id] = private
+	s.mu.Unlock()
+
+	s.logger.Infof("generated signing key %s", id)
+	return nil
+}
+
+// currentKey returns the newest signing key.
+// If there are no keys yet, generates one.
+func (s *service) currentKey(ctx context.Context) (string, *rsa.PrivateKey, error) {
+	keys, err := s.storage.ListKeys(ctx)
+	if err != nil {
+		s.logger.Warnf("failed to list signing keys: %v", err)
+		return "", nil, err
+	}
+
+	if len(keys) == 0 {
+		if err := s.RotateKeys(ctx); err != nil {
+			return "", nil, err
+		}
+		return s.currentKey(ctx)
+	}
+
+	private, err := s.parseKey(&keys[0])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return keys[0].ID, private, nil
+}
+
+// publicKey returns public key of the signing key with given id.
+func (s *service) publicKey(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	keys, err := s.storage.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if key.ID == id {
+			private, err := s.parseKey(&key)
+			if err != nil {
+				return nil, err
+			}
+			return &private.PublicKey, nil
+		}
+	}
+
+	return nil, errors.New("unknown signing key")
+}
+
+// parseKey decodes private key of the signing key.
+// Decoded keys are cached, because keys never change.
+func (s *service) parseKey(key *SigningKey) (*rsa.PrivateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if private, ok := s.parsed[key.ID]; ok {
+		return private, nil
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("cannot decode signing key %s", key.ID)
+	}
+
+	private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse signing key %s: %w", key.ID, err)
+	}
+
+	s.parsed[key.ID] = private
+	return private, nil
+}
+
+// sign signs given claims with the newest signing key.
+// typ is set as a token type header.
+func (s *service) sign(ctx context.Context, claims jwt.Claims, typ string) (string, error) {
+	id, private, err := s.currentKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = id
+	t.Header["typ"] = typ
+
+	signed, err := t.SignedString(private)
+	if err != nil {
+		return "", fmt.Errorf("cannot sign token: %w", err)
+	}
+
+	return signed, nil
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// Check whether storage implements OpenID Connect storage interface.
+var _ oidc.Storage = &storage{}
+
+// storage implements OpenID Connect storage interface in memory.
+// It's suitable for a single instance and tests.
+type storage struct {
+	mu      sync.RWMutex
+	clients map[string]oidc.Client
+	codes   map[string]oidc.AuthCode
+	keys    map[string]oidc.SigningKey
+}
+
+// NewStorage returns a new in-memory OpenID Connect storage instance.
+func NewStorage() oidc.Storage {
+	return &storage{
+		clients: make(map[string]oidc.Client),
+		codes:   make(map[string]oidc.AuthCode),
+		keys:    make(map[string]oidc.SigningKey),
+	}
+}
+
+// CreateClient stores a copy of given client.
+func (s *storage) CreateClient(ctx context.Context, client *oidc.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *client
+	c.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	s.clients[c.ID] = c
+	return nil
+}
+
+// FindClient finds the client by given id.
+// Returns No Rows error if there's no client with given id.
+func (s *storage) FindClient(ctx context.Context, id string) (*oidc.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, apperror.ErrNoRows
+	}
+
+	client.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	return &client, nil
+}
+
+// CreateCode stores a copy of given authorization code.
+func (s *storage) CreateCode(ctx context.Context, code *oidc.AuthCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[code.Hash] = *code
+	return nil
+}
+
+// ConsumeCode finds and deletes the code with given hash.
+// Returns No Rows error if there's no code with given hash.
+func (s *storage) ConsumeCode(ctx context.Context, hash string) (*oidc.AuthCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[hash]
+	if !ok {
+		return nil, apperror.ErrNoRows
+	}
+
+	delete(s.codes, hash)
+	return &code, nil
+}
+
+// DeleteExpiredCodes deletes codes expired before given time.
+// Returns number of deleted codes.
+func (s *storage) DeleteExpiredCodes(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for hash, code := range s.codes {
+		if code.ExpiresAt.Before(before) {
+			delete(s.codes, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// CreateKey stores a copy of given signing key.
+func (s *storage) CreateKey(ctx context.Context, key *oidc.SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = *key
+	return nil
+}
+
+// ListKeys returns signing keys, newest first.
+func (s *storage) ListKeys(ctx context.Context) ([]oidc.SigningKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]oidc.SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// DeleteKeys deletes keys created before given time.
+// Returns number of deleted keys.
+func (s *storage) DeleteKeys(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, key := range s.keys {
+		if key.CreatedAt.Before(before) {
+			delete(s.keys, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (oidc.Storage, func() error) {
+		return memory.NewStorage(), func() error { return nil }
+	})
+}
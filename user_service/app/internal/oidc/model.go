@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+// Client is an application which authenticates users with OpenID Connect.
+// Confidential clients authenticate with a secret, only SHA-256 hash
+// of which is stored. Public clients have no secret and rely on PKCE.
+type Client struct {
+	ID           string    `json:"clientId" bson:"_id" example:"3f2b8c1d9e7a4b6c8d0e1f2a3b4c5d6e"`
+	Name         string    `json:"name" bson:"name" example:"wiki"`
+	SecretHash   string    `json:"-" bson:"secretHash,omitempty"`
+	RedirectURIs []string  `json:"redirectUris" bson:"redirectUris" example:"https://wiki.sueta.local/callback"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+} // @name OIDCClient
+
+// Public reports whether the client has no secret.
+func (c *Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// RegisteredClient is returned once on client registration.
+// Secret can't be obtained later.
+type RegisteredClient struct {
+	Client
+	Secret string `json:"clientSecret,omitempty" example:"9c4f0e5a7b2d4e1f8a6c3b5d7e9f1a2b"`
+} // @name RegisteredOIDCClient
+
+// CreateClientDTO is used to register a client.
+type CreateClientDTO struct {
+	Name         string   `json:"name" example:"wiki"`
+	RedirectURIs []string `json:"redirectUris" example:"https://wiki.sueta.local/callback"`
+	Public       bool     `json:"public" example:"false"`
+} // @name CreateOIDCClientInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (c *CreateClientDTO) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Name, validation.Required, validation.Length(1, 50)),
+		validation.Field(&c.RedirectURIs, validation.Required, validation.Each(validation.Required, is.URL)),
+	)
+}
+
+// AuthCode is a single-use authorization code issued to the client
+// on behalf of the user. Code is stored as SHA-256 hash.
+type AuthCode struct {
+	Hash          string    `bson:"_id"`
+	ClientID      string    `bson:"clientId"`
+	RedirectURI   string    `bson:"redirectUri"`
+	UserUUID      string    `bson:"userId"`
+	Scope         string    `bson:"scope"`
+	Nonce         string    `bson:"nonce,omitempty"`
+	CodeChallenge string    `bson:"codeChallenge"`
+	ExpiresAt     time.Time `bson:"expiresAt"`
+}
+
+// SigningKey is RSA key tokens are signed with.
+// PrivateKey is PKCS #1 key in PEM format.
+type SigningKey struct {
+	ID         string    `bson:"_id"`
+	PrivateKey string    `bson:"privateKey"`
+	CreatedAt  time.Time `bson:"createdAt"`
+}
+
+// AuthorizeDTO is a request of authorization code. UUID is the user
+// logged in to the provider, it's empty if the user hasn't logged in.
+type AuthorizeDTO struct {
+	UUID                string
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Consent is what the user is asked before the client gets authorization
+// code. Token carries the authorization request and is posted back with
+// the decision, so it's issued only once the user has logged in.
+// If the request can't be fulfilled, only Redirect is set: it carries
+// OAuth error for the client.
+type Consent struct {
+	ClientName string
+	Scopes     []string
+	Token      string
+	Redirect   string
+}
+
+// ConsentDTO is a decision of the user on the authorization request.
+// UUID is the user who has logged in.
+type ConsentDTO struct {
+	UUID  string
+	Token string
+	Allow bool
+}
+
+// TokenDTO is a request to exchange authorization code for tokens.
+type TokenDTO struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+// TokenResponse contains tokens issued for authorization code.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int    `json:"expires_in" example:"3600"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope" example:"openid email profile"`
+} // @name OIDCTokenResponse
+
+// UserInfo contains claims about the user.
+type UserInfo struct {
+	Subject       string `json:"sub" example:"6205151b67f8792099abb78e"`
+	Email         string `json:"email" example:"admin@example.com"`
+	EmailVerified bool   `json:"email_verified" example:"true"`
+	Username      string `json:"preferred_username" example:"admin"`
+} // @name OIDCUserInfo
+
+// Discovery is OpenID Provider metadata.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+} // @name OIDCDiscovery
+
+// JWK is a public RSA key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty" example:"RSA"`
+	Use       string `json:"use" example:"sig"`
+	Algorithm string `json:"alg" example:"RS256"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e" example:"AQAB"`
+} // @name JWK
+
+// JWKS is a set of public keys tokens are signed with.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+} // @name JWKS
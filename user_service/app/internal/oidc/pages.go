@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+)
+
+// pageFiles contains HTML pages the user interacts with during authorization.
+//
+//go:embed pages/*.html
+var pageFiles embed.FS
+
+var pages = template.Must(template.ParseFS(pageFiles, "pages/*.html"))
+
+// loginPage is data of the login page. Request is the query of
+// the authorization request the user returns to after login.
+// Client is shown only when it's taken from the client registration.
+// Challenge is set once password is checked and the second factor
+// is required.
+type loginPage struct {
+	Action    string
+	Client    string
+	Request   string
+	Challenge string
+	Error     string
+}
+
+// consentPage is data of the consent page.
+type consentPage struct {
+	Action string
+	Client string
+	Scopes []string
+	Token  string
+}
+
+// page responses with given HTML page. Pages must not be cached
+// or framed by other sites, so the user can't be tricked into
+// submitting them.
+func (h *Handler) page(w http.ResponseWriter, code int, name string, data interface{}) {
+	var body bytes.Buffer
+	if err := pages.ExecuteTemplate(&body, name, data); err != nil {
+		h.logger.Errorf("failed to render %s: %v", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(code)
+	w.Write(body.Bytes())
+}
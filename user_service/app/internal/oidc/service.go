@@ -0,0 +1,586 @@
+// Package oidc implements OpenID Connect provider, so other applications
+// can authenticate users of the service. Only authorization code flow
+// with PKCE is supported. Users log in to the provider with a password
+// and consent to every authorization request.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+const (
+	// scopeOpenID must be requested by every client.
+	scopeOpenID = "openid"
+	// codeChallengeS256 is the only supported PKCE method.
+	codeChallengeS256 = "S256"
+	// accessTokenType is a type header of issued access tokens.
+	accessTokenType = "at+jwt"
+	// consentTokenType is a type header of consent tokens.
+	consentTokenType = "consent+jwt"
+	// consentTTL is how long the user can decide on the consent.
+	consentTTL = 10 * time.Minute
+	// sessionTokenType is a type header of provider session tokens.
+	sessionTokenType = "session+jwt"
+	// sessionTTL is how long the user stays logged in to the provider.
+	sessionTTL = time.Hour
+)
+
+// Service describes OpenID Connect provider functionality.
+type Service interface {
+	RegisterClient(ctx context.Context, input *CreateClientDTO) (*RegisteredClient, error)
+	StartSession(ctx context.Context, u *user.User) (string, error)
+	Session(ctx context.Context, sessionToken string) (string, error)
+	Consent(ctx context.Context, input *AuthorizeDTO) (*Consent, error)
+	Authorize(ctx context.Context, input *ConsentDTO) (string, error)
+	Exchange(ctx context.Context, input *TokenDTO) (*TokenResponse, error)
+	UserInfo(ctx context.Context, accessToken string) (*UserInfo, error)
+	Discovery() *Discovery
+	Keys(ctx context.Context) (*JWKS, error)
+	RotateKeys(ctx context.Context) error
+}
+
+// Params describes provider settings. Issuer is the base URL the provider
+// is served at. Signing keys are rotated every KeyRotation and kept for
+// TokenTTL more, so issued tokens can still be verified.
+type Params struct {
+	Issuer      string
+	CodeTTL     time.Duration
+	TokenTTL    time.Duration
+	KeyRotation time.Duration
+}
+
+type service struct {
+	logger  logger.Logger
+	storage Storage
+	users   user.Service
+	params  Params
+
+	mu     sync.Mutex
+	parsed map[string]*rsa.PrivateKey
+}
+
+// NewService returns a new instance that implements Service interface.
+// Users are found with given user service.
+func NewService(storage Storage, users user.Service, params Params, logger logger.Logger) Service {
+	params.Issuer = strings.TrimSuffix(params.Issuer, "/")
+
+	return &service{
+		logger:  logger,
+		storage: storage,
+		users:   users,
+		params:  params,
+		parsed:  make(map[string]*rsa.PrivateKey),
+	}
+}
+
+// idClaims describes a payload of ID token.
+type idClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Username      string `json:"preferred_username"`
+}
+
+// accessClaims describes a payload of access token.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+}
+
+// providerClaims are claims of tokens the provider issues for itself.
+// Methods are promoted from embedded registered claims.
+type providerClaims interface {
+	jwt.Claims
+	VerifyIssuer(cmp string, req bool) bool
+	VerifyAudience(cmp string, req bool) bool
+}
+
+// sessionClaims describes a payload of provider session token.
+// Stamp is the security stamp of the user, so the session ends
+// once the password changes.
+type sessionClaims struct {
+	jwt.RegisteredClaims
+	Stamp string `json:"stamp"`
+}
+
+// consentClaims describes a payload of consent token.
+// It carries the authorization request the user decides on.
+type consentClaims struct {
+	jwt.RegisteredClaims
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state,omitempty"`
+	Nonce         string `json:"nonce,omitempty"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// RegisterClient registers a new client. Confidential clients get
+// a secret, which is returned only once.
+func (s *service) RegisterClient(ctx context.Context, input *CreateClientDTO) (*RegisteredClient, error) {
+	id, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	client := RegisteredClient{
+		Client: Client{
+			ID:           id,
+			Name:         input.Name,
+			RedirectURIs: input.RedirectURIs,
+			CreatedAt:    time.Now().UTC(),
+		},
+	}
+
+	if !input.Public {
+		client.Secret, err = token.NewID()
+		if err != nil {
+			return nil, err
+		}
+		client.SecretHash = token.Hash(client.Secret)
+	}
+
+	if err := s.storage.CreateClient(ctx, &client.Client); err != nil {
+		s.logger.Warnf("failed to store client: %v", err)
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// StartSession issues a provider session token for the user who has logged
+// in to the provider. The session is known to the provider only, so neither
+// user session nor tokens accepted by the API are created.
+func (s *service) StartSession(ctx context.Context, u *user.User) (string, error) {
+	now := time.Now().UTC()
+	return s.sign(ctx, &sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.params.Issuer,
+			Subject:   u.UUID,
+			Audience:  jwt.ClaimStrings{s.params.Issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(sessionTTL)),
+		},
+		Stamp: u.SecurityStamp(),
+	}, sessionTokenType)
+}
+
+// Session returns uuid of the user given provider session token was issued
+// to. Returns Access Denied OAuth error if the token can't be used, the user
+// is locked or has changed password since the session started.
+func (s *service) Session(ctx context.Context, sessionToken string) (string, error) {
+	var claims sessionClaims
+	if err := s.parse(ctx, sessionToken, sessionTokenType, &claims); err != nil {
+		return "", newError(ErrCodeAccessDenied, "session is invalid or expired")
+	}
+
+	u, err := s.users.GetById(ctx, claims.Subject)
+	switch {
+	case errors.Is(err, apperror.ErrNoRows), errors.Is(err, apperror.ErrInvalidUUID):
+		return "", newError(ErrCodeAccessDenied, "user is not found")
+	case err != nil:
+		return "", err
+	case u.LockedAt != nil:
+		return "", newError(ErrCodeAccessDenied, "user is locked")
+	case u.SecurityStamp() != claims.Stamp:
+		return "", newError(ErrCodeAccessDenied, "session is invalid or expired")
+	}
+
+	return u.UUID, nil
+}
+
+// Consent checks the authorization request of the user and returns
+// the consent the user is asked for. If UUID is empty, only the request
+// is checked and consent token isn't issued, the user has to log in first.
+// If the client or redirect URI is not valid, the user must not be
+// redirected, so OAuth error is returned instead.
+func (s *service) Consent(ctx context.Context, input *AuthorizeDTO) (*Consent, error) {
+	client, redirect, err := s.checkRequest(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if redirect != "" {
+		return &Consent{Redirect: redirect}, nil
+	}
+
+	consent := &Consent{
+		ClientName: client.Name,
+		Scopes:     strings.Fields(input.Scope),
+	}
+	if input.UUID == "" {
+		return consent, nil
+	}
+
+	now := time.Now().UTC()
+	consent.Token, err = s.sign(ctx, &consentClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.params.Issuer,
+			Subject:   input.UUID,
+			Audience:  jwt.ClaimStrings{s.params.Issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(consentTTL)),
+		},
+		ClientID:      input.ClientID,
+		RedirectURI:   input.RedirectURI,
+		Scope:         input.Scope,
+		State:         input.State,
+		Nonce:         input.Nonce,
+		CodeChallenge: input.CodeChallenge,
+	}, consentTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	return consent, nil
+}
+
+// Authorize applies the decision of the user on the authorization request
+// carried by consent token. Consent token is accepted only from the user
+// it was issued to. Returns URL the user agent should be redirected to.
+// It carries authorization code if the user has allowed access, otherwise
+// Access Denied OAuth error. Returns OAuth error if the consent can't be used.
+func (s *service) Authorize(ctx context.Context, input *ConsentDTO) (string, error) {
+	var claims consentClaims
+	if err := s.parse(ctx, input.Token, consentTokenType, &claims); err != nil || claims.Subject != input.UUID {
+		return "", newError(ErrCodeInvalidRequest, "consent is invalid or expired")
+	}
+
+	request := &AuthorizeDTO{
+		UUID:                claims.Subject,
+		ResponseType:        "code",
+		ClientID:            claims.ClientID,
+		RedirectURI:         claims.RedirectURI,
+		Scope:               claims.Scope,
+		State:               claims.State,
+		Nonce:               claims.Nonce,
+		CodeChallenge:       claims.CodeChallenge,
+		CodeChallengeMethod: codeChallengeS256,
+	}
+
+	// The client or the user could have changed since consent was asked.
+	client, redirect, err := s.checkRequest(ctx, request)
+	if err != nil || redirect != "" {
+		return redirect, err
+	}
+
+	if !input.Allow {
+		return errorRedirect(request, newError(ErrCodeAccessDenied, "the user denied access"))
+	}
+
+	code, err := token.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.storage.CreateCode(ctx, &AuthCode{
+		Hash:          token.Hash(code),
+		ClientID:      client.ID,
+		RedirectURI:   request.RedirectURI,
+		UserUUID:      request.UUID,
+		Scope:         request.Scope,
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(s.params.CodeTTL),
+	})
+	if err != nil {
+		s.logger.Warnf("failed to store authorization code: %v", err)
+		return "", err
+	}
+
+	return redirectTo(request, url.Values{"code": {code}})
+}
+
+// checkRequest checks the authorization request and the user, if UUID
+// is set. Returns OAuth error if the client or redirect URI is not valid.
+// Other problems are reported to the client, so URL carrying OAuth error
+// is returned instead of the client.
+func (s *service) checkRequest(ctx context.Context, input *AuthorizeDTO) (*Client, string, error) {
+	client, err := s.findClient(ctx, input.ClientID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !contains(client.RedirectURIs, input.RedirectURI) {
+		return nil, "", newError(ErrCodeInvalidRequest, "redirect_uri is not registered for the client")
+	}
+
+	var e *Error
+	switch {
+	case input.ResponseType != "code":
+		e = newError(ErrCodeUnsupportedResponseType, "only code response type is supported")
+	case !contains(strings.Fields(input.Scope), scopeOpenID):
+		e = newError(ErrCodeInvalidScope, "openid scope is required")
+	case input.CodeChallenge == "" || input.CodeChallengeMethod != codeChallengeS256:
+		e = newError(ErrCodeInvalidRequest, "code_challenge with S256 method is required")
+	}
+
+	if e == nil && input.UUID != "" {
+		u, err := s.users.GetById(ctx, input.UUID)
+		switch {
+		case errors.Is(err, apperror.ErrNoRows), errors.Is(err, apperror.ErrInvalidUUID):
+			e = newError(ErrCodeAccessDenied, "user is not found")
+		case err != nil:
+			return nil, "", err
+		case u.LockedAt != nil:
+			e = newError(ErrCodeAccessDenied, "user is locked")
+		}
+	}
+
+	if e != nil {
+		redirect, err := errorRedirect(input, e)
+		return nil, redirect, err
+	}
+
+	return client, "", nil
+}
+
+// Exchange exchanges authorization code for ID and access tokens.
+// The code can be exchanged only once by the client it was issued to.
+// Returns OAuth error if the request can't be fulfilled or the user
+// is locked.
+func (s *service) Exchange(ctx context.Context, input *TokenDTO) (*TokenResponse, error) {
+	if input.GrantType != "authorization_code" {
+		return nil, newError(ErrCodeUnsupportedGrantType, "only authorization_code grant type is supported")
+	}
+
+	client, err := s.findClient(ctx, input.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.Public() && !equalHash(token.Hash(input.ClientSecret), client.SecretHash) {
+		return nil, newError(ErrCodeInvalidClient, "client authentication failed")
+	}
+
+	code, err := s.storage.ConsumeCode(ctx, token.Hash(input.Code))
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, newError(ErrCodeInvalidGrant, "authorization code is invalid or expired")
+		}
+		s.logger.Warnf("failed to find authorization code: %v", err)
+		return nil, err
+	}
+
+	switch {
+	case !time.Now().Before(code.ExpiresAt), code.ClientID != client.ID:
+		return nil, newError(ErrCodeInvalidGrant, "authorization code is invalid or expired")
+	case code.RedirectURI != input.RedirectURI:
+		return nil, newError(ErrCodeInvalidGrant, "redirect_uri doesn't match")
+	case !equalHash(challenge(input.CodeVerifier), code.CodeChallenge):
+		return nil, newError(ErrCodeInvalidGrant, "code_verifier doesn't match")
+	}
+
+	u, err := s.users.GetById(ctx, code.UserUUID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, newError(ErrCodeInvalidGrant, "user is not found")
+		}
+		return nil, err
+	}
+
+	// The user could have been locked since the code was issued.
+	if u.LockedAt != nil {
+		return nil, newError(ErrCodeInvalidGrant, "user is locked")
+	}
+
+	now := time.Now().UTC()
+	registered := func(audience string) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    s.params.Issuer,
+			Subject:   u.UUID,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.params.TokenTTL)),
+		}
+	}
+
+	idToken, err := s.sign(ctx, &idClaims{
+		RegisteredClaims: registered(client.ID),
+		Nonce:            code.Nonce,
+		Email:            u.Email,
+		EmailVerified:    u.Verified,
+		Username:         u.Username,
+	}, "JWT")
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.sign(ctx, &accessClaims{
+		RegisteredClaims: registered(s.params.Issuer),
+		Scope:            code.Scope,
+		ClientID:         client.ID,
+	}, accessTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.params.TokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+// UserInfo returns current claims about the user given access token
+// was issued for. Returns OAuth error if the token can't be used
+// or the user is locked.
+func (s *service) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	var claims accessClaims
+	if err := s.parse(ctx, accessToken, accessTokenType, &claims); err != nil {
+		return nil, newError(ErrCodeInvalidToken, "access token is invalid or expired")
+	}
+
+	u, err := s.users.GetById(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) || errors.Is(err, apperror.ErrInvalidUUID) {
+			return nil, newError(ErrCodeInvalidToken, "user is not found")
+		}
+		return nil, err
+	}
+
+	if u.LockedAt != nil {
+		return nil, newError(ErrCodeInvalidToken, "user is locked")
+	}
+
+	return &UserInfo{
+		Subject:       u.UUID,
+		Email:         u.Email,
+		EmailVerified: u.Verified,
+		Username:      u.Username,
+	}, nil
+}
+
+// Discovery returns provider metadata.
+func (s *service) Discovery() *Discovery {
+	return &Discovery{
+		Issuer:                            s.params.Issuer,
+		AuthorizationEndpoint:             s.params.Issuer + authorizeURL,
+		TokenEndpoint:                     s.params.Issuer + tokenURL,
+		UserInfoEndpoint:                  s.params.Issuer + userInfoURL,
+		JWKSURI:                           s.params.Issuer + jwksURL,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		ScopesSupported:                   []string{scopeOpenID, "email", "profile"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "email_verified", "preferred_username"},
+	}
+}
+
+// parse verifies signature, lifetime and type of the token signed
+// by the provider for itself and decodes it into claims.
+func (s *service) parse(ctx context.Context, tokenString, typ string, claims providerClaims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
+		}
+		if t.Header["typ"] != typ {
+			return nil, fmt.Errorf("not a %s token", typ)
+		}
+		kid, _ := t.Header["kid"].(string)
+		return s.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !claims.VerifyIssuer(s.params.Issuer, true) || !claims.VerifyAudience(s.params.Issuer, true) {
+		return errors.New("token is issued for another audience")
+	}
+
+	return nil
+}
+
+// findClient finds the client with given id.
+// Returns Invalid Client OAuth error if there's no such client.
+func (s *service) findClient(ctx context.Context, id string) (*Client, error) {
+	if id == "" {
+		return nil, newError(ErrCodeInvalidClient, "client_id is required")
+	}
+
+	client, err := s.storage.FindClient(ctx, id)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, newError(ErrCodeInvalidClient, "unknown client")
+		}
+		s.logger.Warnf("failed to find client: %v", err)
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// redirectTo returns redirect URI of the request with given parameters
+// and state of the request.
+func redirectTo(input *AuthorizeDTO, params url.Values) (string, error) {
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+	return appendQuery(input.RedirectURI, params)
+}
+
+// errorRedirect returns redirect URI of the request carrying given error.
+func errorRedirect(input *AuthorizeDTO, e *Error) (string, error) {
+	return redirectTo(input, url.Values{"error": {e.Code}, "error_description": {e.Description}})
+}
+
+// challenge returns S256 PKCE code challenge of given verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// equalHash compares hashes in constant time.
+func equalHash(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// appendQuery adds given parameters to query of the URL.
+func appendQuery(rawURL string, params url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect uri: %w", err)
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// contains reports whether values contain given value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package oidc
+
+import (
+	"context"
+	"time"
+)
+
+// Storage describes OpenID Connect provider storage functionality.
+// Missing records are reported with apperror.ErrNoRows.
+type Storage interface {
+	CreateClient(ctx context.Context, client *Client) error
+	FindClient(ctx context.Context, id string) (*Client, error)
+	CreateCode(ctx context.Context, code *AuthCode) error
+	// ConsumeCode finds and deletes the code with given hash,
+	// so the code can be used only once.
+	ConsumeCode(ctx context.Context, hash string) (*AuthCode, error)
+	// DeleteExpiredCodes deletes codes expired before given time.
+	DeleteExpiredCodes(ctx context.Context, before time.Time) (int64, error)
+	CreateKey(ctx context.Context, key *SigningKey) error
+	// ListKeys returns signing keys, newest first.
+	ListKeys(ctx context.Context) ([]SigningKey, error)
+	// DeleteKeys deletes keys created before given time.
+	DeleteKeys(ctx context.Context, before time.Time) (int64, error)
+}
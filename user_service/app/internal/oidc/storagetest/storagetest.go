@@ -0,0 +1,189 @@
+// Package storagetest provides a contract test suite for oidc.Storage
+// implementations, so every implementation behaves the same way.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/oidc"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewStorage returns a new empty storage and a function that cleans it up.
+type NewStorage func(t *testing.T) (oidc.Storage, func() error)
+
+// Run runs the contract test suite against storages returned by newStorage.
+// Every test gets its own storage.
+func Run(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage oidc.Storage)
+	}{
+		{"Clients", testClients},
+		{"ConsumeCode", testConsumeCode},
+		{"ConsumeCodeConcurrently", testConsumeCodeConcurrently},
+		{"DeleteExpiredCodes", testDeleteExpiredCodes},
+		{"Keys", testKeys},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+func testClients(t *testing.T, storage oidc.Storage) {
+	ctx := context.Background()
+
+	client := &oidc.Client{
+		ID:           "client",
+		Name:         "wiki",
+		SecretHash:   "hash",
+		RedirectURIs: []string{"https://wiki.sueta.local/callback", "http://localhost:3000/callback"},
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	assert.NoError(t, storage.CreateClient(ctx, client))
+
+	found, err := storage.FindClient(ctx, client.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, client.Name, found.Name)
+	assert.Equal(t, client.SecretHash, found.SecretHash)
+	assert.Equal(t, client.RedirectURIs, found.RedirectURIs)
+	assert.True(t, client.CreatedAt.Equal(found.CreatedAt))
+
+	public := &oidc.Client{ID: "public", Name: "cli", RedirectURIs: []string{"http://localhost/cb"}, CreatedAt: time.Now()}
+	assert.NoError(t, storage.CreateClient(ctx, public))
+
+	found, err = storage.FindClient(ctx, public.ID)
+	assert.NoError(t, err)
+	assert.True(t, found.Public())
+
+	_, err = storage.FindClient(ctx, "missing")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testConsumeCode(t *testing.T, storage oidc.Storage) {
+	ctx := context.Background()
+
+	code := newCode("hash", time.Now().Add(time.Minute))
+	assert.NoError(t, storage.CreateCode(ctx, code))
+
+	consumed, err := storage.ConsumeCode(ctx, code.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, code.ClientID, consumed.ClientID)
+	assert.Equal(t, code.RedirectURI, consumed.RedirectURI)
+	assert.Equal(t, code.UserUUID, consumed.UserUUID)
+	assert.Equal(t, code.Scope, consumed.Scope)
+	assert.Equal(t, code.Nonce, consumed.Nonce)
+	assert.Equal(t, code.CodeChallenge, consumed.CodeChallenge)
+	assert.True(t, code.ExpiresAt.Equal(consumed.ExpiresAt))
+
+	_, err = storage.ConsumeCode(ctx, code.Hash)
+	assert.ErrorIs(t, err, apperror.ErrNoRows, "code must be consumed only once")
+}
+
+func testConsumeCodeConcurrently(t *testing.T, storage oidc.Storage) {
+	ctx := context.Background()
+
+	code := newCode("hash", time.Now().Add(time.Minute))
+	assert.NoError(t, storage.CreateCode(ctx, code))
+
+	const workers = 10
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		consumed int
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := storage.ConsumeCode(ctx, code.Hash); err == nil {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, consumed)
+}
+
+func testDeleteExpiredCodes(t *testing.T, storage oidc.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	assert.NoError(t, storage.CreateCode(ctx, newCode("expired", now.Add(-time.Minute))))
+	assert.NoError(t, storage.CreateCode(ctx, newCode("active", now.Add(time.Minute))))
+
+	deleted, err := storage.DeleteExpiredCodes(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = storage.ConsumeCode(ctx, "expired")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	_, err = storage.ConsumeCode(ctx, "active")
+	assert.NoError(t, err)
+}
+
+func testKeys(t *testing.T, storage oidc.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	keys, err := storage.ListKeys(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, storage.CreateKey(ctx, &oidc.SigningKey{
+			ID:         fmt.Sprintf("key%d", i),
+			PrivateKey: "pem",
+			CreatedAt:  now.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	keys, err = storage.ListKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 3) {
+		assert.Equal(t, "key2", keys[0].ID, "newest key must be first")
+		assert.Equal(t, "key0", keys[2].ID)
+		assert.Equal(t, "pem", keys[0].PrivateKey)
+	}
+
+	deleted, err := storage.DeleteKeys(ctx, now.Add(90*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	keys, err = storage.ListKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "key2", keys[0].ID)
+	}
+}
+
+// newCode returns authorization code with given hash and expiration time.
+func newCode(hash string, expiresAt time.Time) *oidc.AuthCode {
+	return &oidc.AuthCode{
+		Hash:          hash,
+		ClientID:      "client",
+		RedirectURI:   "https://wiki.sueta.local/callback",
+		UserUUID:      "6205151b67f8792099abb78e",
+		Scope:         "openid email",
+		Nonce:         "nonce",
+		CodeChallenge: "challenge",
+		ExpiresAt:     expiresAt.UTC().Truncate(time.Millisecond),
+	}
+}
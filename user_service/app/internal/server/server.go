@@ -7,7 +7,6 @@ import (
 
 	"github.com/juicyluv/sueta/user_service/app/config"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
-	"github.com/julienschmidt/httprouter"
 )
 
 // Server represents http server.
@@ -17,7 +16,7 @@ type Server struct {
 }
 
 // NewServer returns a new Server instance.
-func NewServer(cfg *config.Config, handler *httprouter.Router, logger *logger.Logger) *Server {
+func NewServer(cfg *config.Config, handler http.Handler, logger *logger.Logger) *Server {
 	return &Server{
 		server: &http.Server{
 			Handler:        handler,
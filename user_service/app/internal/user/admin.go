@@ -0,0 +1,168 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// GetByEmail will find a user with provided email.
+// Returns No Rows error if there's no user with this email.
+func (s *service) GetByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := s.storage.FindByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, apperror.ErrNoRows) {
+			s.logger.Warnf("error occurred on finding user by email: %v", err)
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ForceVerify marks email of the user with given uuid as verified
+// without verification token. Does nothing if it's verified already.
+func (s *service) ForceVerify(ctx context.Context, uuid string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if user.Verified {
+		return nil
+	}
+
+	if err := s.storage.UpdatePartially(ctx, &User{UUID: user.UUID, Verified: true}); err != nil {
+		s.logger.Warnf("failed to verify the user: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserVerify, Target: user.UUID, Fields: []string{"verified"}})
+
+	return nil
+}
+
+// SetPassword sets a new password of the user with given uuid without
+// the old one. The password is checked against password policy, validation
+// error is returned if it's too weak. The user is logged out everywhere.
+func (s *service) SetPassword(ctx context.Context, uuid, password string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPassword("password", password, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	updated := &User{UUID: user.UUID, Password: password}
+	if err := updated.HashPassword(s.hasher); err != nil {
+		s.logger.Warnf("failed to hash password: %v", err)
+		return err
+	}
+
+	if err := s.storage.UpdatePartially(ctx, updated); err != nil {
+		s.logger.Warnf("failed to update the user: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionPasswordReset, Target: user.UUID, Fields: []string{"password"}})
+
+	return s.revokeSessions(ctx, user.UUID)
+}
+
+// Replace replaces username, password hash, verification and role
+// of the user with given uuid with values of given replacement, e.g.
+// a user imported from another system. Changed fields are recorded
+// to audit log. The user is logged out everywhere if the password
+// has been replaced.
+func (s *service) Replace(ctx context.Context, uuid string, replacement *User) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	if user.Username != replacement.Username {
+		fields = append(fields, "username")
+	}
+	if user.Password != replacement.Password {
+		fields = append(fields, "password")
+	}
+	if user.Verified != replacement.Verified {
+		fields = append(fields, "verified")
+	}
+	if user.Role != replacement.Role {
+		fields = append(fields, "role")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	passwordReplaced := user.Password != replacement.Password
+	user.Username = replacement.Username
+	user.Password = replacement.Password
+	user.Verified = replacement.Verified
+	user.Role = replacement.Role
+
+	if err := s.storage.Replace(ctx, user); err != nil {
+		s.logger.Warnf("failed to replace the user: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserImport, Target: user.UUID, Fields: fields})
+
+	if passwordReplaced {
+		return s.revokeSessions(ctx, user.UUID)
+	}
+	return nil
+}
+
+// Lock locks the user with given uuid, so the user can't log in
+// or use API keys until unlocked. The user is logged out everywhere.
+// Locking a locked user keeps the original lock time.
+func (s *service) Lock(ctx context.Context, uuid string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if user.LockedAt == nil {
+		now := time.Now().UTC()
+		if err := s.storage.SetLocked(ctx, user.UUID, &now); err != nil {
+			s.logger.Warnf("failed to lock the user: %v", err)
+			return err
+		}
+
+		s.record(ctx, &audit.Event{Action: audit.ActionUserLock, Target: user.UUID, Fields: []string{"lockedAt"}})
+	}
+
+	// Sessions are revoked even if the user was locked already,
+	// so repeating the command fixes a failed revocation.
+	return s.revokeSessions(ctx, user.UUID)
+}
+
+// Unlock unlocks the user with given uuid.
+// Does nothing if the user isn't locked.
+func (s *service) Unlock(ctx context.Context, uuid string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if user.LockedAt == nil {
+		return nil
+	}
+
+	if err := s.storage.SetLocked(ctx, user.UUID, nil); err != nil {
+		s.logger.Warnf("failed to unlock the user: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserUnlock, Target: user.UUID, Fields: []string{"lockedAt"}})
+
+	return nil
+}
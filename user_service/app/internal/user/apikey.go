@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// Check whether authService implements auth key verifier interface.
+var _ auth.KeyVerifier = &authService{}
+
+// touchInterval is how often last use of API key is recorded,
+// so every request doesn't cause a write. Change of client IP
+// is recorded immediately.
+const touchInterval = time.Minute
+
+// CreateAPIKey issues a new API key for the user with provided uuid.
+// Key has the form of auth.APIKeyPrefix, key id and secret separated
+// by underscore. Only hash of the key is stored, so the key is returned
+// only once.
+func (s *authService) CreateAPIKey(ctx context.Context, input *CreateAPIKeyDTO) (*CreatedAPIKey, error) {
+	user, err := s.userService.GetById(ctx, input.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	plain := auth.APIKeyPrefix + id + "_" + secret
+	now := time.Now().UTC()
+
+	key := APIKey{
+		ID:        id,
+		UserUUID:  user.UUID,
+		Name:      input.Name,
+		Scopes:    uniqueScopes(input.Scopes),
+		Hash:      token.Hash(plain),
+		ExpiresAt: now.AddDate(0, 0, input.ExpiresInDays),
+		CreatedAt: now,
+	}
+
+	if err := s.apiKeys.Create(ctx, &key); err != nil {
+		s.logger.Warnf("failed to store API key: %v", err)
+		return nil, err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionAPIKeyCreate, Target: input.UUID})
+	return &CreatedAPIKey{APIKey: key, Key: plain}, nil
+}
+
+// ListAPIKeys returns not revoked API keys of the user with provided uuid.
+// Expired keys are listed too, so the user can see them.
+func (s *authService) ListAPIKeys(ctx context.Context, uuid string) ([]APIKey, error) {
+	user, err := s.userService.GetById(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.apiKeys.ListByUser(ctx, user.UUID)
+	if err != nil {
+		s.logger.Warnf("failed to list API keys: %v", err)
+		return nil, err
+	}
+
+	if keys == nil {
+		keys = []APIKey{}
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes API key with given id of the user with provided uuid.
+// Returns No Rows error if the user has no active key with given id.
+func (s *authService) RevokeAPIKey(ctx context.Context, uuid, id string) error {
+	if err := s.apiKeys.Revoke(ctx, uuid, id); err != nil {
+		if !errors.Is(err, apperror.ErrNoRows) {
+			s.logger.Warnf("failed to revoke API key: %v", err)
+		}
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionAPIKeyRevoke, Target: uuid})
+	return nil
+}
+
+// VerifyKey implements auth.KeyVerifier. It checks given API key and
+// returns claims of the key owner with current role and scopes of the key.
+// Records when and from which IP the key was used.
+// Returns Invalid API Key error if the key cannot be used.
+func (s *authService) VerifyKey(ctx context.Context, plain, ip string) (*token.Claims, error) {
+	id, ok := parseAPIKey(plain)
+	if !ok {
+		return nil, apperror.ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeys.FindById(ctx, id)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, apperror.ErrInvalidAPIKey
+		}
+		s.logger.Warnf("failed to find API key: %v", err)
+		return nil, err
+	}
+
+	hash := token.Hash(plain)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) != 1 {
+		return nil, apperror.ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if key.Revoked || !now.Before(key.ExpiresAt) {
+		return nil, apperror.ErrInvalidAPIKey
+	}
+
+	user, err := s.userService.GetById(ctx, key.UserUUID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, apperror.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if user.LockedAt != nil {
+		return nil, apperror.ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || key.LastUsedIP != ip || now.Sub(*key.LastUsedAt) >= touchInterval {
+		// Failure to record usage shouldn't break the request.
+		if err := s.apiKeys.Touch(ctx, key.ID, now, ip); err != nil {
+			s.logger.Warnf("failed to record API key usage: %v", err)
+		}
+	}
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	claims := &token.Claims{
+		Type:   token.APIKey,
+		Email:  user.Email,
+		Role:   string(user.Role),
+		Scopes: scopes,
+	}
+	claims.Subject = user.UUID
+	claims.ID = key.ID
+
+	return claims, nil
+}
+
+// parseAPIKey returns id of given API key.
+// Reports false if the key is malformed.
+func parseAPIKey(plain string) (string, bool) {
+	if !strings.HasPrefix(plain, auth.APIKeyPrefix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(plain, auth.APIKeyPrefix), "_")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// uniqueScopes returns given scopes without duplicates keeping the order.
+func uniqueScopes(scopes []auth.Scope) []auth.Scope {
+	seen := make(map[auth.Scope]bool, len(scopes))
+	unique := make([]auth.Scope, 0, len(scopes))
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
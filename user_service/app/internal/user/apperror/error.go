@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 var (
@@ -20,13 +21,76 @@ var (
 	// ErrEmailTaken is used when the user is being created and given email is already taken.
 	ErrEmailTaken = errors.New("email already taken")
 
+	// ErrUsernameTaken is used when given username is already taken by another user.
+	// Usernames are compared case-insensitively.
+	ErrUsernameTaken = errors.New("username already taken")
+
 	// ErrWrongPassword is used when user entered wrong password.
 	ErrWrongPassword = errors.New("wrong email or password")
 
+	// ErrVersionConflict is used when the user has been changed since
+	// the requested version, so the change would overwrite another one.
+	ErrVersionConflict = errors.New("user has been changed by another request, please fetch it again")
+
 	// ErrInvalidUUID is used when invalid uuid provided.
 	ErrInvalidUUID = errors.New("invalid uuid")
+
+	// ErrInvalidToken is used when provided token is malformed, expired or revoked.
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	// ErrAlreadyVerified is used when user email has been verified already.
+	ErrAlreadyVerified = errors.New("email already verified")
+
+	// ErrPasswordsDontMatch is used when repeated password differs from the new one.
+	ErrPasswordsDontMatch = errors.New("passwords don't match")
+
+	// ErrTooManyRequests is used when the same action is requested too often.
+	ErrTooManyRequests = errors.New("too many requests, please try again later")
+
+	// ErrInvalidCursor is used when provided page cursor is malformed.
+	ErrInvalidCursor = errors.New("invalid page cursor")
+
+	// ErrLoginLocked is used when login is temporarily blocked
+	// because of too many failed attempts.
+	ErrLoginLocked = errors.New("too many failed login attempts, please try again later")
+
+	// ErrAccountLocked is used when the user has been locked by an operator.
+	ErrAccountLocked = errors.New("account is locked")
+
+	// ErrTwoFactorEnabled is used when two-factor authentication is enabled already.
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+
+	// ErrTwoFactorNotEnabled is used when two-factor authentication
+	// hasn't been enrolled or confirmed yet.
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+	// ErrInvalidCode is used when provided one-time or recovery code is wrong.
+	ErrInvalidCode = errors.New("invalid two-factor code")
+
+	// ErrInvalidAPIKey is used when provided API key is malformed, expired or revoked.
+	ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+	// ErrExportNotReady is used when archive of personal data export
+	// is requested before the export is done or after it has expired.
+	ErrExportNotReady = errors.New("export is not ready or has expired")
 )
 
+// RetryError wraps an error of an action that can be retried later.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error returns a string representation of an error.
+func (re *RetryError) Error() string {
+	return re.Err.Error()
+}
+
+// Unwrap returns wrapped error.
+func (re *RetryError) Unwrap() error {
+	return re.Err
+}
+
 // AppError describes a structure of an error response in JSON format.
 type AppError struct {
 	Err              error  `json:"-"`
@@ -0,0 +1,482 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// AuthService describes authentication functionality.
+type AuthService interface {
+	Login(ctx context.Context, input *LoginDTO) (*LoginResult, error)
+	LoginTwoFactor(ctx context.Context, input *TwoFactorLoginDTO) (*token.Pair, error)
+	Authenticate(ctx context.Context, input *LoginDTO) (*Authentication, error)
+	AuthenticateTwoFactor(ctx context.Context, input *TwoFactorLoginDTO) (*User, error)
+	Refresh(ctx context.Context, input *RefreshTokenDTO) (*token.Pair, error)
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutAll(ctx context.Context, refreshToken string) error
+	CreateAPIKey(ctx context.Context, input *CreateAPIKeyDTO) (*CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, uuid string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, uuid, id string) error
+	VerifyKey(ctx context.Context, key, ip string) (*token.Claims, error)
+	ListSessions(ctx context.Context, uuid, current string) ([]Session, error)
+	RevokeSession(ctx context.Context, uuid, id string) error
+}
+
+type authService struct {
+	logger       logger.Logger
+	userService  Service
+	tokenStorage TokenStorage
+	sessions     SessionStorage
+	apiKeys      APIKeyStorage
+	auditor      audit.Recorder
+	tokens       *token.Manager
+	emailLimiter *lockout.Limiter
+	ipLimiter    *lockout.Limiter
+}
+
+// NewAuthService returns a new instance that implements AuthService interface.
+// Failed logins are throttled per email with emailLimiter and per client IP with ipLimiter.
+// Changes of API keys and sessions are recorded with auditor.
+func NewAuthService(
+	userService Service,
+	tokenStorage TokenStorage,
+	sessions SessionStorage,
+	apiKeys APIKeyStorage,
+	auditor audit.Recorder,
+	tokens *token.Manager,
+	emailLimiter, ipLimiter *lockout.Limiter,
+	logger logger.Logger,
+) AuthService {
+	return &authService{
+		logger:       logger,
+		userService:  userService,
+		tokenStorage: tokenStorage,
+		sessions:     sessions,
+		apiKeys:      apiKeys,
+		auditor:      auditor,
+		tokens:       tokens,
+		emailLimiter: emailLimiter,
+		ipLimiter:    ipLimiter,
+	}
+}
+
+// Login checks user credentials like Authenticate and returns signed
+// access and refresh tokens of a new session on success. If the user has
+// enabled two-factor authentication, returns challenge token instead,
+// which is exchanged for tokens by LoginTwoFactor.
+func (s *authService) Login(ctx context.Context, input *LoginDTO) (*LoginResult, error) {
+	authn, err := s.Authenticate(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if authn.TwoFactorRequired {
+		return &LoginResult{TwoFactorRequired: true, ChallengeToken: authn.ChallengeToken}, nil
+	}
+
+	pair, err := s.login(ctx, authn.User, input.IP, input.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{Pair: pair}, nil
+}
+
+// LoginTwoFactor completes login of the user with two-factor authentication
+// enabled like AuthenticateTwoFactor and returns signed access and refresh
+// tokens of a new session on success.
+func (s *authService) LoginTwoFactor(ctx context.Context, input *TwoFactorLoginDTO) (*token.Pair, error) {
+	user, err := s.AuthenticateTwoFactor(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.login(ctx, user, input.IP, input.UserAgent)
+}
+
+// Authenticate checks user credentials through user service without
+// issuing tokens, so the caller can keep the user logged in by itself.
+// If there's no user with given email or password doesn't match,
+// returns Wrong Password error, so the caller can't tell which one was wrong.
+// If there were too many failed attempts for the email or client IP recently,
+// returns Login Locked error wrapped into Retry error without checking credentials.
+// If the user has enabled two-factor authentication, returns challenge token
+// instead of the user, which is exchanged for the user by AuthenticateTwoFactor.
+func (s *authService) Authenticate(ctx context.Context, input *LoginDTO) (*Authentication, error) {
+	email := strings.ToLower(input.Email)
+
+	if err := s.checkAttempts(ctx, email, input.IP); err != nil {
+		return nil, err
+	}
+
+	if err := s.reserveAttempt(ctx, email); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetByEmailAndPassword(ctx, input.Email, input.Password)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) || errors.Is(err, apperror.ErrWrongPassword) {
+			s.failAttempt(ctx, input.IP)
+			return nil, apperror.ErrWrongPassword
+		}
+		return nil, err
+	}
+
+	// Attempts are reset only once the second factor is checked,
+	// otherwise codes could be guessed without limits.
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		challenge, err := s.tokens.NewToken(token.TwoFactor, user.UUID, user.Email, user.SecurityStamp())
+		if err != nil {
+			return nil, err
+		}
+		return &Authentication{TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
+
+	s.authenticated(ctx, user, email)
+	return &Authentication{User: user}, nil
+}
+
+// AuthenticateTwoFactor completes authentication of the user with
+// two-factor authentication enabled. Challenge token returned by Login
+// or Authenticate is exchanged with one-time or recovery code for the user.
+// Wrong codes are throttled the same way as wrong passwords. Returns Invalid
+// Token error if challenge token cannot be used and Invalid Code error
+// if code is wrong.
+func (s *authService) AuthenticateTwoFactor(ctx context.Context, input *TwoFactorLoginDTO) (*User, error) {
+	claims, err := s.tokens.Parse(input.ChallengeToken, token.TwoFactor)
+	if err != nil {
+		return nil, apperror.ErrInvalidToken
+	}
+
+	email := strings.ToLower(claims.Email)
+
+	if err := s.checkAttempts(ctx, email, input.IP); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.GetById(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) || errors.Is(err, apperror.ErrInvalidUUID) {
+			return nil, apperror.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	// Password change invalidates pending challenges.
+	if claims.Stamp != user.SecurityStamp() {
+		return nil, apperror.ErrInvalidToken
+	}
+
+	if user.LockedAt != nil {
+		return nil, apperror.ErrAccountLocked
+	}
+
+	if err := s.reserveAttempt(ctx, email); err != nil {
+		return nil, err
+	}
+
+	if err := s.userService.VerifySecondFactor(ctx, user, input.Code); err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrInvalidCode):
+			s.failAttempt(ctx, input.IP)
+			return nil, err
+		case errors.Is(err, apperror.ErrTwoFactorNotEnabled):
+			return nil, apperror.ErrInvalidToken
+		default:
+			return nil, err
+		}
+	}
+
+	s.authenticated(ctx, user, email)
+	return user, nil
+}
+
+// Refresh rotates given refresh token: it revokes the token and issues
+// a new token pair within the same family. If revoked token is used again,
+// the whole family is revoked, because the token has probably leaked.
+// Session of the token family is marked as seen from the client IP.
+// Returns Invalid Token error if token cannot be used.
+func (s *authService) Refresh(ctx context.Context, input *RefreshTokenDTO) (*token.Pair, error) {
+	stored, err := s.findActive(ctx, input.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokenStorage.Revoke(ctx, stored.ID); err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			// Token has been rotated by a concurrent request.
+			return nil, s.revokeFamily(ctx, stored)
+		}
+		s.logger.Warnf("failed to revoke refresh token: %v", err)
+		return nil, err
+	}
+
+	user, err := s.userService.GetById(ctx, stored.UserUUID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, apperror.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	pair, err := s.issue(ctx, user, stored.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err = s.sessions.Touch(ctx, stored.Family, now, input.IP, now.Add(s.tokens.TTL(token.Refresh)))
+	if err != nil && !errors.Is(err, apperror.ErrNoRows) {
+		// Failure to record session activity shouldn't break refresh.
+		s.logger.Warnf("failed to update session: %v", err)
+	}
+
+	return pair, nil
+}
+
+// Logout revokes given refresh token and ends its session.
+// Returns Invalid Token error if token cannot be used.
+func (s *authService) Logout(ctx context.Context, refreshToken string) error {
+	stored, err := s.findActive(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenStorage.Revoke(ctx, stored.ID); err != nil && !errors.Is(err, apperror.ErrNoRows) {
+		s.logger.Warnf("failed to revoke refresh token: %v", err)
+		return err
+	}
+
+	if err := s.sessions.Revoke(ctx, stored.UserUUID, stored.Family); err != nil && !errors.Is(err, apperror.ErrNoRows) {
+		s.logger.Warnf("failed to revoke session: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// LogoutAll revokes all refresh tokens of the user who owns given token,
+// so the user is logged out on every device.
+// Returns Invalid Token error if token cannot be used.
+func (s *authService) LogoutAll(ctx context.Context, refreshToken string) error {
+	stored, err := s.findActive(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokenStorage.RevokeAllByUser(ctx, stored.UserUUID); err != nil {
+		s.logger.Warnf("failed to revoke user refresh tokens: %v", err)
+		return err
+	}
+
+	if err := s.sessions.RevokeAllByUser(ctx, stored.UserUUID); err != nil {
+		s.logger.Warnf("failed to revoke user sessions: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// checkAttempts returns Login Locked error wrapped into Retry error
+// if login for given email or client IP is throttled now.
+// Attempts store failures are logged only, so login keeps working
+// if the store is unavailable.
+func (s *authService) checkAttempts(ctx context.Context, email, ip string) error {
+	var retryAfter time.Duration
+
+	limits := []struct {
+		limiter *lockout.Limiter
+		key     string
+	}{
+		{s.emailLimiter, email},
+		{s.ipLimiter, ip},
+	}
+
+	for _, l := range limits {
+		if l.key == "" {
+			continue
+		}
+
+		wait, err := l.limiter.RetryAfter(ctx, l.key)
+		if err != nil {
+			s.logger.Warnf("failed to check login attempts: %v", err)
+			continue
+		}
+
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		s.logger.Warnf("login for %s from %s is throttled for %s", email, ip, retryAfter)
+		return &apperror.RetryError{Err: apperror.ErrLoginLocked, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// reserveAttempt counts login attempt for given email as failed before
+// credentials are checked, so concurrent attempts which have all passed
+// checkAttempts can't check more credentials than the limit allows.
+// Returns Login Locked error wrapped into Retry error if the attempt is
+// over the limit. The attempt is forgotten once the user is authenticated,
+// since attempts of the email are reset then.
+func (s *authService) reserveAttempt(ctx context.Context, email string) error {
+	count, err := s.emailLimiter.Fail(ctx, email)
+	if err != nil {
+		s.logger.Warnf("failed to record login attempt: %v", err)
+		return nil
+	}
+
+	if wait := s.emailLimiter.Exceeded(count); wait > 0 {
+		s.logger.Warnf("login for %s is locked out for %s", email, wait)
+		return &apperror.RetryError{Err: apperror.ErrLoginLocked, RetryAfter: wait}
+	}
+
+	return nil
+}
+
+// failAttempt records failed login from given client IP. Attempt
+// for the email has been recorded already by reserveAttempt.
+func (s *authService) failAttempt(ctx context.Context, ip string) {
+	if ip == "" {
+		return
+	}
+
+	if _, err := s.ipLimiter.Fail(ctx, ip); err != nil {
+		s.logger.Warnf("failed to record login attempt: %v", err)
+	}
+}
+
+// authenticated resets failed attempts of given email and records
+// last login time of the user once credentials are checked.
+func (s *authService) authenticated(ctx context.Context, user *User, email string) {
+	if err := s.emailLimiter.Reset(ctx, email); err != nil {
+		s.logger.Warnf("failed to reset login attempts: %v", err)
+	}
+
+	// Last login time is informational, so login doesn't fail without it.
+	if err := s.userService.RecordLogin(ctx, user.UUID); err != nil {
+		s.logger.Warnf("failed to record login time: %v", err)
+	}
+}
+
+// login issues a token pair of a new family for the authenticated user.
+// The login is recorded as a session with client IP and user agent.
+func (s *authService) login(ctx context.Context, user *User, ip, userAgent string) (*token.Pair, error) {
+	family, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err = s.sessions.Create(ctx, &Session{
+		ID:         family,
+		UserUUID:   user.UUID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		ExpiresAt:  now.Add(s.tokens.TTL(token.Refresh)),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+	if err != nil {
+		s.logger.Warnf("failed to store session: %v", err)
+		return nil, err
+	}
+
+	return s.issue(ctx, user, family)
+}
+
+// issue issues a new token pair for the user and stores refresh token.
+func (s *authService) issue(ctx context.Context, user *User, family string) (*token.Pair, error) {
+	id, err := token.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := s.tokens.NewPair(token.Identity{
+		UUID:  user.UUID,
+		Email: user.Email,
+		Role:  string(user.Role),
+	}, id, family)
+	if err != nil {
+		s.logger.Warnf("failed to issue tokens: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err = s.tokenStorage.Create(ctx, &RefreshToken{
+		ID:        id,
+		Family:    family,
+		UserUUID:  user.UUID,
+		ExpiresAt: now.Add(s.tokens.TTL(token.Refresh)),
+		CreatedAt: now,
+	})
+	if err != nil {
+		s.logger.Warnf("failed to store refresh token: %v", err)
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// findActive verifies given refresh token and finds it in storage.
+// If token has been revoked already, revokes its family.
+// Returns Invalid Token error if token cannot be used.
+func (s *authService) findActive(ctx context.Context, refreshToken string) (*RefreshToken, error) {
+	claims, err := s.tokens.ParseRefresh(refreshToken)
+	if err != nil {
+		return nil, apperror.ErrInvalidToken
+	}
+
+	stored, err := s.tokenStorage.FindById(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil, apperror.ErrInvalidToken
+		}
+		s.logger.Warnf("failed to find refresh token: %v", err)
+		return nil, err
+	}
+
+	if stored.Revoked {
+		return nil, s.revokeFamily(ctx, stored)
+	}
+
+	return stored, nil
+}
+
+// revokeFamily revokes all tokens of the family given token belongs to
+// and ends the session of the family.
+// Returns Invalid Token error if family has been revoked.
+func (s *authService) revokeFamily(ctx context.Context, stored *RefreshToken) error {
+	s.logger.Warnf("refresh token reuse detected for user %s, revoking token family", stored.UserUUID)
+
+	if err := s.tokenStorage.RevokeFamily(ctx, stored.Family); err != nil {
+		s.logger.Warnf("failed to revoke token family: %v", err)
+		return err
+	}
+
+	if err := s.sessions.Revoke(ctx, stored.UserUUID, stored.Family); err != nil && !errors.Is(err, apperror.ErrNoRows) {
+		s.logger.Warnf("failed to revoke session: %v", err)
+		return err
+	}
+
+	return apperror.ErrInvalidToken
+}
+
+// record records given event to audit log. Failure to record
+// is logged only, so the change itself isn't rolled back.
+func (s *authService) record(ctx context.Context, event *audit.Event) {
+	if err := s.auditor.Record(ctx, event); err != nil {
+		s.logger.Errorf("failed to record %s of user %s to audit log: %v", event.Action, event.Target, err)
+	}
+}
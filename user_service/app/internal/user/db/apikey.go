@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check whether apiKeyDB implements API key storage interface.
+var _ user.APIKeyStorage = &apiKeyDB{}
+
+// apiKeyDB implementes API key storage interface.
+type apiKeyDB struct {
+	logger     logger.Logger
+	collection *mongo.Collection
+}
+
+// NewAPIKeyStorage returns a new API key storage instance.
+func NewAPIKeyStorage(storage *mongo.Database, collection string) user.APIKeyStorage {
+	return &apiKeyDB{
+		logger:     logger.GetLogger(),
+		collection: storage.Collection(collection),
+	}
+}
+
+// Create inserts a new API key in the database.
+// Returns an error on failure.
+func (d *apiKeyDB) Create(ctx context.Context, key *user.APIKey) error {
+	_, err := d.collection.InsertOne(ctx, key)
+	if err != nil {
+		e := fmt.Errorf("cannot insert API key in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the API key by given id.
+// Returns No Rows error if there's no key with given id.
+func (d *apiKeyDB) FindById(ctx context.Context, id string) (*user.APIKey, error) {
+	result := d.collection.FindOne(ctx, bson.M{"_id": id})
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var key user.APIKey
+	if err := result.Decode(&key); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	return &key, nil
+}
+
+// ListByUser returns not revoked keys of the user with given uuid, newest first.
+func (d *apiKeyDB) ListByUser(ctx context.Context, userUUID string) ([]user.APIKey, error) {
+	filter := bson.M{"userId": userUUID, "revoked": false}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := d.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var keys []user.APIKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Revoke marks the key with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active key with given id.
+func (d *apiKeyDB) Revoke(ctx context.Context, userUUID, id string) error {
+	filter := bson.M{"_id": id, "userId": userUUID, "revoked": false}
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot revoke API key: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllByUser marks all keys of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *apiKeyDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	filter := bson.M{"userId": userUUID, "revoked": false}
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	if _, err := d.collection.UpdateMany(ctx, filter, query); err != nil {
+		return fmt.Errorf("cannot revoke API keys: %w", err)
+	}
+
+	return nil
+}
+
+// Touch records when and from which IP the key with given id was used.
+// Returns No Rows error if there's no key with given id.
+func (d *apiKeyDB) Touch(ctx context.Context, id string, at time.Time, ip string) error {
+	query := bson.M{"$set": bson.M{"lastUsedAt": at, "lastUsedIp": ip}}
+
+	result, err := d.collection.UpdateOne(ctx, bson.M{"_id": id}, query)
+	if err != nil {
+		return fmt.Errorf("cannot update API key: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
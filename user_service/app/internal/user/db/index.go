@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index modes describe what to do with postgres schema at startup.
+// Indexes of mongo users collection are created by migrations,
+// so they're only checked in both modes.
+const (
+	// IndexModeApply creates missing tables and indexes.
+	IndexModeApply = "apply"
+	// IndexModeReport only reports schema drift without changing anything.
+	IndexModeReport = "report"
+)
+
+const (
+	emailIndex    = "email_unique"
+	usernameIndex = "username_unique_ci"
+)
+
+// index describes an index users collection must have.
+type index struct {
+	name      string
+	key       string
+	collation *options.Collation
+}
+
+// indexes contains unique indexes of users collection created
+// by migrations. They cover only users which aren't deleted.
+// Usernames are compared case-insensitively.
+var indexes = []index{
+	{name: emailIndex, key: "email"},
+	{name: usernameIndex, key: "username", collation: &options.Collation{Locale: "en", Strength: 2}},
+}
+
+// CheckIndexes compares indexes of given collection with expected ones
+// without changing anything. Returns descriptions of found drift.
+func CheckIndexes(ctx context.Context, storage *mongo.Database, collection string) ([]string, error) {
+	existing, err := listIndexes(ctx, storage, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for _, idx := range indexes {
+		found := findIndex(existing, idx.name)
+
+		switch {
+		case found == nil:
+			drift = append(drift, fmt.Sprintf("index %s on %s.%s missing", idx.name, collection, idx.key))
+		case !idx.matches(found):
+			drift = append(drift, fmt.Sprintf("index %s on %s.%s has different definition", idx.name, collection, idx.key))
+		}
+	}
+
+	return drift, nil
+}
+
+// RequireIndexes returns an error if unique indexes of given collection
+// are missing. Nothing but the indexes keeps emails and usernames unique,
+// so the storage must not be used until migrations create them.
+func RequireIndexes(ctx context.Context, storage *mongo.Database, collection string) error {
+	existing, err := listIndexes(ctx, storage, collection)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if findIndex(existing, idx.name) == nil {
+			return fmt.Errorf("index %s on %s.%s missing, run usersctl migrate up", idx.name, collection, idx.key)
+		}
+	}
+
+	return nil
+}
+
+// listIndexes returns indexes of given collection.
+func listIndexes(ctx context.Context, storage *mongo.Database, collection string) ([]bson.M, error) {
+	cursor, err := storage.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list indexes: %w", err)
+	}
+
+	var existing []bson.M
+	if err := cursor.All(ctx, &existing); err != nil {
+		return nil, fmt.Errorf("failed to decode indexes: %w", err)
+	}
+
+	return existing, nil
+}
+
+// findIndex returns index with given name or nil.
+func findIndex(existing []bson.M, name string) bson.M {
+	for _, idx := range existing {
+		if idx["name"] == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// matches reports whether existing index has expected definition.
+func (idx index) matches(existing bson.M) bool {
+	if unique, _ := existing["unique"].(bool); !unique {
+		return false
+	}
+
+	key, _ := existing["key"].(bson.M)
+	if len(key) != 1 || key[idx.key] == nil {
+		return false
+	}
+
+	partial, _ := existing["partialFilterExpression"].(bson.M)
+	deletedAt, _ := partial["deletedAt"].(bson.M)
+	if len(partial) != 1 || len(deletedAt) != 1 || deletedAt["$exists"] != false {
+		return false
+	}
+
+	collation, _ := existing["collation"].(bson.M)
+	if idx.collation == nil {
+		return collation == nil
+	}
+
+	return collation != nil &&
+		collation["locale"] == idx.collation.Locale &&
+		fmt.Sprint(collation["strength"]) == fmt.Sprint(idx.collation.Strength)
+}
+
+// duplicateKeyError translates duplicate key error into Email Taken
+// or Username Taken error depending on violated index.
+func duplicateKeyError(err error) error {
+	switch {
+	case strings.Contains(err.Error(), usernameIndex):
+		return apperror.ErrUsernameTaken
+	case strings.Contains(err.Error(), emailIndex):
+		return apperror.ErrEmailTaken
+	}
+
+	return err
+}
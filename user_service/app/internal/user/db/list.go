@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxCount limits counting of filtered users, so large
+// collections are not scanned entirely for an estimate.
+const maxCount = 10000
+
+// List finds users matching given filter. Deleted users are skipped. Users are sorted by
+// requested field and then by object id, so the cursor can point
+// to the exact position in the result.
+func (d *db) List(ctx context.Context, filter *user.ListFilter) ([]user.User, error) {
+	query, err := listQuery(filter, true)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := 1
+	if filter.Descending {
+		direction = -1
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: filter.SortBy, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(filter.Limit))
+
+	cursor, err := d.collection.Find(ctx, query, opts)
+	if err != nil {
+		d.logger.Warnf("failed to execute query: %v", err)
+		return nil, err
+	}
+
+	var users []user.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+
+	return users, nil
+}
+
+// Count returns number of users matching given filter.
+// Counting stops at maxCount, so large collections are not scanned entirely.
+func (d *db) Count(ctx context.Context, filter *user.ListFilter) (int64, error) {
+	query, err := listQuery(filter, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.collection.CountDocuments(ctx, query, options.Count().SetLimit(maxCount))
+}
+
+// listQuery builds a query from given filter. Cursor is applied if withCursor is true.
+func listQuery(filter *user.ListFilter, withCursor bool) (bson.M, error) {
+	conditions := bson.A{bson.M{"deletedAt": notDeleted}}
+
+	if filter.Verified != nil {
+		// Unverified users have no verified field, because it's omitted when empty.
+		if *filter.Verified {
+			conditions = append(conditions, bson.M{"verified": true})
+		} else {
+			conditions = append(conditions, bson.M{"verified": bson.M{"$ne": true}})
+		}
+	}
+
+	if !filter.RegisteredFrom.IsZero() {
+		conditions = append(conditions, bson.M{
+			"registeredAt": bson.M{"$gte": filter.RegisteredFrom},
+		})
+	}
+
+	if !filter.RegisteredTo.IsZero() {
+		conditions = append(conditions, bson.M{
+			"registeredAt": bson.M{"$lt": filter.RegisteredTo},
+		})
+	}
+
+	if filter.UsernamePrefix != "" {
+		conditions = append(conditions, bson.M{
+			"username": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.UsernamePrefix)},
+		})
+	}
+
+	if withCursor && filter.After != nil {
+		id, err := primitive.ObjectIDFromHex(filter.After.UUID)
+		if err != nil {
+			return nil, apperror.ErrInvalidCursor
+		}
+
+		value, err := user.AfterValue(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		op := "$gt"
+		if filter.Descending {
+			op = "$lt"
+		}
+
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{filter.SortBy: bson.M{op: value}},
+			bson.M{filter.SortBy: value, "_id": bson.M{op: id}},
+		}})
+	}
+
+	return bson.M{"$and": conditions}, nil
+}
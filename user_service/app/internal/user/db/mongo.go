@@ -12,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Check whether db implements user storage interface.
@@ -33,10 +34,14 @@ func NewStorage(storage *mongo.Database, collection string) user.Storage {
 
 // Create inserts a new row in the database.
 // It uses InsertOne behind the scenes.
-// Returns an error on failure or inserted user uuid on success.
+// Returns Email Taken or Username Taken error if unique index is violated,
+// an error on failure or inserted user uuid on success.
 func (d *db) Create(ctx context.Context, user *user.User) (string, error) {
 	result, err := d.collection.InsertOne(ctx, user)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", duplicateKeyError(err)
+		}
 		e := fmt.Errorf("cannot insert user in database: %w", err)
 		d.logger.Warn(e)
 		return "", e
@@ -52,12 +57,81 @@ func (d *db) Create(ctx context.Context, user *user.User) (string, error) {
 	return id.Hex(), nil
 }
 
+// CreateBatch inserts given users with a single unordered InsertMany,
+// so a user violating unique index doesn't stop insertion of the others.
+// Object ids are generated beforehand to report uuid of every inserted user.
+// Returns Email Taken or Username Taken error in results of duplicate users
+// and an error if the batch failed as a whole.
+func (d *db) CreateBatch(ctx context.Context, users []*user.User) ([]user.BatchResult, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	results := make([]user.BatchResult, len(users))
+	docs := make([]interface{}, len(users))
+	for i, u := range users {
+		doc, err := bson.Marshal(u)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode user: %w", err)
+		}
+
+		id := primitive.NewObjectID()
+		docs[i] = append(bson.D{{Key: "_id", Value: id}}, withoutID(doc)...)
+		results[i].UUID = id.Hex()
+	}
+
+	_, err := d.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			e := fmt.Errorf("cannot insert users in database: %w", err)
+			d.logger.Warn(e)
+			return nil, e
+		}
+
+		for _, we := range bulkErr.WriteErrors {
+			e := error(we.WriteError)
+			if isDuplicateKeyCode(we.Code) {
+				e = duplicateKeyError(we.WriteError)
+			}
+			results[we.Index] = user.BatchResult{Err: e}
+		}
+	}
+
+	return results, nil
+}
+
+// isDuplicateKeyCode reports whether given write error code
+// is one of codes mongo.IsDuplicateKeyError checks for.
+func isDuplicateKeyCode(code int) bool {
+	return code == 11000 || code == 11001 || code == 12582
+}
+
+// withoutID returns elements of encoded document except _id.
+func withoutID(raw []byte) bson.D {
+	var doc bson.D
+	// Document has just been marshaled, so it's always valid.
+	_ = bson.Unmarshal(raw, &doc)
+
+	fields := doc[:0]
+	for _, e := range doc {
+		if e.Key != "_id" {
+			fields = append(fields, e)
+		}
+	}
+	return fields
+}
+
+// notDeleted matches users which are not marked as deleted.
+var notDeleted = bson.M{"$exists": false}
+
 // FindByEmail finds the user by given email.
 // Returns user instance on success, but on failure
 // returns an error or No Rows Error
 // if there's no user with given email.
+// Deleted users are not found.
 func (d *db) FindByEmail(ctx context.Context, email string) (*user.User, error) {
-	filter := bson.M{"email": email}
+	filter := bson.M{"email": email, "deletedAt": notDeleted}
 
 	result := d.collection.FindOne(ctx, filter)
 	if err := result.Err(); err != nil {
@@ -79,6 +153,7 @@ func (d *db) FindByEmail(ctx context.Context, email string) (*user.User, error)
 // FindById finds the user by given uuid.
 // Returns user instance on success, but on failure
 // returns an error or No Rows Error if there's no user with given uuid.
+// Deleted users are not found.
 func (d *db) FindById(ctx context.Context, uuid string) (*user.User, error) {
 	var user *user.User
 
@@ -87,7 +162,7 @@ func (d *db) FindById(ctx context.Context, uuid string) (*user.User, error) {
 		return nil, apperror.ErrInvalidUUID
 	}
 
-	filter := bson.M{"_id": objectID}
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -109,37 +184,138 @@ func (d *db) FindById(ctx context.Context, uuid string) (*user.User, error) {
 }
 
 // UpdatePartially updates the user with new provided values.
-// Returns an error if something went wrong or No Rows error if
-// there's no user with given uuid.
+// Returns an error if something went wrong, No Rows error if
+// there's no user with given uuid and Email Taken or Username Taken
+// error if unique index is violated.
 func (d *db) UpdatePartially(ctx context.Context, user *user.User) error {
 	objectId, err := primitive.ObjectIDFromHex(user.UUID)
 	if err != nil {
-		return fmt.Errorf("failed to convert hex to objectid: %w", err)
+		return apperror.ErrInvalidUUID
 	}
-	filter := bson.M{"_id": objectId}
+	filter := bson.M{"_id": objectId, "deletedAt": notDeleted}
 
+	updated, err := setFields(user)
+	if err != nil {
+		return err
+	}
+
+	return d.update(ctx, filter, changed(bson.M{"$set": updated}))
+}
+
+// CompareAndUpdate updates the user with new provided values if
+// the stored version is the version of given user. Returns Version
+// Conflict error if the version has changed and errors
+// of UpdatePartially otherwise.
+func (d *db) CompareAndUpdate(ctx context.Context, user *user.User) error {
+	objectId, err := primitive.ObjectIDFromHex(user.UUID)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+	filter := bson.M{"_id": objectId, "deletedAt": notDeleted, "version": versionIs(user.Version)}
+
+	updated, err := setFields(user)
+	if err != nil {
+		return err
+	}
+	updatedAt := time.Now().UTC().Truncate(time.Millisecond)
+	updated["version"] = user.Version + 1
+	updated["updatedAt"] = updatedAt
+
+	if err := d.update(ctx, filter, bson.M{"$set": updated}); err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return d.conflictOrNoRows(ctx, objectId)
+		}
+		return err
+	}
+
+	user.Version++
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+// Replace writes username, password, verification and role of given user
+// as they are if the stored version is the version of given user.
+// Returns Version Conflict error if it isn't and errors of UpdatePartially
+// otherwise.
+func (d *db) Replace(ctx context.Context, user *user.User) error {
+	objectId, err := primitive.ObjectIDFromHex(user.UUID)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+	filter := bson.M{"_id": objectId, "deletedAt": notDeleted, "version": versionIs(user.Version)}
+
+	updatedAt := time.Now().UTC().Truncate(time.Millisecond)
+	query := bson.M{"$set": bson.M{
+		"username":  user.Username,
+		"password":  user.Password,
+		"verified":  user.Verified,
+		"role":      user.Role,
+		"version":   user.Version + 1,
+		"updatedAt": updatedAt,
+	}}
+
+	if err := d.update(ctx, filter, query); err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return d.conflictOrNoRows(ctx, objectId)
+		}
+		return err
+	}
+
+	user.Version++
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+// setFields returns non-empty fields of given user to set on update.
+// Version and update time are left to the caller. Last login time
+// is set by SetLastLogin only, so an update of the user read before
+// the login doesn't overwrite it.
+func setFields(user *user.User) (bson.M, error) {
 	userBytes, err := bson.Marshal(&user)
 	if err != nil {
-		return fmt.Errorf("failed to marshal document: %w", err)
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
 	}
 
 	var updated bson.M
 	err = bson.Unmarshal(userBytes, &updated)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal document: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal document: %w", err)
 	}
 
 	delete(updated, "_id")
+	delete(updated, "version")
+	delete(updated, "updatedAt")
+	delete(updated, "lastLoginAt")
+
+	return updated, nil
+}
 
-	query := bson.M{
-		"$set": updated,
+// changed adds incrementing of the version and setting of the update time
+// to given update query. Every change of a user goes through it.
+func changed(query bson.M) bson.M {
+	set, ok := query["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		query["$set"] = set
 	}
+	// Mongo keeps milliseconds only, so the returned time
+	// matches the stored one.
+	set["updatedAt"] = time.Now().UTC().Truncate(time.Millisecond)
+	query["$inc"] = bson.M{"version": 1}
+	return query
+}
 
+// update executes given update query of a single user.
+// Returns No Rows error if no user matches the filter.
+func (d *db) update(ctx context.Context, filter, query bson.M) error {
 	result, err := d.collection.UpdateOne(ctx, filter, query)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return apperror.ErrNoRows
 		}
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyError(err)
+		}
 		d.logger.Warn("failed to execute query: %w", err)
 		return err
 	}
@@ -151,23 +327,222 @@ func (d *db) UpdatePartially(ctx context.Context, user *user.User) error {
 	return nil
 }
 
-// Delete deletes the user row with given uuid. Returns an error on failure.
+// conflictOrNoRows tells why a conditional change has matched nothing.
+// Returns Version Conflict error if the user exists and No Rows error
+// if it doesn't.
+func (d *db) conflictOrNoRows(ctx context.Context, objectID primitive.ObjectID) error {
+	count, err := d.collection.CountDocuments(ctx, bson.M{"_id": objectID, "deletedAt": notDeleted})
+	if err != nil {
+		return fmt.Errorf("cannot check user existence: %v", err)
+	}
+
+	if count == 0 {
+		return apperror.ErrNoRows
+	}
+	return apperror.ErrVersionConflict
+}
+
+// versionIs matches users with given version. Users stored
+// before versioning have no version, which is version zero.
+func versionIs(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// SetTwoFactor replaces two-factor state of the user with given uuid.
+// Nil two-factor state is removed from the document.
 // Returns ErrNoRows if there's no such user with given uuid.
-func (d *db) Delete(ctx context.Context, uuid string) error {
+func (d *db) SetTwoFactor(ctx context.Context, uuid string, twoFactor *user.TwoFactor) error {
+	return d.setTwoFactor(ctx, uuid, nil, twoFactor)
+}
+
+// CompareAndSetTwoFactor replaces two-factor state of the user with given
+// uuid if the stored version is given version. Returns Version Conflict
+// error if it isn't and errors of SetTwoFactor otherwise.
+func (d *db) CompareAndSetTwoFactor(ctx context.Context, uuid string, version int64, twoFactor *user.TwoFactor) error {
+	return d.setTwoFactor(ctx, uuid, &version, twoFactor)
+}
+
+// setTwoFactor replaces two-factor state of the user if expected
+// version is nil or it's the stored version.
+func (d *db) setTwoFactor(ctx context.Context, uuid string, expected *int64, twoFactor *user.TwoFactor) error {
 	objectID, err := primitive.ObjectIDFromHex(uuid)
 	if err != nil {
-		return fmt.Errorf("failed to convert hex to objectid: %w", err)
+		return apperror.ErrInvalidUUID
 	}
 
-	filter := bson.M{"_id": objectID}
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
+	if expected != nil {
+		filter["version"] = versionIs(*expected)
+	}
 
-	result := d.collection.FindOneAndDelete(ctx, filter)
-	if err := result.Err(); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return apperror.ErrNoRows
+	query := changed(bson.M{"$set": bson.M{"twoFactor": twoFactor}})
+	if twoFactor == nil {
+		query = changed(bson.M{"$unset": bson.M{"twoFactor": ""}})
+	}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot update two-factor state: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		if expected != nil {
+			return d.conflictOrNoRows(ctx, objectID)
 		}
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetPendingEmail replaces pending email change of the user with given uuid.
+// Nil pending email change is removed from the document.
+// Returns ErrNoRows if there's no such user with given uuid.
+func (d *db) SetPendingEmail(ctx context.Context, uuid string, pending *user.PendingEmail) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
+	query := changed(bson.M{"$set": bson.M{"pendingEmail": pending}})
+	if pending == nil {
+		query = changed(bson.M{"$unset": bson.M{"pendingEmail": ""}})
+	}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot update pending email change: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetLocked replaces lock time of the user with given uuid.
+// Nil lock time is removed from the document.
+// Returns ErrNoRows if there's no such user with given uuid.
+func (d *db) SetLocked(ctx context.Context, uuid string, lockedAt *time.Time) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
+	query := changed(bson.M{"$set": bson.M{"lockedAt": lockedAt}})
+	if lockedAt == nil {
+		query = changed(bson.M{"$unset": bson.M{"lockedAt": ""}})
+	}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot update user lock: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetLastLogin records login time of the user with given uuid.
+// It's not a change of the user, so version and update time are kept.
+// Returns ErrNoRows if there's no such user with given uuid.
+func (d *db) SetLastLogin(ctx context.Context, uuid string, at time.Time) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
+	query := bson.M{"$set": bson.M{"lastLoginAt": at}}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot update last login time: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete marks the user with given uuid as deleted. If version is given,
+// the user is deleted only if it's the stored version. Returns ErrNoRows
+// if there's no such user with given uuid and ErrVersionConflict if
+// the version has changed.
+func (d *db) Delete(ctx context.Context, uuid string, version *int64) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	filter := bson.M{"_id": objectID, "deletedAt": notDeleted}
+	if version != nil {
+		filter["version"] = versionIs(*version)
+	}
+	query := changed(bson.M{"$set": bson.M{"deletedAt": time.Now().UTC().Truncate(time.Millisecond)}})
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
 		return fmt.Errorf("cannot delete user: %v", err)
 	}
 
+	if result.MatchedCount == 0 {
+		if version != nil {
+			return d.conflictOrNoRows(ctx, objectID)
+		}
+		return apperror.ErrNoRows
+	}
+
 	return nil
 }
+
+// Restore removes deletion mark of the user with given uuid.
+// Returns ErrNoRows if there's no deleted user with given uuid and
+// Email Taken or Username Taken error if unique index is violated.
+func (d *db) Restore(ctx context.Context, uuid string) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	filter := bson.M{"_id": objectID, "deletedAt": bson.M{"$exists": true}}
+	query := changed(bson.M{"$unset": bson.M{"deletedAt": ""}})
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyError(err)
+		}
+		return fmt.Errorf("cannot restore user: %v", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Purge permanently deletes users marked as deleted before given time.
+// Returns number of deleted users.
+func (d *db) Purge(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.M{"deletedAt": bson.M{"$lte": before}}
+
+	result, err := d.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge users: %v", err)
+	}
+
+	return result.DeletedCount, nil
+}
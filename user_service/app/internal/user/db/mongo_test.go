@@ -0,0 +1,133 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/migrations"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/storagetest"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+	"github.com/stretchr/testify/assert"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// connectMongo connects to MongoDB available at MONGO_URL.
+// The test is skipped if it's not set.
+func connectMongo(t *testing.T) *mongodriver.Database {
+	mongoURL := os.Getenv("MONGO_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+
+	logger.Init()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	database, err := mongo.NewMongoClient(ctx, "sueta_test", mongoURL)
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %v", err)
+	}
+	t.Cleanup(func() { database.Client().Disconnect(context.Background()) })
+
+	return database
+}
+
+// newCollection returns name of a new collection and a function dropping it.
+func newCollection(database *mongodriver.Database, prefix string) (string, func() error) {
+	collection := fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+	return collection, func() error {
+		return database.Collection(collection).Drop(context.Background())
+	}
+}
+
+// TestStorage runs storage contract tests against MongoDB
+// available at MONGO_URL. Skipped if it's not set.
+func TestStorage(t *testing.T) {
+	database := connectMongo(t)
+
+	// migrate applies migrations to given collection like usersctl does
+	// and returns a function dropping collection of applied migrations.
+	migrate := func(t *testing.T, collection string) func() error {
+		migrator, err := migrations.New(database, collection, collection+"_migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(context.Background(), 0); err != nil {
+			t.Fatalf("cannot apply migrations: %v", err)
+		}
+		return func() error {
+			return database.Collection(collection + "_migrations").Drop(context.Background())
+		}
+	}
+
+	t.Run("CheckIndexes", func(t *testing.T) {
+		collection := fmt.Sprintf("users_%d", time.Now().UnixNano())
+		defer database.Collection(collection).Drop(context.Background())
+
+		drift, err := db.CheckIndexes(context.Background(), database, collection)
+		assert.NoError(t, err)
+		assert.Len(t, drift, 2)
+		assert.Error(t, db.RequireIndexes(context.Background(), database, collection))
+
+		defer migrate(t, collection)()
+
+		drift, err = db.CheckIndexes(context.Background(), database, collection)
+		assert.NoError(t, err)
+		assert.Empty(t, drift)
+		assert.NoError(t, db.RequireIndexes(context.Background(), database, collection))
+	})
+
+	storagetest.Run(t, func(t *testing.T) (user.Storage, func() error) {
+		collection := fmt.Sprintf("users_%d", time.Now().UnixNano())
+
+		dropMigrations := migrate(t, collection)
+		teardown := func() error {
+			if err := dropMigrations(); err != nil {
+				return err
+			}
+			return database.Collection(collection).Drop(context.Background())
+		}
+
+		return db.NewStorage(database, collection), teardown
+	})
+}
+
+// TestTokenStorage runs refresh token storage contract tests
+// against MongoDB available at MONGO_URL. Skipped if it's not set.
+func TestTokenStorage(t *testing.T) {
+	database := connectMongo(t)
+
+	storagetest.RunTokenStorage(t, func(t *testing.T) (user.TokenStorage, func() error) {
+		collection, teardown := newCollection(database, "tokens")
+		return db.NewTokenStorage(database, collection), teardown
+	})
+}
+
+// TestSessionStorage runs session storage contract tests
+// against MongoDB available at MONGO_URL. Skipped if it's not set.
+func TestSessionStorage(t *testing.T) {
+	database := connectMongo(t)
+
+	storagetest.RunSessionStorage(t, func(t *testing.T) (user.SessionStorage, func() error) {
+		collection, teardown := newCollection(database, "sessions")
+		return db.NewSessionStorage(database, collection), teardown
+	})
+}
+
+// TestAPIKeyStorage runs API key storage contract tests
+// against MongoDB available at MONGO_URL. Skipped if it's not set.
+func TestAPIKeyStorage(t *testing.T) {
+	database := connectMongo(t)
+
+	storagetest.RunAPIKeyStorage(t, func(t *testing.T) (user.APIKeyStorage, func() error) {
+		collection, teardown := newCollection(database, "api_keys")
+		return db.NewAPIKeyStorage(database, collection), teardown
+	})
+}
@@ -0,0 +1,749 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+)
+
+// uniqueViolation is a postgres error code of unique constraint violation.
+const uniqueViolation = "23505"
+
+// schema creates users, refresh tokens, sessions and API keys tables
+// if they don't exist.
+//
+//go:embed postgres.sql
+var schema string
+
+// Check whether postgresDB implements user storage interface.
+var _ user.Storage = &postgresDB{}
+
+// postgresDB implements user storage interface on postgres.
+type postgresDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresStorage returns a new postgres user storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresStorage(pool *pgx.ConnPool) user.Storage {
+	return &postgresDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+// MigratePostgres creates users, refresh tokens, sessions and API keys
+// tables and their indexes if they don't exist.
+func MigratePostgres(ctx context.Context, pool *pgx.ConnPool) error {
+	if _, err := pool.ExecEx(ctx, schema, nil); err != nil {
+		return fmt.Errorf("cannot migrate users schema: %w", err)
+	}
+	return nil
+}
+
+// postgresIndexes maps unique indexes of users table to indexed expression.
+var postgresIndexes = map[string]string{
+	"users_email_active_idx":    "(email) WHERE (deleted_at IS NULL)",
+	"users_username_active_idx": "(lower(username)) WHERE (deleted_at IS NULL)",
+}
+
+// CheckPostgres reports missing tables and unique indexes of users table
+// which are missing or differ from the schema without changing anything.
+func CheckPostgres(ctx context.Context, pool *pgx.ConnPool) ([]string, error) {
+	rows, err := pool.QueryEx(ctx, `SELECT indexname, indexdef FROM pg_indexes WHERE tablename = 'users'`, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list indexes: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]string)
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			return nil, err
+		}
+		existing[name] = def
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for name, expr := range postgresIndexes {
+		def, ok := existing[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("index %s on users%s missing", name, expr))
+		case !strings.Contains(def, "UNIQUE") || !strings.HasSuffix(def, expr):
+			drift = append(drift, fmt.Sprintf("index %s on users%s has different definition", name, expr))
+		}
+	}
+	sort.Strings(drift)
+
+	missing, err := postgres.MissingTables(ctx, pool, "users", "refresh_tokens", "sessions", "api_keys")
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range missing {
+		drift = append(drift, fmt.Sprintf("table %s missing", table))
+	}
+
+	return drift, nil
+}
+
+const userColumns = `id::text, email, username, password, verified, role, registered_at, verification_sent_at, two_factor::text, pending_email::text, locked_at, version, updated_at, last_login_at`
+
+// Create inserts a new row in the database.
+// Returns Email Taken or Username Taken error if unique index is violated
+// or inserted user uuid on success.
+func (d *postgresDB) Create(ctx context.Context, user *user.User) (string, error) {
+	query := `
+		INSERT INTO users (email, username, password, verified, role, registered_at, verification_sent_at, two_factor, updated_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), $7, $8::jsonb, $9)
+		RETURNING id::text`
+
+	twoFactor, err := nullJSON(user.TwoFactor)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = d.pool.QueryRowEx(ctx, query, nil,
+		user.Email,
+		user.Username,
+		user.Password,
+		user.Verified,
+		string(user.Role),
+		nullTime(user.RegisteredAt),
+		nullTime(user.VerificationSentAt),
+		twoFactor,
+		nullTime(user.UpdatedAt),
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return "", uniqueViolationError(err)
+		}
+		e := fmt.Errorf("cannot insert user in database: %w", err)
+		d.logger.Warn(e)
+		return "", e
+	}
+
+	return id, nil
+}
+
+// CreateBatch inserts given users one by one, so a unique violation
+// aborts only the insert of the user causing it.
+// Results are reported the same way as by Create.
+func (d *postgresDB) CreateBatch(ctx context.Context, users []*user.User) ([]user.BatchResult, error) {
+	results := make([]user.BatchResult, len(users))
+	for i, u := range users {
+		results[i].UUID, results[i].Err = d.Create(ctx, u)
+	}
+	return results, nil
+}
+
+// FindByEmail finds the user by given email.
+// Returns No Rows error if there's no user with given email.
+func (d *postgresDB) FindByEmail(ctx context.Context, email string) (*user.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1 AND deleted_at IS NULL`
+
+	return d.findOne(ctx, query, email)
+}
+
+// FindById finds the user by given uuid.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) FindById(ctx context.Context, id string) (*user.User, error) {
+	if _, err := uuid.FromString(id); err != nil {
+		return nil, apperror.ErrInvalidUUID
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+
+	return d.findOne(ctx, query, id)
+}
+
+// UpdatePartially updates the user with non-empty provided values,
+// the same way as mongo storage does.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) UpdatePartially(ctx context.Context, user *user.User) error {
+	if _, err := uuid.FromString(user.UUID); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	updated, err := d.update(ctx, user, nil, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// CompareAndUpdate updates the user like UpdatePartially if the stored
+// version is the version of given user. Returns Version Conflict error
+// if the version has changed and errors of UpdatePartially otherwise.
+func (d *postgresDB) CompareAndUpdate(ctx context.Context, user *user.User) error {
+	if _, err := uuid.FromString(user.UUID); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	updatedAt := time.Now().UTC().Truncate(time.Microsecond)
+	updated, err := d.update(ctx, user, &user.Version, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return d.conflictOrNoRows(ctx, user.UUID)
+	}
+
+	user.Version++
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+// Replace writes username, password, verification and role of given user
+// as they are if the stored version is the version of given user.
+// Returns Version Conflict error if it isn't and errors of UpdatePartially
+// otherwise.
+func (d *postgresDB) Replace(ctx context.Context, user *user.User) error {
+	if _, err := uuid.FromString(user.UUID); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	query := `
+		UPDATE users SET
+			username = $2,
+			password = $3,
+			verified = $4,
+			role = $5,
+			version = version + 1,
+			updated_at = $7
+		WHERE id = $1 AND deleted_at IS NULL AND version = $6`
+
+	updatedAt := time.Now().UTC().Truncate(time.Microsecond)
+	tag, err := d.pool.ExecEx(ctx, query, nil,
+		user.UUID,
+		user.Username,
+		user.Password,
+		user.Verified,
+		string(user.Role),
+		user.Version,
+		updatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return uniqueViolationError(err)
+		}
+		d.logger.Warnf("failed to execute query: %v", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return d.conflictOrNoRows(ctx, user.UUID)
+	}
+
+	user.Version++
+	user.UpdatedAt = updatedAt
+	return nil
+}
+
+// update updates the user row with non-empty provided values if its
+// version is the expected one or expected version is nil.
+// Update time is set to given time. Reports whether the row has been updated.
+func (d *postgresDB) update(ctx context.Context, user *user.User, expected *int64, updatedAt time.Time) (bool, error) {
+	query := `
+		UPDATE users SET
+			email = COALESCE(NULLIF($2, ''), email),
+			username = COALESCE(NULLIF($3, ''), username),
+			password = COALESCE(NULLIF($4, ''), password),
+			verified = verified OR $5,
+			role = COALESCE(NULLIF($6, ''), role),
+			registered_at = COALESCE($7, registered_at),
+			verification_sent_at = COALESCE($8, verification_sent_at),
+			version = version + 1,
+			updated_at = $10
+		WHERE id = $1 AND deleted_at IS NULL AND ($9::bigint IS NULL OR version = $9)`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil,
+		user.UUID,
+		user.Email,
+		user.Username,
+		user.Password,
+		user.Verified,
+		string(user.Role),
+		nullTime(user.RegisteredAt),
+		nullTime(user.VerificationSentAt),
+		expected,
+		updatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return false, uniqueViolationError(err)
+		}
+		d.logger.Warnf("failed to execute query: %v", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// conflictOrNoRows tells why a conditional change has matched nothing.
+// Returns Version Conflict error if the user exists and No Rows error
+// if it doesn't.
+func (d *postgresDB) conflictOrNoRows(ctx context.Context, id string) error {
+	var exists bool
+	err := d.pool.QueryRowEx(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`, nil, id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("cannot check user existence: %v", err)
+	}
+
+	if !exists {
+		return apperror.ErrNoRows
+	}
+	return apperror.ErrVersionConflict
+}
+
+// SetTwoFactor replaces two-factor state of the user row with given uuid.
+// Nil two-factor state is stored as NULL.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) SetTwoFactor(ctx context.Context, id string, twoFactor *user.TwoFactor) error {
+	return d.setTwoFactor(ctx, id, nil, twoFactor)
+}
+
+// CompareAndSetTwoFactor replaces two-factor state of the user row with
+// given uuid if the stored version is given version. Returns Version
+// Conflict error if it isn't and errors of SetTwoFactor otherwise.
+func (d *postgresDB) CompareAndSetTwoFactor(ctx context.Context, id string, version int64, twoFactor *user.TwoFactor) error {
+	return d.setTwoFactor(ctx, id, &version, twoFactor)
+}
+
+// setTwoFactor replaces two-factor state of the user row if expected
+// version is nil or it's the stored version.
+func (d *postgresDB) setTwoFactor(ctx context.Context, id string, expected *int64, twoFactor *user.TwoFactor) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	value, err := nullJSON(twoFactor)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE users SET two_factor = $2::jsonb, version = version + 1, updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL AND ($3::bigint IS NULL OR version = $3)`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, value, expected)
+	if err != nil {
+		return fmt.Errorf("cannot update two-factor state: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		if expected != nil {
+			return d.conflictOrNoRows(ctx, id)
+		}
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetPendingEmail replaces pending email change of the user row with given uuid.
+// Nil pending email change is stored as NULL.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) SetPendingEmail(ctx context.Context, id string, pending *user.PendingEmail) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	value, err := nullJSON(pending)
+	if err != nil {
+		return err
+	}
+
+	tag, err := d.pool.ExecEx(ctx, `UPDATE users SET pending_email = $2::jsonb, version = version + 1, updated_at = now() WHERE id = $1 AND deleted_at IS NULL`, nil, id, value)
+	if err != nil {
+		return fmt.Errorf("cannot update pending email change: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetLocked replaces lock time of the user row with given uuid.
+// Nil lock time is stored as NULL.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) SetLocked(ctx context.Context, id string, lockedAt *time.Time) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	tag, err := d.pool.ExecEx(ctx, `UPDATE users SET locked_at = $2, version = version + 1, updated_at = now() WHERE id = $1 AND deleted_at IS NULL`, nil, id, lockedAt)
+	if err != nil {
+		return fmt.Errorf("cannot update user lock: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetLastLogin records login time of the user row with given uuid.
+// Version and update time are kept, since it's not a change of the user.
+// Returns Invalid UUID error if given uuid is malformed
+// and No Rows error if there's no user with given uuid.
+func (d *postgresDB) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	tag, err := d.pool.ExecEx(ctx, `UPDATE users SET last_login_at = $2 WHERE id = $1 AND deleted_at IS NULL`, nil, id, at)
+	if err != nil {
+		return fmt.Errorf("cannot update last login time: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete marks the user row with given uuid as deleted. If version is given,
+// the row is deleted only if it has this version.
+// Returns Invalid UUID error if given uuid is malformed, No Rows error
+// if there's no user with given uuid and Version Conflict error
+// if the version has changed.
+func (d *postgresDB) Delete(ctx context.Context, id string, version *int64) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	query := `
+		UPDATE users SET deleted_at = now(), version = version + 1, updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL AND ($2::bigint IS NULL OR version = $2)`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, version)
+	if err != nil {
+		return fmt.Errorf("cannot delete user: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		if version != nil {
+			return d.conflictOrNoRows(ctx, id)
+		}
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Restore removes deletion mark of the user row with given uuid.
+// Returns Invalid UUID error if given uuid is malformed, No Rows error
+// if there's no deleted user with given uuid and Email Taken or
+// Username Taken error if unique index is violated.
+func (d *postgresDB) Restore(ctx context.Context, id string) error {
+	if _, err := uuid.FromString(id); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	tag, err := d.pool.ExecEx(ctx, `UPDATE users SET deleted_at = NULL, version = version + 1, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL`, nil, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return uniqueViolationError(err)
+		}
+		return fmt.Errorf("cannot restore user: %v", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Purge permanently deletes user rows marked as deleted before given time.
+// Returns number of deleted rows.
+func (d *postgresDB) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := d.pool.ExecEx(ctx, `DELETE FROM users WHERE deleted_at <= $1`, nil, before)
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge users: %v", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// postgresColumns maps sort fields to table columns.
+var postgresColumns = map[string]string{
+	user.SortByRegisteredAt: "registered_at",
+	user.SortByUsername:     "username",
+	user.SortByEmail:        "email",
+}
+
+// List returns users matching given filter. Users are sorted by
+// requested field and then by id, so the cursor can point
+// to the exact position in the result.
+func (d *postgresDB) List(ctx context.Context, filter *user.ListFilter) ([]user.User, error) {
+	column, ok := postgresColumns[filter.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field: %s", filter.SortBy)
+	}
+
+	where, args := postgresConditions(filter)
+
+	if filter.After != nil {
+		if _, err := uuid.FromString(filter.After.UUID); err != nil {
+			return nil, apperror.ErrInvalidCursor
+		}
+
+		op := ">"
+		if filter.Descending {
+			op = "<"
+		}
+
+		value, err := user.AfterValue(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, value, filter.After.UUID)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d, $%d::uuid)", column, op, len(args)-1, len(args)))
+	}
+
+	direction := "ASC"
+	if filter.Descending {
+		direction = "DESC"
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users` + whereClause(where) +
+		fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.pool.QueryEx(ctx, query, nil, args...)
+	if err != nil {
+		d.logger.Warnf("failed to execute query: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []user.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+
+	return users, rows.Err()
+}
+
+// Count returns number of users matching given filter.
+func (d *postgresDB) Count(ctx context.Context, filter *user.ListFilter) (int64, error) {
+	where, args := postgresConditions(filter)
+
+	var count int64
+	err := d.pool.QueryRowEx(ctx, `SELECT count(*) FROM users`+whereClause(where), nil, args...).Scan(&count)
+	if err != nil {
+		d.logger.Warnf("failed to execute query: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// postgresConditions returns WHERE conditions of given filter except cursor and their arguments.
+// Deleted users are always excluded.
+func postgresConditions(filter *user.ListFilter) ([]string, []interface{}) {
+	where := []string{"deleted_at IS NULL"}
+	var args []interface{}
+
+	if filter.Verified != nil {
+		args = append(args, *filter.Verified)
+		where = append(where, fmt.Sprintf("verified = $%d", len(args)))
+	}
+
+	if !filter.RegisteredFrom.IsZero() {
+		args = append(args, filter.RegisteredFrom)
+		where = append(where, fmt.Sprintf("registered_at >= $%d", len(args)))
+	}
+
+	if !filter.RegisteredTo.IsZero() {
+		args = append(args, filter.RegisteredTo)
+		where = append(where, fmt.Sprintf("registered_at < $%d", len(args)))
+	}
+
+	if filter.UsernamePrefix != "" {
+		args = append(args, likeEscaper.Replace(filter.UsernamePrefix)+"%")
+		where = append(where, fmt.Sprintf("username LIKE $%d", len(args)))
+	}
+
+	return where, args
+}
+
+// likeEscaper escapes special characters of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// whereClause joins given conditions into WHERE clause.
+func whereClause(where []string) string {
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// findOne executes given query and scans a single user.
+// Returns No Rows error if query returned nothing.
+func (d *postgresDB) findOne(ctx context.Context, query string, args ...interface{}) (*user.User, error) {
+	u, err := scanUser(d.pool.QueryRowEx(ctx, query, nil, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		d.logger.Warnf("failed to execute query: %v", err)
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// scanner is implemented by pgx.Row and pgx.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans user selected with userColumns.
+func scanUser(row scanner) (*user.User, error) {
+	var u user.User
+	var role string
+	var sentAt *time.Time
+	var twoFactor *string
+	var pendingEmail *string
+	var lockedAt *time.Time
+	var updatedAt *time.Time
+
+	err := row.Scan(
+		&u.UUID,
+		&u.Email,
+		&u.Username,
+		&u.Password,
+		&u.Verified,
+		&role,
+		&u.RegisteredAt,
+		&sentAt,
+		&twoFactor,
+		&pendingEmail,
+		&lockedAt,
+		&u.Version,
+		&updatedAt,
+		&u.LastLoginAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor != nil {
+		if err := json.Unmarshal([]byte(*twoFactor), &u.TwoFactor); err != nil {
+			return nil, fmt.Errorf("cannot decode two-factor state: %w", err)
+		}
+	}
+
+	if pendingEmail != nil {
+		if err := json.Unmarshal([]byte(*pendingEmail), &u.PendingEmail); err != nil {
+			return nil, fmt.Errorf("cannot decode pending email change: %w", err)
+		}
+	}
+
+	u.Role = auth.Role(role)
+	u.RegisteredAt = u.RegisteredAt.UTC()
+	if updatedAt != nil {
+		u.UpdatedAt = updatedAt.UTC()
+	}
+	if u.LastLoginAt != nil {
+		utc := u.LastLoginAt.UTC()
+		u.LastLoginAt = &utc
+	}
+	if sentAt != nil {
+		u.VerificationSentAt = sentAt.UTC()
+	}
+	if lockedAt != nil {
+		utc := lockedAt.UTC()
+		u.LockedAt = &utc
+	}
+
+	return &u, nil
+}
+
+// nullJSON returns JSON encoded value or nil for nil two-factor state
+// or pending email change, so it's stored as NULL.
+func nullJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *user.TwoFactor:
+		if v == nil {
+			return nil, nil
+		}
+	case *user.PendingEmail:
+		if v == nil {
+			return nil, nil
+		}
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %T: %w", value, err)
+	}
+	return string(b), nil
+}
+
+// nullTime returns nil for zero time, so it's stored as NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// uniqueViolationError translates unique violation into Email Taken
+// or Username Taken error depending on violated index.
+func uniqueViolationError(err error) error {
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_username_active_idx" {
+		return apperror.ErrUsernameTaken
+	}
+	return apperror.ErrEmailTaken
+}
+
+// isUniqueViolation reports whether given error is caused by unique index.
+func isUniqueViolation(err error) bool {
+	var pgErr pgx.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
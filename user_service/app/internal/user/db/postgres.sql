@@ -0,0 +1,80 @@
+-- gen_random_uuid is built in since PostgreSQL 13.
+CREATE TABLE IF NOT EXISTS users (
+    id                   UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email                TEXT NOT NULL,
+    username             TEXT NOT NULL,
+    password             TEXT NOT NULL,
+    verified             BOOLEAN NOT NULL DEFAULT FALSE,
+    role                 TEXT NOT NULL DEFAULT '',
+    registered_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    verification_sent_at TIMESTAMPTZ
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Deleted users don't hold their email and username until purged.
+-- Indexes of previous versions covered them too.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_active_idx ON users (email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_active_idx ON users (lower(username)) WHERE deleted_at IS NULL;
+DROP INDEX IF EXISTS users_email_idx;
+DROP INDEX IF EXISTS users_username_idx;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS two_factor JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+
+-- Registration dates used to be stored as "YYYY/MM/DD" text.
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'registered_at') = 'text' THEN
+        ALTER TABLE users ALTER COLUMN registered_at DROP DEFAULT;
+        ALTER TABLE users ALTER COLUMN registered_at TYPE TIMESTAMPTZ
+            USING COALESCE(to_date(NULLIF(registered_at, ''), 'YYYY/MM/DD')::timestamp AT TIME ZONE 'UTC', now());
+        ALTER TABLE users ALTER COLUMN registered_at SET DEFAULT now();
+    END IF;
+END $$;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;
+
+-- Refresh tokens, sessions and API keys are stored with users.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         TEXT PRIMARY KEY,
+    family     TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    revoked    BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    scopes       TEXT[] NOT NULL,
+    hash         TEXT NOT NULL,
+    revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id, created_at);
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+)
+
+// Check whether postgresAPIKeyDB implements API key storage interface.
+var _ user.APIKeyStorage = &postgresAPIKeyDB{}
+
+// postgresAPIKeyDB implements API key storage interface on postgres.
+type postgresAPIKeyDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresAPIKeyStorage returns a new postgres API key storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresAPIKeyStorage(pool *pgx.ConnPool) user.APIKeyStorage {
+	return &postgresAPIKeyDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+const apiKeyColumns = `id, user_id, name, scopes, hash, revoked, expires_at, created_at, last_used_at, last_used_ip`
+
+// Create inserts a new API key row.
+// Returns an error on failure.
+func (d *postgresAPIKeyDB) Create(ctx context.Context, key *user.APIKey) error {
+	query := `INSERT INTO api_keys (` + apiKeyColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		key.ID,
+		key.UserUUID,
+		key.Name,
+		scopes,
+		key.Hash,
+		key.Revoked,
+		key.ExpiresAt,
+		key.CreatedAt,
+		key.LastUsedAt,
+		key.LastUsedIP,
+	)
+	if err != nil {
+		e := fmt.Errorf("cannot insert API key in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the API key by given id.
+// Returns No Rows error if there's no key with given id.
+func (d *postgresAPIKeyDB) FindById(ctx context.Context, id string) (*user.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+
+	key, err := scanAPIKey(d.pool.QueryRowEx(ctx, query, nil, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListByUser returns not revoked keys of the user with given uuid, newest first.
+func (d *postgresAPIKeyDB) ListByUser(ctx context.Context, userUUID string) ([]user.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 AND NOT revoked ORDER BY created_at DESC`
+
+	rows, err := d.pool.QueryEx(ctx, query, nil, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []user.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Revoke marks the key with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active key with given id.
+func (d *postgresAPIKeyDB) Revoke(ctx context.Context, userUUID, id string) error {
+	query := `UPDATE api_keys SET revoked = TRUE WHERE id = $1 AND user_id = $2 AND NOT revoked`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, userUUID)
+	if err != nil {
+		return fmt.Errorf("cannot revoke API key: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllByUser marks all keys of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *postgresAPIKeyDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	query := `UPDATE api_keys SET revoked = TRUE WHERE user_id = $1 AND NOT revoked`
+
+	if _, err := d.pool.ExecEx(ctx, query, nil, userUUID); err != nil {
+		return fmt.Errorf("cannot revoke API keys: %w", err)
+	}
+
+	return nil
+}
+
+// Touch records when and from which IP the key with given id was used.
+// Returns No Rows error if there's no key with given id.
+func (d *postgresAPIKeyDB) Touch(ctx context.Context, id string, at time.Time, ip string) error {
+	query := `UPDATE api_keys SET last_used_at = $2, last_used_ip = $3 WHERE id = $1`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, at, ip)
+	if err != nil {
+		return fmt.Errorf("cannot update API key: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanAPIKey scans API key selected with apiKeyColumns.
+func scanAPIKey(row scanner) (*user.APIKey, error) {
+	var key user.APIKey
+	var scopes []string
+	err := row.Scan(
+		&key.ID,
+		&key.UserUUID,
+		&key.Name,
+		&scopes,
+		&key.Hash,
+		&key.Revoked,
+		&key.ExpiresAt,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = make([]auth.Scope, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = auth.Scope(scope)
+	}
+
+	key.ExpiresAt = key.ExpiresAt.UTC()
+	key.CreatedAt = key.CreatedAt.UTC()
+	if key.LastUsedAt != nil {
+		utc := key.LastUsedAt.UTC()
+		key.LastUsedAt = &utc
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+)
+
+// Check whether postgresSessionDB implements session storage interface.
+var _ user.SessionStorage = &postgresSessionDB{}
+
+// postgresSessionDB implements session storage interface on postgres.
+type postgresSessionDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresSessionStorage returns a new postgres session storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresSessionStorage(pool *pgx.ConnPool) user.SessionStorage {
+	return &postgresSessionDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, revoked, expires_at, created_at, last_seen_at`
+
+// Create inserts a new session row.
+// Returns an error on failure.
+func (d *postgresSessionDB) Create(ctx context.Context, session *user.Session) error {
+	query := `INSERT INTO sessions (` + sessionColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		session.ID,
+		session.UserUUID,
+		session.UserAgent,
+		session.IP,
+		session.Revoked,
+		session.ExpiresAt,
+		session.CreatedAt,
+		session.LastSeenAt,
+	)
+	if err != nil {
+		e := fmt.Errorf("cannot insert session in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the session by given id.
+// Returns No Rows error if there's no session with given id.
+func (d *postgresSessionDB) FindById(ctx context.Context, id string) (*user.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+
+	session, err := scanSession(d.pool.QueryRowEx(ctx, query, nil, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	return session, nil
+}
+
+// ListByUser returns not revoked sessions of the user with given uuid, newest first.
+func (d *postgresSessionDB) ListByUser(ctx context.Context, userUUID string) ([]user.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = $1 AND NOT revoked ORDER BY created_at DESC`
+
+	rows, err := d.pool.QueryEx(ctx, query, nil, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []user.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Touch records when and from which IP the session with given id was used
+// and when it expires. Returns No Rows error if there's no session with given id.
+func (d *postgresSessionDB) Touch(ctx context.Context, id string, at time.Time, ip string, expiresAt time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = $2, ip = $3, expires_at = $4 WHERE id = $1`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, at, ip, expiresAt)
+	if err != nil {
+		return fmt.Errorf("cannot update session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Revoke marks the session with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active session with given id.
+func (d *postgresSessionDB) Revoke(ctx context.Context, userUUID, id string) error {
+	query := `UPDATE sessions SET revoked = TRUE WHERE id = $1 AND user_id = $2 AND NOT revoked`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id, userUUID)
+	if err != nil {
+		return fmt.Errorf("cannot revoke session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllByUser marks all sessions of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *postgresSessionDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	query := `UPDATE sessions SET revoked = TRUE WHERE user_id = $1 AND NOT revoked`
+
+	if _, err := d.pool.ExecEx(ctx, query, nil, userUUID); err != nil {
+		return fmt.Errorf("cannot revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// scanSession scans session selected with sessionColumns.
+func scanSession(row scanner) (*user.Session, error) {
+	var s user.Session
+	err := row.Scan(
+		&s.ID,
+		&s.UserUUID,
+		&s.UserAgent,
+		&s.IP,
+		&s.Revoked,
+		&s.ExpiresAt,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ExpiresAt = s.ExpiresAt.UTC()
+	s.CreatedAt = s.CreatedAt.UTC()
+	s.LastSeenAt = s.LastSeenAt.UTC()
+
+	return &s, nil
+}
@@ -0,0 +1,90 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/storagetest"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectPostgres connects to PostgreSQL available at POSTGRES_URL
+// and migrates the schema. The test is skipped if it's not set.
+func connectPostgres(t *testing.T) *pgx.ConnPool {
+	postgresURL := os.Getenv("POSTGRES_URL")
+	if postgresURL == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+
+	logger.Init()
+
+	pool, err := postgres.NewPostgresClient(postgresURL, 5)
+	if err != nil {
+		t.Fatalf("cannot connect to postgres: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	if err := db.MigratePostgres(context.Background(), pool); err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+// truncate returns a function truncating given table.
+func truncate(pool *pgx.ConnPool, table string) func() error {
+	return func() error {
+		_, err := pool.Exec(`TRUNCATE ` + table)
+		return err
+	}
+}
+
+// TestPostgresStorage runs storage contract tests against PostgreSQL
+// available at POSTGRES_URL. Skipped if it's not set.
+func TestPostgresStorage(t *testing.T) {
+	pool := connectPostgres(t)
+
+	storagetest.Run(t, func(t *testing.T) (user.Storage, func() error) {
+		return db.NewPostgresStorage(pool), truncate(pool, "users")
+	})
+
+	drift, err := db.CheckPostgres(context.Background(), pool)
+	assert.NoError(t, err)
+	assert.Empty(t, drift)
+}
+
+// TestPostgresTokenStorage runs refresh token storage contract tests
+// against PostgreSQL available at POSTGRES_URL. Skipped if it's not set.
+func TestPostgresTokenStorage(t *testing.T) {
+	pool := connectPostgres(t)
+
+	storagetest.RunTokenStorage(t, func(t *testing.T) (user.TokenStorage, func() error) {
+		return db.NewPostgresTokenStorage(pool), truncate(pool, "refresh_tokens")
+	})
+}
+
+// TestPostgresSessionStorage runs session storage contract tests
+// against PostgreSQL available at POSTGRES_URL. Skipped if it's not set.
+func TestPostgresSessionStorage(t *testing.T) {
+	pool := connectPostgres(t)
+
+	storagetest.RunSessionStorage(t, func(t *testing.T) (user.SessionStorage, func() error) {
+		return db.NewPostgresSessionStorage(pool), truncate(pool, "sessions")
+	})
+}
+
+// TestPostgresAPIKeyStorage runs API key storage contract tests
+// against PostgreSQL available at POSTGRES_URL. Skipped if it's not set.
+func TestPostgresAPIKeyStorage(t *testing.T) {
+	pool := connectPostgres(t)
+
+	storagetest.RunAPIKeyStorage(t, func(t *testing.T) (user.APIKeyStorage, func() error) {
+		return db.NewPostgresAPIKeyStorage(pool), truncate(pool, "api_keys")
+	})
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+)
+
+// Check whether postgresTokenDB implements token storage interface.
+var _ user.TokenStorage = &postgresTokenDB{}
+
+// postgresTokenDB implements token storage interface on postgres.
+type postgresTokenDB struct {
+	logger logger.Logger
+	pool   *pgx.ConnPool
+}
+
+// NewPostgresTokenStorage returns a new postgres refresh token storage instance.
+// Call MigratePostgres before using it.
+func NewPostgresTokenStorage(pool *pgx.ConnPool) user.TokenStorage {
+	return &postgresTokenDB{
+		logger: logger.GetLogger(),
+		pool:   pool,
+	}
+}
+
+// Create inserts a new refresh token row.
+// Returns an error on failure.
+func (d *postgresTokenDB) Create(ctx context.Context, token *user.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, family, user_id, revoked, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := d.pool.ExecEx(ctx, query, nil,
+		token.ID,
+		token.Family,
+		token.UserUUID,
+		token.Revoked,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		e := fmt.Errorf("cannot insert refresh token in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the refresh token by given id.
+// Returns No Rows error if there's no token with given id.
+func (d *postgresTokenDB) FindById(ctx context.Context, id string) (*user.RefreshToken, error) {
+	query := `SELECT id, family, user_id, revoked, expires_at, created_at FROM refresh_tokens WHERE id = $1`
+
+	var token user.RefreshToken
+	err := d.pool.QueryRowEx(ctx, query, nil, id).Scan(
+		&token.ID,
+		&token.Family,
+		&token.UserUUID,
+		&token.Revoked,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.CreatedAt = token.CreatedAt.UTC()
+
+	return &token, nil
+}
+
+// Revoke marks the token with given id as revoked.
+// Returns No Rows error if there's no active token with given id,
+// so only one caller can revoke the token.
+func (d *postgresTokenDB) Revoke(ctx context.Context, id string) error {
+	query := `UPDATE refresh_tokens SET revoked = TRUE WHERE id = $1 AND NOT revoked`
+
+	tag, err := d.pool.ExecEx(ctx, query, nil, id)
+	if err != nil {
+		return fmt.Errorf("cannot revoke refresh token: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeFamily marks all tokens of given family as revoked.
+// Returns an error on failure.
+func (d *postgresTokenDB) RevokeFamily(ctx context.Context, family string) error {
+	query := `UPDATE refresh_tokens SET revoked = TRUE WHERE family = $1 AND NOT revoked`
+
+	if _, err := d.pool.ExecEx(ctx, query, nil, family); err != nil {
+		return fmt.Errorf("cannot revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAllByUser marks all tokens of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *postgresTokenDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	query := `UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = $1 AND NOT revoked`
+
+	if _, err := d.pool.ExecEx(ctx, query, nil, userUUID); err != nil {
+		return fmt.Errorf("cannot revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check whether sessionDB implements session storage interface.
+var _ user.SessionStorage = &sessionDB{}
+
+// sessionDB implementes session storage interface.
+// Sessions are stored by id, so they are found and
+// revoked by primary key lookup.
+type sessionDB struct {
+	logger     logger.Logger
+	collection *mongo.Collection
+}
+
+// NewSessionStorage returns a new session storage instance.
+func NewSessionStorage(storage *mongo.Database, collection string) user.SessionStorage {
+	return &sessionDB{
+		logger:     logger.GetLogger(),
+		collection: storage.Collection(collection),
+	}
+}
+
+// Create inserts a new session in the database.
+// Returns an error on failure.
+func (d *sessionDB) Create(ctx context.Context, session *user.Session) error {
+	_, err := d.collection.InsertOne(ctx, session)
+	if err != nil {
+		e := fmt.Errorf("cannot insert session in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the session by given id.
+// Returns No Rows error if there's no session with given id.
+func (d *sessionDB) FindById(ctx context.Context, id string) (*user.Session, error) {
+	result := d.collection.FindOne(ctx, bson.M{"_id": id})
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var session user.Session
+	if err := result.Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	return &session, nil
+}
+
+// ListByUser returns not revoked sessions of the user with given uuid, newest first.
+func (d *sessionDB) ListByUser(ctx context.Context, userUUID string) ([]user.Session, error) {
+	filter := bson.M{"userId": userUUID, "revoked": false}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := d.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var sessions []user.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Touch records when and from which IP the session with given id was used
+// and when it expires. Returns No Rows error if there's no session with given id.
+func (d *sessionDB) Touch(ctx context.Context, id string, at time.Time, ip string, expiresAt time.Time) error {
+	query := bson.M{"$set": bson.M{"lastSeenAt": at, "ip": ip, "expiresAt": expiresAt}}
+
+	result, err := d.collection.UpdateOne(ctx, bson.M{"_id": id}, query)
+	if err != nil {
+		return fmt.Errorf("cannot update session: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// Revoke marks the session with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active session with given id.
+func (d *sessionDB) Revoke(ctx context.Context, userUUID, id string) error {
+	filter := bson.M{"_id": id, "userId": userUUID, "revoked": false}
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot revoke session: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllByUser marks all sessions of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *sessionDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	filter := bson.M{"userId": userUUID, "revoked": false}
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	if _, err := d.collection.UpdateMany(ctx, filter, query); err != nil {
+		return fmt.Errorf("cannot revoke sessions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Check whether tokenDB implements token storage interface.
+var _ user.TokenStorage = &tokenDB{}
+
+// tokenDB implementes token storage interface.
+type tokenDB struct {
+	logger     logger.Logger
+	collection *mongo.Collection
+}
+
+// NewTokenStorage returns a new refresh token storage instance.
+func NewTokenStorage(storage *mongo.Database, collection string) user.TokenStorage {
+	return &tokenDB{
+		logger:     logger.GetLogger(),
+		collection: storage.Collection(collection),
+	}
+}
+
+// Create inserts a new refresh token in the database.
+// Returns an error on failure.
+func (d *tokenDB) Create(ctx context.Context, token *user.RefreshToken) error {
+	_, err := d.collection.InsertOne(ctx, token)
+	if err != nil {
+		e := fmt.Errorf("cannot insert refresh token in database: %w", err)
+		d.logger.Warn(e)
+		return e
+	}
+
+	return nil
+}
+
+// FindById finds the refresh token by given id.
+// Returns No Rows error if there's no token with given id.
+func (d *tokenDB) FindById(ctx context.Context, id string) (*user.RefreshToken, error) {
+	result := d.collection.FindOne(ctx, bson.M{"_id": id})
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.ErrNoRows
+		}
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var token user.RefreshToken
+	if err := result.Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	return &token, nil
+}
+
+// Revoke marks the token with given id as revoked.
+// Returns No Rows error if there's no active token with given id,
+// so only one caller can revoke the token.
+func (d *tokenDB) Revoke(ctx context.Context, id string) error {
+	filter := bson.M{"_id": id, "revoked": false}
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	result, err := d.collection.UpdateOne(ctx, filter, query)
+	if err != nil {
+		return fmt.Errorf("cannot revoke refresh token: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return apperror.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeFamily marks all tokens of given family as revoked.
+// Returns an error on failure.
+func (d *tokenDB) RevokeFamily(ctx context.Context, family string) error {
+	return d.revokeMany(ctx, bson.M{"family": family, "revoked": false})
+}
+
+// RevokeAllByUser marks all tokens of the user with given uuid as revoked.
+// Returns an error on failure.
+func (d *tokenDB) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	return d.revokeMany(ctx, bson.M{"userId": userUUID, "revoked": false})
+}
+
+// revokeMany marks all tokens matched by filter as revoked.
+func (d *tokenDB) revokeMany(ctx context.Context, filter bson.M) error {
+	query := bson.M{"$set": bson.M{"revoked": true}}
+
+	if _, err := d.collection.UpdateMany(ctx, filter, query); err != nil {
+		return fmt.Errorf("cannot revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// requestEmailChange stores pending change of the user email, sends
+// confirmation token to the new address and notifies the current one.
+// A new request replaces the previous one, so its token can't be used anymore.
+// Whether the new address is taken is checked on confirmation only,
+// so the request doesn't reveal which emails are registered.
+func (s *service) requestEmailChange(ctx context.Context, user *User, email string) error {
+	id, err := token.NewID()
+	if err != nil {
+		return err
+	}
+
+	confirmationToken, err := s.tokens.NewToken(token.EmailChange, user.UUID, email, id)
+	if err != nil {
+		return err
+	}
+
+	ttl := s.tokens.TTL(token.EmailChange)
+	pending := &PendingEmail{
+		Email:     email,
+		ID:        id,
+		ExpiresAt: time.Now().UTC().Add(ttl).Truncate(time.Millisecond),
+	}
+
+	if err := s.storage.SetPendingEmail(ctx, user.UUID, pending); err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: "Confirm your new email",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nTo use this address for your account, send the token below to /api/users/%s/email/confirm.\n"+
+				"The token expires in %s. Until then your current email stays unchanged.\n\nToken: %s\n",
+			user.Username, user.UUID, ttl, confirmationToken,
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The change is still confirmed at the new address,
+	// so it isn't cancelled if the notice couldn't be sent.
+	err = s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nSomebody requested to change email of your account to %s. "+
+				"The change takes effect once confirmed at the new address.\n"+
+				"If it wasn't you, change your password right away.\n",
+			user.Username, email,
+		),
+	})
+	if err != nil {
+		s.logger.Warnf("failed to send email change notice: %v", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmail replaces email of the user with given uuid with the pending one
+// and marks it as verified. Token must be issued on the latest email change
+// request of the user and the change must not be expired. Returns Invalid Token
+// error if token cannot be used and Email Taken error if another user has
+// registered the new email in the meantime.
+func (s *service) ConfirmEmail(ctx context.Context, uuid, confirmationToken string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	claims, err := s.tokens.Parse(confirmationToken, token.EmailChange)
+	if err != nil {
+		return apperror.ErrInvalidToken
+	}
+
+	pending := user.PendingEmail
+	if pending == nil || !time.Now().Before(pending.ExpiresAt) {
+		return apperror.ErrInvalidToken
+	}
+
+	if claims.Subject != user.UUID || claims.Email != pending.Email || claims.Stamp != pending.ID {
+		return apperror.ErrInvalidToken
+	}
+
+	found, err := s.storage.FindByEmail(ctx, pending.Email)
+	if err != nil && !errors.Is(err, apperror.ErrNoRows) {
+		return err
+	}
+	if found != nil && found.UUID != user.UUID {
+		return apperror.ErrEmailTaken
+	}
+
+	// Unique index still rejects the email if it has been taken
+	// right after the check.
+	err = s.storage.UpdatePartially(ctx, &User{UUID: user.UUID, Email: pending.Email, Verified: true})
+	if err != nil {
+		if !errors.Is(err, apperror.ErrEmailTaken) {
+			s.logger.Warnf("failed to change user email: %v", err)
+		}
+		return err
+	}
+
+	if err := s.storage.SetPendingEmail(ctx, user.UUID, nil); err != nil {
+		s.logger.Warnf("failed to remove pending email change: %v", err)
+		return err
+	}
+
+	// Change is confirmed by the token holder, not by a logged in user.
+	s.record(ctx, &audit.Event{
+		Action: audit.ActionUserUpdate,
+		Actor:  user.UUID,
+		Target: user.UUID,
+		Fields: []string{"email"},
+	})
+
+	return nil
+}
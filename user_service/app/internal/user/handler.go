@@ -5,91 +5,772 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
+	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/juicyluv/sueta/user_service/app/internal/handler"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/etag"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
 	"github.com/julienschmidt/httprouter"
 )
 
 const (
-	usersURL = "/api/users"
-	userURL  = "/api/users/:uuid"
+	usersURL     = "/api/users"
+	userURL      = "/api/users/:uuid"
+	roleURL      = "/api/users/:uuid/role"
+	restoreURL   = "/api/users/:uuid/restore"
+	twoFactorURL = "/api/users/:uuid/2fa"
+	confirmURL   = "/api/users/:uuid/2fa/confirm"
+	disableURL   = "/api/users/:uuid/2fa/disable"
+	apiKeysURL   = "/api/users/:uuid/keys"
+	apiKeyURL    = "/api/users/:uuid/keys/:id"
+	sessionsURL  = "/api/users/:uuid/sessions"
+	sessionURL   = "/api/users/:uuid/sessions/:id"
+	verifyURL    = "/api/users/:uuid/verify"
+	resendURL    = "/api/users/:uuid/verify/resend"
+	emailURL     = "/api/users/:uuid/email/confirm"
+	loginURL     = "/api/auth/login"
+	login2faURL  = "/api/auth/login/2fa"
+	refreshURL   = "/api/auth/refresh"
+	logoutURL    = "/api/auth/logout"
+	logoutAllURL = "/api/auth/logout/all"
+	forgotURL    = "/api/auth/password/forgot"
+	resetURL     = "/api/auth/password/reset"
 )
 
 // Handler handles requests specified to user service.
 type Handler struct {
 	logger      logger.Logger
 	userService Service
+	authService AuthService
+	authorizer  *auth.Middleware
 }
 
 // NewHandler returns a new user Handler instance.
-func NewHandler(logger logger.Logger, userService Service) handler.Handling {
+func NewHandler(logger logger.Logger, userService Service, authService AuthService, authorizer *auth.Middleware) handler.Handling {
 	return &Handler{
 		logger:      logger,
 		userService: userService,
+		authService: authService,
+		authorizer:  authorizer,
 	}
 }
 
 // Register registers new routes for router.
+// Users are allowed to access their own accounts only,
+// unless they have a privileged role.
 func (h *Handler) Register(router *httprouter.Router) {
-	router.HandlerFunc(http.MethodGet, userURL, h.GetUser)
-	router.HandlerFunc(http.MethodGet, usersURL, h.GetUserByEmailAndPassword)
+	owner := auth.OwnerParam("uuid")
+	ownerOrStaff := auth.Rule{Roles: []auth.Role{auth.RoleAdmin, auth.RoleModerator}, Owner: owner}
+	ownerOrAdmin := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}, Owner: owner}
+	adminOnly := auth.Rule{Roles: []auth.Role{auth.RoleAdmin}}
+	ownerOnly := auth.Rule{Owner: owner}
+
+	router.HandlerFunc(http.MethodGet, usersURL, h.authorizer.Authorize(adminOnly, h.ListUsers))
+	router.HandlerFunc(http.MethodGet, userURL, h.authorizer.Authorize(ownerOrStaff, h.GetUser))
 	router.HandlerFunc(http.MethodPost, usersURL, h.CreateUser)
-	router.HandlerFunc(http.MethodPatch, userURL, h.UpdateUserPartially)
-	router.HandlerFunc(http.MethodDelete, userURL, h.DeleteUser)
+	router.HandlerFunc(http.MethodPatch, userURL, h.authorizer.Authorize(ownerOrAdmin, h.UpdateUserPartially))
+	router.HandlerFunc(http.MethodDelete, userURL, h.authorizer.Authorize(ownerOrAdmin, h.DeleteUser))
+	router.HandlerFunc(http.MethodPatch, roleURL, h.authorizer.Authorize(adminOnly, h.SetRole))
+	router.HandlerFunc(http.MethodPost, restoreURL, h.authorizer.Authorize(adminOnly, h.RestoreUser))
+	router.HandlerFunc(http.MethodPost, verifyURL, h.VerifyEmail)
+	router.HandlerFunc(http.MethodPost, resendURL, h.ResendVerification)
+	router.HandlerFunc(http.MethodPost, emailURL, h.ConfirmEmail)
+	router.HandlerFunc(http.MethodPost, twoFactorURL, h.authorizer.Authorize(ownerOnly, h.EnrollTwoFactor))
+	router.HandlerFunc(http.MethodPost, confirmURL, h.authorizer.Authorize(ownerOnly, h.ConfirmTwoFactor))
+	router.HandlerFunc(http.MethodPost, disableURL, h.authorizer.Authorize(ownerOnly, h.DisableTwoFactor))
+	router.HandlerFunc(http.MethodPost, apiKeysURL, h.authorizer.Authorize(ownerOnly, h.CreateAPIKey))
+	router.HandlerFunc(http.MethodGet, apiKeysURL, h.authorizer.Authorize(ownerOrAdmin, h.ListAPIKeys))
+	router.HandlerFunc(http.MethodDelete, apiKeyURL, h.authorizer.Authorize(ownerOrAdmin, h.RevokeAPIKey))
+	router.HandlerFunc(http.MethodGet, sessionsURL, h.authorizer.Authorize(ownerOrAdmin, h.ListSessions))
+	router.HandlerFunc(http.MethodDelete, sessionURL, h.authorizer.Authorize(ownerOrAdmin, h.RevokeSession))
+	router.HandlerFunc(http.MethodPost, loginURL, h.Login)
+	router.HandlerFunc(http.MethodPost, login2faURL, h.LoginTwoFactor)
+	router.HandlerFunc(http.MethodPost, refreshURL, h.Refresh)
+	router.HandlerFunc(http.MethodPost, logoutURL, h.Logout)
+	router.HandlerFunc(http.MethodPost, logoutAllURL, h.LogoutAll)
+	router.HandlerFunc(http.MethodPost, forgotURL, h.ForgotPassword)
+	router.HandlerFunc(http.MethodPost, resetURL, h.ResetPassword)
+}
+
+// GetUser godoc
+// @Summary Show user information
+// @Description Get user by uuid. ETag header holds version of the user, send it in If-Match header to update or delete the user only if it hasn't been changed since.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200 {object} User
+// @Header 200 {string} ETag "Version of the user"
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid} [get]
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("GET USER")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	user, err := h.userService.GetById(r.Context(), uuid)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	etag.Set(w, user.Version)
+	h.JSON(w, http.StatusOK, user)
+}
+
+// CreateUser godoc
+// @Summary Create user
+// @Description Register a new user. Password must fit password policy: length limits, not a common password and not containing email or username.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param input body user.CreateUserDTO true "JSON input"
+// @Success 201 {object} internal.CreateUserResponse
+// @Failure 400 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /users [post]
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("CREATE USER")
+
+	var input CreateUserDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	if input.Password != input.RepeatPassword {
+		h.BadRequest(w, "passwords don't match", "provided passwords must to match")
+		return
+	}
+
+	userId, err := h.userService.Create(r.Context(), &input)
+	if err != nil {
+		if errors.Is(err, apperror.ErrEmailTaken) || errors.Is(err, apperror.ErrUsernameTaken) {
+			h.BadRequest(w, err.Error(), "")
+			return
+		}
+		if isValidationError(err) {
+			h.BadRequest(w, err.Error(), "password doesn't fit password policy")
+			return
+		}
+		h.InternalError(w, fmt.Sprintf("cannot create user: %v", err), "")
+		return
+	}
+
+	h.JSON(w, http.StatusCreated, map[string]string{"id": userId})
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Get a page of users. Pass nextCursor of the previous page as cursor to get the next one. Available for admins only.
+// @Tags users
+// @Produce json
+// @Param verified query bool false "Filter by email verification"
+// @Param registeredFrom query string false "Registered on or after the date" example(2022-02-01)
+// @Param registeredTo query string false "Registered on or before the date" example(2022-02-28)
+// @Param username query string false "Username prefix"
+// @Param sort query string false "Sort order, minus means descending" Enums(registeredAt, -registeredAt, username, -username, email, -email) default(registeredAt)
+// @Param limit query int false "Page size" minimum(1) maximum(100) default(20)
+// @Param cursor query string false "Page cursor"
+// @Success 200 {object} UserPage
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users [get]
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LIST USERS")
+
+	query := r.URL.Query()
+	input := ListUsersDTO{
+		Verified:       query.Get("verified"),
+		RegisteredFrom: query.Get("registeredFrom"),
+		RegisteredTo:   query.Get("registeredTo"),
+		Username:       query.Get("username"),
+		Sort:           query.Get("sort"),
+		Cursor:         query.Get("cursor"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		input.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			h.BadRequest(w, "limit: must be an integer.", "input validation failed. please, provide valid values")
+			return
+		}
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	page, err := h.userService.List(r.Context(), &input)
+	if err != nil {
+		if errors.Is(err, apperror.ErrInvalidCursor) {
+			h.BadRequest(w, err.Error(), "please, pass nextCursor of the previous page with the same sort order")
+			return
+		}
+		h.InternalError(w, err.Error(), "")
+		return
+	}
+
+	h.JSON(w, http.StatusOK, page)
+}
+
+// SetRole godoc
+// @Summary Change user role
+// @Description Change role of the user. Available for admins only.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.SetRoleDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/role [patch]
+func (h *Handler) SetRole(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("SET ROLE")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	var input SetRoleDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.UUID = uuid
+
+	err := h.userService.SetRole(r.Context(), &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// VerifyEmail godoc
+// @Summary Verify email
+// @Description Confirm user email with the token sent on registration.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.VerifyEmailDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /users/{uuid}/verify [post]
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("VERIFY EMAIL")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	var input VerifyEmailDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	err := h.userService.Verify(r.Context(), uuid, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID), errors.Is(err, apperror.ErrInvalidToken):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ConfirmEmail godoc
+// @Summary Confirm email change
+// @Description Replace user email with the requested one using the token sent to the new address. The new email becomes verified.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.ConfirmEmailDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /users/{uuid}/email/confirm [post]
+func (h *Handler) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("CONFIRM EMAIL")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	var input ConfirmEmailDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	err := h.userService.ConfirmEmail(r.Context(), uuid, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID), errors.Is(err, apperror.ErrInvalidToken), errors.Is(err, apperror.ErrEmailTaken):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification token to user email. Can be requested once a minute.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 202
+// @Failure 400 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 429 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /users/{uuid}/verify/resend [post]
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("RESEND VERIFICATION")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	err := h.userService.ResendVerification(r.Context(), uuid)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID), errors.Is(err, apperror.ErrAlreadyVerified):
+			h.BadRequest(w, err.Error(), "")
+		case errors.Is(err, apperror.ErrTooManyRequests):
+			h.TooManyRequests(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Login godoc
+// @Summary Log in
+// @Description Check user credentials and issue access and refresh tokens. If two-factor authentication is enabled, only challenge token is returned, which should be sent with a code to /auth/login/2fa. Locked accounts can't log in.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body user.LoginDTO true "JSON input"
+// @Success 200 {object} LoginResult
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 429 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /auth/login [post]
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LOGIN")
+
+	var input LoginDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.IP = auth.ClientIP(r)
+	input.UserAgent = r.UserAgent()
+
+	result, err := h.authService.Login(r.Context(), &input)
+	if err != nil {
+		var retry *apperror.RetryError
+		switch {
+		case errors.Is(err, apperror.ErrWrongPassword):
+			h.Unauthorized(w, err.Error(), "")
+		case errors.Is(err, apperror.ErrAccountLocked):
+			h.Error(w, http.StatusForbidden, err.Error(), "please, contact support")
+		case errors.As(err, &retry):
+			h.retryLater(w, retry)
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, result)
+}
+
+// LoginTwoFactor godoc
+// @Summary Log in with second factor
+// @Description Exchange challenge token returned by login and a one-time or recovery code for access and refresh tokens.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body user.TwoFactorLoginDTO true "JSON input"
+// @Success 200 {object} token.Pair
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 429 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /auth/login/2fa [post]
+func (h *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LOGIN TWO FACTOR")
+
+	var input TwoFactorLoginDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.IP = auth.ClientIP(r)
+	input.UserAgent = r.UserAgent()
+
+	tokens, err := h.authService.LoginTwoFactor(r.Context(), &input)
+	if err != nil {
+		var retry *apperror.RetryError
+		switch {
+		case errors.Is(err, apperror.ErrInvalidToken):
+			h.Unauthorized(w, err.Error(), "please, log in again")
+		case errors.Is(err, apperror.ErrInvalidCode):
+			h.Unauthorized(w, err.Error(), "")
+		case errors.Is(err, apperror.ErrAccountLocked):
+			h.Error(w, http.StatusForbidden, err.Error(), "please, contact support")
+		case errors.As(err, &retry):
+			h.retryLater(w, retry)
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, tokens)
+}
+
+// EnrollTwoFactor godoc
+// @Summary Enroll two-factor authentication
+// @Description Generate a new TOTP secret. Add it to an authenticator app and confirm with the first code to enable two-factor authentication.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200 {object} TwoFactorEnrollment
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/2fa [post]
+func (h *Handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("ENROLL TWO FACTOR")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	enrollment, err := h.userService.EnrollTwoFactor(r.Context(), uuid)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID), errors.Is(err, apperror.ErrTwoFactorEnabled):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, enrollment)
+}
+
+// ConfirmTwoFactor godoc
+// @Summary Confirm two-factor authentication
+// @Description Enable two-factor authentication with the first code from authenticator app. Recovery codes are returned only once.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.TwoFactorCodeDTO true "JSON input"
+// @Success 200 {object} RecoveryCodes
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/2fa/confirm [post]
+func (h *Handler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("CONFIRM TWO FACTOR")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	var input TwoFactorCodeDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.UUID = params.ByName("uuid")
+
+	codes, err := h.userService.ConfirmTwoFactor(r.Context(), &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID),
+			errors.Is(err, apperror.ErrInvalidCode),
+			errors.Is(err, apperror.ErrTwoFactorEnabled),
+			errors.Is(err, apperror.ErrTwoFactorNotEnabled):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, codes)
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable two-factor authentication
+// @Description Disable two-factor authentication. Password and a one-time or recovery code are required.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.DisableTwoFactorDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/2fa/disable [post]
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("DISABLE TWO FACTOR")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	var input DisableTwoFactorDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.UUID = params.ByName("uuid")
+
+	err := h.userService.DisableTwoFactor(r.Context(), &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrWrongPassword):
+			h.BadRequest(w, err.Error(), "you entered wrong password")
+		case errors.Is(err, apperror.ErrInvalidUUID),
+			errors.Is(err, apperror.ErrInvalidCode),
+			errors.Is(err, apperror.ErrTwoFactorNotEnabled):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Refresh godoc
+// @Summary Refresh tokens
+// @Description Rotate refresh token and issue a new token pair. Reusing rotated token revokes all tokens issued from the same login.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body user.RefreshTokenDTO true "JSON input"
+// @Success 200 {object} token.Pair
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /auth/refresh [post]
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("REFRESH")
+
+	input, ok := h.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	input.IP = auth.ClientIP(r)
+
+	tokens, err := h.authService.Refresh(r.Context(), input)
+	if err != nil {
+		h.tokenError(w, err)
+		return
+	}
+
+	h.JSON(w, http.StatusOK, tokens)
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revoke given refresh token and end its session.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body user.RefreshTokenDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Router /auth/logout [post]
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LOGOUT")
+
+	input, ok := h.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.authService.Logout(r.Context(), input.RefreshToken); err != nil {
+		h.tokenError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
-// GetUser godoc
-// @Summary Show user information
-// @Description Get user by uuid.
-// @Tags users
+// LogoutAll godoc
+// @Summary Log out everywhere
+// @Description Revoke all refresh tokens and sessions of the user who owns given refresh token.
+// @Tags auth
 // @Accept json
 // @Produce json
-// @Param uuid path string true "User id"
-// @Success 200 {object} User
-// @Failure 404 {object} apperror.AppError
+// @Param input body user.RefreshTokenDTO true "JSON input"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
-// @Router /users/{uuid} [get]
-func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
-	h.logger.Info("GET USER")
+// @Router /auth/logout/all [post]
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LOGOUT ALL")
 
-	params := httprouter.ParamsFromContext(r.Context())
-	uuid := params.ByName("uuid")
+	input, ok := h.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
 
-	user, err := h.userService.GetById(r.Context(), uuid)
-	if err != nil {
-		switch {
-		case errors.Is(err, apperror.ErrNoRows):
-			h.NotFound(w)
-		case errors.Is(err, apperror.ErrInvalidUUID):
-			h.BadRequest(w, err.Error(), "")
-		default:
-			h.InternalError(w, err.Error(), "")
-		}
+	if err := h.authService.LogoutAll(r.Context(), input.RefreshToken); err != nil {
+		h.tokenError(w, err)
 		return
 	}
 
-	h.JSON(w, http.StatusOK, user)
+	w.WriteHeader(http.StatusOK)
 }
 
-// CreateUser godoc
-// @Summary Create user
-// @Description Register a new user.
-// @Tags users
+// ForgotPassword godoc
+// @Summary Request password reset
+// @Description Send password reset token to given email. Response is the same whether account exists or not.
+// @Tags auth
 // @Accept json
 // @Produce json
-// @Param input body user.CreateUserDTO true "JSON input"
-// @Success 201 {object} internal.CreateUserResponse
+// @Param input body user.ForgotPasswordDTO true "JSON input"
+// @Success 202
 // @Failure 400 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
-// @Router /users [post]
-func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	h.logger.Info("CREATE USER")
+// @Router /auth/password/forgot [post]
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("FORGOT PASSWORD")
 
-	var input CreateUserDTO
+	var input ForgotPasswordDTO
 	if err := h.readJSON(w, r, &input); err != nil {
 		h.BadRequest(w, err.Error(), "invalid request body")
 		return
@@ -100,73 +781,100 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if input.Password != input.RepeatPassword {
-		h.BadRequest(w, "passwords don't match", "provided passwords must to match")
-		return
-	}
-
-	userId, err := h.userService.Create(r.Context(), &input)
-	if err != nil {
-		if errors.Is(err, apperror.ErrEmailTaken) {
-			h.BadRequest(w, err.Error(), "")
-			return
-		}
-		h.InternalError(w, fmt.Sprintf("cannot create user: %v", err), "")
+	if err := h.userService.ForgotPassword(r.Context(), input.Email); err != nil {
+		h.InternalError(w, err.Error(), "")
 		return
 	}
 
-	h.JSON(w, http.StatusCreated, map[string]string{"id": userId})
+	w.WriteHeader(http.StatusAccepted)
 }
 
-// GetUserByEmailAndPassword godoc
-// @Summary Get user by email and password from query parameters
-// @Description Create a new user
-// @Tags users
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password with password reset token. Logs the user out everywhere.
+// @Tags auth
 // @Accept json
 // @Produce json
-// @Param email query string true "user email"
-// @Param password query string true "user raw password"
-// @Success 200 {object} User
+// @Param input body user.ResetPasswordDTO true "JSON input"
+// @Success 200
 // @Failure 400 {object} apperror.AppError
-// @Failure 404 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
-// @Router /users [get]
-func (h *Handler) GetUserByEmailAndPassword(w http.ResponseWriter, r *http.Request) {
-	h.logger.Info("GET USER BY EMAIL AND PASSWORD")
+// @Router /auth/password/reset [post]
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("RESET PASSWORD")
 
-	email := r.URL.Query().Get("email")
-	password := r.URL.Query().Get("password")
+	var input ResetPasswordDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
 
-	if email == "" || password == "" {
-		h.BadRequest(w, "empty email or password", "email and password must be provided")
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
 		return
 	}
 
-	user, err := h.userService.GetByEmailAndPassword(r.Context(), email, password)
+	err := h.userService.ResetPassword(r.Context(), &input)
 	if err != nil {
-		if errors.Is(err, apperror.ErrNoRows) {
-			h.NotFound(w)
-			return
+		switch {
+		case errors.Is(err, apperror.ErrInvalidToken):
+			h.BadRequest(w, err.Error(), "please, request password reset again")
+		case errors.Is(err, apperror.ErrPasswordsDontMatch):
+			h.BadRequest(w, err.Error(), "provided passwords must to match")
+		case isValidationError(err):
+			h.BadRequest(w, err.Error(), "password doesn't fit password policy")
+		default:
+			h.InternalError(w, err.Error(), "")
 		}
-		h.BadRequest(w, err.Error(), "")
 		return
 	}
 
-	h.JSON(w, http.StatusOK, user)
+	w.WriteHeader(http.StatusOK)
+}
+
+// readRefreshToken reads and validates refresh token from request body.
+// Responses with 400 Bad Request and returns false on failure.
+func (h *Handler) readRefreshToken(w http.ResponseWriter, r *http.Request) (*RefreshTokenDTO, bool) {
+	var input RefreshTokenDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return nil, false
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return nil, false
+	}
+
+	return &input, true
+}
+
+// tokenError responses with an error returned by token operations.
+func (h *Handler) tokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, apperror.ErrInvalidToken) {
+		h.Unauthorized(w, err.Error(), "please, log in again")
+		return
+	}
+	h.InternalError(w, err.Error(), "")
 }
 
 // UpdateUserPartially godoc
 // @Summary Update user
-// @Description Partially update the user with provided current password.
+// @Description Partially update the user with provided current password. New email is applied only once confirmed with the token sent to it, and the current email is notified about the request. With If-Match header the user is updated only if its version is the given ETag. The user is never updated if it has been changed concurrently.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param uuid path string true "User id"
+// @Param If-Match header string false "ETag of the user"
 // @Param input body user.UpdateUserDTO true "JSON input"
 // @Success 200
 // @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
 // @Failure 404 {object} apperror.AppError
+// @Failure 412 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
 // @Router /users/{uuid} [patch]
 func (h *Handler) UpdateUserPartially(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("UPDATE USER PARTIALLY")
@@ -185,15 +893,30 @@ func (h *Handler) UpdateUserPartially(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		h.BadRequest(w, err.Error(), "please, send ETag of the user")
+		return
+	}
+
 	input.UUID = uuid
+	input.Version = version
 
-	err := h.userService.UpdatePartially(r.Context(), &input)
+	err = h.userService.UpdatePartially(r.Context(), &input)
 	if err != nil {
+		if isValidationError(err) {
+			h.BadRequest(w, err.Error(), "you have provided invalid values")
+			return
+		}
 		switch err {
 		case apperror.ErrNoRows:
 			h.NotFound(w)
+		case apperror.ErrVersionConflict:
+			h.Error(w, http.StatusPreconditionFailed, err.Error(), "")
 		case apperror.ErrWrongPassword:
 			h.BadRequest(w, err.Error(), "you entered wrong password")
+		case apperror.ErrEmailTaken, apperror.ErrUsernameTaken:
+			h.BadRequest(w, err.Error(), "")
 		default:
 			h.InternalError(w, err.Error(), "")
 		}
@@ -205,14 +928,20 @@ func (h *Handler) UpdateUserPartially(w http.ResponseWriter, r *http.Request) {
 
 // DeleteUser godoc
 // @Summary Delete user
-// @Description Delete the user by uuid.
+// @Description Delete the user by uuid. The user can be restored by an admin until deleted users are purged. With If-Match header the user is deleted only if its version is the given ETag.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param uuid path string true "User id"
+// @Param If-Match header string false "ETag of the user"
 // @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
 // @Failure 404 {object} apperror.AppError
+// @Failure 412 {object} apperror.AppError
 // @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
 // @Router /users/{uuid} [delete]
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("DELETE USER")
@@ -220,12 +949,22 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 	uuid := params.ByName("uuid")
 
-	err := h.userService.Delete(r.Context(), uuid)
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		h.BadRequest(w, err.Error(), "please, send ETag of the user")
+		return
+	}
+
+	err = h.userService.Delete(r.Context(), uuid, version)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNoRows) {
 			h.NotFound(w)
 			return
 		}
+		if errors.Is(err, apperror.ErrVersionConflict) {
+			h.Error(w, http.StatusPreconditionFailed, err.Error(), "")
+			return
+		}
 		h.InternalError(w, err.Error(), "something went wrong on the server side")
 		return
 	}
@@ -233,6 +972,252 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// RestoreUser godoc
+// @Summary Restore user
+// @Description Restore the deleted user by uuid, unless its email or username has been taken since. Available for admins only.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/restore [post]
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("RESTORE USER")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	err := h.userService.Restore(r.Context(), uuid)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID), errors.Is(err, apperror.ErrEmailTaken), errors.Is(err, apperror.ErrUsernameTaken):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "something went wrong on the server side")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Description Create a personal API key for automation. Pass it as Bearer token instead of access token. The key is shown only once. Scopes limit requests made with the key: read allows GET requests, write allows the rest. Keys can't be created with another API key.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param input body user.CreateAPIKeyDTO true "JSON input"
+// @Success 201 {object} CreatedAPIKey
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/keys [post]
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("CREATE API KEY")
+
+	// A leaked key must not be able to outlive its revocation
+	// by creating another key.
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.Type == token.APIKey {
+		h.Error(w, http.StatusForbidden, "API keys can't create API keys", "please, use access token")
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	var input CreateAPIKeyDTO
+	if err := h.readJSON(w, r, &input); err != nil {
+		h.BadRequest(w, err.Error(), "invalid request body")
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.BadRequest(w, err.Error(), "input validation failed. please, provide valid values")
+		return
+	}
+
+	input.UUID = params.ByName("uuid")
+
+	key, err := h.authService.CreateAPIKey(r.Context(), &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusCreated, key)
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description Get API keys of the user which are not revoked. Keys themselves are never shown.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200 {array} APIKey
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/keys [get]
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LIST API KEYS")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	keys, err := h.authService.ListAPIKeys(r.Context(), uuid)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Description Revoke API key of the user, so it can't be used anymore.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param id path string true "API key id"
+// @Success 200
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("REVOKE API KEY")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := h.authService.RevokeAPIKey(r.Context(), params.ByName("uuid"), params.ByName("id"))
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			h.NotFound(w)
+			return
+		}
+		h.InternalError(w, err.Error(), "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListSessions godoc
+// @Summary List sessions
+// @Description Get active sessions of the user, newest first. Every login starts a new session. Session of the caller is marked as current.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Success 200 {array} Session
+// @Failure 400 {object} apperror.AppError
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/sessions [get]
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("LIST SESSIONS")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	uuid := params.ByName("uuid")
+
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	sessions, err := h.authService.ListSessions(r.Context(), uuid, claims.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperror.ErrNoRows):
+			h.NotFound(w)
+		case errors.Is(err, apperror.ErrInvalidUUID):
+			h.BadRequest(w, err.Error(), "")
+		default:
+			h.InternalError(w, err.Error(), "")
+		}
+		return
+	}
+
+	h.JSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary Revoke session
+// @Description End session of the user, so its refresh token can't be used anymore. Access tokens already issued are valid until they expire.
+// @Tags users
+// @Produce json
+// @Param uuid path string true "User id"
+// @Param id path string true "Session id"
+// @Success 200
+// @Failure 401 {object} apperror.AppError
+// @Failure 403 {object} apperror.AppError
+// @Failure 404 {object} apperror.AppError
+// @Failure 500 {object} apperror.AppError
+// @Security BearerAuth
+// @Router /users/{uuid}/sessions/{id} [delete]
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("REVOKE SESSION")
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := h.authService.RevokeSession(r.Context(), params.ByName("uuid"), params.ByName("id"))
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			h.NotFound(w)
+			return
+		}
+		h.InternalError(w, err.Error(), "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// retryLater responses with 429 Too Many Requests status code
+// and Retry-After header in whole seconds.
+func (h *Handler) retryLater(w http.ResponseWriter, retry *apperror.RetryError) {
+	seconds := int(math.Ceil(retry.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	h.TooManyRequests(w, retry.Error(), "")
+}
+
+// isValidationError reports whether err is an input validation error.
+func isValidationError(err error) bool {
+	var validationErr validation.Errors
+	return errors.As(err, &validationErr)
+}
+
 // JSON encodes to JSON format given data and sends a response
 // to the client with a given http code and encoded data.
 func (h *Handler) JSON(w http.ResponseWriter, code int, data interface{}) {
@@ -320,6 +1305,18 @@ func (h *Handler) BadRequest(w http.ResponseWriter, message, developerMessage st
 	h.Error(w, http.StatusBadRequest, message, developerMessage)
 }
 
+// Unauthorized is a wrapper around Error method.
+// Responses with 401 Unauthorized status code and specified error message.
+func (h *Handler) Unauthorized(w http.ResponseWriter, message, developerMessage string) {
+	h.Error(w, http.StatusUnauthorized, message, developerMessage)
+}
+
+// TooManyRequests is a wrapper around Error method.
+// Responses with 429 Too Many Requests status code and specified error message.
+func (h *Handler) TooManyRequests(w http.ResponseWriter, message, developerMessage string) {
+	h.Error(w, http.StatusTooManyRequests, message, developerMessage)
+}
+
 // Not Found is a wrapper around JSON method.
 // Responses with 404 Not Found status code and specified error message.
 func (h *Handler) NotFound(w http.ResponseWriter) {
@@ -8,34 +8,86 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/juicyluv/sueta/user_service/app/internal/handler"
 	"github.com/juicyluv/sueta/user_service/app/internal/user"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/etag"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/juicyluv/sueta/user_service/app/pkg/totp"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
 )
 
 const (
-	userURL  = "/api/users/:uuid"
-	usersURL = "/api/users"
+	userURL      = "/api/users/:uuid"
+	usersURL     = "/api/users"
+	loginURL     = "/api/auth/login"
+	refreshURL   = "/api/auth/refresh"
+	logoutURL    = "/api/auth/logout"
+	logoutAllURL = "/api/auth/logout/all"
+	forgotURL    = "/api/auth/password/forgot"
+	resetURL     = "/api/auth/password/reset"
+	roleURL      = "/api/users/:uuid/role"
+	restoreURL   = "/api/users/:uuid/restore"
+	twoFactorURL = "/api/users/:uuid/2fa"
+	confirmURL   = "/api/users/:uuid/2fa/confirm"
+	disableURL   = "/api/users/:uuid/2fa/disable"
+	apiKeysURL   = "/api/users/:uuid/keys"
+	login2faURL  = "/api/auth/login/2fa"
+	verifyURL    = "/api/users/:uuid/verify"
+	resendURL    = "/api/users/:uuid/verify/resend"
+	emailURL     = "/api/users/:uuid/email/confirm"
+
+	testAccessSecret  = "access-secret"
+	testRefreshSecret = "refresh-secret"
 )
 
+var testLockoutPolicy = lockout.Policy{
+	Window:      time.Minute,
+	Threshold:   3,
+	BaseDelay:   time.Minute,
+	MaxAttempts: 5,
+	Lockout:     time.Minute,
+}
+
 func NewTestHandler(t *testing.T) (handler.Handling, func() error) {
+	handler, _, teardown := NewTestHandlerWithOutbox(t)
+	return handler, teardown
+}
+
+func NewTestHandlerWithOutbox(t *testing.T) (handler.Handling, *mail.Outbox, func() error) {
 	logger.Init()
 	l := logger.GetLogger()
 
 	router := httprouter.New()
 
 	userStorage, teardown := NewTestStorage(t)
-	service := user.NewService(userStorage, l)
-	handler := user.NewHandler(l, service)
+	outbox := NewTestMailer(t)
+	tokens := NewTestTokenManager()
+	tokenStorage := memory.NewTokenStorage()
+	sessionStorage := memory.NewSessionStorage()
+	apiKeyStorage := memory.NewAPIKeyStorage()
+	auditor := NewTestAuditor()
+	service := user.NewService(userStorage, tokenStorage, sessionStorage, apiKeyStorage, auditor, outbox, tokens, NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), l)
+	attempts := lockout.NewMemoryStore()
+	emailLimiter := lockout.NewLimiter(attempts, "login:email:", testLockoutPolicy)
+	ipLimiter := lockout.NewLimiter(attempts, "login:ip:", testLockoutPolicy)
+	authService := user.NewAuthService(service, tokenStorage, sessionStorage, apiKeyStorage, auditor, tokens, emailLimiter, ipLimiter, l)
+	handler := user.NewHandler(l, service, authService, auth.NewMiddleware(testAccessSecret, authService))
 	handler.Register(router)
 
-	return handler, teardown
+	return handler, outbox, teardown
 }
 
 func TestUserHandler_CreateUser(t *testing.T) {
@@ -192,45 +244,56 @@ func TestUserHandler_CreateUser(t *testing.T) {
 			),
 		},
 		{
-			name:         "password is not alphanumeric",
-			expectedCode: http.StatusBadRequest,
+			name:         "password with symbols and unicode",
+			expectedCode: http.StatusCreated,
 			input: input{
-				Email:          "test@mail.com",
-				Username:       "test",
-				Password:       "aNTOsha_44",
-				RepeatPassword: "qwerty",
+				Email:          "unicode@mail.com",
+				Username:       "unicode",
+				Password:       "пароль_№44!",
+				RepeatPassword: "пароль_№44!",
 			},
-			expectedErrorResponse: apperror.BadRequestError(
-				"password: must contain English letters and digits only.",
-				"input validation failed. please, provide valid values",
-			),
+			expectedErrorResponse: nil,
 		},
 		{
 			name:         "password length less than 6",
 			expectedCode: http.StatusBadRequest,
 			input: input{
-				Email:          "test@mail.com",
-				Username:       "test",
+				Email:          "short@mail.com",
+				Username:       "short",
 				Password:       "qwe",
-				RepeatPassword: "qwerty",
+				RepeatPassword: "qwe",
 			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"password: the length must be between 6 and 24.",
-				"input validation failed. please, provide valid values",
+				"password: minLength: must be at least 6 characters long.",
+				"password doesn't fit password policy",
 			),
 		},
 		{
 			name:         "password length greater than 24",
 			expectedCode: http.StatusBadRequest,
 			input: input{
-				Email:          "test@mail.com",
-				Username:       "test",
+				Email:          "long@mail.com",
+				Username:       "long",
 				Password:       "qwertyqwertyqwertyqwertywwww",
-				RepeatPassword: "qwerty",
+				RepeatPassword: "qwertyqwertyqwertyqwertywwww",
 			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"password: the length must be between 6 and 24.",
-				"input validation failed. please, provide valid values",
+				"password: maxLength: must be at most 24 characters long.",
+				"password doesn't fit password policy",
+			),
+		},
+		{
+			name:         "password contains username",
+			expectedCode: http.StatusBadRequest,
+			input: input{
+				Email:          "andrew@mail.com",
+				Username:       "andrew",
+				Password:       "Andrew1993",
+				RepeatPassword: "Andrew1993",
+			},
+			expectedErrorResponse: apperror.BadRequestError(
+				"password: email: must not contain the email.",
+				"password doesn't fit password policy",
 			),
 		},
 		{
@@ -329,11 +392,13 @@ func TestUserHandler_GetUser(t *testing.T) {
 			expectedCode:          http.StatusOK,
 			expectedErrorResponse: nil,
 			expectedResponse: user.User{
-				UUID:         id,
-				Email:        u.Email,
-				Username:     u.Username,
-				Verified:     false,
-				RegisteredAt: time.Now().UTC().Format("2006/01/02"),
+				UUID:     id,
+				Email:    u.Email,
+				Username: u.Username,
+				Verified: false,
+				Role:     auth.RoleUser,
+				// Sending verification email has changed the user.
+				Version: 1,
 			},
 		},
 		{
@@ -376,15 +441,102 @@ func TestUserHandler_GetUser(t *testing.T) {
 				assert.NoError(t, err)
 				assert.EqualValues(t, response, expectedResponse)
 			} else {
-				expectedResponseBytes, err := json.Marshal(&tc.expectedResponse)
-				assert.NoError(t, err)
-				assert.Equal(t, expectedResponseBytes, response)
+				var found user.User
+				assert.NoError(t, json.Unmarshal(response, &found))
+				assert.WithinDuration(t, time.Now(), found.RegisteredAt, time.Minute)
+				assert.False(t, found.UpdatedAt.Before(found.RegisteredAt))
+
+				// Times are checked above, since they can't be known beforehand.
+				found.RegisteredAt, found.UpdatedAt = time.Time{}, time.Time{}
+				assert.Equal(t, tc.expectedResponse, found)
+				assert.Equal(t, etag.Format(tc.expectedResponse.Version), res.Header.Get("ETag"))
 			}
 		})
 	}
 }
 
-func TestUserHandler_GetUserByEmailAndPassword(t *testing.T) {
+func TestUserHandler_ListUsers(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	for _, username := range []string{"bob", "alice", "alex", "carol", "albert"} {
+		_, err := createUser(h, &user.CreateUserDTO{
+			Email:          username + "@mail.com",
+			Username:       username,
+			Password:       "qwerty",
+			RepeatPassword: "qwerty",
+		})
+		assert.NoError(t, err)
+	}
+
+	listUsers := func(query string) (int, *user.UserPage) {
+		req, err := http.NewRequest(http.MethodGet, usersURL+"?"+query, nil)
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+
+		h.ListUsers(rec, req)
+
+		var page user.UserPage
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+		}
+		return rec.Code, &page
+	}
+
+	code, page := listUsers("username=al&sort=-username&limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 3, page.TotalEstimate)
+	if assert.Len(t, page.Users, 2) {
+		assert.Equal(t, "alice", page.Users[0].Username)
+		assert.Equal(t, "alex", page.Users[1].Username)
+	}
+	assert.NotEmpty(t, page.NextCursor)
+
+	code, page = listUsers("username=al&sort=-username&limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, "albert", page.Users[0].Username)
+	}
+	assert.Empty(t, page.NextCursor)
+
+	code, page = listUsers("verified=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, page.Users)
+	assert.NotNil(t, page.Users)
+
+	testCases := []struct {
+		name  string
+		query string
+	}{
+		{"invalid sort", "sort=password"},
+		{"invalid limit", "limit=ten"},
+		{"limit too big", "limit=1000"},
+		{"invalid date", "registeredFrom=2022/02/01"},
+		{"invalid verified", "verified=yes"},
+		{"malformed cursor", "cursor=not-a-cursor"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _ := listUsers(tc.query)
+			assert.Equal(t, http.StatusBadRequest, code)
+		})
+	}
+
+	// Cursor is bound to the sort order it has been issued for.
+	_, page = listUsers("sort=username&limit=1")
+	code, _ = listUsers("sort=email&cursor=" + page.NextCursor)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestUserHandler_Authorization(t *testing.T) {
 	handler, teardown := NewTestHandler(t)
 	defer func() {
 		assert.NoError(t, teardown())
@@ -397,127 +549,372 @@ func TestUserHandler_GetUserByEmailAndPassword(t *testing.T) {
 		t.Fatal("cannot convert handler to user.Handler type")
 	}
 
-	u := user.CreateUserDTO{
-		Email:          "test@mail.com",
-		Username:       "test",
+	owner := user.CreateUserDTO{
+		Email:          "owner@mail.com",
+		Username:       "owner",
 		Password:       "qwerty",
 		RepeatPassword: "qwerty",
 	}
+	ownerId, err := createUser(h, &owner)
+	assert.NoError(t, err)
 
-	id, err := createUser(h, &u)
+	other := user.CreateUserDTO{
+		Email:          "other@mail.com",
+		Username:       "other",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+	otherId, err := createUser(h, &other)
+	assert.NoError(t, err)
+
+	ownerTokens, err := login(h, owner.Email, owner.Password)
+	assert.NoError(t, err)
+
+	adminTokens, err := NewTestTokenManager().NewPair(token.Identity{
+		UUID:  "62056f8cf21b83383a5ae7fa",
+		Email: "admin@mail.com",
+		Role:  string(auth.RoleAdmin),
+	}, "refresh-id", "family-id")
+	assert.NoError(t, err)
+
+	exportToken, err := NewTestTokenManager().NewToken(token.ContentExport, ownerId, owner.Email, "")
 	assert.NoError(t, err)
 
 	testCases := []struct {
-		name                  string
-		expectedCode          int
-		expectedResponse      *user.User
-		expectedErrorResponse *apperror.AppError
-		email                 string
-		password              string
+		name         string
+		method       string
+		url          string
+		accessToken  string
+		body         interface{}
+		expectedCode int
 	}{
 		{
-			name:         "user exists",
+			name:         "no access token",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "invalid access token",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			accessToken:  ownerTokens.RefreshToken,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "content export token",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			accessToken:  exportToken,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "owner gets own account",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			accessToken:  ownerTokens.AccessToken,
 			expectedCode: http.StatusOK,
-			expectedResponse: &user.User{
-				UUID:         id,
-				Email:        u.Email,
-				Username:     u.Username,
-				Verified:     false,
-				RegisteredAt: time.Now().UTC().Format("2006/01/02"),
-			},
-			expectedErrorResponse: nil,
-			email:                 u.Email,
-			password:              u.Password,
 		},
 		{
-			name:             "user not found by email",
-			expectedCode:     http.StatusNotFound,
-			expectedResponse: nil,
-			expectedErrorResponse: apperror.NewAppError(
-				http.StatusNotFound,
-				"requested resource is not found",
-				"please, double check your request",
-			),
-			email:    "test2@mail.com",
-			password: u.Password,
+			name:         "user gets another account",
+			method:       http.MethodGet,
+			url:          "/api/users/" + otherId,
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
 		},
 		{
-			name:                  "user not found by wrong password",
-			expectedCode:          http.StatusBadRequest,
-			expectedResponse:      nil,
-			expectedErrorResponse: apperror.BadRequestError(apperror.ErrWrongPassword.Error(), ""),
-			email:                 u.Email,
-			password:              "qwerty123",
+			name:         "user deletes another account",
+			method:       http.MethodDelete,
+			url:          "/api/users/" + otherId,
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
 		},
 		{
-			name:             "empty email",
-			expectedCode:     http.StatusBadRequest,
-			expectedResponse: nil,
-			expectedErrorResponse: apperror.BadRequestError(
-				"empty email or password",
-				"email and password must be provided",
-			),
-			email:    "",
-			password: u.Password,
+			name:         "user changes own role",
+			method:       http.MethodPatch,
+			url:          "/api/users/" + ownerId + "/role",
+			accessToken:  ownerTokens.AccessToken,
+			body:         &user.SetRoleDTO{Role: auth.RoleAdmin},
+			expectedCode: http.StatusForbidden,
 		},
 		{
-			name:             "empty password",
-			expectedCode:     http.StatusBadRequest,
-			expectedResponse: nil,
-			expectedErrorResponse: apperror.BadRequestError(
-				"empty email or password",
-				"email and password must be provided",
-			),
-			email:    u.Email,
-			password: "",
+			name:         "user lists users",
+			method:       http.MethodGet,
+			url:          "/api/users",
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
 		},
 		{
-			name:             "empty email and password",
-			expectedCode:     http.StatusBadRequest,
-			expectedResponse: nil,
-			expectedErrorResponse: apperror.BadRequestError(
-				"empty email or password",
-				"email and password must be provided",
-			),
-			email:    "",
-			password: "",
+			name:         "admin lists users",
+			method:       http.MethodGet,
+			url:          "/api/users",
+			accessToken:  adminTokens.AccessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "admin gets another account",
+			method:       http.MethodGet,
+			url:          "/api/users/" + otherId,
+			accessToken:  adminTokens.AccessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "admin sets unknown role",
+			method:       http.MethodPatch,
+			url:          "/api/users/" + otherId + "/role",
+			accessToken:  adminTokens.AccessToken,
+			body:         &user.SetRoleDTO{Role: "superuser"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "admin changes role",
+			method:       http.MethodPatch,
+			url:          "/api/users/" + otherId + "/role",
+			accessToken:  adminTokens.AccessToken,
+			body:         &user.SetRoleDTO{Role: auth.RoleModerator},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "user enrolls two-factor for another account",
+			method:       http.MethodPost,
+			url:          "/api/users/" + otherId + "/2fa",
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "admin enrolls two-factor for another account",
+			method:       http.MethodPost,
+			url:          "/api/users/" + otherId + "/2fa",
+			accessToken:  adminTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "owner enrolls two-factor",
+			method:       http.MethodPost,
+			url:          "/api/users/" + ownerId + "/2fa",
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "admin deletes another account",
+			method:       http.MethodDelete,
+			url:          "/api/users/" + otherId,
+			accessToken:  adminTokens.AccessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "owner deletes own account",
+			method:       http.MethodDelete,
+			url:          "/api/users/" + ownerId,
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "user restores account",
+			method:       http.MethodPost,
+			url:          "/api/users/" + ownerId + "/restore",
+			accessToken:  ownerTokens.AccessToken,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "admin restores account",
+			method:       http.MethodPost,
+			url:          "/api/users/" + otherId + "/restore",
+			accessToken:  adminTokens.AccessToken,
+			expectedCode: http.StatusOK,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			rec := httptest.NewRecorder()
+			body := &bytes.Buffer{}
+			if tc.body != nil {
+				assert.NoError(t, json.NewEncoder(body).Encode(tc.body))
+			}
 
-			req, err := http.NewRequest(
-				http.MethodGet,
-				fmt.Sprintf("%s?email=%s&password=%s", usersURL, tc.email, tc.password),
-				nil,
-			)
+			req, err := http.NewRequest(tc.method, tc.url, body)
 			assert.NoError(t, err)
+			if tc.accessToken != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.accessToken)
+			}
+			rec := httptest.NewRecorder()
 
-			h.GetUserByEmailAndPassword(rec, req)
-			res := rec.Result()
+			router.ServeHTTP(rec, req)
 
-			assert.Equal(t, res.StatusCode, tc.expectedCode)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+}
 
-			response, err := ioutil.ReadAll(res.Body)
-			assert.NoError(t, err)
-			if tc.expectedErrorResponse != nil {
-				expectedResponse, err := json.Marshal(tc.expectedErrorResponse)
-				assert.NoError(t, err)
+func TestUserHandler_VerifyEmail(t *testing.T) {
+	handler, outbox, teardown := NewTestHandlerWithOutbox(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
 
-				assert.NoError(t, err)
-				assert.EqualValues(t, response, expectedResponse)
-			} else {
-				expectedResponseBytes, err := json.Marshal(&tc.expectedResponse)
-				assert.NoError(t, err)
-				assert.Equal(t, expectedResponseBytes, response)
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	messages, err := outbox.Messages()
+	assert.NoError(t, err)
+	if !assert.Len(t, messages, 1) {
+		return
+	}
+	assert.Equal(t, u.Email, messages[0].To)
+	verificationToken := extractToken(t, messages[0].Body)
+
+	// Verification email can't be resent right after registration.
+	res := postUserAction(t, h.ResendVerification, resendURL, id, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	res = postUserAction(t, h.VerifyEmail, verifyURL, id, &user.VerifyEmailDTO{Token: "invalid token"})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postUserAction(t, h.VerifyEmail, verifyURL, "62056f8cf21b83383a5ae7fa", &user.VerifyEmailDTO{Token: verificationToken})
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = postUserAction(t, h.VerifyEmail, verifyURL, id, &user.VerifyEmailDTO{Token: verificationToken})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = getUser(t, h, id)
+	var verified user.User
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&verified))
+	assert.True(t, verified.Verified)
+
+	res = postUserAction(t, h.ResendVerification, resendURL, id, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestUserHandler_ChangeEmail(t *testing.T) {
+	handler, outbox, teardown := NewTestHandlerWithOutbox(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "old@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	// requestChange requests email change and returns the token sent to the new email.
+	requestChange := func(email string) string {
+		sent, err := outbox.Messages()
+		assert.NoError(t, err)
+
+		res := patchUser(t, h, id, &user.UpdateUserDTO{Email: &email, OldPassword: &u.Password})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		messages, err := outbox.Messages()
+		assert.NoError(t, err)
+		if !assert.Len(t, messages, len(sent)+2) {
+			t.FailNow()
+		}
+
+		var confirmationToken string
+		for _, m := range messages[len(sent):] {
+			switch m.To {
+			case email:
+				confirmationToken = extractToken(t, m.Body)
+			case u.Email:
+				assert.Contains(t, m.Body, email)
+				assert.NotContains(t, m.Body, "Token:", "the old address can't confirm the change")
+			default:
+				t.Errorf("unexpected recipient: %s", m.To)
 			}
+		}
+		return confirmationToken
+	}
+
+	getEmail := func() (string, bool) {
+		var found user.User
+		assert.NoError(t, json.NewDecoder(getUser(t, h, id).Body).Decode(&found))
+		return found.Email, found.Verified
+	}
+
+	firstToken := requestChange("first@mail.com")
+
+	email, verified := getEmail()
+	assert.Equal(t, u.Email, email, "email isn't changed until confirmed")
+	assert.False(t, verified)
+
+	_, err = login(h, "first@mail.com", u.Password)
+	assert.Error(t, err)
+
+	newToken := requestChange("new@mail.com")
+
+	testCases := []struct {
+		name         string
+		id           string
+		token        string
+		expectedCode int
+	}{
+		{"invalid token", id, "invalid token", http.StatusBadRequest},
+		{"replaced request", id, firstToken, http.StatusBadRequest},
+		{"other user", "62056f8cf21b83383a5ae7fa", newToken, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := postUserAction(t, h.ConfirmEmail, emailURL, tc.id, &user.ConfirmEmailDTO{Token: tc.token})
+			assert.Equal(t, tc.expectedCode, res.StatusCode)
 		})
 	}
+
+	// Email is checked on confirmation, since it could be taken after the request.
+	_, err = createUser(h, &user.CreateUserDTO{
+		Email:          "new@mail.com",
+		Username:       "other",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	res := postUserAction(t, h.ConfirmEmail, emailURL, id, &user.ConfirmEmailDTO{Token: newToken})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	email, _ = getEmail()
+	assert.Equal(t, u.Email, email)
+
+	lastToken := requestChange("last@mail.com")
+
+	res = postUserAction(t, h.ConfirmEmail, emailURL, id, &user.ConfirmEmailDTO{Token: lastToken})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	email, verified = getEmail()
+	assert.Equal(t, "last@mail.com", email)
+	assert.True(t, verified)
+
+	_, err = login(h, "last@mail.com", u.Password)
+	assert.NoError(t, err)
+
+	// Confirmation token can be used once.
+	res = postUserAction(t, h.ConfirmEmail, emailURL, id, &user.ConfirmEmailDTO{Token: lastToken})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 }
 
-func TestUserHandler_UpdatePartially(t *testing.T) {
+func TestUserHandler_Login(t *testing.T) {
 	handler, teardown := NewTestHandler(t)
 	defer func() {
 		assert.NoError(t, teardown())
@@ -540,35 +937,409 @@ func TestUserHandler_UpdatePartially(t *testing.T) {
 	id, err := createUser(h, &u)
 	assert.NoError(t, err)
 
-	stringPtr := func(s string) *string {
-		return &s
-	}
-
 	testCases := []struct {
 		name                  string
 		expectedCode          int
-		input                 *user.UpdateUserDTO
 		expectedErrorResponse *apperror.AppError
+		input                 user.LoginDTO
 	}{
 		{
-			name:         "valid email update",
-			expectedCode: http.StatusOK,
-			input: &user.UpdateUserDTO{
-				Email:       stringPtr("test@mail.com"),
-				OldPassword: &u.Password,
-			},
+			name:                  "valid credentials",
+			expectedCode:          http.StatusOK,
 			expectedErrorResponse: nil,
+			input:                 user.LoginDTO{Email: u.Email, Password: u.Password},
 		},
 		{
-			name:         "invalid email update",
+			name:         "user not found by email",
+			expectedCode: http.StatusUnauthorized,
+			expectedErrorResponse: apperror.NewAppError(
+				http.StatusUnauthorized,
+				apperror.ErrWrongPassword.Error(),
+				"",
+			),
+			input: user.LoginDTO{Email: "test2@mail.com", Password: u.Password},
+		},
+		{
+			name:         "wrong password",
+			expectedCode: http.StatusUnauthorized,
+			expectedErrorResponse: apperror.NewAppError(
+				http.StatusUnauthorized,
+				apperror.ErrWrongPassword.Error(),
+				"",
+			),
+			input: user.LoginDTO{Email: u.Email, Password: "qwerty123"},
+		},
+		{
+			name:         "empty email and password",
 			expectedCode: http.StatusBadRequest,
-			input: &user.UpdateUserDTO{
-				Email:       stringPtr("testmail.com"),
-				OldPassword: &u.Password,
-			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"email: must be a valid email address.",
-				"you have provided invalid values",
+				"email: cannot be blank; password: cannot be blank.",
+				"input validation failed. please, provide valid values",
+			),
+			input: user.LoginDTO{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := json.Marshal(&tc.input)
+			assert.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodPost, loginURL, bytes.NewBuffer(body))
+			assert.NoError(t, err)
+
+			h.Login(rec, req)
+			res := rec.Result()
+
+			assert.Equal(t, tc.expectedCode, res.StatusCode)
+
+			response, err := ioutil.ReadAll(res.Body)
+			assert.NoError(t, err)
+			if tc.expectedErrorResponse != nil {
+				expectedResponse, err := json.Marshal(tc.expectedErrorResponse)
+				assert.NoError(t, err)
+				assert.EqualValues(t, response, expectedResponse)
+			} else {
+				var pair token.Pair
+				assert.NoError(t, json.Unmarshal(response, &pair))
+
+				claims, err := token.Verify(pair.AccessToken, testAccessSecret, token.Access)
+				assert.NoError(t, err)
+				assert.Equal(t, id, claims.Subject)
+				assert.Equal(t, u.Email, claims.Email)
+
+				_, err = token.Verify(pair.RefreshToken, testRefreshSecret, token.Refresh)
+				assert.NoError(t, err)
+
+				// Login time is recorded without changing the user.
+				var found user.User
+				assert.NoError(t, json.NewDecoder(getUser(t, h, id).Body).Decode(&found))
+				if assert.NotNil(t, found.LastLoginAt) {
+					assert.WithinDuration(t, time.Now(), *found.LastLoginAt, time.Minute)
+				}
+				assert.EqualValues(t, 1, found.Version)
+			}
+		})
+	}
+}
+
+func TestUserHandler_LoginLockout(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	_, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	postLogin := func(remoteAddr, email, password string) *http.Response {
+		body, err := json.Marshal(&user.LoginDTO{Email: email, Password: password})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, loginURL, bytes.NewBuffer(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+
+		h.Login(rec, req)
+		return rec.Result()
+	}
+
+	for i := 0; i < testLockoutPolicy.Threshold; i++ {
+		res := postLogin("10.0.0.1:1234", u.Email, "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+
+	// Valid credentials are not checked while login is throttled,
+	// even if they come from another address.
+	res := postLogin("10.0.0.2:1234", u.Email, u.Password)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("Retry-After"))
+
+	response, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	expectedResponse, err := json.Marshal(apperror.NewAppError(
+		http.StatusTooManyRequests,
+		apperror.ErrLoginLocked.Error(),
+		"",
+	))
+	assert.NoError(t, err)
+	assert.EqualValues(t, expectedResponse, response)
+
+	// Client address is throttled for other emails too.
+	res = postLogin("10.0.0.1:1234", "test2@mail.com", u.Password)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	res = postLogin("10.0.0.3:1234", "test2@mail.com", u.Password)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestUserHandler_Refresh(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	first, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	res := postRefreshToken(t, h.Refresh, refreshURL, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var second token.Pair
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&second))
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	claims, err := token.Verify(second.AccessToken, testAccessSecret, token.Access)
+	assert.NoError(t, err)
+	assert.Equal(t, id, claims.Subject)
+
+	// Reusing rotated token revokes the whole family.
+	res = postRefreshToken(t, h.Refresh, refreshURL, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = postRefreshToken(t, h.Refresh, refreshURL, second.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = postRefreshToken(t, h.Refresh, refreshURL, "invalid token")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestUserHandler_Logout(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	_, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	first, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+	second, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	res := postRefreshToken(t, h.Logout, logoutURL, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = postRefreshToken(t, h.Refresh, refreshURL, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Other sessions stay alive after logout.
+	res = postRefreshToken(t, h.Refresh, refreshURL, second.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestUserHandler_LogoutAll(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	_, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	first, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+	second, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	res := postRefreshToken(t, h.LogoutAll, logoutAllURL, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, refreshToken := range []string{first.RefreshToken, second.RefreshToken} {
+		res = postRefreshToken(t, h.Refresh, refreshURL, refreshToken)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	}
+}
+
+func TestUserHandler_ResetPassword(t *testing.T) {
+	handler, outbox, teardown := NewTestHandlerWithOutbox(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	_, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	session, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	// Unknown email gets the same response and no email is sent.
+	res := postUserAction(t, h.ForgotPassword, forgotURL, "", &user.ForgotPasswordDTO{Email: "unknown@mail.com"})
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	res = postUserAction(t, h.ForgotPassword, forgotURL, "", &user.ForgotPasswordDTO{Email: u.Email})
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	// Verification email and password reset email, which is sent in background.
+	var messages []mail.Message
+	sent := assert.Eventually(t, func() bool {
+		messages, err = outbox.Messages()
+		return err == nil && len(messages) == 2
+	}, time.Second, 10*time.Millisecond)
+	if !sent {
+		return
+	}
+	assert.Equal(t, u.Email, messages[1].To)
+	resetToken := extractToken(t, messages[1].Body)
+
+	res = postUserAction(t, h.ResetPassword, resetURL, "", &user.ResetPasswordDTO{
+		Token:          resetToken,
+		NewPassword:    "newpassword",
+		RepeatPassword: "anotherpassword",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Password policy is checked and the token stays valid.
+	res = postUserAction(t, h.ResetPassword, resetURL, "", &user.ResetPasswordDTO{
+		Token:          resetToken,
+		NewPassword:    "short",
+		RepeatPassword: "short",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postUserAction(t, h.ResetPassword, resetURL, "", &user.ResetPasswordDTO{
+		Token:          resetToken,
+		NewPassword:    "newpassword",
+		RepeatPassword: "newpassword",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Token can be used only once.
+	res = postUserAction(t, h.ResetPassword, resetURL, "", &user.ResetPasswordDTO{
+		Token:          resetToken,
+		NewPassword:    "newpassword2",
+		RepeatPassword: "newpassword2",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Existing sessions are revoked.
+	res = postRefreshToken(t, h.Refresh, refreshURL, session.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	_, err = login(h, u.Email, u.Password)
+	assert.Error(t, err)
+
+	_, err = login(h, u.Email, "newpassword")
+	assert.NoError(t, err)
+}
+
+func TestUserHandler_UpdatePartially(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+	router := httprouter.New()
+	handler.Register(router)
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	stringPtr := func(s string) *string {
+		return &s
+	}
+
+	testCases := []struct {
+		name                  string
+		expectedCode          int
+		input                 *user.UpdateUserDTO
+		expectedErrorResponse *apperror.AppError
+	}{
+		{
+			name:         "valid email update",
+			expectedCode: http.StatusOK,
+			input: &user.UpdateUserDTO{
+				Email:       stringPtr("test@mail.com"),
+				OldPassword: &u.Password,
+			},
+			expectedErrorResponse: nil,
+		},
+		{
+			name:         "invalid email update",
+			expectedCode: http.StatusBadRequest,
+			input: &user.UpdateUserDTO{
+				Email:       stringPtr("testmail.com"),
+				OldPassword: &u.Password,
+			},
+			expectedErrorResponse: apperror.BadRequestError(
+				"email: must be a valid email address.",
+				"you have provided invalid values",
 			),
 		},
 		{
@@ -621,7 +1392,7 @@ func TestUserHandler_UpdatePartially(t *testing.T) {
 				OldPassword: stringPtr("qwertyqwerty"),
 			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"newPassword: the length must be between 6 and 24.",
+				"newPassword: minLength: must be at least 6 characters long.",
 				"you have provided invalid values",
 			),
 		},
@@ -633,7 +1404,7 @@ func TestUserHandler_UpdatePartially(t *testing.T) {
 				OldPassword: stringPtr("qwertyqwerty"),
 			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"newPassword: the length must be between 6 and 24.",
+				"newPassword: maxLength: must be at most 24 characters long.",
 				"you have provided invalid values",
 			),
 		},
@@ -684,17 +1455,26 @@ func TestUserHandler_UpdatePartially(t *testing.T) {
 			),
 		},
 		{
-			name:         "new password is not alphanumeric",
+			name:         "new password contains email",
 			expectedCode: http.StatusBadRequest,
 			input: &user.UpdateUserDTO{
-				NewPassword: stringPtr("antoha_44ru"),
+				NewPassword: stringPtr("my-test-pass"),
 				OldPassword: stringPtr("qwertyqwerty"),
 			},
 			expectedErrorResponse: apperror.BadRequestError(
-				"newPassword: must contain English letters and digits only.",
+				"newPassword: email: must not contain the email.",
 				"you have provided invalid values",
 			),
 		},
+		{
+			name:         "new password with symbols",
+			expectedCode: http.StatusOK,
+			input: &user.UpdateUserDTO{
+				NewPassword: stringPtr("antoha_44ru!"),
+				OldPassword: stringPtr("qwertyqwerty"),
+			},
+			expectedErrorResponse: nil,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -850,24 +1630,634 @@ func TestUserHandler_Delete(t *testing.T) {
 	}
 }
 
-func createUser(h *user.Handler, u *user.CreateUserDTO) (string, error) {
+func TestUserHandler_IfMatch(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
 
-	body, err := json.Marshal(&u)
-	if err != nil {
-		return "", err
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
 	}
 
-	req, err := http.NewRequest(http.MethodPost, usersURL, bytes.NewBuffer(body))
-	if err != nil {
-		return "", err
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
 	}
-	rec := httptest.NewRecorder()
 
-	h.CreateUser(rec, req)
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
 
-	type response struct {
-		Id string `json:"id"`
-	}
+	request := func(method, ifMatch string, body interface{}) *http.Request {
+		var buf bytes.Buffer
+		if body != nil {
+			assert.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+
+		req, err := http.NewRequest(method, userURL, &buf)
+		assert.NoError(t, err)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		ctx := context.WithValue(req.Context(), httprouter.ParamsKey, httprouter.Params{
+			{Key: "uuid", Value: id},
+		})
+		return req.WithContext(ctx)
+	}
+
+	getETag := func() string {
+		rec := httptest.NewRecorder()
+		h.GetUser(rec, request(http.MethodGet, "", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Header().Get("ETag")
+	}
+
+	update := func(ifMatch, username string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.UpdateUserPartially(rec, request(http.MethodPatch, ifMatch, &user.UpdateUserDTO{
+			Username:    &username,
+			OldPassword: &u.Password,
+		}))
+		return rec
+	}
+
+	stale := getETag()
+	assert.NotEmpty(t, stale)
+
+	assert.Equal(t, http.StatusOK, update(stale, "first").Code)
+	current := getETag()
+	assert.NotEqual(t, stale, current)
+
+	// The user has been changed since stale ETag.
+	rec := update(stale, "second")
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	expectedResponse, err := json.Marshal(apperror.NewAppError(http.StatusPreconditionFailed, apperror.ErrVersionConflict.Error(), ""))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResponse, rec.Body.Bytes())
+
+	assert.Equal(t, http.StatusBadRequest, update("W/"+current, "second").Code)
+	assert.Equal(t, current, getETag())
+
+	assert.Equal(t, http.StatusOK, update("*", "second").Code)
+	current = getETag()
+
+	rec = httptest.NewRecorder()
+	h.DeleteUser(rec, request(http.MethodDelete, stale, nil))
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.DeleteUser(rec, request(http.MethodDelete, current, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUserHandler_Restore(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	tokens, err := login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	res := postUserAction(t, h.RestoreUser, restoreURL, id, nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	req, err := http.NewRequest(http.MethodDelete, userURL, nil)
+	assert.NoError(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, httprouter.Params{
+		{Key: "uuid", Value: id},
+	}))
+	rec := httptest.NewRecorder()
+	h.DeleteUser(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Deleted user can't log in and existing sessions are revoked.
+	_, err = login(h, u.Email, u.Password)
+	assert.Error(t, err)
+
+	res = postRefreshToken(t, h.Refresh, refreshURL, tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = postUserAction(t, h.RestoreUser, restoreURL, id, nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = getUser(t, h, id)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+
+	res = postUserAction(t, h.RestoreUser, restoreURL, "invalid", nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestUserHandler_TwoFactor(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	u := user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+
+	id, err := createUser(h, &u)
+	assert.NoError(t, err)
+
+	// Not enabled yet.
+	res := postUserAction(t, h.DisableTwoFactor, disableURL, id, &user.DisableTwoFactorDTO{Password: u.Password, Code: "123456"})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postUserAction(t, h.EnrollTwoFactor, twoFactorURL, id, nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var enrollment user.TwoFactorEnrollment
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&enrollment))
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/")
+
+	res = postUserAction(t, h.ConfirmTwoFactor, confirmURL, id, &user.TwoFactorCodeDTO{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	res = postUserAction(t, h.ConfirmTwoFactor, confirmURL, id, &user.TwoFactorCodeDTO{Code: code})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var recovery user.RecoveryCodes
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&recovery))
+	assert.Len(t, recovery.RecoveryCodes, 10)
+
+	// Secret must never leak through user representation.
+	res = getUser(t, h, id)
+	body, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(body), enrollment.Secret)
+	assert.NotContains(t, string(body), "twoFactor")
+
+	res = postUserAction(t, h.EnrollTwoFactor, twoFactorURL, id, nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Password alone only gives a challenge.
+	result := loginResult(t, h, u.Email, u.Password)
+	assert.True(t, result.TwoFactorRequired)
+	assert.NotEmpty(t, result.ChallengeToken)
+	assert.Nil(t, result.Pair)
+
+	testCases := []struct {
+		name         string
+		input        user.TwoFactorLoginDTO
+		expectedCode int
+	}{
+		{
+			name:         "invalid challenge token",
+			input:        user.TwoFactorLoginDTO{ChallengeToken: "invalid", Code: recovery.RecoveryCodes[0]},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "replayed confirmation code",
+			input:        user.TwoFactorLoginDTO{ChallengeToken: result.ChallengeToken, Code: code},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "recovery code",
+			input:        user.TwoFactorLoginDTO{ChallengeToken: result.ChallengeToken, Code: recovery.RecoveryCodes[0]},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "used recovery code",
+			input:        user.TwoFactorLoginDTO{ChallengeToken: result.ChallengeToken, Code: recovery.RecoveryCodes[0]},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "empty code",
+			input:        user.TwoFactorLoginDTO{ChallengeToken: result.ChallengeToken},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := json.Marshal(&tc.input)
+			assert.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, login2faURL, bytes.NewBuffer(body))
+			assert.NoError(t, err)
+			rec := httptest.NewRecorder()
+
+			h.LoginTwoFactor(rec, req)
+
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+			if tc.expectedCode == http.StatusOK {
+				var pair token.Pair
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&pair))
+				assert.NotEmpty(t, pair.AccessToken)
+				assert.NotEmpty(t, pair.RefreshToken)
+			}
+		})
+	}
+
+	res = postUserAction(t, h.DisableTwoFactor, disableURL, id, &user.DisableTwoFactorDTO{Password: "wrong", Code: recovery.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postUserAction(t, h.DisableTwoFactor, disableURL, id, &user.DisableTwoFactorDTO{Password: u.Password, Code: recovery.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = login(h, u.Email, u.Password)
+	assert.NoError(t, err)
+}
+
+func TestUserHandler_APIKeys(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+	router := httprouter.New()
+	handler.Register(router)
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	owner := user.CreateUserDTO{
+		Email:          "owner@mail.com",
+		Username:       "owner",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+	ownerId, err := createUser(h, &owner)
+	assert.NoError(t, err)
+
+	other := user.CreateUserDTO{
+		Email:          "other@mail.com",
+		Username:       "other",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+	otherId, err := createUser(h, &other)
+	assert.NoError(t, err)
+
+	tokens, err := login(h, owner.Email, owner.Password)
+	assert.NoError(t, err)
+
+	serve := func(method, url, bearer string, body interface{}) *httptest.ResponseRecorder {
+		buf := &bytes.Buffer{}
+		if body != nil {
+			assert.NoError(t, json.NewEncoder(buf).Encode(body))
+		}
+
+		req, err := http.NewRequest(method, url, buf)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	keysURL := "/api/users/" + ownerId + "/keys"
+
+	invalid := []struct {
+		name  string
+		input user.CreateAPIKeyDTO
+	}{
+		{"empty name", user.CreateAPIKeyDTO{Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: 30}},
+		{"no scopes", user.CreateAPIKeyDTO{Name: "ci", ExpiresInDays: 30}},
+		{"unknown scope", user.CreateAPIKeyDTO{Name: "ci", Scopes: []auth.Scope{"admin"}, ExpiresInDays: 30}},
+		{"no expiration", user.CreateAPIKeyDTO{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}}},
+		{"too long expiration", user.CreateAPIKeyDTO{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: user.MaxAPIKeyDays + 1}},
+	}
+
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(http.MethodPost, keysURL, tokens.AccessToken, &tc.input)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		})
+	}
+
+	create := func(scopes ...auth.Scope) user.CreatedAPIKey {
+		rec := serve(http.MethodPost, keysURL, tokens.AccessToken, &user.CreateAPIKeyDTO{
+			Name:          "ci",
+			Scopes:        scopes,
+			ExpiresInDays: 30,
+		})
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var created user.CreatedAPIKey
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+		assert.True(t, strings.HasPrefix(created.Key, auth.APIKeyPrefix))
+		assert.NotEmpty(t, created.ID)
+
+		return created
+	}
+
+	readKey := create(auth.ScopeRead)
+	writeKey := create(auth.ScopeRead, auth.ScopeWrite)
+
+	// Keys can't be created for another user.
+	rec := serve(http.MethodPost, "/api/users/"+otherId+"/keys", tokens.AccessToken, &user.CreateAPIKeyDTO{
+		Name:          "ci",
+		Scopes:        []auth.Scope{auth.ScopeRead},
+		ExpiresInDays: 30,
+	})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	testCases := []struct {
+		name         string
+		method       string
+		url          string
+		key          string
+		body         interface{}
+		expectedCode int
+	}{
+		{
+			name:         "read key gets own account",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			key:          readKey.Key,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "read key gets another account",
+			method:       http.MethodGet,
+			url:          "/api/users/" + otherId,
+			key:          readKey.Key,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "read key updates account",
+			method:       http.MethodPatch,
+			url:          "/api/users/" + ownerId,
+			key:          readKey.Key,
+			body:         map[string]string{"username": "renamed", "oldPassword": owner.Password},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "write key updates account",
+			method:       http.MethodPatch,
+			url:          "/api/users/" + ownerId,
+			key:          writeKey.Key,
+			body:         map[string]string{"username": "renamed", "oldPassword": owner.Password},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "write key creates key",
+			method:       http.MethodPost,
+			url:          keysURL,
+			key:          writeKey.Key,
+			body:         &user.CreateAPIKeyDTO{Name: "ci", Scopes: []auth.Scope{auth.ScopeRead}, ExpiresInDays: 30},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "malformed key",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			key:          auth.APIKeyPrefix + "malformed",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "wrong secret",
+			method:       http.MethodGet,
+			url:          "/api/users/" + ownerId,
+			key:          auth.APIKeyPrefix + readKey.ID + "_wrong",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(tc.method, tc.url, tc.key, tc.body)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	rec = serve(http.MethodGet, keysURL, tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), readKey.Key)
+	assert.NotContains(t, rec.Body.String(), "hash")
+
+	var keys []user.APIKey
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(t, keys, 2)
+	for _, key := range keys {
+		assert.NotNil(t, key.LastUsedAt, key.Name)
+		assert.Equal(t, "192.0.2.1", key.LastUsedIP, key.Name)
+	}
+
+	rec = serve(http.MethodDelete, keysURL+"/"+readKey.ID, tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(http.MethodDelete, keysURL+"/"+readKey.ID, tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(http.MethodGet, "/api/users/"+ownerId, readKey.Key, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(http.MethodGet, keysURL, tokens.AccessToken, nil)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(t, keys, 1)
+
+	// Changing password revokes the rest of the keys.
+	newPassword := "qwerty123"
+	rec = serve(http.MethodPatch, "/api/users/"+ownerId, tokens.AccessToken, &user.UpdateUserDTO{
+		OldPassword: &owner.Password,
+		NewPassword: &newPassword,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serve(http.MethodGet, "/api/users/"+ownerId, writeKey.Key, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserHandler_Sessions(t *testing.T) {
+	handler, teardown := NewTestHandler(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+	router := httprouter.New()
+	handler.Register(router)
+
+	h, ok := handler.(*user.Handler)
+	if !ok {
+		t.Fatal("cannot convert handler to user.Handler type")
+	}
+
+	owner := user.CreateUserDTO{
+		Email:          "owner@mail.com",
+		Username:       "owner",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+	ownerId, err := createUser(h, &owner)
+	assert.NoError(t, err)
+
+	other := user.CreateUserDTO{
+		Email:          "other@mail.com",
+		Username:       "other",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	}
+	_, err = createUser(h, &other)
+	assert.NoError(t, err)
+
+	serve := func(method, url, bearer, userAgent string, body interface{}) *httptest.ResponseRecorder {
+		buf := &bytes.Buffer{}
+		if body != nil {
+			assert.NoError(t, json.NewEncoder(buf).Encode(body))
+		}
+
+		req, err := http.NewRequest(method, url, buf)
+		assert.NoError(t, err)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	loginFrom := func(email, userAgent string) token.Pair {
+		rec := serve(http.MethodPost, "/api/auth/login", "", userAgent, &user.LoginDTO{Email: email, Password: "qwerty"})
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var pair token.Pair
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&pair))
+		return pair
+	}
+
+	sessionsURL := "/api/users/" + ownerId + "/sessions"
+
+	list := func(bearer string) []user.Session {
+		rec := serve(http.MethodGet, sessionsURL, bearer, "", nil)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var sessions []user.Session
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+		return sessions
+	}
+
+	laptop := loginFrom(owner.Email, "laptop")
+	phone := loginFrom(owner.Email, "phone")
+	otherTokens := loginFrom(other.Email, "other")
+
+	sessions := list(laptop.AccessToken)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.False(t, sessions[0].Current)
+	assert.Equal(t, "laptop", sessions[1].UserAgent)
+	assert.True(t, sessions[1].Current)
+	assert.Equal(t, "192.0.2.1", sessions[1].IP)
+	assert.False(t, sessions[1].CreatedAt.IsZero())
+
+	// Refresh records activity, but keeps the session.
+	rec := serve(http.MethodPost, "/api/auth/refresh", "", "phone", &user.RefreshTokenDTO{RefreshToken: phone.RefreshToken})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&phone))
+
+	refreshed := list(phone.AccessToken)
+	assert.Len(t, refreshed, 2)
+	assert.Equal(t, sessions[0].ID, refreshed[0].ID)
+	assert.True(t, refreshed[0].Current)
+	assert.False(t, refreshed[0].LastSeenAt.Before(sessions[0].LastSeenAt))
+
+	// Another user can neither list nor revoke sessions.
+	rec = serve(http.MethodGet, sessionsURL, otherTokens.AccessToken, "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(http.MethodDelete, sessionsURL+"/"+sessions[0].ID, otherTokens.AccessToken, "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(http.MethodDelete, sessionsURL+"/missing", laptop.AccessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Revoked session can't refresh tokens anymore.
+	rec = serve(http.MethodDelete, sessionsURL+"/"+sessions[0].ID, laptop.AccessToken, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serve(http.MethodDelete, sessionsURL+"/"+sessions[0].ID, laptop.AccessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(http.MethodPost, "/api/auth/refresh", "", "phone", &user.RefreshTokenDTO{RefreshToken: phone.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	sessions = list(laptop.AccessToken)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "laptop", sessions[0].UserAgent)
+
+	adminTokens, err := NewTestTokenManager().NewPair(token.Identity{
+		UUID:  "62056f8cf21b83383a5ae7fa",
+		Email: "admin@mail.com",
+		Role:  string(auth.RoleAdmin),
+	}, "refresh-id", "family-id")
+	assert.NoError(t, err)
+	assert.Len(t, list(adminTokens.AccessToken), 1)
+
+	// Password change revokes every session.
+	newPassword := "qwerty2"
+	rec = serve(http.MethodPatch, "/api/users/"+ownerId, laptop.AccessToken, "", &user.UpdateUserDTO{
+		OldPassword: &owner.Password,
+		NewPassword: &newPassword,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.Empty(t, list(laptop.AccessToken))
+
+	rec = serve(http.MethodPost, "/api/auth/refresh", "", "laptop", &user.RefreshTokenDTO{RefreshToken: laptop.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func createUser(h *user.Handler, u *user.CreateUserDTO) (string, error) {
+
+	body, err := json.Marshal(&u)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, usersURL, bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	rec := httptest.NewRecorder()
+
+	h.CreateUser(rec, req)
+
+	type response struct {
+		Id string `json:"id"`
+	}
 
 	res := response{}
 
@@ -878,3 +2268,117 @@ func createUser(h *user.Handler, u *user.CreateUserDTO) (string, error) {
 
 	return res.Id, nil
 }
+
+func login(h *user.Handler, email, password string) (*token.Pair, error) {
+	body, err := json.Marshal(&user.LoginDTO{Email: email, Password: password})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, loginURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	rec := httptest.NewRecorder()
+
+	h.Login(rec, req)
+
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("cannot log in: %s", rec.Body.String())
+	}
+
+	var pair token.Pair
+	if err := json.NewDecoder(rec.Body).Decode(&pair); err != nil {
+		return nil, err
+	}
+
+	return &pair, nil
+}
+
+func loginResult(t *testing.T, h *user.Handler, email, password string) *user.LoginResult {
+	body, err := json.Marshal(&user.LoginDTO{Email: email, Password: password})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, loginURL, bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+
+	h.Login(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var result user.LoginResult
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+
+	return &result
+}
+
+func postRefreshToken(t *testing.T, handle http.HandlerFunc, url, refreshToken string) *http.Response {
+	body, err := json.Marshal(&user.RefreshTokenDTO{RefreshToken: refreshToken})
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+
+	handle(rec, req)
+
+	return rec.Result()
+}
+
+func getUser(t *testing.T, h *user.Handler, id string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, userURL, nil)
+	assert.NoError(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, httprouter.Params{
+		{Key: "uuid", Value: id},
+	}))
+	rec := httptest.NewRecorder()
+
+	h.GetUser(rec, req)
+
+	return rec.Result()
+}
+
+func patchUser(t *testing.T, h *user.Handler, id string, input *user.UpdateUserDTO) *http.Response {
+	body := &bytes.Buffer{}
+	assert.NoError(t, json.NewEncoder(body).Encode(input))
+
+	req, err := http.NewRequest(http.MethodPatch, userURL, body)
+	assert.NoError(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, httprouter.Params{
+		{Key: "uuid", Value: id},
+	}))
+	rec := httptest.NewRecorder()
+
+	h.UpdateUserPartially(rec, req)
+
+	return rec.Result()
+}
+
+func postUserAction(t *testing.T, handle http.HandlerFunc, url, id string, input interface{}) *http.Response {
+	body := &bytes.Buffer{}
+	if input != nil {
+		assert.NoError(t, json.NewEncoder(body).Encode(input))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	assert.NoError(t, err)
+	req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, httprouter.Params{
+		{Key: "uuid", Value: id},
+	}))
+	rec := httptest.NewRecorder()
+
+	handle(rec, req)
+
+	return rec.Result()
+}
+
+var tokenPattern = regexp.MustCompile(`Token: (\S+)`)
+
+func extractToken(t *testing.T, body string) string {
+	match := tokenPattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("cannot find token in message: %q", body)
+	}
+	return match[1]
+}
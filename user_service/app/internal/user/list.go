@@ -0,0 +1,187 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+const (
+	// DefaultListLimit is a page size used if limit is not specified.
+	DefaultListLimit = 20
+	// MaxListLimit is a maximum page size.
+	MaxListLimit = 100
+
+	// listDateLayout is a layout of dates in list filters.
+	listDateLayout = "2006-01-02"
+	// LegacyDateLayout is a layout registration dates were stored in
+	// before they became timestamps.
+	LegacyDateLayout = "2006/01/02"
+	// SortTimeLayout is a layout of registration time in page cursors.
+	// It's RFC 3339 of fixed width, so UTC times sort the same way
+	// as their formatted strings do.
+	SortTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// Fields users can be sorted by.
+const (
+	SortByRegisteredAt = "registeredAt"
+	SortByUsername     = "username"
+	SortByEmail        = "email"
+)
+
+// ListSortOptions contains all known sort options.
+// Options prefixed with minus sort in descending order.
+var ListSortOptions = []string{
+	SortByRegisteredAt, "-" + SortByRegisteredAt,
+	SortByUsername, "-" + SortByUsername,
+	SortByEmail, "-" + SortByEmail,
+}
+
+// List returns a page of users matching given filters.
+// Returns Invalid Cursor error if cursor is malformed
+// or has been issued for another sort order.
+func (s *service) List(ctx context.Context, input *ListUsersDTO) (*UserPage, error) {
+	filter, err := newListFilter(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// One more user is requested to know whether there's a next page.
+	limit := filter.Limit
+	filter.Limit++
+
+	users, err := s.storage.List(ctx, filter)
+	if err != nil {
+		s.logger.Warnf("failed to list users: %v", err)
+		return nil, err
+	}
+
+	total, err := s.storage.Count(ctx, filter)
+	if err != nil {
+		s.logger.Warnf("failed to count users: %v", err)
+		return nil, err
+	}
+
+	page := &UserPage{
+		Users:         users,
+		TotalEstimate: total,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		last := page.Users[limit-1]
+		page.NextCursor = encodeCursor(&ListCursor{
+			Sort:  input.Sort,
+			Value: SortValue(&last, filter.SortBy),
+			UUID:  last.UUID,
+		})
+	}
+
+	if page.Users == nil {
+		page.Users = []User{}
+	}
+
+	return page, nil
+}
+
+// SortValue returns value of the user field with given name,
+// which is used to sort users.
+func SortValue(u *User, field string) string {
+	switch field {
+	case SortByUsername:
+		return u.Username
+	case SortByEmail:
+		return u.Email
+	default:
+		return u.RegisteredAt.UTC().Format(SortTimeLayout)
+	}
+}
+
+// AfterValue returns sort value of the cursor of given filter
+// in type of the sorted field, so storages can compare it with
+// stored values. Returns Invalid Cursor error if it's malformed.
+func AfterValue(filter *ListFilter) (interface{}, error) {
+	if filter.SortBy != SortByRegisteredAt {
+		return filter.After.Value, nil
+	}
+
+	t, err := time.Parse(SortTimeLayout, filter.After.Value)
+	if err != nil {
+		return nil, apperror.ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// newListFilter converts validated input into storage filter.
+func newListFilter(input *ListUsersDTO) (*ListFilter, error) {
+	filter := &ListFilter{
+		UsernamePrefix: input.Username,
+		SortBy:         strings.TrimPrefix(input.Sort, "-"),
+		Descending:     strings.HasPrefix(input.Sort, "-"),
+		Limit:          input.Limit,
+	}
+
+	if input.Sort == "" {
+		input.Sort = SortByRegisteredAt
+		filter.SortBy = SortByRegisteredAt
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultListLimit
+	}
+
+	if input.Verified != "" {
+		verified := input.Verified == "true"
+		filter.Verified = &verified
+	}
+
+	if input.RegisteredFrom != "" {
+		filter.RegisteredFrom, _ = time.Parse(listDateLayout, input.RegisteredFrom)
+	}
+
+	// The whole day of RegisteredTo is included.
+	if input.RegisteredTo != "" {
+		registeredTo, _ := time.Parse(listDateLayout, input.RegisteredTo)
+		filter.RegisteredTo = registeredTo.AddDate(0, 0, 1)
+	}
+
+	if input.Cursor != "" {
+		cursor, err := decodeCursor(input.Cursor)
+		if err != nil || cursor.Sort != input.Sort {
+			return nil, apperror.ErrInvalidCursor
+		}
+		filter.After = cursor
+
+		if _, err := AfterValue(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
+
+// encodeCursor encodes given cursor into opaque string.
+func encodeCursor(cursor *ListCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes cursor encoded with encodeCursor.
+func decodeCursor(s string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor ListCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
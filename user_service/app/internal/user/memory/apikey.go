@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// Check whether apiKeyStorage implements API key storage interface.
+var _ user.APIKeyStorage = &apiKeyStorage{}
+
+// apiKeyStorage implements API key storage interface in memory.
+// It's suitable for a single instance and tests.
+type apiKeyStorage struct {
+	mu   sync.RWMutex
+	keys map[string]user.APIKey
+}
+
+// NewAPIKeyStorage returns a new in-memory API key storage instance.
+func NewAPIKeyStorage() user.APIKeyStorage {
+	return &apiKeyStorage{
+		keys: make(map[string]user.APIKey),
+	}
+}
+
+// Create stores a copy of given API key.
+func (s *apiKeyStorage) Create(ctx context.Context, key *user.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = *key
+	return nil
+}
+
+// FindById finds the API key by given id.
+// Returns No Rows error if there's no key with given id.
+func (s *apiKeyStorage) FindById(ctx context.Context, id string) (*user.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, apperror.ErrNoRows
+	}
+
+	return &key, nil
+}
+
+// ListByUser returns not revoked keys of the user with given uuid, newest first.
+func (s *apiKeyStorage) ListByUser(ctx context.Context, userUUID string) ([]user.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []user.APIKey
+	for _, key := range s.keys {
+		if key.UserUUID == userUUID && !key.Revoked {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// Revoke marks the key with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active key with given id.
+func (s *apiKeyStorage) Revoke(ctx context.Context, userUUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.UserUUID != userUUID || key.Revoked {
+		return apperror.ErrNoRows
+	}
+
+	key.Revoked = true
+	s.keys[id] = key
+	return nil
+}
+
+// RevokeAllByUser marks all keys of the user with given uuid as revoked.
+func (s *apiKeyStorage) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, key := range s.keys {
+		if key.UserUUID == userUUID {
+			key.Revoked = true
+			s.keys[id] = key
+		}
+	}
+
+	return nil
+}
+
+// Touch records when and from which IP the key with given id was used.
+// Returns No Rows error if there's no key with given id.
+func (s *apiKeyStorage) Touch(ctx context.Context, id string, at time.Time, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return apperror.ErrNoRows
+	}
+
+	key.LastUsedAt = &at
+	key.LastUsedIP = ip
+	s.keys[id] = key
+	return nil
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// Check whether sessionStorage implements session storage interface.
+var _ user.SessionStorage = &sessionStorage{}
+
+// sessionStorage implements session storage interface in memory.
+// It's suitable for a single instance and tests.
+type sessionStorage struct {
+	mu       sync.RWMutex
+	sessions map[string]user.Session
+}
+
+// NewSessionStorage returns a new in-memory session storage instance.
+func NewSessionStorage() user.SessionStorage {
+	return &sessionStorage{
+		sessions: make(map[string]user.Session),
+	}
+}
+
+// Create stores a copy of given session.
+func (s *sessionStorage) Create(ctx context.Context, session *user.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+// FindById finds the session by given id.
+// Returns No Rows error if there's no session with given id.
+func (s *sessionStorage) FindById(ctx context.Context, id string) (*user.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, apperror.ErrNoRows
+	}
+
+	return &session, nil
+}
+
+// ListByUser returns not revoked sessions of the user with given uuid, newest first.
+func (s *sessionStorage) ListByUser(ctx context.Context, userUUID string) ([]user.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []user.Session
+	for _, session := range s.sessions {
+		if session.UserUUID == userUUID && !session.Revoked {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// Touch records when and from which IP the session with given id was used
+// and when it expires. Returns No Rows error if there's no session with given id.
+func (s *sessionStorage) Touch(ctx context.Context, id string, at time.Time, ip string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return apperror.ErrNoRows
+	}
+
+	session.LastSeenAt = at
+	session.IP = ip
+	session.ExpiresAt = expiresAt
+	s.sessions[id] = session
+	return nil
+}
+
+// Revoke marks the session with given id of the user with given uuid as revoked.
+// Returns No Rows error if the user has no active session with given id.
+func (s *sessionStorage) Revoke(ctx context.Context, userUUID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserUUID != userUUID || session.Revoked {
+		return apperror.ErrNoRows
+	}
+
+	session.Revoked = true
+	s.sessions[id] = session
+	return nil
+}
+
+// RevokeAllByUser marks all sessions of the user with given uuid as revoked.
+func (s *sessionStorage) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserUUID == userUUID {
+			session.Revoked = true
+			s.sessions[id] = session
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// Check whether tokenStorage implements token storage interface.
+var _ user.TokenStorage = &tokenStorage{}
+
+// tokenStorage implements token storage interface in memory.
+// It's suitable for a single instance and tests.
+type tokenStorage struct {
+	mu     sync.RWMutex
+	tokens map[string]user.RefreshToken
+}
+
+// NewTokenStorage returns a new in-memory refresh token storage instance.
+func NewTokenStorage() user.TokenStorage {
+	return &tokenStorage{
+		tokens: make(map[string]user.RefreshToken),
+	}
+}
+
+// Create stores a copy of given refresh token.
+func (s *tokenStorage) Create(ctx context.Context, token *user.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = *token
+	return nil
+}
+
+// FindById finds the refresh token by given id.
+// Returns No Rows error if there's no token with given id.
+func (s *tokenStorage) FindById(ctx context.Context, id string) (*user.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, apperror.ErrNoRows
+	}
+
+	return &token, nil
+}
+
+// Revoke marks the token with given id as revoked.
+// Returns No Rows error if there's no active token with given id.
+func (s *tokenStorage) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.Revoked {
+		return apperror.ErrNoRows
+	}
+
+	token.Revoked = true
+	s.tokens[id] = token
+	return nil
+}
+
+// RevokeFamily marks all tokens of given family as revoked.
+func (s *tokenStorage) RevokeFamily(ctx context.Context, family string) error {
+	s.revokeWhere(func(t *user.RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+// RevokeAllByUser marks all tokens of the user with given uuid as revoked.
+func (s *tokenStorage) RevokeAllByUser(ctx context.Context, userUUID string) error {
+	s.revokeWhere(func(t *user.RefreshToken) bool { return t.UserUUID == userUUID })
+	return nil
+}
+
+// revokeWhere marks all tokens matched by given predicate as revoked.
+func (s *tokenStorage) revokeWhere(match func(t *user.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if match(&token) {
+			token.Revoked = true
+			s.tokens[id] = token
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Check whether storage implements user storage interface.
+var _ user.Storage = &storage{}
+
+// storage implements user storage interface in memory.
+// Users are kept as BSON documents, so field tags and partial
+// updates behave the same way as in Mongo storage.
+// It's suitable for a single instance and tests.
+type storage struct {
+	mu    sync.RWMutex
+	users map[string]bson.M
+}
+
+// NewStorage returns a new in-memory user storage instance.
+func NewStorage() user.Storage {
+	return &storage{
+		users: make(map[string]bson.M),
+	}
+}
+
+// Create stores a copy of given user with a new object id.
+// Returns Email Taken or Username Taken error if another user has the same
+// email or username ignoring case, like unique indexes of Mongo storage do.
+// Returns inserted user uuid on success.
+func (s *storage) Create(ctx context.Context, u *user.User) (string, error) {
+	doc, err := toDocument(u)
+	if err != nil {
+		return "", err
+	}
+
+	id := primitive.NewObjectID().Hex()
+	delete(doc, "_id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(id, doc); err != nil {
+		return "", err
+	}
+
+	s.users[id] = doc
+	return id, nil
+}
+
+// CreateBatch stores given users one by one.
+// Users failing Create are reported in their results.
+func (s *storage) CreateBatch(ctx context.Context, users []*user.User) ([]user.BatchResult, error) {
+	results := make([]user.BatchResult, len(users))
+	for i, u := range users {
+		results[i].UUID, results[i].Err = s.Create(ctx, u)
+	}
+	return results, nil
+}
+
+// FindByEmail finds the user by given email.
+// Returns No Rows error if there's no user with given email.
+func (s *storage) FindByEmail(ctx context.Context, email string) (*user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, doc := range s.users {
+		if doc["email"] == email && !isDeleted(doc) {
+			return fromDocument(id, doc)
+		}
+	}
+
+	return nil, apperror.ErrNoRows
+}
+
+// FindById finds the user by given uuid.
+// Returns Invalid UUID error if uuid is not an object id
+// and No Rows error if there's no user with given uuid.
+func (s *storage) FindById(ctx context.Context, uuid string) (*user.User, error) {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return nil, apperror.ErrInvalidUUID
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return nil, apperror.ErrNoRows
+	}
+
+	return fromDocument(uuid, doc)
+}
+
+// UpdatePartially sets non-empty fields of given user.
+// Returns Invalid UUID error if uuid is not an object id,
+// No Rows error if there's no user with given uuid and
+// Email Taken or Username Taken error if they are used by another user.
+func (s *storage) UpdatePartially(ctx context.Context, u *user.User) error {
+	_, err := s.update(u, nil)
+	return err
+}
+
+// CompareAndUpdate sets non-empty fields of given user if the stored
+// version is the version of given user. Returns Version Conflict error
+// if it isn't and errors of UpdatePartially otherwise.
+func (s *storage) CompareAndUpdate(ctx context.Context, u *user.User) error {
+	doc, err := s.update(u, &u.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := fromDocument(u.UUID, doc)
+	if err != nil {
+		return err
+	}
+	u.Version, u.UpdatedAt = updated.Version, updated.UpdatedAt
+	return nil
+}
+
+// Replace writes username, password, verification and role of given user
+// as they are if the stored version is the version of given user.
+// Returns Version Conflict error if it isn't and errors of UpdatePartially
+// otherwise.
+func (s *storage) Replace(ctx context.Context, u *user.User) error {
+	if _, err := primitive.ObjectIDFromHex(u.UUID); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[u.UUID]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	if version(doc) != u.Version {
+		return apperror.ErrVersionConflict
+	}
+
+	replaced := make(bson.M, len(doc))
+	for field, value := range doc {
+		replaced[field] = value
+	}
+	replaced["username"] = u.Username
+	replaced["password"] = u.Password
+	replaced["verified"] = u.Verified
+	replaced["role"] = string(u.Role)
+
+	if err := s.checkUnique(u.UUID, replaced); err != nil {
+		return err
+	}
+
+	changed(replaced)
+	s.users[u.UUID] = replaced
+
+	updated, err := fromDocument(u.UUID, replaced)
+	if err != nil {
+		return err
+	}
+	u.Version, u.UpdatedAt = updated.Version, updated.UpdatedAt
+	return nil
+}
+
+// update merges non-empty fields of given user into the stored document,
+// if its version is the expected one or expected version is nil.
+// Returns the updated document.
+func (s *storage) update(u *user.User, expected *int64) (bson.M, error) {
+	if _, err := primitive.ObjectIDFromHex(u.UUID); err != nil {
+		return nil, apperror.ErrInvalidUUID
+	}
+
+	updated, err := toDocument(u)
+	if err != nil {
+		return nil, err
+	}
+	delete(updated, "_id")
+	delete(updated, "version")
+	delete(updated, "updatedAt")
+	delete(updated, "lastLoginAt")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[u.UUID]
+	if !ok || isDeleted(doc) {
+		return nil, apperror.ErrNoRows
+	}
+
+	if expected != nil && version(doc) != *expected {
+		return nil, apperror.ErrVersionConflict
+	}
+
+	merged := make(bson.M, len(doc))
+	for field, value := range doc {
+		merged[field] = value
+	}
+	for field, value := range updated {
+		merged[field] = value
+	}
+
+	if err := s.checkUnique(u.UUID, merged); err != nil {
+		return nil, err
+	}
+
+	changed(merged)
+	s.users[u.UUID] = merged
+	return merged, nil
+}
+
+// SetTwoFactor replaces two-factor state of the user with given uuid.
+// Nil two-factor state is removed from the document.
+// Returns Invalid UUID error if uuid is not an object id
+// and No Rows error if there's no user with given uuid.
+func (s *storage) SetTwoFactor(ctx context.Context, uuid string, twoFactor *user.TwoFactor) error {
+	return s.setTwoFactor(uuid, nil, twoFactor)
+}
+
+// CompareAndSetTwoFactor replaces two-factor state of the user with given
+// uuid if the stored version is given version. Returns Version Conflict
+// error if it isn't and errors of SetTwoFactor otherwise.
+func (s *storage) CompareAndSetTwoFactor(ctx context.Context, uuid string, version int64, twoFactor *user.TwoFactor) error {
+	return s.setTwoFactor(uuid, &version, twoFactor)
+}
+
+// setTwoFactor replaces two-factor state of the user if expected
+// version is nil or it's the stored version.
+func (s *storage) setTwoFactor(uuid string, expected *int64, twoFactor *user.TwoFactor) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	if expected != nil && version(doc) != *expected {
+		return apperror.ErrVersionConflict
+	}
+
+	changed(doc)
+	if twoFactor == nil {
+		delete(doc, "twoFactor")
+		return nil
+	}
+
+	updated, err := toDocument(&user.User{TwoFactor: twoFactor})
+	if err != nil {
+		return err
+	}
+	doc["twoFactor"] = updated["twoFactor"]
+	return nil
+}
+
+// SetPendingEmail replaces pending email change of the user with given uuid.
+// Nil pending email change is removed from the document.
+// Returns Invalid UUID error if uuid is not an object id
+// and No Rows error if there's no user with given uuid.
+func (s *storage) SetPendingEmail(ctx context.Context, uuid string, pending *user.PendingEmail) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	changed(doc)
+	if pending == nil {
+		delete(doc, "pendingEmail")
+		return nil
+	}
+
+	updated, err := toDocument(&user.User{PendingEmail: pending})
+	if err != nil {
+		return err
+	}
+	doc["pendingEmail"] = updated["pendingEmail"]
+	return nil
+}
+
+// SetLocked replaces lock time of the user with given uuid. Nil unlocks the user.
+// Returns Invalid UUID error if uuid is not an object id
+// and No Rows error if there's no user with given uuid.
+func (s *storage) SetLocked(ctx context.Context, uuid string, lockedAt *time.Time) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	changed(doc)
+	if lockedAt == nil {
+		delete(doc, "lockedAt")
+		return nil
+	}
+
+	updated, err := toDocument(&user.User{LockedAt: lockedAt})
+	if err != nil {
+		return err
+	}
+	doc["lockedAt"] = updated["lockedAt"]
+	return nil
+}
+
+// SetLastLogin records login time of the user with given uuid.
+// Returns Invalid UUID error if uuid is not an object id
+// and No Rows error if there's no user with given uuid.
+func (s *storage) SetLastLogin(ctx context.Context, uuid string, at time.Time) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	doc["lastLoginAt"] = primitive.NewDateTimeFromTime(at)
+	return nil
+}
+
+// Delete marks the user with given uuid as deleted.
+// Returns Invalid UUID error if uuid is not an object id,
+// No Rows error if there's no user with given uuid and Version
+// Conflict error if version is given and it isn't the stored one.
+func (s *storage) Delete(ctx context.Context, uuid string, v *int64) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	if v != nil && version(doc) != *v {
+		return apperror.ErrVersionConflict
+	}
+
+	changed(doc)
+	doc["deletedAt"] = primitive.NewDateTimeFromTime(time.Now())
+	return nil
+}
+
+// Restore removes deletion mark of the user with given uuid.
+// Returns Invalid UUID error if uuid is not an object id, No Rows
+// error if there's no deleted user with given uuid and Email Taken or
+// Username Taken error if another user has taken them.
+func (s *storage) Restore(ctx context.Context, uuid string) error {
+	if _, err := primitive.ObjectIDFromHex(uuid); err != nil {
+		return apperror.ErrInvalidUUID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.users[uuid]
+	if !ok || !isDeleted(doc) {
+		return apperror.ErrNoRows
+	}
+
+	if err := s.checkUnique(uuid, doc); err != nil {
+		return err
+	}
+
+	changed(doc)
+	delete(doc, "deletedAt")
+	return nil
+}
+
+// Purge permanently deletes users marked as deleted before given time.
+// Returns number of deleted users.
+func (s *storage) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, doc := range s.users {
+		deletedAt, ok := doc["deletedAt"].(primitive.DateTime)
+		if ok && !deletedAt.Time().After(before) {
+			delete(s.users, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// checkUnique checks that no other user which isn't deleted has
+// the same email or username ignoring case. Must be called with lock held.
+func (s *storage) checkUnique(id string, doc bson.M) error {
+	email, _ := doc["email"].(string)
+	username, _ := doc["username"].(string)
+
+	for otherID, other := range s.users {
+		if otherID == id || isDeleted(other) {
+			continue
+		}
+
+		if otherEmail, _ := other["email"].(string); email != "" && otherEmail == email {
+			return apperror.ErrEmailTaken
+		}
+
+		if otherUsername, _ := other["username"].(string); username != "" && strings.EqualFold(otherUsername, username) {
+			return apperror.ErrUsernameTaken
+		}
+	}
+
+	return nil
+}
+
+// List returns users matching given filter in requested order.
+func (s *storage) List(ctx context.Context, filter *user.ListFilter) ([]user.User, error) {
+	users, err := s.filter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return less(&users[i], &users[j], filter)
+	})
+
+	if filter.After != nil {
+		start := sort.Search(len(users), func(i int) bool {
+			return follows(&users[i], filter)
+		})
+		users = users[start:]
+	}
+
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	return users, nil
+}
+
+// Count returns exact number of users matching given filter.
+func (s *storage) Count(ctx context.Context, filter *user.ListFilter) (int64, error) {
+	users, err := s.filter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(users)), nil
+}
+
+// filter returns copies of all users matching given filter except cursor.
+func (s *storage) filter(filter *user.ListFilter) ([]user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []user.User
+	for id, doc := range s.users {
+		if isDeleted(doc) {
+			continue
+		}
+
+		u, err := fromDocument(id, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if matches(u, filter) {
+			users = append(users, *u)
+		}
+	}
+
+	return users, nil
+}
+
+// matches reports whether the user matches given filter.
+func matches(u *user.User, filter *user.ListFilter) bool {
+	if filter.Verified != nil && u.Verified != *filter.Verified {
+		return false
+	}
+
+	if !filter.RegisteredFrom.IsZero() && u.RegisteredAt.Before(filter.RegisteredFrom) {
+		return false
+	}
+
+	if !filter.RegisteredTo.IsZero() && !u.RegisteredAt.Before(filter.RegisteredTo) {
+		return false
+	}
+
+	return strings.HasPrefix(u.Username, filter.UsernamePrefix)
+}
+
+// less reports whether user a goes before user b in order requested by filter.
+func less(a, b *user.User, filter *user.ListFilter) bool {
+	va, vb := user.SortValue(a, filter.SortBy), user.SortValue(b, filter.SortBy)
+	if va == vb {
+		va, vb = a.UUID, b.UUID
+	}
+
+	if filter.Descending {
+		return va > vb
+	}
+	return va < vb
+}
+
+// follows reports whether the user goes after the cursor of given filter.
+func follows(u *user.User, filter *user.ListFilter) bool {
+	value, after := user.SortValue(u, filter.SortBy), filter.After.Value
+	if value == after {
+		value, after = u.UUID, filter.After.UUID
+	}
+
+	if filter.Descending {
+		return value < after
+	}
+	return value > after
+}
+
+// isDeleted reports whether given document is marked as deleted.
+func isDeleted(doc bson.M) bool {
+	_, ok := doc["deletedAt"]
+	return ok
+}
+
+// version returns version of given document.
+// Documents without version have version zero.
+func version(doc bson.M) int64 {
+	v, _ := doc["version"].(int64)
+	return v
+}
+
+// changed increments version of given document and sets its
+// update time. Must be called with lock held.
+func changed(doc bson.M) {
+	doc["version"] = version(doc) + 1
+	doc["updatedAt"] = primitive.NewDateTimeFromTime(time.Now())
+}
+
+// toDocument converts given user to BSON document.
+func toDocument(u *user.User) (bson.M, error) {
+	userBytes, err := bson.Marshal(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(userBytes, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// fromDocument decodes a new user instance from stored document.
+func fromDocument(uuid string, doc bson.M) (*user.User, error) {
+	userBytes, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	var u user.User
+	if err := bson.Unmarshal(userBytes, &u); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	u.UUID = uuid
+
+	return &u, nil
+}
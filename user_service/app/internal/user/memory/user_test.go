@@ -0,0 +1,46 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/storagetest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (user.Storage, func() error) {
+		return memory.NewStorage(), func() error { return nil }
+	})
+}
+
+func TestTokenStorage(t *testing.T) {
+	storagetest.RunTokenStorage(t, func(t *testing.T) (user.TokenStorage, func() error) {
+		return memory.NewTokenStorage(), func() error { return nil }
+	})
+}
+
+func TestSessionStorage(t *testing.T) {
+	storagetest.RunSessionStorage(t, func(t *testing.T) (user.SessionStorage, func() error) {
+		return memory.NewSessionStorage(), func() error { return nil }
+	})
+}
+
+func TestAPIKeyStorage(t *testing.T) {
+	storagetest.RunAPIKeyStorage(t, func(t *testing.T) (user.APIKeyStorage, func() error) {
+		return memory.NewAPIKeyStorage(), func() error { return nil }
+	})
+}
+
+func TestStorage_ObjectID(t *testing.T) {
+	storage := memory.NewStorage()
+
+	id, err := storage.Create(context.Background(), &user.User{Email: "test@mail.com"})
+	assert.NoError(t, err)
+
+	_, err = primitive.ObjectIDFromHex(id)
+	assert.NoError(t, err, "id must be a hex object id like in mongo storage")
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// timestamps converts registration dates stored as YYYY/MM/DD strings
+// into timestamps of the day start in UTC. Users having no update time
+// get their registration time.
+//
+// Rolling back converts registration times into YYYY/MM/DD strings of
+// their UTC date, so time of the day is lost. Update and last login times
+// are removed, since previous versions don't know about them.
+var timestamps = Migration{
+	Version: 1,
+	Name:    "timestamps",
+	Up: func(ctx context.Context, users *mongo.Collection) error {
+		filter := bson.M{"registeredAt": bson.M{"$type": "string"}}
+
+		return convertDocuments(ctx, users, filter, func(doc bson.M) (mongo.WriteModel, error) {
+			value, _ := doc["registeredAt"].(string)
+			registeredAt, err := time.Parse(user.LegacyDateLayout, value)
+			if err != nil {
+				return nil, fmt.Errorf("user %v has invalid registration date %q", doc["_id"], value)
+			}
+
+			set := bson.M{"registeredAt": registeredAt}
+			if _, ok := doc["updatedAt"]; !ok {
+				set["updatedAt"] = registeredAt
+			}
+
+			return mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": doc["_id"], "registeredAt": value}).
+				SetUpdate(bson.M{"$set": set}), nil
+		})
+	},
+	Down: func(ctx context.Context, users *mongo.Collection) error {
+		filter := bson.M{"registeredAt": bson.M{"$type": "date"}}
+
+		return convertDocuments(ctx, users, filter, func(doc bson.M) (mongo.WriteModel, error) {
+			value, _ := doc["registeredAt"].(primitive.DateTime)
+
+			return mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": doc["_id"], "registeredAt": value}).
+				SetUpdate(bson.M{
+					"$set":   bson.M{"registeredAt": value.Time().UTC().Format(user.LegacyDateLayout)},
+					"$unset": bson.M{"updatedAt": "", "lastLoginAt": ""},
+				}), nil
+		})
+	},
+}
+
+// convertBatchSize is a number of documents converted by a single bulk write.
+const convertBatchSize = 500
+
+// convertDocuments writes changes made by convert to users matching given
+// filter in batches. Converted users should not match the filter anymore,
+// so interrupted conversion continues where it stopped when run again.
+func convertDocuments(ctx context.Context, users *mongo.Collection, filter bson.M, convert func(doc bson.M) (mongo.WriteModel, error)) error {
+	opts := options.Find().SetProjection(bson.M{"registeredAt": 1, "updatedAt": 1})
+
+	cursor, err := users.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("cannot find users to convert: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if _, err := users.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("cannot convert users: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode document: %w", err)
+		}
+
+		model, err := convert(doc)
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, model)
+		if len(batch) == convertBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cannot read users to convert: %w", err)
+	}
+
+	return flush()
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes creates unique indexes of emails and usernames of users which
+// aren't deleted, so deleted users don't hold their email and username
+// until purged. Usernames are compared case-insensitively. Indexes created
+// by previous versions at startup cover deleted users too, so they're
+// replaced. Indexes can't be created while the collection has duplicates.
+//
+// Rolling back drops the indexes.
+var indexes = Migration{
+	Version: 2,
+	Name:    "indexes",
+	Up: func(ctx context.Context, users *mongo.Collection) error {
+		cursor, err := users.Indexes().List(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot list indexes: %w", err)
+		}
+		var existing []bson.M
+		if err := cursor.All(ctx, &existing); err != nil {
+			return fmt.Errorf("failed to decode indexes: %w", err)
+		}
+		for _, idx := range existing {
+			name, _ := idx["name"].(string)
+			if (name == "email_unique" || name == "username_unique_ci") && idx["partialFilterExpression"] == nil {
+				if _, err := users.Indexes().DropOne(ctx, name); err != nil {
+					return fmt.Errorf("cannot drop index %s: %w", name, err)
+				}
+			}
+		}
+
+		notDeleted := bson.M{"deletedAt": bson.M{"$exists": false}}
+		_, err = users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetName("email_unique").SetUnique(true).SetPartialFilterExpression(notDeleted),
+			},
+			{
+				Keys: bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().SetName("username_unique_ci").SetUnique(true).SetPartialFilterExpression(notDeleted).
+					SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot create indexes, collection may contain duplicates: %w", err)
+		}
+		return nil
+	},
+	Down: func(ctx context.Context, users *mongo.Collection) error {
+		for _, name := range []string{"email_unique", "username_unique_ci"} {
+			if _, err := users.Indexes().DropOne(ctx, name); err != nil {
+				return fmt.Errorf("cannot drop index %s: %w", name, err)
+			}
+		}
+		return nil
+	},
+}
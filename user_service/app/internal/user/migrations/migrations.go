@@ -0,0 +1,348 @@
+// Package migrations evolves documents and indexes of users collection
+// of mongo storage with ordered Go migrations. Applied migrations are
+// recorded with checksums of their source files, so a migration changed
+// after it has been applied is noticed. Migrations are applied by a single
+// instance at a time, others fail with Locked error.
+//
+// A migration lives in its own file named after its version and name,
+// like 0001_timestamps.go, and is added to the end of the all list.
+// Applied migrations must not be changed, a new one is added instead.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// all contains migrations of users collection ordered by version.
+var all = []Migration{
+	timestamps,
+	indexes,
+}
+
+// sources contains source files of migrations, which checksums are made of.
+//
+//go:embed 0*.go
+var sources embed.FS
+
+// ErrLocked is returned when migrations are being applied by another instance.
+var ErrLocked = errors.New("migrations are locked by another instance")
+
+const (
+	// lockID is _id of the lock document in applied migrations collection.
+	lockID = "lock"
+	// lockTTL is how long the lock is held if its owner has died
+	// without releasing it. Migrations must be faster than that.
+	lockTTL = 15 * time.Minute
+)
+
+// Func changes users collection.
+type Func func(ctx context.Context, users *mongo.Collection) error
+
+// Migration is a single step of users collection evolution.
+// Down reverts changes made by Up.
+type Migration struct {
+	Version int
+	Name    string
+	Up      Func
+	Down    Func
+
+	checksum string
+}
+
+// String returns version and name of the migration, like its file name.
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status describes a known or applied migration.
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	// Changed means that the migration has been changed since it was applied.
+	Changed bool `json:"changed,omitempty"`
+	// Unknown means that the migration has been applied by another version.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// applied is a record of applied migration.
+type applied struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	Checksum  string    `bson:"checksum"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// Migrator applies migrations to users collection and records
+// them in applied migrations collection.
+type Migrator struct {
+	logger     logger.Logger
+	users      *mongo.Collection
+	applied    *mongo.Collection
+	migrations []Migration
+	owner      string
+}
+
+// New returns a migrator of given users collection, which records
+// applied migrations in migrations collection.
+func New(database *mongo.Database, collection, migrationCollection string) (*Migrator, error) {
+	migrations, err := withChecksums(all)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMigrator(database, collection, migrationCollection, migrations), nil
+}
+
+func newMigrator(database *mongo.Database, collection, migrationCollection string, migrations []Migration) *Migrator {
+	return &Migrator{
+		logger:     logger.GetLogger(),
+		users:      database.Collection(collection),
+		applied:    database.Collection(migrationCollection),
+		migrations: migrations,
+		owner:      primitive.NewObjectID().Hex(),
+	}
+}
+
+// withChecksums checks order of given migrations and returns
+// their copies with checksums of their source files.
+func withChecksums(migrations []Migration) ([]Migration, error) {
+	result := make([]Migration, len(migrations))
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return nil, fmt.Errorf("migration %s must have a version greater than %s", &m, &migrations[i-1])
+		}
+		if m.Up == nil || m.Down == nil {
+			return nil, fmt.Errorf("migration %s has no up or down step", &m)
+		}
+
+		source, err := sources.ReadFile(m.String() + ".go")
+		if err != nil {
+			return nil, fmt.Errorf("cannot read source of migration %s: %w", &m, err)
+		}
+
+		sum := sha256.Sum256(source)
+		m.checksum = hex.EncodeToString(sum[:])
+		result[i] = m
+	}
+	return result, nil
+}
+
+// Status returns known migrations followed by applied migrations unknown
+// to this version, ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if record, ok := records[migration.Version]; ok {
+			appliedAt := record.AppliedAt.UTC()
+			status.AppliedAt = &appliedAt
+			status.Changed = record.Checksum != migration.checksum
+			delete(records, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range records {
+		appliedAt := record.AppliedAt.UTC()
+		statuses = append(statuses, Status{
+			Version:   record.Version,
+			Name:      record.Name,
+			AppliedAt: &appliedAt,
+			Unknown:   true,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// Pending returns number of known migrations which haven't been applied.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Up applies pending migrations up to given version in order,
+// all of them if version is zero. Nothing is applied if an applied
+// migration has been changed. Returns applied migrations.
+func (m *Migrator) Up(ctx context.Context, version int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		record, ok := records[migration.Version]
+		switch {
+		case ok && record.Checksum != migration.checksum:
+			return nil, fmt.Errorf("migration %s has been changed since it was applied", &migration)
+		case !ok && (version == 0 || migration.Version <= version):
+			pending = append(pending, migration)
+		}
+	}
+
+	var done []Migration
+	for _, migration := range pending {
+		m.logger.Infof("applying migration %s", &migration)
+		if err := migration.Up(ctx, m.users); err != nil {
+			return done, fmt.Errorf("cannot apply migration %s: %w", &migration, err)
+		}
+
+		_, err := m.applied.InsertOne(ctx, &applied{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Checksum:  migration.checksum,
+			AppliedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return done, fmt.Errorf("cannot record migration %s: %w", &migration, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts given number of the latest applied migrations
+// in reverse order. Migrations unknown to this version or changed
+// since they were applied can't be reverted. Returns reverted migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	records, err := m.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(records))
+	for version := range records {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	known := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	var reverting []Migration
+	for _, version := range versions {
+		migration, ok := known[version]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("migration %04d_%s is unknown to this version", version, records[version].Name)
+		case records[version].Checksum != migration.checksum:
+			return nil, fmt.Errorf("migration %s has been changed since it was applied", &migration)
+		}
+		reverting = append(reverting, migration)
+	}
+
+	var done []Migration
+	for _, migration := range reverting {
+		m.logger.Infof("reverting migration %s", &migration)
+		if err := migration.Down(ctx, m.users); err != nil {
+			return done, fmt.Errorf("cannot revert migration %s: %w", &migration, err)
+		}
+
+		if _, err := m.applied.DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return done, fmt.Errorf("cannot remove record of migration %s: %w", &migration, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// records returns applied migrations by version.
+func (m *Migrator) records(ctx context.Context) (map[int]applied, error) {
+	cursor, err := m.applied.Find(ctx, bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, fmt.Errorf("cannot find applied migrations: %w", err)
+	}
+
+	var list []applied
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %w", err)
+	}
+
+	records := make(map[int]applied, len(list))
+	for _, record := range list {
+		records[record.Version] = record
+	}
+	return records, nil
+}
+
+// lock takes the lock of migrations collection. The lock document is
+// upserted only if it's missing or expired, otherwise the upsert violates
+// uniqueness of _id and Locked error is returned. Returns a function
+// releasing the lock.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": lockID, "expiresAt": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": m.owner, "lockedAt": now, "expiresAt": now.Add(lockTTL)}}
+
+	_, err := m.applied.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("cannot lock migrations: %w", err)
+	}
+
+	return func() {
+		// The lock is released even if the context has been canceled.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if _, err := m.applied.DeleteOne(ctx, bson.M{"_id": lockID, "owner": m.owner}); err != nil {
+			m.logger.Warnf("cannot unlock migrations: %v", err)
+		}
+	}, nil
+}
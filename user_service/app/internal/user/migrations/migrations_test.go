@@ -0,0 +1,228 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWithChecksums(t *testing.T) {
+	migrations, err := withChecksums(all)
+	assert.NoError(t, err)
+	for _, m := range migrations {
+		assert.Len(t, m.checksum, 64, m.String())
+	}
+
+	noop := func(ctx context.Context, users *mongodriver.Collection) error { return nil }
+
+	_, err = withChecksums([]Migration{timestamps, {Version: 1, Name: "again", Up: noop, Down: noop}})
+	assert.Error(t, err)
+
+	_, err = withChecksums([]Migration{{Version: 1, Name: "timestamps", Up: noop}})
+	assert.Error(t, err)
+
+	// Every migration must have a source file.
+	_, err = withChecksums([]Migration{{Version: 99, Name: "missing", Up: noop, Down: noop}})
+	assert.Error(t, err)
+}
+
+// TestMigrator runs migrations against MongoDB available
+// at MONGO_URL. Skipped if it's not set.
+func TestMigrator(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+
+	logger.Init()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	database, err := mongo.NewMongoClient(ctx, "sueta_test", mongoURL)
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %v", err)
+	}
+	defer database.Client().Disconnect(context.Background())
+
+	// newTestMigrator returns a migrator of new collections dropped by teardown.
+	newTestMigrator := func(migrations []Migration) (*Migrator, func()) {
+		collection := fmt.Sprintf("users_%d", time.Now().UnixNano())
+		m := newMigrator(database, collection, collection+"_migrations", migrations)
+		return m, func() {
+			m.users.Drop(context.Background())
+			m.applied.Drop(context.Background())
+		}
+	}
+
+	t.Run("UpDown", func(t *testing.T) {
+		var calls []string
+		step := func(name string) Func {
+			return func(ctx context.Context, users *mongodriver.Collection) error {
+				calls = append(calls, name)
+				return nil
+			}
+		}
+		migrations := []Migration{
+			{Version: 1, Name: "first", Up: step("up 1"), Down: step("down 1"), checksum: "1"},
+			{Version: 2, Name: "second", Up: step("up 2"), Down: step("down 2"), checksum: "2"},
+			{Version: 3, Name: "third", Up: step("up 3"), Down: step("down 3"), checksum: "3"},
+		}
+
+		m, teardown := newTestMigrator(migrations)
+		defer teardown()
+
+		pending, err := m.Pending(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, pending)
+
+		done, err := m.Up(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Len(t, done, 2)
+
+		done, err = m.Up(context.Background(), 0)
+		assert.NoError(t, err)
+		assert.Len(t, done, 1)
+
+		pending, err = m.Pending(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, pending)
+
+		done, err = m.Down(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Len(t, done, 2)
+		assert.Equal(t, []string{"up 1", "up 2", "up 3", "down 3", "down 2"}, calls)
+
+		statuses, err := m.Status(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, statuses, 3) {
+			assert.NotNil(t, statuses[0].AppliedAt)
+			assert.Nil(t, statuses[1].AppliedAt)
+			assert.Nil(t, statuses[2].AppliedAt)
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		noop := func(ctx context.Context, users *mongodriver.Collection) error { return nil }
+		m, teardown := newTestMigrator([]Migration{{Version: 1, Name: "first", Up: noop, Down: noop, checksum: "1"}})
+		defer teardown()
+
+		_, err := m.Up(context.Background(), 0)
+		assert.NoError(t, err)
+
+		// Another version has changed the first migration and added one more.
+		changed := newMigrator(database, m.users.Name(), m.applied.Name(), []Migration{
+			{Version: 1, Name: "first", Up: noop, Down: noop, checksum: "changed"},
+			{Version: 2, Name: "second", Up: noop, Down: noop, checksum: "2"},
+		})
+
+		_, err = changed.Up(context.Background(), 0)
+		assert.Error(t, err)
+
+		statuses, err := changed.Status(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, statuses, 2) {
+			assert.True(t, statuses[0].Changed)
+			assert.Nil(t, statuses[1].AppliedAt)
+		}
+
+		// The first migration is unknown to a version without migrations.
+		statuses, err = newMigrator(database, m.users.Name(), m.applied.Name(), nil).Status(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, statuses, 1) {
+			assert.True(t, statuses[0].Unknown)
+		}
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		m, teardown := newTestMigrator(nil)
+		defer teardown()
+
+		unlock, err := m.lock(context.Background())
+		assert.NoError(t, err)
+
+		other := newMigrator(database, m.users.Name(), m.applied.Name(), nil)
+		_, err = other.Up(context.Background(), 0)
+		assert.ErrorIs(t, err, ErrLocked)
+
+		unlock()
+
+		_, err = other.Up(context.Background(), 0)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Timestamps", func(t *testing.T) {
+		m, teardown := newTestMigrator(nil)
+		defer teardown()
+
+		_, err := m.users.InsertMany(context.Background(), []interface{}{
+			bson.M{"email": "legacy@mail.com", "registeredAt": "2022/02/24"},
+			bson.M{"email": "current@mail.com", "registeredAt": time.Date(2022, 3, 1, 10, 15, 0, 0, time.UTC)},
+		})
+		assert.NoError(t, err)
+
+		assert.NoError(t, timestamps.Up(context.Background(), m.users))
+		// Converted users are not converted again.
+		assert.NoError(t, timestamps.Up(context.Background(), m.users))
+
+		var doc bson.M
+		assert.NoError(t, m.users.FindOne(context.Background(), bson.M{"email": "legacy@mail.com"}).Decode(&doc))
+		registeredAt, _ := doc["registeredAt"].(primitive.DateTime)
+		assert.True(t, time.Date(2022, 2, 24, 0, 0, 0, 0, time.UTC).Equal(registeredAt.Time()))
+		assert.Equal(t, doc["registeredAt"], doc["updatedAt"])
+
+		assert.NoError(t, timestamps.Down(context.Background(), m.users))
+
+		doc = nil
+		assert.NoError(t, m.users.FindOne(context.Background(), bson.M{"email": "current@mail.com"}).Decode(&doc))
+		assert.Equal(t, "2022/03/01", doc["registeredAt"])
+		assert.NotContains(t, doc, "updatedAt")
+
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "bad@mail.com", "registeredAt": "24.02.2022"})
+		assert.NoError(t, err)
+		assert.Error(t, timestamps.Up(context.Background(), m.users))
+	})
+
+	t.Run("Indexes", func(t *testing.T) {
+		m, teardown := newTestMigrator(nil)
+		defer teardown()
+
+		// Previous versions created indexes covering deleted users at startup.
+		_, err := m.users.Indexes().CreateOne(context.Background(), mongodriver.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true),
+		})
+		assert.NoError(t, err)
+
+		assert.NoError(t, indexes.Up(context.Background(), m.users))
+		// Existing indexes with the same definitions are kept.
+		assert.NoError(t, indexes.Up(context.Background(), m.users))
+
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "first@mail.com", "username": "Username"})
+		assert.NoError(t, err)
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "second@mail.com", "username": "username"})
+		assert.True(t, mongodriver.IsDuplicateKeyError(err))
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "first@mail.com", "username": "other"})
+		assert.True(t, mongodriver.IsDuplicateKeyError(err))
+
+		// Deleted users don't hold their email and username.
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "first@mail.com", "username": "USERNAME", "deletedAt": time.Now()})
+		assert.NoError(t, err)
+
+		assert.NoError(t, indexes.Down(context.Background(), m.users))
+
+		_, err = m.users.InsertOne(context.Background(), bson.M{"email": "second@mail.com", "username": "username"})
+		assert.NoError(t, err)
+		assert.Error(t, indexes.Up(context.Background(), m.users))
+	})
+}
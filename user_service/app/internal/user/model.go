@@ -1,37 +1,86 @@
 package user
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
 )
 
 // User represents the user model.
 type User struct {
-	UUID         string `json:"uuid" bson:"_id,omitempty" example:"6205151b67f8792099abb78e"`
-	Email        string `json:"email" bson:"email,omitempty" example:"admin@example.com"`
-	Username     string `json:"username" bson:"username,omitempty" example:"admin"`
-	Password     string `json:"-" bson:"password,omitempty"`
-	Verified     bool   `json:"verified" bson:"verified,omitempty" example:"true"`
-	RegisteredAt string `json:"registeredAt" bson:"registeredAt,omitempty" example:"2022/02/24"`
+	UUID         string     `json:"uuid" bson:"_id,omitempty" example:"6205151b67f8792099abb78e"`
+	Email        string     `json:"email" bson:"email,omitempty" example:"admin@example.com"`
+	Username     string     `json:"username" bson:"username,omitempty" example:"admin"`
+	Password     string     `json:"-" bson:"password,omitempty"`
+	Verified     bool       `json:"verified" bson:"verified,omitempty" example:"true"`
+	Role         auth.Role  `json:"role" bson:"role,omitempty" example:"user"`
+	RegisteredAt time.Time  `json:"registeredAt" bson:"registeredAt,omitempty" example:"2022-02-24T10:15:00Z"`
+	UpdatedAt    time.Time  `json:"updatedAt" bson:"updatedAt,omitempty" example:"2022-03-01T08:00:00Z"`
+	LastLoginAt  *time.Time `json:"lastLoginAt,omitempty" bson:"lastLoginAt,omitempty" example:"2022-03-02T18:30:00Z"`
+
+	VerificationSentAt time.Time     `json:"-" bson:"verificationSentAt,omitempty"`
+	DeletedAt          *time.Time    `json:"-" bson:"deletedAt,omitempty"`
+	TwoFactor          *TwoFactor    `json:"-" bson:"twoFactor,omitempty"`
+	PendingEmail       *PendingEmail `json:"-" bson:"pendingEmail,omitempty"`
+	LockedAt           *time.Time    `json:"lockedAt,omitempty" bson:"lockedAt,omitempty"`
+
+	// Version is incremented on every change of the user along with UpdatedAt.
+	// Login isn't a change of the user, so it sets LastLoginAt only.
+	// Users created before versioning have no version stored, which reads as zero.
+	Version int64 `json:"version" bson:"version,omitempty" example:"3"`
 } // @name User
 
-// HashPassword will encrypt current user password.
+// PendingEmail is a requested email change waiting for confirmation
+// at the new address. ID is carried by the confirmation token, so only
+// the token sent on the latest request can be used.
+type PendingEmail struct {
+	Email     string    `json:"email" bson:"email"`
+	ID        string    `json:"id" bson:"id"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// TwoFactor holds TOTP two-factor authentication state of the user.
+// Secret is set on enrollment, but the second factor is required
+// on login only once Enabled. RecoveryCodes are SHA-256 hashes of
+// unused recovery codes. LastStep is the last accepted TOTP time step,
+// so the same code can't be used twice.
+type TwoFactor struct {
+	Secret        string   `json:"secret" bson:"secret"`
+	Enabled       bool     `json:"enabled" bson:"enabled"`
+	RecoveryCodes []string `json:"recoveryCodes" bson:"recoveryCodes"`
+	LastStep      int64    `json:"lastStep" bson:"lastStep"`
+}
+
+// HashPassword will encrypt current user password with given hasher.
 // Returns an error on failure.
-func (u *User) HashPassword() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword(
-		[]byte(u.Password), bcrypt.DefaultCost)
+func (u *User) HashPassword(hasher password.Hasher) error {
+	hashedPassword, err := hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 // ComparePassword compares hashed user password with given raw password.
-// If it doesn't match, returns false.
-func (u *User) ComparePassword(password string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+// If it doesn't match or the hash can't be decoded, returns false.
+func (u *User) ComparePassword(hasher password.Hasher, password string) bool {
+	ok, err := hasher.Compare(u.Password, password)
+	return err == nil && ok
+}
+
+// SecurityStamp returns a value derived from current password hash.
+// It changes every time the password changes, so tokens carrying
+// the stamp become invalid after password change.
+func (u *User) SecurityStamp() string {
+	sum := sha256.Sum256([]byte(u.Password))
+	return hex.EncodeToString(sum[:8])
 }
 
 // CreateUserDTO is used to create user.
@@ -44,6 +93,7 @@ type CreateUserDTO struct {
 
 // Validate will validates current struct fields.
 // Returns an error if something doesn't fit rules.
+// Password strength is checked by the service against password policy.
 func (u *CreateUserDTO) Validate() error {
 	return validation.ValidateStruct(
 		u,
@@ -58,24 +108,15 @@ func (u *CreateUserDTO) Validate() error {
 			validation.Length(3, 20),
 			validation.Required,
 		),
-		validation.Field(
-			&u.Password,
-			is.Alphanumeric,
-			validation.Length(6, 24),
-			validation.Required,
-		),
-		validation.Field(
-			&u.RepeatPassword,
-			is.Alphanumeric,
-			validation.Length(6, 24),
-			validation.Required,
-		),
+		validation.Field(&u.Password, validation.Required),
+		validation.Field(&u.RepeatPassword, validation.Required),
 	)
 }
 
 // UpdateUserDTO is used to update user record.
 type UpdateUserDTO struct {
 	UUID        string  `json:"-"`
+	Version     *int64  `json:"-"`
 	Email       *string `json:"email"`
 	Username    *string `json:"username"`
 	OldPassword *string `json:"oldPassword"`
@@ -91,10 +132,347 @@ func (u *UpdateUserDTO) Validate() error {
 		validation.Field(&u.Username,
 			validation.Length(3, 20),
 			is.Alphanumeric),
-		validation.Field(&u.OldPassword, is.Alphanumeric, validation.Required),
-		validation.Field(
-			&u.NewPassword,
-			validation.Length(6, 24),
-			is.Alphanumeric),
+		validation.Field(&u.OldPassword, validation.Required),
+	)
+}
+
+// LoginDTO is used to authenticate user.
+type LoginDTO struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+} // @name LoginInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (l *LoginDTO) Validate() error {
+	return validation.ValidateStruct(
+		l,
+		validation.Field(&l.Email, is.Email, validation.Required),
+		validation.Field(&l.Password, validation.Required),
+	)
+}
+
+// LoginResult is returned on successful password check. Tokens are issued
+// right away, unless the user has enabled two-factor authentication.
+// Then only ChallengeToken is returned, which should be sent
+// with a one-time code to complete login.
+type LoginResult struct {
+	*token.Pair
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty" example:"false"`
+	ChallengeToken    string `json:"challengeToken,omitempty"`
+} // @name LoginResult
+
+// Authentication is returned on successful password check when
+// the caller logs the user in by itself, so no tokens are issued.
+// User is set right away, unless the user has enabled two-factor
+// authentication. Then only ChallengeToken is set like in LoginResult.
+type Authentication struct {
+	User              *User
+	TwoFactorRequired bool
+	ChallengeToken    string
+}
+
+// TwoFactorLoginDTO is used to complete login with the second factor.
+// Code is either a one-time code or a recovery code.
+type TwoFactorLoginDTO struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code" example:"123456"`
+	IP             string `json:"-"`
+	UserAgent      string `json:"-"`
+} // @name TwoFactorLoginInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (l *TwoFactorLoginDTO) Validate() error {
+	return validation.ValidateStruct(
+		l,
+		validation.Field(&l.ChallengeToken, validation.Required),
+		validation.Field(&l.Code, validation.Required),
+	)
+}
+
+// RefreshTokenDTO is used to pass refresh token.
+type RefreshTokenDTO struct {
+	RefreshToken string `json:"refreshToken"`
+	IP           string `json:"-"`
+} // @name RefreshTokenInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (r *RefreshTokenDTO) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.RefreshToken, validation.Required),
+	)
+}
+
+// RefreshToken represents issued refresh token stored on the server side.
+// Tokens issued by rotating each other share the same family.
+type RefreshToken struct {
+	ID        string    `bson:"_id"`
+	Family    string    `bson:"family"`
+	UserUUID  string    `bson:"userId"`
+	Revoked   bool      `bson:"revoked"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// Session represents a login of the user on some device. Session lives
+// as long as refresh tokens issued by the login, so its id is the id
+// of refresh token family. Current is set for the session of the caller.
+type Session struct {
+	ID         string    `json:"id" bson:"_id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	UserUUID   string    `json:"-" bson:"userId"`
+	UserAgent  string    `json:"userAgent" bson:"userAgent" example:"Mozilla/5.0 (X11; Linux x86_64)"`
+	IP         string    `json:"ip" bson:"ip" example:"127.0.0.1"`
+	Revoked    bool      `json:"-" bson:"revoked"`
+	Current    bool      `json:"current" bson:"-"`
+	ExpiresAt  time.Time `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt" bson:"lastSeenAt"`
+} // @name Session
+
+// VerifyEmailDTO is used to confirm user email.
+type VerifyEmailDTO struct {
+	Token string `json:"token"`
+} // @name VerifyEmailInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (v *VerifyEmailDTO) Validate() error {
+	return validation.ValidateStruct(
+		v,
+		validation.Field(&v.Token, validation.Required),
+	)
+}
+
+// ConfirmEmailDTO is used to confirm email change.
+type ConfirmEmailDTO struct {
+	Token string `json:"token"`
+} // @name ConfirmEmailInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (c *ConfirmEmailDTO) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Token, validation.Required),
+	)
+}
+
+// ForgotPasswordDTO is used to request password reset.
+type ForgotPasswordDTO struct {
+	Email string `json:"email"`
+} // @name ForgotPasswordInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (f *ForgotPasswordDTO) Validate() error {
+	return validation.ValidateStruct(
+		f,
+		validation.Field(&f.Email, is.Email, validation.Required),
+	)
+}
+
+// ResetPasswordDTO is used to set a new password with password reset token.
+type ResetPasswordDTO struct {
+	Token          string `json:"token"`
+	NewPassword    string `json:"newPassword"`
+	RepeatPassword string `json:"repeatPassword"`
+} // @name ResetPasswordInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (r *ResetPasswordDTO) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Token, validation.Required),
+		validation.Field(&r.NewPassword, validation.Required),
+		validation.Field(&r.RepeatPassword, validation.Required),
+	)
+}
+
+// TwoFactorEnrollment is returned on two-factor enrollment. Secret should be
+// added to an authenticator app, usually by scanning URI as QR code.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	URI    string `json:"uri" example:"otpauth://totp/sueta:admin@example.com?secret=JBSWY3DPEHPK3PXP&issuer=sueta"`
+} // @name TwoFactorEnrollment
+
+// TwoFactorCodeDTO is used to confirm two-factor enrollment with the first code.
+type TwoFactorCodeDTO struct {
+	UUID string `json:"-"`
+	Code string `json:"code" example:"123456"`
+} // @name TwoFactorCodeInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (c *TwoFactorCodeDTO) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Code, validation.Required),
+	)
+}
+
+// RecoveryCodes are shown once when two-factor authentication is enabled.
+// Each code can be used once instead of a one-time code.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes" example:"k7q2m-x4dp9"`
+} // @name RecoveryCodes
+
+// DisableTwoFactorDTO is used to disable two-factor authentication.
+// Password and either a one-time code or a recovery code are required.
+type DisableTwoFactorDTO struct {
+	UUID     string `json:"-"`
+	Password string `json:"password"`
+	Code     string `json:"code" example:"123456"`
+} // @name DisableTwoFactorInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (d *DisableTwoFactorDTO) Validate() error {
+	return validation.ValidateStruct(
+		d,
+		validation.Field(&d.Password, validation.Required),
+		validation.Field(&d.Code, validation.Required),
+	)
+}
+
+// SetRoleDTO is used to change user role.
+type SetRoleDTO struct {
+	UUID string    `json:"-"`
+	Role auth.Role `json:"role" example:"moderator"`
+} // @name SetRoleInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (r *SetRoleDTO) Validate() error {
+	roles := make([]interface{}, 0, len(auth.Roles))
+	for _, role := range auth.Roles {
+		roles = append(roles, role)
+	}
+
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Role, validation.Required, validation.In(roles...)),
+	)
+}
+
+// ListUsersDTO is used to list users page by page.
+// Dates are in 2006-01-02 format. Sort is one of ListSortOptions.
+type ListUsersDTO struct {
+	Verified       string
+	RegisteredFrom string
+	RegisteredTo   string
+	Username       string
+	Sort           string
+	Limit          int
+	Cursor         string
+}
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (l *ListUsersDTO) Validate() error {
+	sortOptions := make([]interface{}, 0, len(ListSortOptions))
+	for _, option := range ListSortOptions {
+		sortOptions = append(sortOptions, option)
+	}
+
+	return validation.ValidateStruct(
+		l,
+		validation.Field(&l.Verified, validation.In("true", "false")),
+		validation.Field(&l.RegisteredFrom, validation.Date(listDateLayout)),
+		validation.Field(&l.RegisteredTo, validation.Date(listDateLayout)),
+		validation.Field(&l.Username, validation.Length(1, 20)),
+		validation.Field(&l.Sort, validation.In(sortOptions...)),
+		validation.Field(&l.Limit, validation.Min(0), validation.Max(MaxListLimit)),
+	)
+}
+
+// ListFilter describes which users storage should list and in which order.
+// Zero values mean no filtering. Users registered at RegisteredFrom or later
+// and before RegisteredTo are listed. Users are ordered by SortBy field and
+// then by uuid, so the order is stable. If After is set, only users
+// following the cursor are listed.
+type ListFilter struct {
+	Verified       *bool
+	RegisteredFrom time.Time
+	RegisteredTo   time.Time
+	UsernamePrefix string
+	SortBy         string
+	Descending     bool
+	After          *ListCursor
+	Limit          int
+}
+
+// ListCursor points to the last user of the previous page.
+type ListCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	UUID  string `json:"id"`
+}
+
+// UserPage is a page of listed users.
+type UserPage struct {
+	Users         []User `json:"users"`
+	NextCursor    string `json:"nextCursor,omitempty" example:"eyJzIjoicmVnaXN0ZXJlZEF0In0"`
+	TotalEstimate int64  `json:"totalEstimate" example:"42"`
+} // @name UserPage
+
+// BatchResult is the outcome of inserting one user of a batch.
+// UUID is set if the user is inserted, Err otherwise.
+type BatchResult struct {
+	UUID string
+	Err  error
+}
+
+// MaxAPIKeyDays is the longest lifetime of API key in days.
+const MaxAPIKeyDays = 365
+
+// APIKey represents personal API key the user uses for automation
+// instead of password. Only SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string       `json:"id" bson:"_id" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	UserUUID   string       `json:"-" bson:"userId"`
+	Name       string       `json:"name" bson:"name" example:"ci"`
+	Scopes     []auth.Scope `json:"scopes" bson:"scopes" example:"read"`
+	Hash       string       `json:"-" bson:"hash"`
+	Revoked    bool         `json:"-" bson:"revoked"`
+	ExpiresAt  time.Time    `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt  time.Time    `json:"createdAt" bson:"createdAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	LastUsedIP string       `json:"lastUsedIp,omitempty" bson:"lastUsedIp,omitempty" example:"127.0.0.1"`
+} // @name APIKey
+
+// CreatedAPIKey is returned once on API key creation.
+// Key can't be obtained later.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key" example:"sueta_9f86d081884c7d659a2feaa0c55ad015_5e884898da28047151d0e56f8dc62927"`
+} // @name CreatedAPIKey
+
+// CreateAPIKeyDTO is used to create API key.
+type CreateAPIKeyDTO struct {
+	UUID          string       `json:"-"`
+	Name          string       `json:"name" example:"ci"`
+	Scopes        []auth.Scope `json:"scopes" example:"read"`
+	ExpiresInDays int          `json:"expiresInDays" example:"90"`
+} // @name CreateAPIKeyInput
+
+// Validate will validates current struct fields.
+// Returns an error if something doesn't fit rules.
+func (c *CreateAPIKeyDTO) Validate() error {
+	scopes := make([]interface{}, 0, len(auth.Scopes))
+	for _, scope := range auth.Scopes {
+		scopes = append(scopes, scope)
+	}
+
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Name, validation.Required, validation.Length(1, 50)),
+		validation.Field(&c.Scopes, validation.Required, validation.Each(validation.In(scopes...))),
+		validation.Field(&c.ExpiresInDays, validation.Required, validation.Min(1), validation.Max(MaxAPIKeyDays)),
 	)
 }
@@ -6,6 +6,7 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -82,28 +83,14 @@ func TestCreateUserDTO_Validate(t *testing.T) {
 			},
 		},
 		{
-			name: "password length is less than 6",
+			name: "password policy is checked by service",
 			input: &user.CreateUserDTO{
 				Email:          "hello@mail.ru",
 				Username:       "username",
 				Password:       "qwe",
-				RepeatPassword: "qwerty",
-			},
-			expectedError: &validation.Errors{
-				"password": errors.New("the length must be between 6 and 24"),
-			},
-		},
-		{
-			name: "password length is greater than 24",
-			input: &user.CreateUserDTO{
-				Email:          "hello@mail.ru",
-				Username:       "username",
-				Password:       "qwertyqwertyqwertyqwertywwww",
-				RepeatPassword: "qwerty",
-			},
-			expectedError: &validation.Errors{
-				"password": errors.New("the length must be between 6 and 24"),
+				RepeatPassword: "qwe",
 			},
+			expectedError: nil,
 		},
 		{
 			name: "repeatPassword is not provided",
@@ -133,6 +120,8 @@ func TestCreateUserDTO_Validate(t *testing.T) {
 			err := tc.input.Validate()
 			if tc.expectedError != nil {
 				assert.EqualValues(t, err, *tc.expectedError)
+			} else {
+				assert.NoError(t, err)
 			}
 		})
 	}
@@ -184,24 +173,14 @@ func TestUpdateUserDTO_Validate(t *testing.T) {
 			expectedError: &validation.Errors{"username": errors.New("must contain English letters and digits only")},
 		},
 		{
-			name: "new password less than 6",
+			name: "new password with symbols",
 			input: in{
 				Email:       "test@mail.com",
 				Username:    "test",
 				OldPassword: "qwerty",
-				NewPassword: "qwe",
+				NewPassword: "qwerty_№1!",
 			},
-			expectedError: &validation.Errors{"newPassword": errors.New("the length must be between 6 and 24")},
-		},
-		{
-			name: "new password greater than 24",
-			input: in{
-				Email:       "test@mail.com",
-				Username:    "test",
-				OldPassword: "qwerty",
-				NewPassword: "qwertyqwertyqwertyqwertywwww",
-			},
-			expectedError: &validation.Errors{"newPassword": errors.New("the length must be between 6 and 24")},
+			expectedError: nil,
 		},
 		{
 			name: "empty string input",
@@ -267,10 +246,13 @@ func TestUser_HashPassword(t *testing.T) {
 		},
 	}
 
+	hasher := NewTestHasher(t, password.Bcrypt)
+
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.u.HashPassword()
+			err := tc.u.HashPassword(hasher)
 			assert.NoError(t, err)
+			assert.NotEqual(t, "qwerty", tc.u.Password)
 		})
 	}
 }
@@ -296,12 +278,15 @@ func TestUser_ComparePassword(t *testing.T) {
 		},
 	}
 
+	hasher := NewTestHasher(t, password.Argon2id)
+
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			password := tc.u.Password
-			err := tc.u.HashPassword()
+			raw := tc.u.Password
+			err := tc.u.HashPassword(hasher)
 			assert.NoError(t, err)
-			assert.True(t, tc.u.ComparePassword(password))
+			assert.True(t, tc.u.ComparePassword(hasher, raw))
+			assert.False(t, tc.u.ComparePassword(hasher, raw+"1"))
 		})
 	}
 }
@@ -0,0 +1,128 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// passwordResetMailTimeout limits sending of password reset email,
+// which is not bound to the request.
+const passwordResetMailTimeout = time.Minute
+
+// ForgotPassword sends password reset token to given email.
+// If there's no user with given email, nothing is sent but no error
+// is returned either, so the caller can't find out whether account exists.
+// The email is sent in background, so response time doesn't tell it either.
+// Returns an error only if storage query failed.
+func (s *service) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.storage.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) {
+			return nil
+		}
+		s.logger.Warnf("error occurred on finding user by email: %v", err)
+		return err
+	}
+
+	go s.sendPasswordReset(user)
+
+	return nil
+}
+
+// sendPasswordReset sends password reset token to the user.
+// Failures are only logged, since nobody waits for them.
+func (s *service) sendPasswordReset(user *User) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetMailTimeout)
+	defer cancel()
+
+	resetToken, err := s.tokens.NewToken(token.PasswordReset, user.UUID, user.Email, user.SecurityStamp())
+	if err != nil {
+		s.logger.Warnf("failed to create password reset token: %v", err)
+		return
+	}
+
+	err = s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nSomebody requested a password reset for your account. If it was you, "+
+				"send the token below with a new password to /api/auth/password/reset.\n"+
+				"The token expires in %s and can be used once.\n\nToken: %s\n",
+			user.Username, s.tokens.TTL(token.PasswordReset), resetToken,
+		),
+	})
+	if err != nil {
+		s.logger.Warnf("failed to send password reset email: %v", err)
+	}
+}
+
+// ResetPassword sets a new password of the user the reset token has been
+// issued for. Token becomes invalid once the password changes, so it can be
+// used only once. All refresh tokens of the user are revoked after reset.
+// Returns Invalid Token error if token cannot be used and validation error
+// if the new password violates password policy.
+func (s *service) ResetPassword(ctx context.Context, input *ResetPasswordDTO) error {
+	if input.NewPassword != input.RepeatPassword {
+		return apperror.ErrPasswordsDontMatch
+	}
+
+	claims, err := s.tokens.Parse(input.Token, token.PasswordReset)
+	if err != nil {
+		return apperror.ErrInvalidToken
+	}
+
+	user, err := s.storage.FindById(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoRows) || errors.Is(err, apperror.ErrInvalidUUID) {
+			return apperror.ErrInvalidToken
+		}
+		return err
+	}
+
+	if claims.Email != user.Email || claims.Stamp != user.SecurityStamp() {
+		return apperror.ErrInvalidToken
+	}
+
+	if err := s.checkPassword("newPassword", input.NewPassword, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	updated := &User{UUID: user.UUID, Password: input.NewPassword}
+	if err := updated.HashPassword(s.hasher); err != nil {
+		s.logger.Warnf("failed to hash password: %v", err)
+		return err
+	}
+
+	if err := s.storage.UpdatePartially(ctx, updated); err != nil {
+		s.logger.Warnf("failed to update the user: %v", err)
+		return err
+	}
+
+	// Reset is made by the user holding the token, not by a logged in user.
+	s.record(ctx, &audit.Event{
+		Action: audit.ActionPasswordReset,
+		Actor:  user.UUID,
+		Target: user.UUID,
+		Fields: []string{"password"},
+	})
+
+	return s.revokeSessions(ctx, user.UUID)
+}
+
+// checkPassword checks the password against password policy.
+// Violated rule is returned as validation error of given field,
+// so it's reported the same way as other invalid input.
+func (s *service) checkPassword(field, password, email, username string) error {
+	if err := s.policy.Check(password, email, username); err != nil {
+		return validation.Errors{field: err}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+)
+
+// Restore restores the deleted user with provided uuid.
+// Returns No Rows error if there's no deleted user with such id and
+// Email Taken or Username Taken error if another user has taken them.
+func (s *service) Restore(ctx context.Context, uuid string) error {
+	err := s.storage.Restore(ctx, uuid)
+	if err != nil {
+		if !errors.Is(err, apperror.ErrNoRows) && !errors.Is(err, apperror.ErrEmailTaken) && !errors.Is(err, apperror.ErrUsernameTaken) {
+			s.logger.Warnf("failed to restore the user: %v", err)
+		}
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserRestore, Target: uuid})
+
+	return nil
+}
+
+// Purge permanently deletes users which were deleted before given time.
+// Returns number of purged users.
+func (s *service) Purge(ctx context.Context, before time.Time) (int64, error) {
+	purged, err := s.storage.Purge(ctx, before)
+	if err != nil {
+		s.logger.Warnf("failed to purge deleted users: %v", err)
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// RunPurge purges users deleted more than retention ago every interval
+// until ctx is done. The first purge runs immediately.
+func RunPurge(ctx context.Context, service Service, retention, interval time.Duration, logger logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := service.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logger.Errorf("failed to purge deleted users: %v", err)
+		} else if purged > 0 {
+			logger.Infof("purged %d deleted users", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
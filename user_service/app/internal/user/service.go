@@ -6,8 +6,13 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
 )
 
 //go:generate mockgen -source=service.go -destination=mocks/service.go
@@ -17,27 +22,64 @@ type Service interface {
 	Create(ctx context.Context, user *CreateUserDTO) (string, error)
 	GetByEmailAndPassword(ctx context.Context, email, password string) (*User, error)
 	GetById(ctx context.Context, uuid string) (*User, error)
+	RecordLogin(ctx context.Context, uuid string) error
 	UpdatePartially(ctx context.Context, user *UpdateUserDTO) error
-	Delete(ctx context.Context, uuid string) error
+	Delete(ctx context.Context, uuid string, version *int64) error
+	Restore(ctx context.Context, uuid string) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	Verify(ctx context.Context, uuid, verificationToken string) error
+	ConfirmEmail(ctx context.Context, uuid, confirmationToken string) error
+	ResendVerification(ctx context.Context, uuid string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, input *ResetPasswordDTO) error
+	SetRole(ctx context.Context, input *SetRoleDTO) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	ForceVerify(ctx context.Context, uuid string) error
+	SetPassword(ctx context.Context, uuid, password string) error
+	Lock(ctx context.Context, uuid string) error
+	Unlock(ctx context.Context, uuid string) error
+	List(ctx context.Context, input *ListUsersDTO) (*UserPage, error)
+	EnrollTwoFactor(ctx context.Context, uuid string) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactor(ctx context.Context, input *TwoFactorCodeDTO) (*RecoveryCodes, error)
+	DisableTwoFactor(ctx context.Context, input *DisableTwoFactorDTO) error
+	VerifySecondFactor(ctx context.Context, user *User, code string) error
+	Replace(ctx context.Context, uuid string, replacement *User) error
 }
 
 type service struct {
-	logger  logger.Logger
-	storage Storage
+	logger       logger.Logger
+	storage      Storage
+	tokenStorage TokenStorage
+	sessions     SessionStorage
+	apiKeys      APIKeyStorage
+	auditor      audit.Recorder
+	mailer       mail.Mailer
+	tokens       *token.Manager
+	hasher       password.Hasher
+	policy       *password.Policy
 }
 
 // NewService returns a new instance that implements Service interface.
-func NewService(storage Storage, logger logger.Logger) Service {
+func NewService(storage Storage, tokenStorage TokenStorage, sessions SessionStorage, apiKeys APIKeyStorage, auditor audit.Recorder, mailer mail.Mailer, tokens *token.Manager, hasher password.Hasher, policy *password.Policy, logger logger.Logger) Service {
 	return &service{
-		logger:  logger,
-		storage: storage,
+		logger:       logger,
+		storage:      storage,
+		tokenStorage: tokenStorage,
+		sessions:     sessions,
+		apiKeys:      apiKeys,
+		auditor:      auditor,
+		mailer:       mailer,
+		tokens:       tokens,
+		hasher:       hasher,
+		policy:       policy,
 	}
 }
 
 // Create will check whether provided email already taken.
-// If it is, returns an error. Then it will hash user password
-// and try to insert the user. Returns inserted UUID or an error
-// on failure.
+// If it is, returns an error. Password is checked against password
+// policy and validation error is returned if it's too weak. Then it will hash user password
+// and try to insert the user. Verification email is sent to the
+// created user. Returns inserted UUID or an error on failure.
 func (s *service) Create(ctx context.Context, input *CreateUserDTO) (string, error) {
 	found, err := s.storage.FindByEmail(ctx, input.Email)
 	if err != nil {
@@ -50,15 +92,23 @@ func (s *service) Create(ctx context.Context, input *CreateUserDTO) (string, err
 		return "", apperror.ErrEmailTaken
 	}
 
+	if err := s.checkPassword("password", input.Password, input.Email, input.Username); err != nil {
+		return "", err
+	}
+
+	// Times are stored with millisecond precision.
+	now := time.Now().UTC().Truncate(time.Millisecond)
 	user := &User{
 		Email:        input.Email,
 		Username:     input.Username,
 		Password:     input.Password,
 		Verified:     false,
-		RegisteredAt: time.Now().UTC().Format("2006/01/02"),
+		Role:         auth.RoleUser,
+		RegisteredAt: now,
+		UpdatedAt:    now,
 	}
 
-	if err := user.HashPassword(); err != nil {
+	if err := user.HashPassword(s.hasher); err != nil {
 		s.logger.Warnf("could not encrypt user password: %v", err)
 		return "", err
 	}
@@ -67,6 +117,13 @@ func (s *service) Create(ctx context.Context, input *CreateUserDTO) (string, err
 	if err != nil {
 		return "", err
 	}
+	user.UUID = id
+
+	// User is able to request verification email again,
+	// so registration doesn't fail if email couldn't be sent.
+	if err := s.sendVerification(ctx, user); err != nil {
+		s.logger.Warnf("failed to send verification email: %v", err)
+	}
 
 	return id, nil
 }
@@ -74,7 +131,10 @@ func (s *service) Create(ctx context.Context, input *CreateUserDTO) (string, err
 // GetByEmailAndPassword will find a user with provided email.
 // If there's no such user with this email, returns No Rows error.
 // If password doesn't match, returns Wrong Password error.
-// Returns a user if everything is OK.
+// If the user is locked, returns Account Locked error, but only
+// for the right password, so lock doesn't reveal the account.
+// If the password hash is outdated, the password is hashed again
+// with current algorithm. Returns a user if everything is OK.
 func (s *service) GetByEmailAndPassword(ctx context.Context, email, password string) (*User, error) {
 	user, err := s.storage.FindByEmail(ctx, email)
 	if err != nil {
@@ -85,13 +145,43 @@ func (s *service) GetByEmailAndPassword(ctx context.Context, email, password str
 		return nil, err
 	}
 
-	if !user.ComparePassword(password) {
+	if !user.ComparePassword(s.hasher, password) {
 		return nil, apperror.ErrWrongPassword
 	}
 
+	if user.LockedAt != nil {
+		return nil, apperror.ErrAccountLocked
+	}
+
+	if s.hasher.NeedsRehash(user.Password) {
+		s.rehashPassword(ctx, user, password)
+	}
+
 	return user, nil
 }
 
+// rehashPassword hashes the password with current algorithm and saves it.
+// Failure is only logged, since the old hash is still valid.
+func (s *service) rehashPassword(ctx context.Context, user *User, password string) {
+	rehashed := &User{UUID: user.UUID, Password: password}
+	if err := rehashed.HashPassword(s.hasher); err != nil {
+		s.logger.Warnf("failed to rehash password: %v", err)
+		return
+	}
+
+	if err := s.storage.UpdatePartially(ctx, rehashed); err != nil {
+		s.logger.Warnf("failed to save rehashed password: %v", err)
+		return
+	}
+
+	user.Password = rehashed.Password
+}
+
+// RecordLogin remembers that the user with given uuid has logged in.
+func (s *service) RecordLogin(ctx context.Context, uuid string) error {
+	return s.storage.SetLastLogin(ctx, uuid, time.Now().UTC().Truncate(time.Millisecond))
+}
+
 // GetById will find a user with specified uuid in storage.
 // Returns an error on failure of there's no user with this uuid.
 func (s *service) GetById(ctx context.Context, uuid string) (*User, error) {
@@ -112,8 +202,13 @@ func (s *service) GetById(ctx context.Context, uuid string) (*User, error) {
 // UpdatePartially will find the user with provided uuid.
 // If there is no user with such id, returns No Rows error.
 // Then passwords will be compared. If it don't match, returns
-// Wrong Password error. Then updates the user. If something went wrong,
-// returns an error and nil if everything is OK.
+// Wrong Password error. Then updates the user. New email isn't applied
+// right away, but confirmation is sent to it instead. If something went
+// wrong, returns an error and nil if everything is OK. Names of changed
+// fields are recorded to audit log. If version is given and it isn't the
+// current one, returns Version Conflict error. The user is saved only if
+// it hasn't been changed since it was read, so concurrent updates can't
+// overwrite each other, Version Conflict error is returned otherwise.
 func (s *service) UpdatePartially(ctx context.Context, user *UpdateUserDTO) error {
 	u, err := s.GetById(ctx, user.UUID)
 	if err != nil {
@@ -123,50 +218,116 @@ func (s *service) UpdatePartially(ctx context.Context, user *UpdateUserDTO) erro
 		return err
 	}
 
-	if !u.ComparePassword(*user.OldPassword) {
+	if user.Version != nil && *user.Version != u.Version {
+		return apperror.ErrVersionConflict
+	}
+
+	if !u.ComparePassword(s.hasher, *user.OldPassword) {
 		return apperror.ErrWrongPassword
 	}
 
+	var changed []string
+
+	// Email is changed only once the new address is confirmed.
+	var newEmail string
+	if user.Email != nil && *user.Email != u.Email {
+		newEmail = *user.Email
+	}
+
+	if user.Username != nil {
+		if *user.Username != u.Username {
+			changed = append(changed, "username")
+		}
+		u.Username = *user.Username
+	}
+
 	if user.NewPassword != nil {
+		changed = append(changed, "password")
+
+		if err := s.checkPassword("newPassword", *user.NewPassword, u.Email, u.Username); err != nil {
+			return err
+		}
+
 		u.Password = *user.NewPassword
-		err = u.HashPassword()
+		err = u.HashPassword(s.hasher)
 		if err != nil {
 			s.logger.Warnf("failed ot hash password: %v", err)
 			return err
 		}
 	}
 
-	if user.Email != nil {
-		u.Email = *user.Email
-	}
-
-	if user.Username != nil {
-		u.Username = *user.Username
-	}
-
 	if err := user.Validate(); err != nil {
 		return err
 	}
 
-	err = s.storage.UpdatePartially(ctx, u)
+	err = s.storage.CompareAndUpdate(ctx, u)
 	if err != nil {
-		s.logger.Warnf("failed to update the user: %v", err)
+		if !errors.Is(err, apperror.ErrVersionConflict) {
+			s.logger.Warnf("failed to update the user: %v", err)
+		}
 		return err
 	}
 
+	if len(changed) > 0 {
+		s.record(ctx, &audit.Event{Action: audit.ActionUserUpdate, Target: u.UUID, Fields: changed})
+	}
+
+	if newEmail != "" {
+		if err := s.requestEmailChange(ctx, u, newEmail); err != nil {
+			s.logger.Warnf("failed to request email change: %v", err)
+			return err
+		}
+	}
+
+	if user.NewPassword != nil {
+		return s.revokeSessions(ctx, u.UUID)
+	}
+
 	return nil
 }
 
 // Delete tries to delete the user with provided uuid.
-// Returns an error on failure or nil if query has been executed.
-func (s *service) Delete(ctx context.Context, uuid string) error {
-	err := s.storage.Delete(ctx, uuid)
+// The user is only marked as deleted and can be restored until purged.
+// Sessions and refresh tokens of the user are revoked. If version is given,
+// the user is deleted only if it's the current one, Version Conflict error
+// is returned otherwise. Returns an error on failure or nil if query
+// has been executed.
+func (s *service) Delete(ctx context.Context, uuid string, version *int64) error {
+	err := s.storage.Delete(ctx, uuid, version)
 	if err != nil {
-		if !errors.Is(err, apperror.ErrNoRows) {
+		if !errors.Is(err, apperror.ErrNoRows) && !errors.Is(err, apperror.ErrVersionConflict) {
 			s.logger.Warnf("failed to delete the user: %v", err)
 		}
 		return err
 	}
 
+	s.record(ctx, &audit.Event{Action: audit.ActionUserDelete, Target: uuid})
+
+	return s.revokeSessions(ctx, uuid)
+}
+
+// SetRole changes role of the user with provided uuid.
+// Returns No Rows error if there's no user with such id.
+func (s *service) SetRole(ctx context.Context, input *SetRoleDTO) error {
+	u, err := s.GetById(ctx, input.UUID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.UpdatePartially(ctx, &User{UUID: u.UUID, Role: input.Role}); err != nil {
+		s.logger.Warnf("failed to update user role: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserRole, Target: u.UUID, Fields: []string{"role"}})
+
 	return nil
 }
+
+// record appends given event to audit log. The change has been made
+// already, so failure is only logged and doesn't fail the request.
+func (s *service) record(ctx context.Context, event *audit.Event) {
+	if err := s.auditor.Record(ctx, event); err != nil {
+		s.logger.Errorf("failed to record %s of user %s to audit log: %v", event.Action, event.Target, err)
+	}
+}
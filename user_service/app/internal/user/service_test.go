@@ -2,19 +2,81 @@ package user_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditmemory "github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
 	"github.com/juicyluv/sueta/user_service/app/internal/user"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/pkg/lockout"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/juicyluv/sueta/user_service/app/pkg/totp"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
+func NewTestTokenManager() *token.Manager {
+	return token.NewManager("sueta-test", testAccessSecret, testRefreshSecret, map[token.Type]time.Duration{
+		token.Access:        time.Minute,
+		token.Refresh:       time.Hour,
+		token.Verification:  time.Hour,
+		token.PasswordReset: time.Hour,
+		token.TwoFactor:     time.Minute,
+		token.EmailChange:   time.Hour,
+		token.ContentExport: time.Minute,
+	})
+}
+
+func NewTestMailer(t *testing.T) *mail.Outbox {
+	outbox, err := mail.NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot create mail outbox: %v", err)
+	}
+	return outbox
+}
+
+// NewTestHasher returns a password hasher with the lowest cost,
+// so tests don't spend time on hashing.
+func NewTestHasher(t *testing.T, algorithm string) password.Hasher {
+	hasher, err := password.New(password.Params{
+		Algorithm:  algorithm,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	if err != nil {
+		t.Fatalf("cannot create password hasher: %v", err)
+	}
+	return hasher
+}
+
+// NewTestAuditor returns an audit log recorder keeping events in memory.
+func NewTestAuditor() audit.Recorder {
+	return audit.NewService(auditmemory.NewStorage(), logger.GetLogger())
+}
+
+// NewTestPolicy returns a password policy without blocklist.
+func NewTestPolicy(t *testing.T) *password.Policy {
+	policy, err := password.NewPolicy(6, 24, "")
+	if err != nil {
+		t.Fatalf("cannot create password policy: %v", err)
+	}
+	return policy
+}
+
 func NewTestService(t *testing.T) (user.Service, func() error) {
 	l := logger.GetLogger()
 
 	userStorage, teardown := NewTestStorage(t)
-	service := user.NewService(userStorage, l)
+	service := user.NewService(userStorage, memory.NewTokenStorage(), memory.NewSessionStorage(), memory.NewAPIKeyStorage(), NewTestAuditor(), NewTestMailer(t), NewTestTokenManager(), NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), l)
 	return service, teardown
 }
 
@@ -122,7 +184,7 @@ func TestUserService_GetByEmailAndPassword(t *testing.T) {
 				assert.Equal(t, id1, u.UUID)
 				assert.Equal(t, created.Email, u.Email)
 				assert.Equal(t, created.Username, u.Username)
-				assert.True(t, u.ComparePassword(created.Password))
+				assert.True(t, u.ComparePassword(NewTestHasher(t, password.Bcrypt), created.Password))
 			}
 		})
 	}
@@ -130,6 +192,53 @@ func TestUserService_GetByEmailAndPassword(t *testing.T) {
 	assert.NoError(t, teardown())
 }
 
+func TestUserService_RehashPassword(t *testing.T) {
+	l := logger.GetLogger()
+
+	userStorage, teardown := NewTestStorage(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	bcryptService := user.NewService(userStorage, memory.NewTokenStorage(), memory.NewSessionStorage(), memory.NewAPIKeyStorage(), NewTestAuditor(), NewTestMailer(t), NewTestTokenManager(), NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), l)
+	argon2Service := user.NewService(userStorage, memory.NewTokenStorage(), memory.NewSessionStorage(), memory.NewAPIKeyStorage(), NewTestAuditor(), NewTestMailer(t), NewTestTokenManager(), NewTestHasher(t, password.Argon2id), NewTestPolicy(t), l)
+
+	created := &user.CreateUserDTO{
+		Email:    "test@mail.com",
+		Username: "test",
+		Password: "qwerty",
+	}
+
+	id, err := bcryptService.Create(context.Background(), created)
+	assert.NoError(t, err)
+
+	stored, err := userStorage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$2a$"))
+
+	// Wrong password doesn't change the hash.
+	_, err = argon2Service.GetByEmailAndPassword(context.Background(), created.Email, "wrong")
+	assert.ErrorIs(t, err, apperror.ErrWrongPassword)
+
+	unchanged, err := userStorage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.Password, unchanged.Password)
+
+	_, err = argon2Service.GetByEmailAndPassword(context.Background(), created.Email, created.Password)
+	assert.NoError(t, err)
+
+	rehashed, err := userStorage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rehashed.Password, "$argon2id$"))
+
+	// Both services still accept the password.
+	_, err = argon2Service.GetByEmailAndPassword(context.Background(), created.Email, created.Password)
+	assert.NoError(t, err)
+
+	_, err = bcryptService.GetByEmailAndPassword(context.Background(), created.Email, created.Password)
+	assert.NoError(t, err)
+}
+
 func TestUserService_GetById(t *testing.T) {
 	service, teardown := NewTestService(t)
 
@@ -155,7 +264,7 @@ func TestUserService_GetById(t *testing.T) {
 		},
 		{
 			name:          "no user with given id",
-			uuid:          id[:len(id)-1] + "7",
+			uuid:          "6202fbbf5ff5d5a12a72a195",
 			expectedError: apperror.ErrNoRows,
 		},
 		{
@@ -255,13 +364,90 @@ func TestUserService_UpdatePartially(t *testing.T) {
 
 	u, err := service.GetById(context.Background(), id)
 	assert.NoError(t, err)
-	assert.Equal(t, u.Email, "newemail@gmail.com")
+	assert.Equal(t, u.Email, "test@mail.com", "new email is applied once confirmed")
+	if assert.NotNil(t, u.PendingEmail) {
+		assert.Equal(t, "newemail@gmail.com", u.PendingEmail.Email)
+	}
 	assert.Equal(t, u.Username, "newusername")
-	assert.True(t, u.ComparePassword("qwerty123123"))
+	assert.True(t, u.ComparePassword(NewTestHasher(t, password.Bcrypt), "qwerty123123"))
 
 	assert.NoError(t, teardown())
 }
 
+func TestUserService_ConfirmEmailExpired(t *testing.T) {
+	userStorage, teardown := NewTestStorage(t)
+	defer func() {
+		assert.NoError(t, teardown())
+	}()
+
+	tokens := token.NewManager("sueta-test", testAccessSecret, testRefreshSecret, map[token.Type]time.Duration{
+		token.Verification: time.Hour,
+		token.EmailChange:  -time.Minute,
+	})
+	outbox := NewTestMailer(t)
+	service := user.NewService(userStorage, memory.NewTokenStorage(), memory.NewSessionStorage(), memory.NewAPIKeyStorage(), NewTestAuditor(), outbox, tokens, NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), logger.GetLogger())
+
+	id, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:    "test@mail.com",
+		Username: "test",
+		Password: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	newEmail, oldPassword := "new@mail.com", "qwerty"
+	assert.NoError(t, service.UpdatePartially(context.Background(), &user.UpdateUserDTO{UUID: id, Email: &newEmail, OldPassword: &oldPassword}))
+
+	messages, err := outbox.Messages()
+	assert.NoError(t, err)
+
+	var confirmationToken string
+	for _, m := range messages {
+		if m.To == newEmail {
+			confirmationToken = strings.TrimSpace(m.Body[strings.LastIndex(m.Body, "Token: ")+len("Token: "):])
+		}
+	}
+	assert.NotEmpty(t, confirmationToken)
+
+	err = service.ConfirmEmail(context.Background(), id, confirmationToken)
+	assert.ErrorIs(t, err, apperror.ErrInvalidToken)
+
+	u, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, "test@mail.com", u.Email)
+}
+
+func TestUserService_UpdatePartiallyVersion(t *testing.T) {
+	service, teardown := NewTestService(t)
+	defer func() { assert.NoError(t, teardown()) }()
+
+	id, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:    "test@mail.com",
+		Username: "test",
+		Password: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	u, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+
+	oldPassword, username := "qwerty", "updated"
+	stale := u.Version - 1
+	err = service.UpdatePartially(context.Background(), &user.UpdateUserDTO{UUID: id, Username: &username, OldPassword: &oldPassword, Version: &stale})
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	assert.NoError(t, service.UpdatePartially(context.Background(), &user.UpdateUserDTO{UUID: id, Username: &username, OldPassword: &oldPassword, Version: &u.Version}))
+
+	updated, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, username, updated.Username)
+	assert.Equal(t, u.Version+1, updated.Version)
+
+	err = service.Delete(context.Background(), id, &u.Version)
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	assert.NoError(t, service.Delete(context.Background(), id, &updated.Version))
+}
+
 func TestUserService_Delete(t *testing.T) {
 	service, teardown := NewTestService(t)
 
@@ -292,7 +478,7 @@ func TestUserService_Delete(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, u2)
 
-	err = service.Delete(context.Background(), u1.UUID)
+	err = service.Delete(context.Background(), u1.UUID, nil)
 	assert.NoError(t, err)
 
 	deleted1, err := service.GetById(context.Background(), u1.UUID)
@@ -304,7 +490,7 @@ func TestUserService_Delete(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, notDeleted)
 
-	err = service.Delete(context.Background(), u2.UUID)
+	err = service.Delete(context.Background(), u2.UUID, nil)
 	assert.NoError(t, err)
 
 	deleted2, err := service.GetById(context.Background(), u2.UUID)
@@ -314,3 +500,216 @@ func TestUserService_Delete(t *testing.T) {
 
 	assert.NoError(t, teardown())
 }
+
+func TestUserService_Lock(t *testing.T) {
+	userStorage, teardown := NewTestStorage(t)
+	defer func() { assert.NoError(t, teardown()) }()
+
+	l := logger.GetLogger()
+	tokens := NewTestTokenManager()
+	tokenStorage := memory.NewTokenStorage()
+	sessionStorage := memory.NewSessionStorage()
+	apiKeyStorage := memory.NewAPIKeyStorage()
+	auditor := NewTestAuditor()
+	service := user.NewService(userStorage, tokenStorage, sessionStorage, apiKeyStorage, auditor, NewTestMailer(t), tokens, NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), l)
+	attempts := lockout.NewMemoryStore()
+	authService := user.NewAuthService(service, tokenStorage, sessionStorage, apiKeyStorage, auditor, tokens,
+		lockout.NewLimiter(attempts, "login:email:", testLockoutPolicy),
+		lockout.NewLimiter(attempts, "login:ip:", testLockoutPolicy), l)
+
+	id, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	credentials := &user.LoginDTO{Email: "test@mail.com", Password: "qwerty"}
+	result, err := authService.Login(context.Background(), credentials)
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.Lock(context.Background(), id))
+
+	locked, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, locked.LockedAt) {
+		t.FailNow()
+	}
+
+	_, err = authService.Login(context.Background(), credentials)
+	assert.ErrorIs(t, err, apperror.ErrAccountLocked)
+
+	// Lock isn't revealed without the right password.
+	_, err = authService.Login(context.Background(), &user.LoginDTO{Email: "test@mail.com", Password: "wrong"})
+	assert.ErrorIs(t, err, apperror.ErrWrongPassword)
+
+	_, err = authService.Refresh(context.Background(), &user.RefreshTokenDTO{RefreshToken: result.RefreshToken})
+	assert.ErrorIs(t, err, apperror.ErrInvalidToken, "sessions are revoked")
+
+	// Locking again keeps the original lock time.
+	assert.NoError(t, service.Lock(context.Background(), id))
+	again, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, again.LockedAt) {
+		assert.True(t, locked.LockedAt.Equal(*again.LockedAt))
+	}
+
+	assert.NoError(t, service.Unlock(context.Background(), id))
+	assert.NoError(t, service.Unlock(context.Background(), id))
+
+	_, err = authService.Login(context.Background(), credentials)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, service.Lock(context.Background(), "62056f8cf21b83383a5ae7fa"), apperror.ErrNoRows)
+	assert.ErrorIs(t, service.Unlock(context.Background(), "invalid"), apperror.ErrInvalidUUID)
+}
+
+// barrierStore holds results of attempts checks until all of expected
+// ones are made, so concurrent logins pass the check together.
+type barrierStore struct {
+	lockout.Store
+	arrived sync.WaitGroup
+}
+
+func (s *barrierStore) Get(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	count, last, err := s.Store.Get(ctx, key, since)
+	s.arrived.Done()
+	s.arrived.Wait()
+	return count, last, err
+}
+
+func TestAuthService_ConcurrentLoginLockout(t *testing.T) {
+	userStorage, teardown := NewTestStorage(t)
+	defer func() { assert.NoError(t, teardown()) }()
+
+	l := logger.GetLogger()
+	tokens := NewTestTokenManager()
+	tokenStorage := memory.NewTokenStorage()
+	sessionStorage := memory.NewSessionStorage()
+	apiKeyStorage := memory.NewAPIKeyStorage()
+	auditor := NewTestAuditor()
+	service := user.NewService(userStorage, tokenStorage, sessionStorage, apiKeyStorage, auditor, NewTestMailer(t), tokens, NewTestHasher(t, password.Bcrypt), NewTestPolicy(t), l)
+
+	const attempts = 20
+	store := &barrierStore{Store: lockout.NewMemoryStore()}
+	store.arrived.Add(attempts)
+	authService := user.NewAuthService(service, tokenStorage, sessionStorage, apiKeyStorage, auditor, tokens,
+		lockout.NewLimiter(store, "login:email:", testLockoutPolicy),
+		lockout.NewLimiter(store, "login:ip:", testLockoutPolicy), l)
+
+	_, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = authService.Login(context.Background(), &user.LoginDTO{Email: "test@mail.com", Password: fmt.Sprintf("wrong-%d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	checked := 0
+	for _, err := range errs {
+		if errors.Is(err, apperror.ErrWrongPassword) {
+			checked++
+		} else {
+			assert.ErrorIs(t, err, apperror.ErrLoginLocked)
+		}
+	}
+	assert.Equal(t, testLockoutPolicy.MaxAttempts, checked, "passwords are checked up to the limit")
+}
+
+func TestUserService_AdminActions(t *testing.T) {
+	service, teardown := NewTestService(t)
+	defer func() { assert.NoError(t, teardown()) }()
+
+	id, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	found, err := service.GetByEmail(context.Background(), "test@mail.com")
+	assert.NoError(t, err)
+	assert.Equal(t, id, found.UUID)
+	assert.False(t, found.Verified)
+
+	_, err = service.GetByEmail(context.Background(), "missing@mail.com")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	assert.NoError(t, service.ForceVerify(context.Background(), id))
+	assert.NoError(t, service.ForceVerify(context.Background(), id))
+
+	found, err = service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.True(t, found.Verified)
+
+	err = service.SetPassword(context.Background(), id, "test")
+	assert.Error(t, err, "password violating policy is rejected")
+
+	assert.NoError(t, service.SetPassword(context.Background(), id, "newpassword"))
+
+	_, err = service.GetByEmailAndPassword(context.Background(), "test@mail.com", "qwerty")
+	assert.ErrorIs(t, err, apperror.ErrWrongPassword)
+	_, err = service.GetByEmailAndPassword(context.Background(), "test@mail.com", "newpassword")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, service.ForceVerify(context.Background(), "62056f8cf21b83383a5ae7fa"), apperror.ErrNoRows)
+	assert.ErrorIs(t, service.SetPassword(context.Background(), "62056f8cf21b83383a5ae7fa", "newpassword"), apperror.ErrNoRows)
+}
+
+func TestUserService_VerifySecondFactorOnce(t *testing.T) {
+	service, teardown := NewTestService(t)
+	defer func() { assert.NoError(t, teardown()) }()
+
+	id, err := service.Create(context.Background(), &user.CreateUserDTO{
+		Email:          "test@mail.com",
+		Username:       "test",
+		Password:       "qwerty",
+		RepeatPassword: "qwerty",
+	})
+	assert.NoError(t, err)
+
+	enrollment, err := service.EnrollTwoFactor(context.Background(), id)
+	assert.NoError(t, err)
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now())-1)
+	assert.NoError(t, err)
+	recovery, err := service.ConfirmTwoFactor(context.Background(), &user.TwoFactorCodeDTO{UUID: id, Code: code})
+	assert.NoError(t, err)
+
+	// Both requests have read the user before either accepted the code.
+	first, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	second, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+
+	code, err = totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	assert.NoError(t, service.VerifySecondFactor(context.Background(), first, code))
+	assert.ErrorIs(t, service.VerifySecondFactor(context.Background(), second, code), apperror.ErrInvalidCode)
+
+	first, err = service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	second, err = service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.VerifySecondFactor(context.Background(), first, recovery.RecoveryCodes[0]))
+	assert.ErrorIs(t, service.VerifySecondFactor(context.Background(), second, recovery.RecoveryCodes[0]), apperror.ErrInvalidCode)
+	assert.Len(t, second.TwoFactor.RecoveryCodes, len(recovery.RecoveryCodes), "rejected code keeps the state")
+
+	stored, err := service.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Len(t, stored.TwoFactor.RecoveryCodes, len(recovery.RecoveryCodes)-1)
+}
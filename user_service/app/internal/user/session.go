@@ -0,0 +1,80 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+)
+
+// ListSessions returns active sessions of the user with provided uuid,
+// newest first. Session with id current is marked as current.
+func (s *authService) ListSessions(ctx context.Context, uuid, current string) ([]Session, error) {
+	user, err := s.userService.GetById(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.sessions.ListByUser(ctx, user.UUID)
+	if err != nil {
+		s.logger.Warnf("failed to list sessions: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sessions := make([]Session, 0, len(stored))
+	for _, session := range stored {
+		if !now.Before(session.ExpiresAt) {
+			continue
+		}
+		session.Current = session.ID == current
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session with given id of the user with provided
+// uuid by revoking refresh tokens of the session. Access tokens already
+// issued stay valid until they expire.
+// Returns No Rows error if the user has no active session with given id.
+func (s *authService) RevokeSession(ctx context.Context, uuid, id string) error {
+	if err := s.sessions.Revoke(ctx, uuid, id); err != nil {
+		if !errors.Is(err, apperror.ErrNoRows) {
+			s.logger.Warnf("failed to revoke session: %v", err)
+		}
+		return err
+	}
+
+	if err := s.tokenStorage.RevokeFamily(ctx, id); err != nil {
+		s.logger.Warnf("failed to revoke token family: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionSessionRevoke, Target: uuid})
+	return nil
+}
+
+// revokeSessions revokes all sessions, refresh tokens and API keys
+// of the user with given uuid, so the user is logged out on every device
+// and nothing issued before keeps access to the account.
+func (s *service) revokeSessions(ctx context.Context, uuid string) error {
+	if err := s.tokenStorage.RevokeAllByUser(ctx, uuid); err != nil {
+		s.logger.Warnf("failed to revoke user refresh tokens: %v", err)
+		return err
+	}
+
+	if err := s.sessions.RevokeAllByUser(ctx, uuid); err != nil {
+		s.logger.Warnf("failed to revoke user sessions: %v", err)
+		return err
+	}
+
+	if err := s.apiKeys.RevokeAllByUser(ctx, uuid); err != nil {
+		s.logger.Warnf("failed to revoke user API keys: %v", err)
+		return err
+	}
+
+	return nil
+}
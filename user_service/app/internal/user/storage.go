@@ -1,12 +1,101 @@
 package user
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Storage descibes a user storage functionality.
+// Deleted users are kept until purged, but storage
+// doesn't find, update or list them.
 type Storage interface {
 	Create(ctx context.Context, user *User) (string, error)
+	// CreateBatch inserts given users at once. Result of every user
+	// is returned at its index, failed user doesn't stop the others.
+	// An error is returned only if the whole batch failed.
+	CreateBatch(ctx context.Context, users []*User) ([]BatchResult, error)
 	FindByEmail(ctx context.Context, email string) (*User, error)
 	FindById(ctx context.Context, uuid string) (*User, error)
+	// UpdatePartially sets non-empty fields of the user. Version and update
+	// time of the user are ignored, the stored version is incremented and
+	// update time is set to the current time, as every other change does.
 	UpdatePartially(ctx context.Context, user *User) error
-	Delete(ctx context.Context, uuid string) error
+	// CompareAndUpdate updates the user like UpdatePartially, but only if
+	// the stored version is still the version of given user. Returns Version
+	// Conflict error otherwise. Version and update time of given user are
+	// updated on success.
+	CompareAndUpdate(ctx context.Context, user *User) error
+	// Replace writes username, password, verification and role of given
+	// user as they are, unlike CompareAndUpdate which skips empty values,
+	// if the stored version is still the version of given user. Returns
+	// Version Conflict error otherwise. Version and update time of given
+	// user are updated on success.
+	Replace(ctx context.Context, user *User) error
+	// SetTwoFactor replaces two-factor state of the user. Nil removes it.
+	SetTwoFactor(ctx context.Context, uuid string, twoFactor *TwoFactor) error
+	// CompareAndSetTwoFactor replaces two-factor state of the user like
+	// SetTwoFactor, but only if the stored version is still given version.
+	// Returns Version Conflict error otherwise.
+	CompareAndSetTwoFactor(ctx context.Context, uuid string, version int64, twoFactor *TwoFactor) error
+	// SetPendingEmail replaces pending email change of the user. Nil removes it.
+	SetPendingEmail(ctx context.Context, uuid string, pending *PendingEmail) error
+	// SetLocked records when the user has been locked. Nil unlocks the user.
+	SetLocked(ctx context.Context, uuid string, lockedAt *time.Time) error
+	// SetLastLogin records when the user has logged in. Neither
+	// version nor update time of the user are changed.
+	SetLastLogin(ctx context.Context, uuid string, at time.Time) error
+	// Delete marks the user as deleted. If version isn't nil, the user is
+	// deleted only if it's the stored version, otherwise Version Conflict
+	// error is returned.
+	Delete(ctx context.Context, uuid string, version *int64) error
+	// Restore removes deletion mark of the deleted user. Deleted users
+	// don't hold their email and username, so Email Taken or Username
+	// Taken error is returned if another user has taken them since.
+	Restore(ctx context.Context, uuid string) error
+	// Purge permanently deletes users marked as deleted before given time.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	// List returns users matching given filter in requested order.
+	List(ctx context.Context, filter *ListFilter) ([]User, error)
+	// Count returns estimated number of users matching given filter.
+	// Cursor and limit of the filter are ignored.
+	Count(ctx context.Context, filter *ListFilter) (int64, error)
+}
+
+// TokenStorage describes a refresh token storage functionality.
+type TokenStorage interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	FindById(ctx context.Context, id string) (*RefreshToken, error)
+	Revoke(ctx context.Context, id string) error
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeAllByUser(ctx context.Context, userUUID string) error
+}
+
+// APIKeyStorage describes an API key storage functionality.
+type APIKeyStorage interface {
+	Create(ctx context.Context, key *APIKey) error
+	FindById(ctx context.Context, id string) (*APIKey, error)
+	// ListByUser returns not revoked keys of the user, newest first.
+	ListByUser(ctx context.Context, userUUID string) ([]APIKey, error)
+	// Revoke marks the key of given user as revoked.
+	Revoke(ctx context.Context, userUUID, id string) error
+	// RevokeAllByUser marks all keys of the user as revoked.
+	RevokeAllByUser(ctx context.Context, userUUID string) error
+	// Touch records when and from which IP the key was used.
+	Touch(ctx context.Context, id string, at time.Time, ip string) error
+}
+
+// SessionStorage describes a login session storage functionality.
+// Sessions are found and revoked by id without scanning other sessions.
+type SessionStorage interface {
+	Create(ctx context.Context, session *Session) error
+	FindById(ctx context.Context, id string) (*Session, error)
+	// ListByUser returns not revoked sessions of the user, newest first.
+	ListByUser(ctx context.Context, userUUID string) ([]Session, error)
+	// Touch records when and from which IP the session was used
+	// and when it expires.
+	Touch(ctx context.Context, id string, at time.Time, ip string, expiresAt time.Time) error
+	// Revoke marks the session of given user as revoked.
+	Revoke(ctx context.Context, userUUID, id string) error
+	// RevokeAllByUser marks all sessions of the user as revoked.
+	RevokeAllByUser(ctx context.Context, userUUID string) error
 }
@@ -2,47 +2,26 @@ package user_test
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
 	"testing"
-	"time"
 
-	"github.com/juicyluv/sueta/user_service/app/config"
 	"github.com/juicyluv/sueta/user_service/app/internal/user"
 	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
-	"github.com/juicyluv/sueta/user_service/app/internal/user/db"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
 	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
-	"github.com/juicyluv/sueta/user_service/app/pkg/mongo"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
 	"github.com/stretchr/testify/assert"
 )
 
+// NewTestStorage returns in-memory user storage, so tests don't need a database.
+// Mongo storage is checked with the same contract in db package.
 func NewTestStorage(t *testing.T) (user.Storage, func() error) {
 	logger.Init()
 
-	wd, _ := os.Getwd()
-	for !strings.HasSuffix(wd, "user_service") {
-		wd = filepath.Dir(wd)
-	}
-
-	cfg := config.Get(fmt.Sprintf("%s/app/config/test.yml", wd), fmt.Sprintf("%s/.env", wd))
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	mongoClient, err := mongo.NewMongoClient(ctx, cfg.DB.Database, cfg.DB.URL)
-	if err != nil {
-		t.Fatalf("cannot connect to mongodb: %v", err)
-	}
-
-	userStorage := db.NewStorage(mongoClient, cfg.DB.Collection)
-
 	teardown := func() error {
-		return mongoClient.Collection(cfg.DB.Collection).Drop(context.Background())
+		return nil
 	}
 
-	return userStorage, teardown
+	return memory.NewStorage(), teardown
 }
 
 func TestUserStorage_Create(t *testing.T) {
@@ -70,10 +49,10 @@ func TestUserStorage_Create(t *testing.T) {
 	for _, tc := range testCases {
 		rawPassword := tc.Password
 
-		err := tc.HashPassword()
+		err := tc.HashPassword(NewTestHasher(t, password.Bcrypt))
 		assert.NoError(t, err)
 
-		assert.True(t, tc.ComparePassword(rawPassword))
+		assert.True(t, tc.ComparePassword(NewTestHasher(t, password.Bcrypt), rawPassword))
 
 		id, err := storage.Create(context.Background(), &tc)
 		assert.NoError(t, err)
@@ -277,13 +256,13 @@ func TestUserStorage_UpdatePartially(t *testing.T) {
 			expectedError: nil,
 			beforeTest: func() {
 				u.Password = "juicyluv"
-				u.HashPassword()
+				u.HashPassword(NewTestHasher(t, password.Bcrypt))
 			},
 			afterTest: func(t *testing.T) {
 				found, err := storage.FindByEmail(context.Background(), u.Email)
 				assert.NoError(t, err)
 				assert.Equal(t, found.UUID, u.UUID)
-				assert.True(t, u.ComparePassword("juicyluv"))
+				assert.True(t, u.ComparePassword(NewTestHasher(t, password.Bcrypt), "juicyluv"))
 			},
 		},
 	}
@@ -321,7 +300,7 @@ func TestUserStorage_Delete(t *testing.T) {
 	}
 
 	for _, u := range users {
-		err := storage.Delete(context.Background(), u.UUID)
+		err := storage.Delete(context.Background(), u.UUID, nil)
 		assert.NoError(t, err)
 		found, err := storage.FindById(context.Background(), u.UUID)
 		assert.Error(t, err)
@@ -0,0 +1,157 @@
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewAPIKeyStorage returns a new empty API key storage
+// and a function that cleans it up.
+type NewAPIKeyStorage func(t *testing.T) (user.APIKeyStorage, func() error)
+
+// RunAPIKeyStorage runs the contract test suite against API key
+// storages returned by newStorage. Every test gets its own storage.
+func RunAPIKeyStorage(t *testing.T, newStorage NewAPIKeyStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage user.APIKeyStorage)
+	}{
+		{"Create", testAPIKeyCreate},
+		{"ListByUser", testAPIKeyListByUser},
+		{"Revoke", testAPIKeyRevoke},
+		{"RevokeAllByUser", testAPIKeyRevokeAllByUser},
+		{"Touch", testAPIKeyTouch},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+func testAPIKeyCreate(t *testing.T, storage user.APIKeyStorage) {
+	key := newAPIKey(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), key))
+
+	found, err := storage.FindById(context.Background(), key.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, key.ID, found.ID)
+		assert.Equal(t, key.UserUUID, found.UserUUID)
+		assert.Equal(t, key.Name, found.Name)
+		assert.Equal(t, key.Scopes, found.Scopes)
+		assert.Equal(t, key.Hash, found.Hash)
+		assert.False(t, found.Revoked)
+		assert.True(t, key.ExpiresAt.Equal(found.ExpiresAt))
+		assert.True(t, key.CreatedAt.Equal(found.CreatedAt))
+		assert.Nil(t, found.LastUsedAt)
+		assert.Empty(t, found.LastUsedIP)
+	}
+
+	_, err = storage.FindById(context.Background(), "unknown")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testAPIKeyListByUser(t *testing.T, storage user.APIKeyStorage) {
+	for _, key := range []*user.APIKey{
+		newAPIKey(1, "user1"),
+		newAPIKey(2, "user1"),
+		newAPIKey(3, "user1"),
+		newAPIKey(4, "user2"),
+	} {
+		assert.NoError(t, storage.Create(context.Background(), key))
+	}
+	assert.NoError(t, storage.Revoke(context.Background(), "user1", "key2"))
+
+	// Revoked keys and keys of other users are skipped, newest first.
+	keys, err := storage.ListByUser(context.Background(), "user1")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "key3", keys[0].ID)
+		assert.Equal(t, "key1", keys[1].ID)
+	}
+
+	keys, err = storage.ListByUser(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func testAPIKeyRevoke(t *testing.T, storage user.APIKeyStorage) {
+	key := newAPIKey(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), key))
+
+	// Keys of other users can't be revoked.
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user2", key.ID), apperror.ErrNoRows)
+
+	assert.NoError(t, storage.Revoke(context.Background(), "user1", key.ID))
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user1", key.ID), apperror.ErrNoRows)
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user1", "unknown"), apperror.ErrNoRows)
+
+	found, err := storage.FindById(context.Background(), key.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.True(t, found.Revoked)
+	}
+}
+
+func testAPIKeyTouch(t *testing.T, storage user.APIKeyStorage) {
+	key := newAPIKey(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), key))
+
+	at := key.CreatedAt.Add(time.Hour)
+	assert.NoError(t, storage.Touch(context.Background(), key.ID, at, "10.0.0.1"))
+
+	found, err := storage.FindById(context.Background(), key.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) && assert.NotNil(t, found.LastUsedAt) {
+		assert.True(t, at.Equal(*found.LastUsedAt))
+		assert.Equal(t, "10.0.0.1", found.LastUsedIP)
+	}
+
+	assert.ErrorIs(t, storage.Touch(context.Background(), "unknown", at, "10.0.0.1"), apperror.ErrNoRows)
+}
+
+func testAPIKeyRevokeAllByUser(t *testing.T, storage user.APIKeyStorage) {
+	for _, key := range []*user.APIKey{
+		newAPIKey(1, "user1"),
+		newAPIKey(2, "user1"),
+		newAPIKey(3, "user2"),
+	} {
+		assert.NoError(t, storage.Create(context.Background(), key))
+	}
+
+	assert.NoError(t, storage.RevokeAllByUser(context.Background(), "user1"))
+
+	keys, err := storage.ListByUser(context.Background(), "user1")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = storage.ListByUser(context.Background(), "user2")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func newAPIKey(i int, userUUID string) *user.APIKey {
+	createdAt := time.Date(2022, 2, 24, 10, 15, i, 0, time.UTC)
+	return &user.APIKey{
+		ID:        fmt.Sprintf("key%d", i),
+		UserUUID:  userUUID,
+		Name:      fmt.Sprintf("ci%d", i),
+		Scopes:    []auth.Scope{auth.ScopeRead, auth.ScopeWrite},
+		Hash:      fmt.Sprintf("hash%d", i),
+		ExpiresAt: createdAt.AddDate(0, 0, 30),
+		CreatedAt: createdAt,
+	}
+}
@@ -0,0 +1,158 @@
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewSessionStorage returns a new empty session storage
+// and a function that cleans it up.
+type NewSessionStorage func(t *testing.T) (user.SessionStorage, func() error)
+
+// RunSessionStorage runs the contract test suite against session
+// storages returned by newStorage. Every test gets its own storage.
+func RunSessionStorage(t *testing.T, newStorage NewSessionStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage user.SessionStorage)
+	}{
+		{"Create", testSessionCreate},
+		{"ListByUser", testSessionListByUser},
+		{"Touch", testSessionTouch},
+		{"Revoke", testSessionRevoke},
+		{"RevokeAllByUser", testSessionRevokeAllByUser},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+func testSessionCreate(t *testing.T, storage user.SessionStorage) {
+	session := newSession(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), session))
+
+	found, err := storage.FindById(context.Background(), session.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, session.ID, found.ID)
+		assert.Equal(t, session.UserUUID, found.UserUUID)
+		assert.Equal(t, session.UserAgent, found.UserAgent)
+		assert.Equal(t, session.IP, found.IP)
+		assert.False(t, found.Revoked)
+		assert.True(t, session.ExpiresAt.Equal(found.ExpiresAt))
+		assert.True(t, session.CreatedAt.Equal(found.CreatedAt))
+		assert.True(t, session.LastSeenAt.Equal(found.LastSeenAt))
+	}
+
+	_, err = storage.FindById(context.Background(), "unknown")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testSessionListByUser(t *testing.T, storage user.SessionStorage) {
+	for _, session := range []*user.Session{
+		newSession(1, "user1"),
+		newSession(2, "user1"),
+		newSession(3, "user1"),
+		newSession(4, "user2"),
+	} {
+		assert.NoError(t, storage.Create(context.Background(), session))
+	}
+	assert.NoError(t, storage.Revoke(context.Background(), "user1", "session2"))
+
+	// Revoked sessions and sessions of other users are skipped, newest first.
+	sessions, err := storage.ListByUser(context.Background(), "user1")
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 2) {
+		assert.Equal(t, "session3", sessions[0].ID)
+		assert.Equal(t, "session1", sessions[1].ID)
+	}
+
+	sessions, err = storage.ListByUser(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func testSessionTouch(t *testing.T, storage user.SessionStorage) {
+	session := newSession(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), session))
+
+	at := session.CreatedAt.Add(time.Hour)
+	expiresAt := at.Add(24 * time.Hour)
+	assert.NoError(t, storage.Touch(context.Background(), session.ID, at, "10.0.0.1", expiresAt))
+
+	found, err := storage.FindById(context.Background(), session.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.True(t, at.Equal(found.LastSeenAt))
+		assert.True(t, expiresAt.Equal(found.ExpiresAt))
+		assert.Equal(t, "10.0.0.1", found.IP)
+		assert.True(t, session.CreatedAt.Equal(found.CreatedAt))
+	}
+
+	err = storage.Touch(context.Background(), "unknown", at, "10.0.0.1", expiresAt)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testSessionRevoke(t *testing.T, storage user.SessionStorage) {
+	session := newSession(1, "user1")
+	assert.NoError(t, storage.Create(context.Background(), session))
+
+	// Sessions of other users can't be revoked.
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user2", session.ID), apperror.ErrNoRows)
+
+	assert.NoError(t, storage.Revoke(context.Background(), "user1", session.ID))
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user1", session.ID), apperror.ErrNoRows)
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "user1", "unknown"), apperror.ErrNoRows)
+
+	found, err := storage.FindById(context.Background(), session.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.True(t, found.Revoked)
+	}
+}
+
+func testSessionRevokeAllByUser(t *testing.T, storage user.SessionStorage) {
+	for _, session := range []*user.Session{
+		newSession(1, "user1"),
+		newSession(2, "user1"),
+		newSession(3, "user2"),
+	} {
+		assert.NoError(t, storage.Create(context.Background(), session))
+	}
+
+	assert.NoError(t, storage.RevokeAllByUser(context.Background(), "user1"))
+
+	sessions, err := storage.ListByUser(context.Background(), "user1")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	sessions, err = storage.ListByUser(context.Background(), "user2")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func newSession(i int, userUUID string) *user.Session {
+	createdAt := time.Date(2022, 2, 24, 10, 15, i, 0, time.UTC)
+	return &user.Session{
+		ID:         fmt.Sprintf("session%d", i),
+		UserUUID:   userUUID,
+		UserAgent:  "Mozilla/5.0 (X11; Linux x86_64)",
+		IP:         "127.0.0.1",
+		ExpiresAt:  createdAt.Add(24 * time.Hour),
+		CreatedAt:  createdAt,
+		LastSeenAt: createdAt,
+	}
+}
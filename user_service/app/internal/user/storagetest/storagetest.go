@@ -0,0 +1,844 @@
+// Package storagetest provides contract test suites for implementations
+// of user.Storage, user.TokenStorage, user.SessionStorage and
+// user.APIKeyStorage, so every implementation behaves the same way.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewStorage returns a new empty storage and a function that cleans it up.
+type NewStorage func(t *testing.T) (user.Storage, func() error)
+
+// Run runs the contract test suite against storages returned by newStorage.
+// Every test gets its own storage.
+func Run(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage user.Storage)
+	}{
+		{"Create", testCreate},
+		{"CreateConcurrently", testCreateConcurrently},
+		{"CreateBatch", testCreateBatch},
+		{"FindByEmail", testFindByEmail},
+		{"FindById", testFindById},
+		{"UpdatePartially", testUpdatePartially},
+		{"CompareAndUpdate", testCompareAndUpdate},
+		{"Replace", testReplace},
+		{"Version", testVersion},
+		{"SetTwoFactor", testSetTwoFactor},
+		{"CompareAndSetTwoFactor", testCompareAndSetTwoFactor},
+		{"SetPendingEmail", testSetPendingEmail},
+		{"SetLocked", testSetLocked},
+		{"SetLastLogin", testSetLastLogin},
+		{"Delete", testDelete},
+		{"DeleteVersion", testDeleteVersion},
+		{"DeletedHidden", testDeletedHidden},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"ReturnsCopies", testReturnsCopies},
+		{"Unique", testUnique},
+		{"List", testList},
+		{"ListFilters", testListFilters},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+func testCreate(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+
+	id, err := storage.Create(context.Background(), u)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, id, found.UUID)
+		assert.Equal(t, u.Email, found.Email)
+		assert.Equal(t, u.Username, found.Username)
+		assert.Equal(t, u.Password, found.Password)
+		assert.Equal(t, u.Role, found.Role)
+		assert.True(t, u.RegisteredAt.Equal(found.RegisteredAt))
+		assert.Nil(t, found.LastLoginAt)
+	}
+
+	another, err := storage.Create(context.Background(), newUser(2))
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, another)
+}
+
+func testCreateConcurrently(t *testing.T, storage user.Storage) {
+	const count = 20
+
+	var wg sync.WaitGroup
+	ids := make([]string, count)
+	errs := make([]error, count)
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = storage.Create(context.Background(), newUser(i))
+		}(i)
+	}
+	wg.Wait()
+
+	unique := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		assert.NoError(t, errs[i])
+		unique[ids[i]] = true
+	}
+	assert.Len(t, unique, count)
+}
+
+func testCreateBatch(t *testing.T, storage user.Storage) {
+	existing := newUser(1)
+	create(t, storage, existing)
+
+	takenEmail := newUser(3)
+	takenEmail.Email = existing.Email
+	takenUsername := newUser(4)
+	takenUsername.Username = "TEST2"
+
+	results, err := storage.CreateBatch(context.Background(), []*user.User{
+		newUser(2), takenEmail, takenUsername, newUser(5),
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 4) {
+		return
+	}
+
+	// Users following the failed ones are inserted too.
+	for _, i := range []int{0, 3} {
+		assert.NoError(t, results[i].Err)
+		found, err := storage.FindById(context.Background(), results[i].UUID)
+		assert.NoError(t, err)
+		if assert.NotNil(t, found) {
+			assert.Equal(t, results[i].UUID, found.UUID)
+		}
+	}
+
+	assert.ErrorIs(t, results[1].Err, apperror.ErrEmailTaken)
+	assert.Empty(t, results[1].UUID)
+	assert.ErrorIs(t, results[2].Err, apperror.ErrUsernameTaken)
+	assert.Empty(t, results[2].UUID)
+
+	results, err = storage.CreateBatch(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func testFindByEmail(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	id := create(t, storage, u)
+	create(t, storage, newUser(2))
+
+	found, err := storage.FindByEmail(context.Background(), u.Email)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, id, found.UUID)
+		assert.Equal(t, u.Email, found.Email)
+	}
+
+	found, err = storage.FindByEmail(context.Background(), "missing@mail.com")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+	assert.Nil(t, found)
+}
+
+func testFindById(t *testing.T, storage user.Storage) {
+	create(t, storage, newUser(1))
+
+	found, err := storage.FindById(context.Background(), missingID(t, storage))
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+	assert.Nil(t, found)
+
+	found, err = storage.FindById(context.Background(), "invalid")
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+	assert.Nil(t, found)
+}
+
+func testUpdatePartially(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	id := create(t, storage, u)
+
+	// Empty fields are left untouched.
+	err := storage.UpdatePartially(context.Background(), &user.User{
+		UUID:     id,
+		Username: "updated",
+		Verified: true,
+	})
+	assert.NoError(t, err)
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "updated", found.Username)
+		assert.True(t, found.Verified)
+		assert.Equal(t, u.Email, found.Email)
+		assert.Equal(t, u.Password, found.Password)
+	}
+
+	err = storage.UpdatePartially(context.Background(), &user.User{
+		UUID:     missingID(t, storage),
+		Username: "updated",
+	})
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.UpdatePartially(context.Background(), &user.User{UUID: "invalid", Username: "updated"})
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testSetTwoFactor(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	twoFactor := &user.TwoFactor{
+		Secret:        "JBSWY3DPEHPK3PXP",
+		Enabled:       true,
+		RecoveryCodes: []string{"first", "second"},
+		LastStep:      42,
+	}
+	assert.NoError(t, storage.SetTwoFactor(context.Background(), id, twoFactor))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, twoFactor, found.TwoFactor)
+	}
+
+	// Partial update keeps two-factor state.
+	assert.NoError(t, storage.UpdatePartially(context.Background(), &user.User{UUID: id, Username: "updated"}))
+
+	twoFactor.RecoveryCodes = nil
+	assert.NoError(t, storage.SetTwoFactor(context.Background(), id, twoFactor))
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) && assert.NotNil(t, found.TwoFactor) {
+		assert.Empty(t, found.TwoFactor.RecoveryCodes)
+		assert.Equal(t, int64(42), found.TwoFactor.LastStep)
+	}
+
+	assert.NoError(t, storage.SetTwoFactor(context.Background(), id, nil))
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Nil(t, found.TwoFactor)
+	}
+
+	err = storage.SetTwoFactor(context.Background(), missingID(t, storage), twoFactor)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.SetTwoFactor(context.Background(), "invalid", twoFactor)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testCompareAndSetTwoFactor(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, found) {
+		t.FailNow()
+	}
+
+	twoFactor := &user.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 42}
+	assert.NoError(t, storage.CompareAndSetTwoFactor(context.Background(), id, found.Version, twoFactor))
+
+	// The version read before the change is stale now.
+	stale := &user.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 41}
+	err = storage.CompareAndSetTwoFactor(context.Background(), id, found.Version, stale)
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	updated, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, updated) {
+		assert.Equal(t, twoFactor, updated.TwoFactor)
+		assert.Equal(t, found.Version+1, updated.Version)
+	}
+
+	assert.NoError(t, storage.CompareAndSetTwoFactor(context.Background(), id, updated.Version, nil))
+
+	err = storage.CompareAndSetTwoFactor(context.Background(), missingID(t, storage), 0, twoFactor)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.CompareAndSetTwoFactor(context.Background(), "invalid", 0, twoFactor)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testSetPendingEmail(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	pending := &user.PendingEmail{
+		Email:     "new@mail.com",
+		ID:        "9f86d081884c7d659a2feaa0c55ad015",
+		ExpiresAt: time.Now().UTC().Add(time.Hour).Truncate(time.Millisecond),
+	}
+	assert.NoError(t, storage.SetPendingEmail(context.Background(), id, pending))
+
+	// Partial update keeps pending email change.
+	assert.NoError(t, storage.UpdatePartially(context.Background(), &user.User{UUID: id, Username: "updated"}))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) && assert.NotNil(t, found.PendingEmail) {
+		assert.Equal(t, pending.Email, found.PendingEmail.Email)
+		assert.Equal(t, pending.ID, found.PendingEmail.ID)
+		assert.True(t, pending.ExpiresAt.Equal(found.PendingEmail.ExpiresAt))
+		assert.Equal(t, newUser(1).Email, found.Email, "email isn't changed until confirmed")
+	}
+
+	assert.NoError(t, storage.SetPendingEmail(context.Background(), id, nil))
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Nil(t, found.PendingEmail)
+	}
+
+	err = storage.SetPendingEmail(context.Background(), missingID(t, storage), pending)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.SetPendingEmail(context.Background(), "invalid", pending)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testCompareAndUpdate(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, found) {
+		return
+	}
+	stale := *found
+
+	found.Username = "updated"
+	assert.NoError(t, storage.CompareAndUpdate(context.Background(), found))
+	assert.Equal(t, stale.Version+1, found.Version)
+
+	// The stored version has changed, so stale user isn't written.
+	stale.Username = "overwritten"
+	err = storage.CompareAndUpdate(context.Background(), &stale)
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	updated, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, updated) {
+		assert.Equal(t, "updated", updated.Username)
+		assert.Equal(t, found.Version, updated.Version)
+		assert.True(t, found.UpdatedAt.Equal(updated.UpdatedAt))
+	}
+
+	err = storage.CompareAndUpdate(context.Background(), &user.User{UUID: missingID(t, storage), Username: "updated"})
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.CompareAndUpdate(context.Background(), &user.User{UUID: "invalid", Username: "updated"})
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testReplace(t *testing.T, storage user.Storage) {
+	verified := newUser(1)
+	verified.Verified = true
+	id := create(t, storage, verified)
+	create(t, storage, newUser(2))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, found) {
+		return
+	}
+	stale := *found
+
+	// Empty values are written too, so verification can be cleared.
+	found.Username = "replaced"
+	found.Password = "$2a$10$replacedpassword"
+	found.Verified = false
+	found.Role = auth.RoleModerator
+	assert.NoError(t, storage.Replace(context.Background(), found))
+	assert.Equal(t, stale.Version+1, found.Version)
+
+	replaced, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, replaced) {
+		assert.Equal(t, "replaced", replaced.Username)
+		assert.Equal(t, "$2a$10$replacedpassword", replaced.Password)
+		assert.False(t, replaced.Verified)
+		assert.Equal(t, auth.RoleModerator, replaced.Role)
+		assert.Equal(t, verified.Email, replaced.Email)
+		assert.True(t, verified.RegisteredAt.Equal(replaced.RegisteredAt))
+		assert.Equal(t, found.Version, replaced.Version)
+		assert.True(t, found.UpdatedAt.Equal(replaced.UpdatedAt))
+	}
+
+	err = storage.Replace(context.Background(), &stale)
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	found.Username = "TEST2"
+	err = storage.Replace(context.Background(), found)
+	assert.ErrorIs(t, err, apperror.ErrUsernameTaken)
+
+	err = storage.Replace(context.Background(), &user.User{UUID: missingID(t, storage), Username: "replaced"})
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.Replace(context.Background(), &user.User{UUID: "invalid", Username: "replaced"})
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testVersion(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+	lockedAt := time.Now().UTC().Truncate(time.Millisecond)
+
+	changes := map[string]func() error{
+		"UpdatePartially": func() error {
+			// Given version is ignored.
+			return storage.UpdatePartially(context.Background(), &user.User{UUID: id, Verified: true, Version: 100})
+		},
+		"SetTwoFactor": func() error {
+			return storage.SetTwoFactor(context.Background(), id, &user.TwoFactor{Secret: "secret"})
+		},
+		"SetPendingEmail": func() error {
+			return storage.SetPendingEmail(context.Background(), id, &user.PendingEmail{Email: "pending@mail.com", ID: "1"})
+		},
+		"SetLocked": func() error {
+			return storage.SetLocked(context.Background(), id, &lockedAt)
+		},
+	}
+
+	for name, change := range changes {
+		before, err := storage.FindById(context.Background(), id)
+		assert.NoError(t, err)
+
+		assert.NoError(t, change(), name)
+
+		after, err := storage.FindById(context.Background(), id)
+		assert.NoError(t, err)
+		if assert.NotNil(t, before) && assert.NotNil(t, after) {
+			assert.Equal(t, before.Version+1, after.Version, name)
+			assert.False(t, after.UpdatedAt.IsZero(), name)
+			assert.False(t, after.UpdatedAt.Before(before.UpdatedAt), name)
+		}
+	}
+}
+
+func testSetLastLogin(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	before, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	assert.NoError(t, storage.SetLastLogin(context.Background(), id, at))
+
+	// Login is not a change of the user.
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, before) && assert.NotNil(t, found) && assert.NotNil(t, found.LastLoginAt) {
+		assert.True(t, at.Equal(*found.LastLoginAt))
+		assert.Equal(t, before.Version, found.Version)
+		assert.True(t, before.UpdatedAt.Equal(found.UpdatedAt))
+	}
+
+	// Update of the user read before the next login keeps last login time.
+	stale := found
+	later := at.Add(time.Minute)
+	assert.NoError(t, storage.SetLastLogin(context.Background(), id, later))
+
+	stale.Username = "updated"
+	assert.NoError(t, storage.CompareAndUpdate(context.Background(), stale))
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) && assert.NotNil(t, found.LastLoginAt) {
+		assert.True(t, later.Equal(*found.LastLoginAt))
+		assert.Equal(t, "updated", found.Username)
+	}
+
+	err = storage.SetLastLogin(context.Background(), missingID(t, storage), at)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.SetLastLogin(context.Background(), "invalid", at)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testSetLocked(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	lockedAt := time.Now().UTC().Truncate(time.Millisecond)
+	assert.NoError(t, storage.SetLocked(context.Background(), id, &lockedAt))
+
+	// Partial update keeps the lock.
+	assert.NoError(t, storage.UpdatePartially(context.Background(), &user.User{UUID: id, Verified: true}))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) && assert.NotNil(t, found.LockedAt) {
+		assert.True(t, lockedAt.Equal(*found.LockedAt))
+	}
+
+	assert.NoError(t, storage.SetLocked(context.Background(), id, nil))
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Nil(t, found.LockedAt)
+	}
+
+	err = storage.SetLocked(context.Background(), missingID(t, storage), &lockedAt)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.SetLocked(context.Background(), "invalid", nil)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testDelete(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	assert.NoError(t, storage.Delete(context.Background(), id, nil))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+	assert.Nil(t, found)
+
+	err = storage.Delete(context.Background(), id, nil)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.Delete(context.Background(), "invalid", nil)
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+}
+
+func testDeleteVersion(t *testing.T, storage user.Storage) {
+	id := create(t, storage, newUser(1))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, found) {
+		return
+	}
+
+	stale := found.Version + 1
+	err = storage.Delete(context.Background(), id, &stale)
+	assert.ErrorIs(t, err, apperror.ErrVersionConflict)
+
+	assert.NoError(t, storage.Delete(context.Background(), id, &found.Version))
+
+	err = storage.Delete(context.Background(), id, &found.Version)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testDeletedHidden(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	id := create(t, storage, u)
+	create(t, storage, newUser(2))
+
+	assert.NoError(t, storage.Delete(context.Background(), id, nil))
+
+	_, err := storage.FindByEmail(context.Background(), u.Email)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.UpdatePartially(context.Background(), &user.User{UUID: id, Username: "updated"})
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	filter := &user.ListFilter{SortBy: user.SortByEmail}
+	users, err := storage.List(context.Background(), filter)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, newUser(2).Email, users[0].Email)
+	}
+
+	count, err := storage.Count(context.Background(), filter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Deleted users don't hold their email and username.
+	_, err = storage.Create(context.Background(), newUser(1))
+	assert.NoError(t, err)
+}
+
+func testRestore(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	id := create(t, storage, u)
+
+	err := storage.Restore(context.Background(), id)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	assert.NoError(t, storage.Delete(context.Background(), id, nil))
+	assert.NoError(t, storage.Restore(context.Background(), id))
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, u.Email, found.Email)
+		assert.Nil(t, found.DeletedAt)
+	}
+
+	err = storage.Restore(context.Background(), id)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	err = storage.Restore(context.Background(), "invalid")
+	assert.ErrorIs(t, err, apperror.ErrInvalidUUID)
+
+	// The user can't be restored once another user takes its email or username.
+	assert.NoError(t, storage.Delete(context.Background(), id, nil))
+	create(t, storage, newUser(1))
+	err = storage.Restore(context.Background(), id)
+	assert.ErrorIs(t, err, apperror.ErrEmailTaken)
+
+	other := newUser(2)
+	otherID := create(t, storage, other)
+	assert.NoError(t, storage.Delete(context.Background(), otherID, nil))
+	taken := newUser(3)
+	taken.Username = strings.ToUpper(other.Username)
+	create(t, storage, taken)
+	err = storage.Restore(context.Background(), otherID)
+	assert.ErrorIs(t, err, apperror.ErrUsernameTaken)
+}
+
+func testPurge(t *testing.T, storage user.Storage) {
+	first := create(t, storage, newUser(1))
+	second := create(t, storage, newUser(2))
+	active := create(t, storage, newUser(3))
+
+	assert.NoError(t, storage.Delete(context.Background(), first, nil))
+	assert.NoError(t, storage.Delete(context.Background(), second, nil))
+
+	purged, err := storage.Purge(context.Background(), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	purged, err = storage.Purge(context.Background(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	err = storage.Restore(context.Background(), first)
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+
+	_, err = storage.FindById(context.Background(), active)
+	assert.NoError(t, err)
+
+	// Purged users no longer occupy their email.
+	_, err = storage.Create(context.Background(), newUser(1))
+	assert.NoError(t, err)
+}
+
+func testReturnsCopies(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	id := create(t, storage, u)
+
+	u.Username = "changed"
+
+	found, err := storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "test1", found.Username)
+		found.Username = "changed"
+	}
+
+	found, err = storage.FindById(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "test1", found.Username)
+	}
+}
+
+// newUser returns a new user with unique email and username.
+func newUser(i int) *user.User {
+	return &user.User{
+		Email:        fmt.Sprintf("test%d@mail.com", i),
+		Username:     fmt.Sprintf("test%d", i),
+		Password:     "$2a$10$hashedpassword",
+		Role:         "user",
+		RegisteredAt: time.Date(2022, 2, 24, 10, 15, 0, 0, time.UTC),
+	}
+}
+
+func testUnique(t *testing.T, storage user.Storage) {
+	u := newUser(1)
+	create(t, storage, u)
+	otherID := create(t, storage, newUser(2))
+
+	duplicate := newUser(3)
+	duplicate.Email = u.Email
+	_, err := storage.Create(context.Background(), duplicate)
+	assert.ErrorIs(t, err, apperror.ErrEmailTaken)
+
+	// Usernames are unique ignoring case.
+	duplicate = newUser(3)
+	duplicate.Username = "TEST1"
+	_, err = storage.Create(context.Background(), duplicate)
+	assert.ErrorIs(t, err, apperror.ErrUsernameTaken)
+
+	err = storage.UpdatePartially(context.Background(), &user.User{UUID: otherID, Email: u.Email})
+	assert.ErrorIs(t, err, apperror.ErrEmailTaken)
+
+	err = storage.UpdatePartially(context.Background(), &user.User{UUID: otherID, Username: "Test1"})
+	assert.ErrorIs(t, err, apperror.ErrUsernameTaken)
+
+	// The user can keep own email and username.
+	err = storage.UpdatePartially(context.Background(), &user.User{UUID: otherID, Email: "test2@mail.com", Username: "TEST2"})
+	assert.NoError(t, err)
+}
+
+func testList(t *testing.T, storage user.Storage) {
+	registered := []int{3, 1, 3, 2, 1}
+	for i, day := range registered {
+		u := newUser(i)
+		u.RegisteredAt = time.Date(2022, 2, day, 0, 0, 0, 0, time.UTC)
+		create(t, storage, u)
+	}
+
+	for _, descending := range []bool{false, true} {
+		filter := &user.ListFilter{SortBy: user.SortByRegisteredAt, Descending: descending}
+
+		all, err := storage.List(context.Background(), filter)
+		assert.NoError(t, err)
+		assert.Len(t, all, len(registered))
+
+		for i := 1; i < len(all); i++ {
+			prev, next := all[i-1].RegisteredAt, all[i].RegisteredAt
+			if descending {
+				assert.False(t, prev.Before(next))
+			} else {
+				assert.False(t, prev.After(next))
+			}
+		}
+
+		// Walking page by page returns the same users in the same order.
+		var paged []user.User
+		filter.Limit = 2
+		for {
+			page, err := storage.List(context.Background(), filter)
+			assert.NoError(t, err)
+			paged = append(paged, page...)
+
+			if len(page) < filter.Limit || len(paged) > len(all) {
+				break
+			}
+
+			last := page[len(page)-1]
+			filter.After = &user.ListCursor{Value: user.SortValue(&last, filter.SortBy), UUID: last.UUID}
+		}
+
+		if assert.Len(t, paged, len(all)) {
+			for i := range all {
+				assert.Equal(t, all[i].UUID, paged[i].UUID)
+			}
+		}
+	}
+
+	count, err := storage.Count(context.Background(), &user.ListFilter{SortBy: user.SortByRegisteredAt})
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(registered), count)
+}
+
+func testListFilters(t *testing.T, storage user.Storage) {
+	users := []*user.User{newUser(1), newUser(2), newUser(3), newUser(4)}
+	users[0].Username, users[0].RegisteredAt, users[0].Verified = "alice", time.Date(2022, 1, 15, 12, 0, 0, 0, time.UTC), true
+	users[1].Username, users[1].RegisteredAt = "alex", time.Date(2022, 2, 10, 12, 0, 0, 0, time.UTC)
+	users[2].Username, users[2].RegisteredAt, users[2].Verified = "bob", time.Date(2022, 2, 20, 12, 0, 0, 0, time.UTC), true
+	users[3].Username, users[3].RegisteredAt = "al_x", time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, u := range users {
+		u.UUID = create(t, storage, u)
+	}
+
+	verified, unverified := true, false
+
+	testCases := []struct {
+		name     string
+		filter   user.ListFilter
+		expected []string
+	}{
+		{
+			name:     "verified",
+			filter:   user.ListFilter{Verified: &verified},
+			expected: []string{"alice", "bob"},
+		},
+		{
+			name:     "unverified",
+			filter:   user.ListFilter{Verified: &unverified},
+			expected: []string{"al_x", "alex"},
+		},
+		{
+			name: "registered at range",
+			filter: user.ListFilter{
+				RegisteredFrom: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+				RegisteredTo:   time.Date(2022, 2, 21, 0, 0, 0, 0, time.UTC),
+			},
+			expected: []string{"alex", "bob"},
+		},
+		{
+			name:     "username prefix",
+			filter:   user.ListFilter{UsernamePrefix: "al"},
+			expected: []string{"al_x", "alex", "alice"},
+		},
+		{
+			name:     "username prefix with special characters",
+			filter:   user.ListFilter{UsernamePrefix: "al_"},
+			expected: []string{"al_x"},
+		},
+		{
+			name:     "combined",
+			filter:   user.ListFilter{UsernamePrefix: "al", Verified: &unverified, RegisteredTo: time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC)},
+			expected: []string{"alex"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.SortBy = user.SortByUsername
+
+			found, err := storage.List(context.Background(), &tc.filter)
+			assert.NoError(t, err)
+
+			usernames := []string{}
+			for _, u := range found {
+				usernames = append(usernames, u.Username)
+			}
+			assert.Equal(t, tc.expected, usernames)
+
+			count, err := storage.Count(context.Background(), &tc.filter)
+			assert.NoError(t, err)
+			assert.EqualValues(t, len(tc.expected), count)
+		})
+	}
+}
+
+// missingID returns a valid uuid of the user that doesn't exist.
+func missingID(t *testing.T, storage user.Storage) string {
+	u := newUser(0)
+	u.Email = "missing@mail.com"
+
+	id := create(t, storage, u)
+	assert.NoError(t, storage.Delete(context.Background(), id, nil))
+	_, err := storage.Purge(context.Background(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	return id
+}
+
+// create stores given user and returns its uuid.
+func create(t *testing.T, storage user.Storage, u *user.User) string {
+	id, err := storage.Create(context.Background(), u)
+	assert.NoError(t, err)
+	return id
+}
@@ -0,0 +1,128 @@
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewTokenStorage returns a new empty refresh token storage
+// and a function that cleans it up.
+type NewTokenStorage func(t *testing.T) (user.TokenStorage, func() error)
+
+// RunTokenStorage runs the contract test suite against refresh token
+// storages returned by newStorage. Every test gets its own storage.
+func RunTokenStorage(t *testing.T, newStorage NewTokenStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage user.TokenStorage)
+	}{
+		{"Create", testTokenCreate},
+		{"Revoke", testTokenRevoke},
+		{"RevokeFamily", testTokenRevokeFamily},
+		{"RevokeAllByUser", testTokenRevokeAllByUser},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, teardown := newStorage(t)
+			defer func() { assert.NoError(t, teardown()) }()
+
+			tt.test(t, storage)
+		})
+	}
+}
+
+func testTokenCreate(t *testing.T, storage user.TokenStorage) {
+	token := newToken(1, "family1", "user1")
+	assert.NoError(t, storage.Create(context.Background(), token))
+
+	found, err := storage.FindById(context.Background(), token.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, token.ID, found.ID)
+		assert.Equal(t, token.Family, found.Family)
+		assert.Equal(t, token.UserUUID, found.UserUUID)
+		assert.False(t, found.Revoked)
+		assert.True(t, token.ExpiresAt.Equal(found.ExpiresAt))
+		assert.True(t, token.CreatedAt.Equal(found.CreatedAt))
+	}
+
+	_, err = storage.FindById(context.Background(), "unknown")
+	assert.ErrorIs(t, err, apperror.ErrNoRows)
+}
+
+func testTokenRevoke(t *testing.T, storage user.TokenStorage) {
+	token := newToken(1, "family1", "user1")
+	assert.NoError(t, storage.Create(context.Background(), token))
+
+	assert.NoError(t, storage.Revoke(context.Background(), token.ID))
+
+	// Only one caller can revoke the token.
+	assert.ErrorIs(t, storage.Revoke(context.Background(), token.ID), apperror.ErrNoRows)
+	assert.ErrorIs(t, storage.Revoke(context.Background(), "unknown"), apperror.ErrNoRows)
+
+	found, err := storage.FindById(context.Background(), token.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.True(t, found.Revoked)
+	}
+}
+
+func testTokenRevokeFamily(t *testing.T, storage user.TokenStorage) {
+	tokens := []*user.RefreshToken{
+		newToken(1, "family1", "user1"),
+		newToken(2, "family1", "user1"),
+		newToken(3, "family2", "user1"),
+	}
+	for _, token := range tokens {
+		assert.NoError(t, storage.Create(context.Background(), token))
+	}
+
+	assert.NoError(t, storage.RevokeFamily(context.Background(), "family1"))
+
+	assertTokensRevoked(t, storage, tokens, true, true, false)
+}
+
+func testTokenRevokeAllByUser(t *testing.T, storage user.TokenStorage) {
+	tokens := []*user.RefreshToken{
+		newToken(1, "family1", "user1"),
+		newToken(2, "family2", "user1"),
+		newToken(3, "family3", "user2"),
+	}
+	for _, token := range tokens {
+		assert.NoError(t, storage.Create(context.Background(), token))
+	}
+
+	assert.NoError(t, storage.RevokeAllByUser(context.Background(), "user1"))
+
+	assertTokensRevoked(t, storage, tokens, true, true, false)
+}
+
+// assertTokensRevoked checks whether stored tokens are revoked as expected.
+func assertTokensRevoked(t *testing.T, storage user.TokenStorage, tokens []*user.RefreshToken, revoked ...bool) {
+	for i, token := range tokens {
+		found, err := storage.FindById(context.Background(), token.ID)
+		assert.NoError(t, err)
+		if assert.NotNil(t, found) {
+			assert.Equal(t, revoked[i], found.Revoked, token.ID)
+		}
+	}
+}
+
+func newToken(i int, family, userUUID string) *user.RefreshToken {
+	createdAt := time.Date(2022, 2, 24, 10, 15, i, 0, time.UTC)
+	return &user.RefreshToken{
+		ID:        fmt.Sprintf("token%d", i),
+		Family:    family,
+		UserUUID:  userUUID,
+		ExpiresAt: createdAt.Add(24 * time.Hour),
+		CreatedAt: createdAt,
+	}
+}
@@ -0,0 +1,52 @@
+package transfer
+
+import (
+	"context"
+	"io"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+)
+
+// exportPageSize is the number of users read from storage at once.
+const exportPageSize = 500
+
+// Export writes all users of the storage to w in given format ordered
+// by email. Password hashes are written too, so exported users can be
+// imported elsewhere with their passwords. Returns the number of
+// written users.
+func Export(ctx context.Context, storage user.Storage, w io.Writer, format string) (int, error) {
+	writer, err := NewWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := &user.ListFilter{SortBy: user.SortByEmail, Limit: exportPageSize}
+	written := 0
+
+	for {
+		users, err := storage.List(ctx, filter)
+		if err != nil {
+			return written, err
+		}
+
+		for i := range users {
+			if err := writer.Write(newRecord(&users[i])); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if len(users) < exportPageSize {
+			break
+		}
+
+		last := users[len(users)-1]
+		filter.After = &user.ListCursor{
+			Sort:  user.SortByEmail,
+			Value: user.SortValue(&last, user.SortByEmail),
+			UUID:  last.UUID,
+		}
+	}
+
+	return written, writer.Flush()
+}
@@ -0,0 +1,509 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+)
+
+// Policy tells what import does with a row whose email is registered
+// already or appears earlier in the file.
+type Policy string
+
+const (
+	// PolicySkip keeps the registered user and reports the row as skipped.
+	PolicySkip Policy = "skip"
+	// PolicyFail reports the row and stops import.
+	PolicyFail Policy = "fail"
+	// PolicyUpdate replaces username, password, role and verification
+	// of the registered user with values of the row. Replacements are
+	// recorded to audit log and the user is logged out everywhere if
+	// the password is replaced.
+	PolicyUpdate Policy = "update"
+)
+
+// Statuses of rows in import report.
+const (
+	StatusInvalid = "invalid"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// DefaultBatchSize is the number of users inserted at once by default.
+const DefaultBatchSize = 500
+
+// ErrDuplicate is returned by Import with fail policy
+// when a row has email of a registered user.
+var ErrDuplicate = errors.New("user with this email is already registered")
+
+// Options configures import.
+type Options struct {
+	Format string
+	// BatchSize is the number of users inserted at once.
+	BatchSize int
+	Policy    Policy
+	// DryRun validates rows and looks for duplicates without writing
+	// anything. Rows are reported the same way as by real import,
+	// but import doesn't stop on duplicates.
+	DryRun bool
+	// Checkpoint is the path of the file keeping import progress.
+	// Import started with existing checkpoint continues after the rows
+	// recorded there. The file is removed when import is over.
+	// Empty path disables resuming.
+	Checkpoint string
+	// Report receives a JSON line for every row which is invalid,
+	// skipped or failed. Optional.
+	Report io.Writer
+}
+
+// ReportRow describes a row which wasn't imported.
+type ReportRow struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Result summarizes import. Resumed counts rows which had been
+// inserted by interrupted import, but weren't recorded in checkpoint.
+type Result struct {
+	Rows    int `json:"rows"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Resumed int `json:"resumed"`
+	Invalid int `json:"invalid"`
+	Failed  int `json:"failed"`
+}
+
+// Importer imports users into user storage.
+type Importer struct {
+	storage user.Storage
+	users   user.Service
+	hasher  password.Hasher
+}
+
+// NewImporter returns a new Importer. New users are inserted into
+// storage in batches, registered users are updated through users service.
+// Plain passwords are hashed with given hasher, password hashes are
+// stored as they are.
+func NewImporter(storage user.Storage, users user.Service, hasher password.Hasher) *Importer {
+	return &Importer{
+		storage: storage,
+		users:   users,
+		hasher:  hasher,
+	}
+}
+
+// importRow is a valid row waiting to be written.
+type importRow struct {
+	line int
+	user *user.User
+	// resumed is set if the row might have been inserted
+	// by interrupted import.
+	resumed bool
+}
+
+// importRun holds state of a single import.
+type importRun struct {
+	*Importer
+	opts       Options
+	result     Result
+	checkpoint checkpoint
+	// reported are report rows of the current batch. They're written
+	// together with checkpoint, so resumed import doesn't repeat them.
+	reported []ReportRow
+	// seen maps emails of the current batch, or of the whole file
+	// on dry run, to lines they were first seen at.
+	seen map[string]int
+	// stop is returned once the current batch is over.
+	stop error
+}
+
+// Import reads users from r and inserts them into storage in batches.
+// Rows are validated with the same rules as registration, except for
+// password strength, so hashes of legacy systems can be imported.
+// Invalid rows are reported and skipped. Duplicates are handled
+// according to the policy. With fail policy nothing of the batch
+// containing a duplicate is written. Progress is saved to checkpoint
+// after every batch, so interrupted import can be resumed.
+func (im *Importer) Import(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	switch opts.Policy {
+	case PolicySkip, PolicyFail, PolicyUpdate:
+	default:
+		return nil, fmt.Errorf("unknown duplicate policy: %s", opts.Policy)
+	}
+
+	reader, err := NewReader(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &importRun{Importer: im, opts: opts, seen: make(map[string]int)}
+	if opts.Checkpoint != "" && !opts.DryRun {
+		run.checkpoint, err = loadCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		batch []importRow
+		rows  int
+	)
+
+	for {
+		record, line, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return &run.result, err
+		}
+
+		rows++
+		if rows <= run.checkpoint.Rows {
+			continue
+		}
+		run.result.Rows++
+
+		if rowErr != nil {
+			run.result.Invalid++
+			run.report(line, "", StatusInvalid, rowErr.Err)
+		} else if u, err := run.newUser(record); err != nil {
+			run.result.Invalid++
+			run.report(line, record.Email, StatusInvalid, err)
+		} else {
+			batch = append(batch, importRow{line: line, user: u, resumed: rows <= run.checkpoint.Pending})
+		}
+
+		if len(batch) >= opts.BatchSize {
+			if err := run.flush(ctx, batch, rows); err != nil {
+				return &run.result, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if err := run.flush(ctx, batch, rows); err != nil {
+		return &run.result, err
+	}
+
+	if opts.Checkpoint != "" && !opts.DryRun {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &run.result, fmt.Errorf("cannot remove checkpoint: %w", err)
+		}
+	}
+
+	return &run.result, nil
+}
+
+// newUser validates the record and returns the user to insert.
+func (run *importRun) newUser(record *Record) (*user.User, error) {
+	dto := user.CreateUserDTO{
+		Email:          record.Email,
+		Username:       record.Username,
+		Password:       record.Password,
+		RepeatPassword: record.Password,
+	}
+	if err := dto.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	u := &user.User{
+		Email:        record.Email,
+		Username:     record.Username,
+		Password:     record.Password,
+		Verified:     record.Verified,
+		Role:         record.Role,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+	}
+
+	if u.Role == "" {
+		u.Role = auth.RoleUser
+	} else if !auth.IsValidRole(u.Role) {
+		return nil, fmt.Errorf("unknown role: %s", u.Role)
+	}
+
+	if record.RegisteredAt != "" {
+		registeredAt, err := parseRegisteredAt(record.RegisteredAt)
+		if err != nil {
+			return nil, err
+		}
+		u.RegisteredAt = registeredAt
+	}
+
+	// Hashing is the slowest part of import and dry run doesn't store passwords.
+	if !password.IsHash(u.Password) && !run.opts.DryRun {
+		if err := u.HashPassword(run.hasher); err != nil {
+			return nil, fmt.Errorf("cannot hash password: %w", err)
+		}
+	}
+
+	return u, nil
+}
+
+// parseRegisteredAt parses registration time of a record.
+// Dates of the layout used before timestamps are midnight UTC.
+func parseRegisteredAt(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	t, err := time.Parse(user.LegacyDateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid registration time %q, expected RFC 3339 or YYYY/MM/DD", value)
+	}
+	return t, nil
+}
+
+// flush writes the batch and saves the checkpoint with given
+// number of rows done. Rows of the batch are marked as pending
+// in checkpoint until the batch is over.
+func (run *importRun) flush(ctx context.Context, batch []importRow, rows int) error {
+	done, pending := run.checkpoint.Rows, rows
+	if run.checkpoint.Pending > pending {
+		// Keep rows of interrupted import pending if it used larger batches.
+		pending = run.checkpoint.Pending
+	}
+
+	if !run.opts.DryRun {
+		run.seen = make(map[string]int)
+		if err := run.saveCheckpoint(checkpoint{Rows: done, Pending: pending}); err != nil {
+			return err
+		}
+	}
+
+	var create, update []importRow
+	for _, row := range batch {
+		existing, err := run.storage.FindByEmail(ctx, row.user.Email)
+		if err != nil && !errors.Is(err, apperror.ErrNoRows) {
+			return err
+		}
+
+		duplicate, reason := err == nil, apperror.ErrEmailTaken
+		if line, ok := run.seen[row.user.Email]; ok && !duplicate {
+			duplicate, reason = true, fmt.Errorf("duplicates row at line %d", line)
+		} else if !ok {
+			run.seen[row.user.Email] = row.line
+		}
+
+		switch {
+		case !duplicate:
+			create = append(create, row)
+		case row.resumed && existing != nil:
+			run.result.Resumed++
+		case run.opts.Policy == PolicyUpdate:
+			update = append(update, row)
+		case run.opts.Policy == PolicySkip:
+			run.result.Skipped++
+			run.report(row.line, row.user.Email, StatusSkipped, reason)
+		default:
+			run.result.Failed++
+			run.report(row.line, row.user.Email, StatusFailed, reason)
+			if !run.opts.DryRun {
+				// Nothing of the batch is written, so it's done again on resume.
+				if err := run.commit(checkpoint{Rows: done, Pending: done}); err != nil {
+					return err
+				}
+				return fmt.Errorf("line %d: %w", row.line, ErrDuplicate)
+			}
+		}
+	}
+
+	if run.opts.DryRun {
+		run.result.Created += len(create)
+		run.result.Updated += len(update)
+		return run.commit(checkpoint{Rows: rows, Pending: rows})
+	}
+
+	update, err := run.create(ctx, create, update)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range update {
+		run.update(ctx, row)
+	}
+
+	if err := run.commit(checkpoint{Rows: rows, Pending: rows}); err != nil {
+		return err
+	}
+	return run.stop
+}
+
+// create inserts given rows. Rows with email registered in the meantime
+// are handled according to the policy, so they might be added to update,
+// which is returned. With fail policy import stops after the batch.
+func (run *importRun) create(ctx context.Context, rows, update []importRow) ([]importRow, error) {
+	if len(rows) == 0 {
+		return update, nil
+	}
+
+	users := make([]*user.User, len(rows))
+	for i := range rows {
+		users[i] = rows[i].user
+	}
+
+	results, err := run.storage.CreateBatch(ctx, users)
+	if err != nil {
+		return update, err
+	}
+
+	for i, res := range results {
+		row := rows[i]
+
+		switch {
+		case res.Err == nil:
+			run.result.Created++
+		case !errors.Is(res.Err, apperror.ErrEmailTaken):
+			run.result.Failed++
+			run.report(row.line, row.user.Email, StatusFailed, res.Err)
+		case row.resumed:
+			run.result.Resumed++
+		case run.opts.Policy == PolicyUpdate:
+			update = append(update, row)
+		case run.opts.Policy == PolicySkip:
+			run.result.Skipped++
+			run.report(row.line, row.user.Email, StatusSkipped, res.Err)
+		default:
+			run.result.Failed++
+			run.report(row.line, row.user.Email, StatusFailed, res.Err)
+			if run.stop == nil {
+				run.stop = fmt.Errorf("line %d: %w", row.line, ErrDuplicate)
+			}
+		}
+	}
+
+	return update, nil
+}
+
+// update replaces fields of the registered user with values of the row.
+// Registration date of the user is kept.
+func (run *importRun) update(ctx context.Context, row importRow) {
+	existing, err := run.storage.FindByEmail(ctx, row.user.Email)
+	if err == nil {
+		err = run.users.Replace(ctx, existing.UUID, row.user)
+	}
+
+	if err != nil {
+		run.result.Failed++
+		run.report(row.line, row.user.Email, StatusFailed, err)
+		return
+	}
+	run.result.Updated++
+}
+
+// report adds a row to the report of the current batch.
+func (run *importRun) report(line int, email, status string, err error) {
+	run.reported = append(run.reported, ReportRow{
+		Line:   line,
+		Email:  email,
+		Status: status,
+		Error:  err.Error(),
+	})
+}
+
+// commit writes report rows of the current batch and saves the checkpoint.
+func (run *importRun) commit(cp checkpoint) error {
+	if run.opts.Report != nil {
+		encoder := json.NewEncoder(run.opts.Report)
+		for i := range run.reported {
+			if err := encoder.Encode(&run.reported[i]); err != nil {
+				return fmt.Errorf("cannot write report: %w", err)
+			}
+		}
+	}
+	run.reported = run.reported[:0]
+
+	return run.saveCheckpoint(cp)
+}
+
+// saveCheckpoint saves the checkpoint unless it's disabled or import is dry run.
+func (run *importRun) saveCheckpoint(cp checkpoint) error {
+	if run.opts.DryRun || run.opts.Checkpoint == "" {
+		return nil
+	}
+
+	run.checkpoint = cp
+	return cp.save(run.opts.Checkpoint)
+}
+
+// checkpoint is the progress of import. Rows is the number of rows
+// done. Rows up to Pending might have been inserted by the batch
+// in progress, so their users are treated as already imported.
+type checkpoint struct {
+	Rows    int `json:"rows"`
+	Pending int `json:"pending"`
+}
+
+// loadCheckpoint reads checkpoint at given path.
+// Missing file means import from the start.
+func loadCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cp, nil
+		}
+		return cp, fmt.Errorf("cannot read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("cannot decode checkpoint: %w", err)
+	}
+
+	return cp, nil
+}
+
+// save writes the checkpoint to a temporary file and renames it,
+// so a crash never leaves the checkpoint half written.
+func (cp checkpoint) save(path string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("cannot encode checkpoint: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimPrefix(filepath.Base(path), ".")+"-*")
+	if err != nil {
+		return fmt.Errorf("cannot create checkpoint: %w", err)
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot save checkpoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+// Package transfer imports users into user storage and exports
+// them from it as CSV or JSON Lines files, one user per row.
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+)
+
+const (
+	// FormatCSV is comma separated values with a header row.
+	FormatCSV = "csv"
+	// FormatJSONL is one JSON object per line.
+	FormatJSONL = "jsonl"
+)
+
+// maxLineSize is the longest JSON line accepted by Reader.
+const maxLineSize = 1 << 20
+
+// csvHeader lists columns of CSV files in the order they're written.
+var csvHeader = []string{"email", "username", "password", "verified", "role", "registeredAt"}
+
+// Record is a user in import and export files. Password is either
+// a plain password or a hash made by a supported algorithm, which is
+// stored as is. Registration time is RFC 3339, but YYYY/MM/DD dates
+// are accepted too. Empty role and registration time get default values.
+type Record struct {
+	Email        string    `json:"email"`
+	Username     string    `json:"username"`
+	Password     string    `json:"password"`
+	Verified     bool      `json:"verified"`
+	Role         auth.Role `json:"role,omitempty"`
+	RegisteredAt string    `json:"registeredAt,omitempty"`
+}
+
+// newRecord returns a record of given user.
+func newRecord(u *user.User) *Record {
+	return &Record{
+		Email:        u.Email,
+		Username:     u.Username,
+		Password:     u.Password,
+		Verified:     u.Verified,
+		Role:         u.Role,
+		RegisteredAt: u.RegisteredAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// RowError is returned by Reader when a single row can't be decoded.
+// Reading can go on with the next row.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads records one by one.
+type Reader interface {
+	// Read returns the next record and the line it starts at.
+	// Returns Row Error if the row is malformed and io.EOF
+	// when there are no more rows.
+	Read() (*Record, int, error)
+}
+
+// NewReader returns a reader of records in given format.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// Writer writes records one by one.
+type Writer interface {
+	Write(record *Record) error
+	// Flush writes buffered records.
+	Flush() error
+}
+
+// NewWriter returns a writer of records in given format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, encoder: json.NewEncoder(bw)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// csvReader reads records from CSV file. Columns are
+// matched by the header, so their order doesn't matter.
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("missing csv header")
+		}
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range []string{"email", "username", "password"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing csv column: %s", name)
+		}
+	}
+
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (r *csvReader) Read() (*Record, int, error) {
+	row, err := r.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.StartLine, &RowError{Line: parseErr.StartLine, Err: parseErr.Err}
+		}
+		return nil, 0, err
+	}
+
+	line, _ := r.r.FieldPos(0)
+
+	column := func(name string) string {
+		if i, ok := r.columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+
+	record := &Record{
+		Email:        column("email"),
+		Username:     column("username"),
+		Password:     column("password"),
+		Role:         auth.Role(column("role")),
+		RegisteredAt: column("registeredAt"),
+	}
+
+	if verified := column("verified"); verified != "" {
+		record.Verified, err = strconv.ParseBool(verified)
+		if err != nil {
+			return nil, line, &RowError{Line: line, Err: fmt.Errorf("invalid verified value: %q", verified)}
+		}
+	}
+
+	return record, line, nil
+}
+
+// jsonlReader reads records from JSON Lines file. Blank lines are skipped.
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlReader) Read() (*Record, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+
+		data := strings.TrimSpace(r.scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, r.line, &RowError{Line: r.line, Err: err}
+		}
+		return &record, r.line, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, r.line, err
+	}
+	return nil, r.line, io.EOF
+}
+
+// csvWriter writes records as CSV rows after the header.
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(record *Record) error {
+	if !w.headerWritten {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	return w.w.Write([]string{
+		record.Email,
+		record.Username,
+		record.Password,
+		strconv.FormatBool(record.Verified),
+		string(record.Role),
+		record.RegisteredAt,
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.headerWritten {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// jsonlWriter writes records as JSON objects, one per line.
+type jsonlWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(record *Record) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,440 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	auditmemory "github.com/juicyluv/sueta/user_service/app/internal/audit/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/user"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/memory"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/transfer"
+	"github.com/juicyluv/sueta/user_service/app/pkg/auth"
+	"github.com/juicyluv/sueta/user_service/app/pkg/logger"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/password"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestHasher returns a hasher with the lowest costs.
+func newTestHasher(t *testing.T, algorithm string) password.Hasher {
+	hasher, err := password.New(password.Params{
+		Algorithm:  algorithm,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	assert.NoError(t, err)
+	return hasher
+}
+
+// newService returns user service on given storages, which updates
+// registered users during import.
+func newService(t *testing.T, storage user.Storage, sessions user.SessionStorage, events audit.Storage, hasher password.Hasher) user.Service {
+	logger.Init()
+	l := logger.GetLogger()
+
+	outbox, err := mail.NewOutbox(t.TempDir())
+	assert.NoError(t, err)
+
+	policy, err := password.NewPolicy(6, 24, "")
+	assert.NoError(t, err)
+
+	tokens := token.NewManager("sueta-test", "access-secret", "refresh-secret", map[token.Type]time.Duration{})
+	return user.NewService(storage, memory.NewTokenStorage(), sessions, memory.NewAPIKeyStorage(), audit.NewService(events, l), outbox, tokens, hasher, policy, l)
+}
+
+// newImporter returns an importer with in-memory storages besides given one.
+func newImporter(t *testing.T, storage user.Storage, hasher password.Hasher) *transfer.Importer {
+	return transfer.NewImporter(storage, newService(t, storage, memory.NewSessionStorage(), auditmemory.NewStorage(), hasher), hasher)
+}
+
+// readReport decodes report rows written by import.
+func readReport(t *testing.T, report *bytes.Buffer) []transfer.ReportRow {
+	var rows []transfer.ReportRow
+	decoder := json.NewDecoder(report)
+	for decoder.More() {
+		var row transfer.ReportRow
+		assert.NoError(t, decoder.Decode(&row))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// findUser returns the user with given email or fails the test.
+func findUser(t *testing.T, storage user.Storage, email string) *user.User {
+	u, err := storage.FindByEmail(context.Background(), email)
+	if !assert.NoError(t, err, email) {
+		t.FailNow()
+	}
+	return u
+}
+
+func TestImport(t *testing.T) {
+	hasher := newTestHasher(t, password.Bcrypt)
+	argon2Hash, err := newTestHasher(t, password.Argon2id).Hash("legacy2")
+	assert.NoError(t, err)
+	bcryptHash, err := hasher.Hash("legacy1")
+	assert.NoError(t, err)
+
+	storage := memory.NewStorage()
+	_, err = storage.Create(context.Background(), &user.User{
+		Email:        "taken@mail.com",
+		Username:     "taken",
+		Password:     bcryptHash,
+		Role:         auth.RoleUser,
+		RegisteredAt: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+
+	input := strings.Join([]string{
+		"username,email,password,verified,role,registeredAt",
+		"plain,plain@mail.com,qwerty,true,admin,2021/05/06",
+		"bcrypt,bcrypt@mail.com," + bcryptHash + ",,,",
+		"rfc,rfc@mail.com,qwerty,,,2021-05-06T10:15:00+03:00",
+		// Argon2 hashes contain commas, so they're quoted.
+		`argon,argon@mail.com,"` + argon2Hash + `",false,moderator,`,
+		"invalid,not-an-email,qwerty,,,",
+		"badrole,badrole@mail.com,qwerty,,root,",
+		"baddate,baddate@mail.com,qwerty,,,06.05.2021",
+		"malformed,malformed@mail.com",
+		"other,taken@mail.com,qwerty,,,",
+		"again,plain@mail.com,qwerty,,,",
+		"clash,clash@mail.com,qwerty,,,",
+		"Plain,newname@mail.com,qwerty,,,",
+	}, "\n")
+
+	var report bytes.Buffer
+	result, err := newImporter(t, storage, hasher).Import(context.Background(), strings.NewReader(input), transfer.Options{
+		Format:    transfer.FormatCSV,
+		BatchSize: 2,
+		Policy:    transfer.PolicySkip,
+		Report:    &report,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &transfer.Result{Rows: 12, Created: 5, Skipped: 2, Invalid: 4, Failed: 1}, result)
+
+	plain := findUser(t, storage, "plain@mail.com")
+	assert.True(t, plain.ComparePassword(hasher, "qwerty"))
+	assert.True(t, plain.Verified)
+	assert.Equal(t, auth.RoleAdmin, plain.Role)
+	assert.Equal(t, time.Date(2021, 5, 6, 0, 0, 0, 0, time.UTC), plain.RegisteredAt)
+
+	// Password hashes are stored as they are.
+	bcryptUser := findUser(t, storage, "bcrypt@mail.com")
+	assert.Equal(t, bcryptHash, bcryptUser.Password)
+	assert.True(t, bcryptUser.ComparePassword(hasher, "legacy1"))
+	assert.Equal(t, auth.RoleUser, bcryptUser.Role)
+	assert.False(t, bcryptUser.RegisteredAt.IsZero())
+	assert.False(t, bcryptUser.UpdatedAt.IsZero())
+
+	// Registration time is stored in UTC.
+	rfcUser := findUser(t, storage, "rfc@mail.com")
+	assert.Equal(t, time.Date(2021, 5, 6, 7, 15, 0, 0, time.UTC), rfcUser.RegisteredAt)
+
+	argonUser := findUser(t, storage, "argon@mail.com")
+	assert.Equal(t, argon2Hash, argonUser.Password)
+	assert.True(t, argonUser.ComparePassword(hasher, "legacy2"))
+	assert.Equal(t, auth.RoleModerator, argonUser.Role)
+
+	assert.Equal(t, "taken", findUser(t, storage, "taken@mail.com").Username)
+
+	rows := readReport(t, &report)
+	statuses := make(map[int]string)
+	for _, row := range rows {
+		assert.NotEmpty(t, row.Error, row.Line)
+		statuses[row.Line] = row.Status
+	}
+	assert.Equal(t, map[int]string{
+		6:  transfer.StatusInvalid,
+		7:  transfer.StatusInvalid,
+		8:  transfer.StatusInvalid,
+		9:  transfer.StatusInvalid,
+		10: transfer.StatusSkipped,
+		11: transfer.StatusSkipped,
+		13: transfer.StatusFailed,
+	}, statuses)
+}
+
+func TestImport_Update(t *testing.T) {
+	ctx := context.Background()
+	hasher := newTestHasher(t, password.Bcrypt)
+	storage := memory.NewStorage()
+	id, err := storage.Create(ctx, &user.User{
+		Email:        "taken@mail.com",
+		Username:     "taken",
+		Password:     "old",
+		Role:         auth.RoleUser,
+		RegisteredAt: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	keptHash, err := hasher.Hash("kept")
+	assert.NoError(t, err)
+	verifiedID, err := storage.Create(ctx, &user.User{
+		Email:    "verified@mail.com",
+		Username: "verified",
+		Password: keptHash,
+		Verified: true,
+		Role:     auth.RoleUser,
+	})
+	assert.NoError(t, err)
+
+	sessions := memory.NewSessionStorage()
+	for _, session := range []*user.Session{
+		{ID: "taken-session", UserUUID: id, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "verified-session", UserUUID: verifiedID, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		assert.NoError(t, sessions.Create(ctx, session))
+	}
+	events := auditmemory.NewStorage()
+
+	input := `{"email":"taken@mail.com","username":"renamed","password":"qwerty","verified":true,"role":"moderator"}
+
+{"email":"new@mail.com","username":"new","password":"qwerty"}
+{"email":"new@mail.com","username":"newer","password":"asdfgh"}
+{"email":"verified@mail.com","username":"verified","password":"` + keptHash + `","verified":false}
+`
+
+	importer := transfer.NewImporter(storage, newService(t, storage, sessions, events, hasher), hasher)
+	result, err := importer.Import(ctx, strings.NewReader(input), transfer.Options{
+		Format: transfer.FormatJSONL,
+		Policy: transfer.PolicyUpdate,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &transfer.Result{Rows: 4, Created: 1, Updated: 3}, result)
+
+	updated := findUser(t, storage, "taken@mail.com")
+	assert.Equal(t, id, updated.UUID)
+	assert.Equal(t, "renamed", updated.Username)
+	assert.True(t, updated.Verified)
+	assert.Equal(t, auth.RoleModerator, updated.Role)
+	assert.True(t, updated.ComparePassword(hasher, "qwerty"))
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), updated.RegisteredAt)
+
+	// The last row of the same email wins.
+	created := findUser(t, storage, "new@mail.com")
+	assert.Equal(t, "newer", created.Username)
+	assert.True(t, created.ComparePassword(hasher, "asdfgh"))
+
+	// Verification is cleared, but the user isn't logged out
+	// since the password is the same.
+	verified := findUser(t, storage, "verified@mail.com")
+	assert.False(t, verified.Verified)
+	list, err := sessions.ListByUser(ctx, verifiedID)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	// The user is logged out everywhere once the password is replaced.
+	list, err = sessions.ListByUser(ctx, id)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	// Every updated user is recorded, including the one created
+	// by a previous row of the same import.
+	recorded, err := events.List(ctx, &audit.Filter{})
+	assert.NoError(t, err)
+	targets := make(map[string][]string)
+	for _, event := range recorded {
+		if event.Action == audit.ActionUserImport {
+			targets[event.Target] = event.Fields
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		id:           {"username", "password", "verified", "role"},
+		verifiedID:   {"verified"},
+		created.UUID: {"username", "password"},
+	}, targets)
+}
+
+func TestImport_Fail(t *testing.T) {
+	storage := memory.NewStorage()
+	_, err := storage.Create(context.Background(), &user.User{Email: "taken@mail.com", Username: "taken"})
+	assert.NoError(t, err)
+
+	input := "email,username,password\n" +
+		"first@mail.com,first,qwerty\n" +
+		"second@mail.com,second,qwerty\n" +
+		"taken@mail.com,other,qwerty\n" +
+		"third@mail.com,third,qwerty\n"
+
+	checkpoint := filepath.Join(t.TempDir(), "users.csv.progress")
+	var report bytes.Buffer
+
+	importer := newImporter(t, storage, newTestHasher(t, password.Bcrypt))
+	result, err := importer.Import(context.Background(), strings.NewReader(input), transfer.Options{
+		Format:     transfer.FormatCSV,
+		BatchSize:  2,
+		Policy:     transfer.PolicyFail,
+		Checkpoint: checkpoint,
+		Report:     &report,
+	})
+	assert.ErrorIs(t, err, transfer.ErrDuplicate)
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 1, result.Failed)
+
+	// Nothing of the batch with the duplicate is written.
+	_, err = storage.FindByEmail(context.Background(), "third@mail.com")
+	assert.Error(t, err)
+
+	rows := readReport(t, &report)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, transfer.ReportRow{Line: 4, Email: "taken@mail.com", Status: transfer.StatusFailed, Error: apperror.ErrEmailTaken.Error()}, rows[0])
+	}
+
+	// Import continues from the failed batch once the duplicate is fixed.
+	fixed := strings.Replace(input, "taken@mail.com", "fixed@mail.com", 1)
+	result, err = importer.Import(context.Background(), strings.NewReader(fixed), transfer.Options{
+		Format:     transfer.FormatCSV,
+		BatchSize:  2,
+		Policy:     transfer.PolicyFail,
+		Checkpoint: checkpoint,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &transfer.Result{Rows: 2, Created: 2}, result)
+	findUser(t, storage, "fixed@mail.com")
+	findUser(t, storage, "third@mail.com")
+
+	_, err = os.Stat(checkpoint)
+	assert.True(t, os.IsNotExist(err), "checkpoint is removed when import is over")
+}
+
+func TestImport_DryRun(t *testing.T) {
+	storage := memory.NewStorage()
+	_, err := storage.Create(context.Background(), &user.User{Email: "taken@mail.com", Username: "taken"})
+	assert.NoError(t, err)
+
+	input := "email,username,password\n" +
+		"first@mail.com,first,qwerty\n" +
+		"taken@mail.com,other,qwerty\n" +
+		"first@mail.com,again,qwerty\n" +
+		"invalid,invalid,qwerty\n"
+
+	checkpoint := filepath.Join(t.TempDir(), "users.csv.progress")
+	var report bytes.Buffer
+
+	result, err := newImporter(t, storage, newTestHasher(t, password.Bcrypt)).Import(context.Background(), strings.NewReader(input), transfer.Options{
+		Format:     transfer.FormatCSV,
+		BatchSize:  1,
+		Policy:     transfer.PolicyFail,
+		DryRun:     true,
+		Checkpoint: checkpoint,
+		Report:     &report,
+	})
+	assert.NoError(t, err, "dry run doesn't stop on duplicates")
+	assert.Equal(t, &transfer.Result{Rows: 4, Created: 1, Invalid: 1, Failed: 2}, result)
+	assert.Len(t, readReport(t, &report), 3)
+
+	_, err = storage.FindByEmail(context.Background(), "first@mail.com")
+	assert.Error(t, err)
+
+	_, err = os.Stat(checkpoint)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// crashingStorage fails batch inserts after given number of batches.
+// The failing batch is inserted anyway, like when the process crashes
+// after the database has written it.
+type crashingStorage struct {
+	user.Storage
+	batches int
+}
+
+var errCrash = errors.New("crash")
+
+func (s *crashingStorage) CreateBatch(ctx context.Context, users []*user.User) ([]user.BatchResult, error) {
+	results, err := s.Storage.CreateBatch(ctx, users)
+	if s.batches--; s.batches < 0 {
+		return nil, errCrash
+	}
+	return results, err
+}
+
+func TestImport_Resume(t *testing.T) {
+	storage := memory.NewStorage()
+	hasher := newTestHasher(t, password.Bcrypt)
+
+	var input strings.Builder
+	input.WriteString("email,username,password\n")
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		input.WriteString(name + "@mail.com,user" + name + ",qwerty\n")
+	}
+
+	checkpoint := filepath.Join(t.TempDir(), "users.csv.progress")
+	opts := transfer.Options{
+		Format:     transfer.FormatCSV,
+		BatchSize:  3,
+		Policy:     transfer.PolicyFail,
+		Checkpoint: checkpoint,
+	}
+
+	result, err := newImporter(t, &crashingStorage{Storage: storage, batches: 1}, hasher).Import(context.Background(), strings.NewReader(input.String()), opts)
+	assert.ErrorIs(t, err, errCrash)
+	assert.Equal(t, 3, result.Created)
+
+	// Users of the interrupted batch aren't reported as duplicates.
+	result, err = newImporter(t, storage, hasher).Import(context.Background(), strings.NewReader(input.String()), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, &transfer.Result{Rows: 4, Created: 1, Resumed: 3}, result)
+
+	users, err := storage.List(context.Background(), &user.ListFilter{SortBy: user.SortByEmail})
+	assert.NoError(t, err)
+	assert.Len(t, users, 7)
+}
+
+func TestExport(t *testing.T) {
+	hasher := newTestHasher(t, password.Bcrypt)
+	source := memory.NewStorage()
+
+	for _, format := range []string{transfer.FormatCSV, transfer.FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			source := memory.NewStorage()
+			input := "email,username,password,verified,role,registeredAt\n" +
+				"b@mail.com,userb,qwerty,true,admin,2022-01-02T10:15:00Z\n" +
+				"a@mail.com,usera,asdfgh,false,user,2022/01/01\n"
+
+			_, err := newImporter(t, source, hasher).Import(context.Background(), strings.NewReader(input), transfer.Options{
+				Format: transfer.FormatCSV,
+				Policy: transfer.PolicyFail,
+			})
+			assert.NoError(t, err)
+
+			var exported bytes.Buffer
+			written, err := transfer.Export(context.Background(), source, &exported, format)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, written)
+			assert.Less(t, strings.Index(exported.String(), "a@mail.com"), strings.Index(exported.String(), "b@mail.com"))
+
+			target := memory.NewStorage()
+			result, err := newImporter(t, target, hasher).Import(context.Background(), &exported, transfer.Options{
+				Format: format,
+				Policy: transfer.PolicyFail,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 2, result.Created)
+
+			for _, email := range []string{"a@mail.com", "b@mail.com"} {
+				original, copied := findUser(t, source, email), findUser(t, target, email)
+				assert.Equal(t, original.Password, copied.Password)
+				assert.Equal(t, original.Username, copied.Username)
+				assert.Equal(t, original.Verified, copied.Verified)
+				assert.Equal(t, original.Role, copied.Role)
+				assert.Equal(t, original.RegisteredAt, copied.RegisteredAt)
+			}
+		})
+	}
+
+	var empty bytes.Buffer
+	written, err := transfer.Export(context.Background(), source, &empty, transfer.FormatCSV)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, written)
+	assert.Equal(t, "email,username,password,verified,role,registeredAt\n", empty.String())
+}
@@ -0,0 +1,214 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/juicyluv/sueta/user_service/app/pkg/totp"
+)
+
+const (
+	// recoveryCodeCount is a number of recovery codes issued on enrollment.
+	recoveryCodeCount = 10
+	// totpSkew is a number of TOTP steps accepted before and after current one.
+	totpSkew = 1
+)
+
+// recoveryEncoding is used to generate readable recovery codes.
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// EnrollTwoFactor generates a new TOTP secret for the user with provided uuid.
+// Two-factor authentication isn't required until it's confirmed with
+// the first code, so enrollment can be restarted any time before that.
+// Returns Two Factor Enabled error if it's enabled already.
+func (s *service) EnrollTwoFactor(ctx context.Context, uuid string) (*TwoFactorEnrollment, error) {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		return nil, apperror.ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.SetTwoFactor(ctx, user.UUID, &TwoFactor{Secret: secret}); err != nil {
+		s.logger.Warnf("failed to save two-factor secret: %v", err)
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(secret, s.tokens.Issuer(), user.Email),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication if provided code
+// matches enrolled secret. Returns recovery codes, which are stored
+// hashed and can't be shown again. Returns Two Factor Not Enabled error
+// if there's no pending enrollment and Invalid Code error if code is wrong.
+func (s *service) ConfirmTwoFactor(ctx context.Context, input *TwoFactorCodeDTO) (*RecoveryCodes, error) {
+	user, err := s.GetById(ctx, input.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor := user.TwoFactor
+	if twoFactor == nil {
+		return nil, apperror.ErrTwoFactorNotEnabled
+	}
+	if twoFactor.Enabled {
+		return nil, apperror.ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Match(twoFactor.Secret, input.Code, time.Now(), totpSkew, twoFactor.LastStep)
+	if !ok {
+		return nil, apperror.ErrInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor.Enabled = true
+	twoFactor.RecoveryCodes = hashes
+	twoFactor.LastStep = step
+
+	// The same code confirming enrollment twice concurrently
+	// would issue two sets of recovery codes.
+	if err := s.storage.CompareAndSetTwoFactor(ctx, user.UUID, user.Version, twoFactor); err != nil {
+		if errors.Is(err, apperror.ErrVersionConflict) {
+			return nil, apperror.ErrInvalidCode
+		}
+		s.logger.Warnf("failed to enable two-factor authentication: %v", err)
+		return nil, err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionTwoFactorEnable, Target: user.UUID, Fields: []string{"twoFactor"}})
+
+	return &RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// DisableTwoFactor disables two-factor authentication after checking
+// user password and the second factor. Returns Wrong Password error
+// if password doesn't match and Invalid Code error if code is wrong.
+func (s *service) DisableTwoFactor(ctx context.Context, input *DisableTwoFactorDTO) error {
+	user, err := s.GetById(ctx, input.UUID)
+	if err != nil {
+		return err
+	}
+
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		return apperror.ErrTwoFactorNotEnabled
+	}
+
+	if !user.ComparePassword(s.hasher, input.Password) {
+		return apperror.ErrWrongPassword
+	}
+
+	if err := s.VerifySecondFactor(ctx, user, input.Code); err != nil {
+		return err
+	}
+
+	if err := s.storage.CompareAndSetTwoFactor(ctx, user.UUID, user.Version, nil); err != nil {
+		if !errors.Is(err, apperror.ErrVersionConflict) {
+			s.logger.Warnf("failed to disable two-factor authentication: %v", err)
+		}
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionTwoFactorDisable, Target: user.UUID, Fields: []string{"twoFactor"}})
+
+	return nil
+}
+
+// VerifySecondFactor checks one-time or recovery code of the user.
+// Accepted one-time code can't be used again and recovery code
+// is removed once used. The code is accepted only if the user hasn't
+// changed since it has been read, so concurrent requests can't accept
+// the same code twice. Returns Invalid Code error if code is wrong
+// or has been accepted by another request. Version of the user is
+// updated on success.
+func (s *service) VerifySecondFactor(ctx context.Context, user *User, code string) error {
+	if user.TwoFactor == nil || !user.TwoFactor.Enabled {
+		return apperror.ErrTwoFactorNotEnabled
+	}
+
+	// Change a copy, so the user keeps the state it has been read with
+	// if the code isn't accepted.
+	twoFactor := *user.TwoFactor
+	twoFactor.RecoveryCodes = append([]string(nil), user.TwoFactor.RecoveryCodes...)
+
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Match(twoFactor.Secret, code, time.Now(), totpSkew, twoFactor.LastStep)
+		if !ok {
+			return apperror.ErrInvalidCode
+		}
+		twoFactor.LastStep = step
+	} else {
+		hash := hashRecoveryCode(code)
+		used := -1
+		for i, h := range twoFactor.RecoveryCodes {
+			if h == hash {
+				used = i
+				break
+			}
+		}
+		if used < 0 {
+			return apperror.ErrInvalidCode
+		}
+		twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:used], twoFactor.RecoveryCodes[used+1:]...)
+	}
+
+	if err := s.storage.CompareAndSetTwoFactor(ctx, user.UUID, user.Version, &twoFactor); err != nil {
+		if errors.Is(err, apperror.ErrVersionConflict) {
+			return apperror.ErrInvalidCode
+		}
+		s.logger.Warnf("failed to update two-factor state: %v", err)
+		return err
+	}
+
+	user.TwoFactor = &twoFactor
+	user.Version++
+	return nil
+}
+
+// newRecoveryCodes generates recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("cannot generate recovery code: %w", err)
+		}
+
+		code := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns hash of the recovery code ignoring case
+// and separators, so the code can be typed in any form.
+func hashRecoveryCode(code string) string {
+	return token.Hash(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+}
@@ -0,0 +1,98 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/internal/audit"
+	"github.com/juicyluv/sueta/user_service/app/internal/user/apperror"
+	"github.com/juicyluv/sueta/user_service/app/pkg/mail"
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+)
+
+// verificationResendInterval is a minimal interval between two
+// verification emails sent to the same user.
+const verificationResendInterval = time.Minute
+
+// Verify checks given verification token and marks the user email
+// as verified. Token must be issued for the user with given uuid and
+// for the current user email. Returns Invalid Token error if token
+// cannot be used and nil if email has been verified already.
+func (s *service) Verify(ctx context.Context, uuid, verificationToken string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if user.Verified {
+		return nil
+	}
+
+	claims, err := s.tokens.Parse(verificationToken, token.Verification)
+	if err != nil {
+		return apperror.ErrInvalidToken
+	}
+
+	if claims.Subject != user.UUID || claims.Email != user.Email {
+		return apperror.ErrInvalidToken
+	}
+
+	if err := s.storage.UpdatePartially(ctx, &User{UUID: user.UUID, Verified: true}); err != nil {
+		s.logger.Warnf("failed to verify the user: %v", err)
+		return err
+	}
+
+	s.record(ctx, &audit.Event{Action: audit.ActionUserVerify, Target: user.UUID, Fields: []string{"verified"}})
+	return nil
+}
+
+// ResendVerification sends verification email to the user with given uuid again.
+// Returns Already Verified error if email has been verified already and
+// Too Many Requests error if previous email has been sent recently.
+func (s *service) ResendVerification(ctx context.Context, uuid string) error {
+	user, err := s.GetById(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if user.Verified {
+		return apperror.ErrAlreadyVerified
+	}
+
+	if time.Since(user.VerificationSentAt) < verificationResendInterval {
+		return apperror.ErrTooManyRequests
+	}
+
+	if err := s.sendVerification(ctx, user); err != nil {
+		s.logger.Warnf("failed to send verification email: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// sendVerification issues verification token, sends it to the user email
+// and remembers when it has been sent.
+func (s *service) sendVerification(ctx context.Context, user *User) error {
+	verificationToken, err := s.tokens.NewToken(token.Verification, user.UUID, user.Email, "")
+	if err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nTo verify your email, send the token below to /api/users/%s/verify.\n"+
+				"The token expires in %s.\n\nToken: %s\n",
+			user.Username, user.UUID, s.tokens.TTL(token.Verification), verificationToken,
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	user.VerificationSentAt = time.Now().UTC()
+	return s.storage.UpdatePartially(ctx, &User{UUID: user.UUID, VerificationSentAt: user.VerificationSentAt})
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Role describes what the user is allowed to do.
+type Role string
+
+const (
+	// RoleUser is a default role of registered users.
+	RoleUser Role = "user"
+	// RoleModerator is allowed to moderate content of other users.
+	RoleModerator Role = "moderator"
+	// RoleAdmin is allowed to do anything.
+	RoleAdmin Role = "admin"
+)
+
+// Roles contains all known roles.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+// Scope limits what a request authenticated with API key is allowed to do.
+// Requests authenticated with access tokens are not limited by scopes.
+type Scope string
+
+const (
+	// ScopeRead allows safe methods, e.g. GET.
+	ScopeRead Scope = "read"
+	// ScopeWrite allows methods which change resources.
+	ScopeWrite Scope = "write"
+)
+
+// Scopes contains all known scopes.
+var Scopes = []Scope{ScopeRead, ScopeWrite}
+
+// APIKeyPrefix starts every API key, so the middleware can tell
+// API keys from access tokens.
+const APIKeyPrefix = "sueta_"
+
+// KeyVerifier verifies API keys. VerifyKey returns claims of the user
+// who owns the key with token.APIKey type and scopes of the key.
+// ip is the address of the client using the key.
+type KeyVerifier interface {
+	VerifyKey(ctx context.Context, key, ip string) (*token.Claims, error)
+}
+
+var (
+	// ErrNoOwner is returned by OwnerFunc when requested resource doesn't exist.
+	ErrNoOwner = errors.New("resource has no owner")
+)
+
+// OwnerFunc returns uuid of the user who owns requested resource.
+type OwnerFunc func(r *http.Request) (string, error)
+
+// Rule describes who is allowed to access a route. Users with one of
+// the Roles are always allowed. If Owner is set, the owner of requested
+// resource is allowed too. Rule without roles and owner allows any
+// authenticated user. Token is the type of tokens the route accepts,
+// access tokens and API keys if it's empty. Single purpose tokens are
+// accepted only by routes of their type.
+type Rule struct {
+	Roles []Role
+	Owner OwnerFunc
+	Token token.Type
+}
+
+type contextKey struct{}
+
+// Middleware authenticates requests with access tokens or API keys
+// passed in Authorization header and checks permissions of the caller.
+type Middleware struct {
+	secret string
+	keys   KeyVerifier
+}
+
+// NewMiddleware returns a new Middleware that verifies access tokens
+// signed with given secret and API keys with given verifier.
+// If keys is nil, API keys are not accepted.
+func NewMiddleware(secret string, keys KeyVerifier) *Middleware {
+	return &Middleware{secret: secret, keys: keys}
+}
+
+// Authorize wraps given handler. Request passes through if it carries
+// valid token of the rule type and the caller satisfies given rule.
+// Otherwise responses with 401 Unauthorized or 403 Forbidden status code.
+// Token claims are available in handler with ClaimsFromContext.
+func (m *Middleware) Authorize(rule Rule, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := m.authenticate(r, rule.Token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err.Error(), "please, provide valid access token")
+			return
+		}
+
+		if !HasScope(claims, RequiredScope(r.Method)) {
+			writeError(w, http.StatusForbidden, "insufficient scope", "API key doesn't have scope required for this request")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, claims)
+		r = r.WithContext(ctx)
+
+		allowed, err := rule.allows(r, claims)
+		if err != nil {
+			if errors.Is(err, ErrNoOwner) {
+				writeError(w, http.StatusNotFound, "requested resource is not found", "please, double check your request")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+
+		if !allowed {
+			writeError(w, http.StatusForbidden, "access denied", "you don't have permission to access this resource")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ClaimsFromContext returns access token claims stored by Authorize.
+func ClaimsFromContext(ctx context.Context) (*token.Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*token.Claims)
+	return claims, ok
+}
+
+// OwnerParam returns OwnerFunc that treats given route parameter
+// as uuid of the owner, e.g. for /users/:uuid routes.
+func OwnerParam(name string) OwnerFunc {
+	return func(r *http.Request) (string, error) {
+		return httprouter.ParamsFromContext(r.Context()).ByName(name), nil
+	}
+}
+
+// HasRole reports whether the caller has one of given roles.
+// Claims without a role are treated as RoleUser.
+func HasRole(claims *token.Claims, roles ...Role) bool {
+	role := Role(claims.Role)
+	if role == "" {
+		role = RoleUser
+	}
+
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredScope returns scope an API key needs to make
+// a request with given method.
+func RequiredScope(method string) Scope {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// HasScope reports whether the caller is allowed to act within given scope.
+// Claims of access tokens have every scope.
+func HasScope(claims *token.Claims, scope Scope) bool {
+	if claims.Type != token.APIKey {
+		return true
+	}
+
+	for _, s := range claims.Scopes {
+		if Scope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns IP address of the client without port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsValidRole reports whether given role is known.
+func IsValidRole(role Role) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate verifies bearer token of given type or API key from
+// Authorization header. API keys are accepted only instead of access tokens.
+func (m *Middleware) authenticate(r *http.Request, typ token.Type) (*token.Claims, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("missing access token")
+	}
+
+	if typ == "" {
+		typ = token.Access
+	}
+
+	bearer := strings.TrimPrefix(header, "Bearer ")
+	if strings.HasPrefix(bearer, APIKeyPrefix) {
+		if m.keys == nil || typ != token.Access {
+			return nil, errors.New("API keys are not accepted")
+		}
+		return m.keys.VerifyKey(r.Context(), bearer, ClientIP(r))
+	}
+
+	return token.Verify(bearer, m.secret, typ)
+}
+
+// allows reports whether the caller with given claims satisfies the rule.
+func (rule Rule) allows(r *http.Request, claims *token.Claims) (bool, error) {
+	if len(rule.Roles) == 0 && rule.Owner == nil {
+		return true, nil
+	}
+
+	if HasRole(claims, rule.Roles...) {
+		return true, nil
+	}
+
+	if rule.Owner == nil {
+		return false, nil
+	}
+
+	owner, err := rule.Owner(r)
+	if err != nil {
+		return false, err
+	}
+
+	return owner != "" && owner == claims.Subject, nil
+}
+
+// errorResponse has the same shape as error responses of the services.
+type errorResponse struct {
+	Message          string `json:"message,omitempty"`
+	DeveloperMessage string `json:"developerMessage,omitempty"`
+	HttpCode         int    `json:"code,omitempty"`
+}
+
+// writeError responses with given status code and error message in JSON format.
+func writeError(w http.ResponseWriter, code int, message, developerMessage string) {
+	obj, err := json.Marshal(&errorResponse{
+		Message:          message,
+		DeveloperMessage: developerMessage,
+		HttpCode:         code,
+	})
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(obj)
+}
@@ -0,0 +1,45 @@
+// Package etag converts resource versions to entity tags and back,
+// so clients can make conditional requests with If-Match header.
+package etag
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is returned when If-Match header is malformed.
+var ErrInvalid = errors.New("invalid If-Match header")
+
+// Format returns a strong entity tag of given version.
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Set sets ETag header of the response to the tag of given version.
+func Set(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", Format(version))
+}
+
+// IfMatch returns the version requested by If-Match header.
+// Returns nil if the header is missing or is "*", since any
+// version matches then. Weak tags never match, so they're
+// reported as Invalid error along with malformed tags.
+func IfMatch(r *http.Request) (*int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return nil, ErrInvalid
+	}
+
+	version, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil || version < 0 {
+		return nil, ErrInvalid
+	}
+
+	return &version, nil
+}
@@ -0,0 +1,51 @@
+package etag_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/etag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, `"0"`, etag.Format(0))
+	assert.Equal(t, `"42"`, etag.Format(42))
+}
+
+func TestIfMatch(t *testing.T) {
+	testCases := []struct {
+		name            string
+		header          string
+		expectedVersion *int64
+		expectedError   error
+	}{
+		{name: "missing"},
+		{name: "any", header: "*"},
+		{name: "version", header: `"7"`, expectedVersion: version(7)},
+		{name: "formatted version", header: etag.Format(12), expectedVersion: version(12)},
+		{name: "spaces", header: ` "3" `, expectedVersion: version(3)},
+		{name: "unquoted", header: "7", expectedError: etag.ErrInvalid},
+		{name: "weak", header: `W/"7"`, expectedError: etag.ErrInvalid},
+		{name: "not a number", header: `"abc"`, expectedError: etag.ErrInvalid},
+		{name: "negative", header: `"-1"`, expectedError: etag.ErrInvalid},
+		{name: "list", header: `"1", "2"`, expectedError: etag.ErrInvalid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/", nil)
+			if tc.header != "" {
+				r.Header.Set("If-Match", tc.header)
+			}
+
+			v, err := etag.IfMatch(r)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedVersion, v)
+		})
+	}
+}
+
+func version(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,131 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// maxBackoffShift limits exponent of progressive backoff to avoid overflow.
+const maxBackoffShift = 30
+
+// Store keeps failed attempts per key within a sliding window.
+type Store interface {
+	// Add records an attempt made at given time, forgets attempts
+	// of the key made before at - window and returns number of the
+	// remaining ones. Attempts are added and counted atomically, so
+	// concurrent attempts get different counts.
+	Add(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	// Get returns number of attempts of the key made since given time
+	// and time of the latest one.
+	Get(ctx context.Context, key string, since time.Time) (int, time.Time, error)
+	// Reset forgets all attempts of the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy describes how failed attempts are throttled.
+// Failed attempts are counted within Window. Once there are Threshold
+// of them, every next attempt must wait BaseDelay doubled for each
+// attempt over the threshold. Once there are MaxAttempts of them,
+// the key is locked out for Lockout since the latest attempt.
+type Policy struct {
+	Window      time.Duration
+	Threshold   int
+	BaseDelay   time.Duration
+	MaxAttempts int
+	Lockout     time.Duration
+}
+
+// Limiter throttles failed attempts according to the policy.
+type Limiter struct {
+	store  Store
+	prefix string
+	policy Policy
+	now    func() time.Time
+}
+
+// NewLimiter returns a new Limiter. Keys are stored with given prefix,
+// so limiters with different policies may share the same store.
+// Window is extended to lockout duration if it's shorter,
+// so attempts are not forgotten while the key is locked out.
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	if policy.Window < policy.Lockout {
+		policy.Window = policy.Lockout
+	}
+
+	return &Limiter{
+		store:  store,
+		prefix: prefix,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// RetryAfter returns how long the key must wait before the next attempt.
+// Returns zero if the attempt is allowed right now.
+func (l *Limiter) RetryAfter(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now()
+
+	count, last, err := l.store.Get(ctx, l.prefix+key, now.Add(-l.policy.Window))
+	if err != nil {
+		return 0, err
+	}
+
+	wait := l.delay(count)
+	if wait == 0 {
+		return 0, nil
+	}
+
+	retryAfter := last.Add(wait).Sub(now)
+	if retryAfter < 0 {
+		return 0, nil
+	}
+
+	return retryAfter, nil
+}
+
+// Fail records a failed attempt of the key and returns number
+// of failed attempts within the window including this one.
+func (l *Limiter) Fail(ctx context.Context, key string) (int, error) {
+	return l.store.Add(ctx, l.prefix+key, l.now(), l.policy.Window)
+}
+
+// Exceeded returns how long the key is locked out if given number
+// of attempts returned by Fail is over MaxAttempts. Returns zero otherwise.
+// Unlike RetryAfter, it's decided by the attempt itself, so attempts
+// made concurrently after RetryAfter has allowed them are limited too.
+func (l *Limiter) Exceeded(count int) time.Duration {
+	if l.policy.MaxAttempts > 0 && count > l.policy.MaxAttempts {
+		return l.policy.Lockout
+	}
+	return 0
+}
+
+// Reset forgets failed attempts of the key, e.g. after successful one.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, l.prefix+key)
+}
+
+// delay returns how long to wait after the latest of given number of attempts.
+func (l *Limiter) delay(count int) time.Duration {
+	p := l.policy
+
+	if p.MaxAttempts > 0 && count >= p.MaxAttempts {
+		return p.Lockout
+	}
+
+	if p.Threshold <= 0 || count < p.Threshold {
+		return 0
+	}
+
+	shift := count - p.Threshold
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+
+	delay := p.BaseDelay << shift
+	if p.Lockout > 0 && delay > p.Lockout {
+		delay = p.Lockout
+	}
+
+	return delay
+}
@@ -0,0 +1,218 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var policy = Policy{
+	Window:      10 * time.Minute,
+	Threshold:   3,
+	BaseDelay:   time.Second,
+	MaxAttempts: 6,
+	Lockout:     5 * time.Minute,
+}
+
+// newTestLimiter returns a limiter with a clock that can be moved by advance.
+func newTestLimiter() (*Limiter, func(d time.Duration)) {
+	now := time.Date(2022, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	l := NewLimiter(NewMemoryStore(), "login:", policy)
+	l.now = func() time.Time { return now }
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+// fail records a failed attempt of test key and returns number of attempts.
+func fail(t *testing.T, l *Limiter) int {
+	count, err := l.Fail(context.Background(), "test@mail.com")
+	assert.NoError(t, err)
+	return count
+}
+
+func TestLimiter_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		failures int
+		wait     time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "below threshold",
+			failures: 2,
+			expected: 0,
+		},
+		{
+			name:     "reached threshold",
+			failures: 3,
+			expected: time.Second,
+		},
+		{
+			name:     "progressive backoff",
+			failures: 5,
+			expected: 4 * time.Second,
+		},
+		{
+			name:     "backoff is over",
+			failures: 5,
+			wait:     4 * time.Second,
+			expected: 0,
+		},
+		{
+			name:     "locked out",
+			failures: 6,
+			wait:     time.Minute,
+			expected: 4 * time.Minute,
+		},
+		{
+			name:     "lockout is over",
+			failures: 6,
+			wait:     5 * time.Minute,
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			l, advance := newTestLimiter()
+
+			for i := 0; i < tc.failures; i++ {
+				fail(t, l)
+			}
+			advance(tc.wait)
+
+			retryAfter, err := l.RetryAfter(ctx, "test@mail.com")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, retryAfter)
+		})
+	}
+}
+
+func TestLimiter_SlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l, advance := newTestLimiter()
+
+	fail(t, l)
+	fail(t, l)
+	advance(policy.Window)
+	fail(t, l)
+
+	// Earlier attempts are outside of the window now.
+	retryAfter, err := l.RetryAfter(ctx, "test@mail.com")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l, _ := newTestLimiter()
+
+	for i := 0; i < policy.MaxAttempts; i++ {
+		fail(t, l)
+	}
+
+	assert.NoError(t, l.Reset(ctx, "test@mail.com"))
+
+	retryAfter, err := l.RetryAfter(ctx, "test@mail.com")
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func TestLimiter_Exceeded(t *testing.T) {
+	t.Parallel()
+
+	l, _ := newTestLimiter()
+
+	for i := 1; i <= policy.MaxAttempts; i++ {
+		count := fail(t, l)
+		assert.Equal(t, i, count)
+		assert.Zero(t, l.Exceeded(count))
+	}
+
+	assert.Equal(t, policy.Lockout, l.Exceeded(fail(t, l)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	testStore(t, NewMemoryStore())
+}
+
+// testStore checks given store against Store contract.
+// Every store must pass it.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+	defer store.Reset(ctx, key)
+
+	start := time.Now()
+	window := time.Minute
+
+	count, last, err := store.Get(ctx, key, start.Add(-window))
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+	assert.True(t, last.IsZero())
+
+	for i := 0; i < 3; i++ {
+		count, err := store.Add(ctx, key, start.Add(time.Duration(i)*time.Second), window)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, count)
+	}
+
+	count, last, err = store.Get(ctx, key, start.Add(-window))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.True(t, last.Equal(start.Add(2*time.Second)), last)
+
+	count, _, err = store.Get(ctx, key, start)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count, "attempts made before since aren't counted")
+
+	// Attempts made before the window are forgotten.
+	count, err = store.Add(ctx, key, start.Add(window+time.Second), window)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Concurrent attempts get different counts.
+	assert.NoError(t, store.Reset(ctx, key))
+
+	const attempts = 20
+	counts := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			counts[i], err = store.Add(ctx, key, time.Now(), window)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Ints(counts)
+	for i, count := range counts {
+		assert.Equal(t, i+1, count)
+	}
+
+	assert.NoError(t, store.Reset(ctx, key))
+	count, _, err = store.Get(ctx, key, start.Add(-window))
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
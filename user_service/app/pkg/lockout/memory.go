@@ -0,0 +1,97 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check whether memoryStore implements Store interface.
+var _ Store = &memoryStore{}
+
+// memoryStore keeps attempts in memory.
+// It's suitable for a single instance and tests.
+type memoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	attempts []time.Time
+	expires  time.Time
+}
+
+// NewMemoryStore returns a new in-memory Store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		entries: make(map[string]*entry),
+	}
+}
+
+// Add records an attempt, forgets outdated ones and returns
+// number of the remaining ones. Expired keys are swept once per window.
+func (s *memoryStore) Add(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if at.Sub(s.lastSweep) > window {
+		for k, e := range s.entries {
+			if at.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = at
+	}
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &entry{}
+		s.entries[key] = e
+	}
+
+	since := at.Add(-window)
+	kept := e.attempts[:0]
+	for _, t := range e.attempts {
+		if t.After(since) {
+			kept = append(kept, t)
+		}
+	}
+
+	e.attempts = append(kept, at)
+	e.expires = at.Add(window)
+	return len(e.attempts), nil
+}
+
+// Get returns number of attempts made since given time and the latest of them.
+func (s *memoryStore) Get(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+
+	var count int
+	var last time.Time
+	for _, t := range e.attempts {
+		if t.After(since) {
+			count++
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	return count, last, nil
+}
+
+// Reset forgets all attempts of the key.
+func (s *memoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,87 @@
+package lockout
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Check whether redisStore implements Store interface.
+var _ Store = &redisStore{}
+
+// redisStore keeps attempts of each key in a sorted set scored by
+// attempt time, so it can be shared by multiple instances.
+type redisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore returns a new Store backed by Redis.
+func NewRedisStore(client *redis.Client) Store {
+	return &redisStore{client: client}
+}
+
+// Add records an attempt, forgets outdated ones, makes the key
+// expire when the window is over and returns number of the remaining
+// attempts. Commands run in a transaction, so the count includes
+// attempts added concurrently before this one, but none after it.
+func (s *redisStore) Add(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	member, err := attemptID(at)
+	if err != nil {
+		return 0, err
+	}
+
+	var count *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(at.UnixNano()), Member: member})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(at.Add(-window).UnixNano(), 10))
+		pipe.PExpire(ctx, key, window)
+		count = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count.Val()), nil
+}
+
+// Get returns number of attempts made since given time and the latest of them.
+func (s *redisStore) Get(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	var count *redis.IntCmd
+	var last *redis.ZSliceCmd
+
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.ZCount(ctx, key, "("+strconv.FormatInt(since.UnixNano(), 10), "+inf")
+		last = pipe.ZRevRangeWithScores(ctx, key, 0, 0)
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var lastAt time.Time
+	if latest := last.Val(); len(latest) > 0 {
+		lastAt = time.Unix(0, int64(latest[0].Score))
+	}
+
+	return int(count.Val()), lastAt, nil
+}
+
+// Reset forgets all attempts of the key.
+func (s *redisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+// attemptID returns unique sorted set member for an attempt made at given time.
+func attemptID(at time.Time) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", at.UnixNano(), hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,31 @@
+package lockout
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestRedisStore runs store contract tests against Redis
+// available at REDIS_URL. Skipped if it's not set.
+func TestRedisStore(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not set")
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		t.Fatalf("invalid redis url: %v", err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("cannot connect to redis: %v", err)
+	}
+
+	testStore(t, NewRedisStore(client))
+}
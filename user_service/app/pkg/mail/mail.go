@@ -0,0 +1,15 @@
+package mail
+
+import "context"
+
+// Message represents an email message.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer describes email sending functionality.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
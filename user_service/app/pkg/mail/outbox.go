@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check whether Outbox implements Mailer interface.
+var _ Mailer = &Outbox{}
+
+// Outbox is a Mailer that writes messages to files in a directory
+// instead of sending them. It's used in tests and local development
+// to read sent messages without a mail server.
+type Outbox struct {
+	mu      sync.Mutex
+	dir     string
+	counter int
+}
+
+// NewOutbox returns a new Outbox that writes messages to given directory.
+// The directory is created if it doesn't exist.
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create outbox directory: %w", err)
+	}
+
+	return &Outbox{dir: dir}, nil
+}
+
+// Send writes given message to a new JSON file in outbox directory.
+func (o *Outbox) Send(ctx context.Context, msg *Message) error {
+	data, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.counter++
+	name := fmt.Sprintf("%d-%06d.json", time.Now().UTC().UnixNano(), o.counter)
+
+	if err := ioutil.WriteFile(filepath.Join(o.dir, name), data, 0644); err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+
+	return nil
+}
+
+// Messages returns all messages written to outbox in order they were sent.
+func (o *Outbox) Messages() ([]Message, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list outbox: %w", err)
+	}
+	sort.Strings(files)
+
+	messages := make([]Message, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read message: %w", err)
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
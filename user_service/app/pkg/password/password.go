@@ -0,0 +1,116 @@
+// Package password hashes passwords and stores algorithm parameters
+// inside the encoded hash, so hashes made with different algorithms
+// or costs can be verified and upgraded later.
+package password
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Bcrypt hashes passwords with bcrypt. Encoded hash has $2a$ prefix.
+	Bcrypt = "bcrypt"
+	// Argon2id hashes passwords with argon2id. Encoded hash has $argon2id$ prefix.
+	Argon2id = "argon2id"
+)
+
+var (
+	// ErrUnsupportedHash is used when encoded hash was made with unknown algorithm.
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+
+	// ErrMalformedHash is used when encoded hash can't be decoded.
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+// Hasher hashes passwords and compares them with encoded hashes.
+type Hasher interface {
+	// Hash returns encoded hash of the password with algorithm parameters.
+	Hash(password string) (string, error)
+	// Compare reports whether the password matches encoded hash.
+	// Returns Unsupported Hash error if the hash was made with other algorithm.
+	Compare(hash, password string) (bool, error)
+	// NeedsRehash reports whether encoded hash was made
+	// with other algorithm or parameters.
+	NeedsRehash(hash string) bool
+}
+
+// Params describes the algorithm used for new hashes and its costs.
+type Params struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// New returns a hasher which hashes passwords with the algorithm
+// from given params, but compares passwords with hashes made
+// by any supported algorithm. NeedsRehash reports hashes which
+// differ from given params.
+func New(params Params) (Hasher, error) {
+	bcryptHasher, err := NewBcrypt(params.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	argon2Hasher, err := NewArgon2id(params.Argon2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch params.Algorithm {
+	case Bcrypt:
+		return &multiHasher{current: bcryptHasher, others: []Hasher{argon2Hasher}}, nil
+	case Argon2id:
+		return &multiHasher{current: argon2Hasher, others: []Hasher{bcryptHasher}}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %s", params.Algorithm)
+	}
+}
+
+// IsHash reports whether s is a well-formed hash made by a supported
+// algorithm, so it can be stored as is instead of being hashed again.
+func IsHash(s string) bool {
+	if isBcrypt(s) {
+		_, err := bcrypt.Cost([]byte(s))
+		return err == nil && len(s) == bcryptHashLen
+	}
+
+	_, _, _, err := decodeArgon2(s)
+	return err == nil
+}
+
+// multiHasher hashes with current hasher and compares with any of them.
+type multiHasher struct {
+	current Hasher
+	others  []Hasher
+}
+
+// Hash returns encoded hash made by current hasher.
+func (h *multiHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Compare compares the password with the hash using the hasher
+// which supports it.
+func (h *multiHasher) Compare(hash, password string) (bool, error) {
+	ok, err := h.current.Compare(hash, password)
+	if !errors.Is(err, ErrUnsupportedHash) {
+		return ok, err
+	}
+
+	for _, other := range h.others {
+		ok, err := other.Compare(hash, password)
+		if !errors.Is(err, ErrUnsupportedHash) {
+			return ok, err
+		}
+	}
+
+	return false, ErrUnsupportedHash
+}
+
+// NeedsRehash reports whether the hash differs from current hasher parameters.
+func (h *multiHasher) NeedsRehash(hash string) bool {
+	return h.current.NeedsRehash(hash)
+}
@@ -0,0 +1,179 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func newTestHasher(t *testing.T, algorithm string) Hasher {
+	h, err := New(Params{Algorithm: algorithm, BcryptCost: bcrypt.MinCost, Argon2: testArgon2Params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHasher_Compare(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range []string{Bcrypt, Argon2id} {
+		algorithm := algorithm
+		t.Run(algorithm, func(t *testing.T) {
+			h := newTestHasher(t, algorithm)
+
+			hash, err := h.Hash("qwerty")
+			assert.NoError(t, err)
+			assert.Contains(t, hash, "$")
+			assert.False(t, h.NeedsRehash(hash))
+
+			ok, err := h.Compare(hash, "qwerty")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = h.Compare(hash, "qwerty1")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			another, err := h.Hash("qwerty")
+			assert.NoError(t, err)
+			assert.NotEqual(t, hash, another)
+		})
+	}
+}
+
+func TestHasher_CompareOtherAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	bcryptHasher := newTestHasher(t, Bcrypt)
+	argon2Hasher := newTestHasher(t, Argon2id)
+
+	hash, err := bcryptHasher.Hash("qwerty")
+	assert.NoError(t, err)
+
+	ok, err := argon2Hasher.Compare(hash, "qwerty")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, argon2Hasher.NeedsRehash(hash))
+
+	hash, err = argon2Hasher.Hash("qwerty")
+	assert.NoError(t, err)
+
+	ok, err = bcryptHasher.Compare(hash, "qwerty")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, bcryptHasher.NeedsRehash(hash))
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	t.Parallel()
+
+	bcryptHasher := newTestHasher(t, Bcrypt)
+	hash, err := bcryptHasher.Hash("qwerty")
+	assert.NoError(t, err)
+
+	stronger, err := New(Params{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1, Argon2: testArgon2Params})
+	assert.NoError(t, err)
+	assert.True(t, stronger.NeedsRehash(hash))
+
+	argon2Hasher := newTestHasher(t, Argon2id)
+	hash, err = argon2Hasher.Hash("qwerty")
+	assert.NoError(t, err)
+
+	params := testArgon2Params
+	params.Iterations++
+	stronger, err = New(Params{Algorithm: Argon2id, BcryptCost: bcrypt.MinCost, Argon2: params})
+	assert.NoError(t, err)
+	assert.True(t, stronger.NeedsRehash(hash))
+
+	// Hash made with old params still matches.
+	ok, err := stronger.Compare(hash, "qwerty")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestHasher_InvalidHash(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHasher(t, Argon2id)
+
+	testCases := []struct {
+		name     string
+		hash     string
+		expected error
+	}{
+		{
+			name:     "unknown algorithm",
+			hash:     "$md5$qwerty",
+			expected: ErrUnsupportedHash,
+		},
+		{
+			name:     "plain text",
+			hash:     "qwerty",
+			expected: ErrUnsupportedHash,
+		},
+		{
+			name:     "missing key",
+			hash:     "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+			expected: ErrMalformedHash,
+		},
+		{
+			name:     "invalid params",
+			hash:     "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+			expected: ErrMalformedHash,
+		},
+		{
+			name:     "invalid bcrypt",
+			hash:     "$2a$04$short",
+			expected: ErrMalformedHash,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ok, err := h.Compare(tc.hash, "qwerty")
+			assert.ErrorIs(t, err, tc.expected)
+			assert.False(t, ok)
+			assert.True(t, h.NeedsRehash(tc.hash))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(Params{Algorithm: "md5", BcryptCost: bcrypt.MinCost, Argon2: testArgon2Params})
+	assert.Error(t, err)
+
+	_, err = New(Params{Algorithm: Bcrypt, BcryptCost: bcrypt.MaxCost + 1, Argon2: testArgon2Params})
+	assert.Error(t, err)
+
+	_, err = New(Params{Algorithm: Argon2id, BcryptCost: bcrypt.MinCost})
+	assert.Error(t, err)
+}
+
+func TestIsHash(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range []string{Bcrypt, Argon2id} {
+		hash, err := newTestHasher(t, algorithm).Hash("qwerty")
+		assert.NoError(t, err)
+		assert.True(t, IsHash(hash), algorithm)
+	}
+
+	for _, s := range []string{
+		"qwerty",
+		"$md5$qwerty",
+		"$2a$04$short",
+		"$2a$99$" + strings.Repeat("a", 53),
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+	} {
+		assert.False(t, IsHash(s), s)
+	}
+}
@@ -0,0 +1,157 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policy rules reported by RuleError.
+const (
+	RuleMinLength = "minLength"
+	RuleMaxLength = "maxLength"
+	RuleEncoding  = "encoding"
+	RuleBlocklist = "blocklist"
+	RuleEmail     = "email"
+	RuleUsername  = "username"
+)
+
+// minIdentityLength is the shortest email local part or username
+// passwords are checked against. Shorter ones, e.g. "al", are parts
+// of too many words to reject every password containing them.
+const minIdentityLength = 4
+
+// RuleError is returned when the password violates a policy rule.
+type RuleError struct {
+	Rule    string
+	Message string
+}
+
+// Error returns the message prefixed with the rule name.
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Rule, e.Message)
+}
+
+// Policy describes which passwords are accepted.
+// Length is counted in characters, so any Unicode is allowed.
+type Policy struct {
+	MinLength int
+	MaxLength int
+
+	blocklist map[[sha1.Size]byte]struct{}
+}
+
+// NewPolicy returns a policy with given length limits. If blocklistPath
+// is not empty, passwords listed in the file are rejected.
+//
+// Every line of the file is either a password or SHA-1 hash of the password
+// in hex, optionally followed by a colon and a count as in breached password
+// dumps. Empty lines and lines starting with # are skipped. Listed passwords
+// are matched case-insensitively, hashes are matched exactly.
+func NewPolicy(minLength, maxLength int, blocklistPath string) (*Policy, error) {
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid password length limits: %d-%d", minLength, maxLength)
+	}
+
+	p := &Policy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		blocklist: make(map[[sha1.Size]byte]struct{}),
+	}
+
+	if blocklistPath == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(blocklistPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open password blocklist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.block(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read password blocklist: %w", err)
+	}
+
+	return p, nil
+}
+
+// block adds the blocklist line to the policy.
+func (p *Policy) block(line string) {
+	hash := line
+	if i := strings.IndexByte(line, ':'); i == sha1.Size*2 {
+		hash = line[:i]
+	}
+
+	if len(hash) == sha1.Size*2 {
+		var sum [sha1.Size]byte
+		if _, err := hex.Decode(sum[:], []byte(hash)); err == nil {
+			p.blocklist[sum] = struct{}{}
+			return
+		}
+	}
+
+	p.blocklist[sha1.Sum([]byte(strings.ToLower(line)))] = struct{}{}
+}
+
+// Blocked reports whether the password is in the blocklist.
+func (p *Policy) Blocked(password string) bool {
+	if _, ok := p.blocklist[sha1.Sum([]byte(password))]; ok {
+		return true
+	}
+	_, ok := p.blocklist[sha1.Sum([]byte(strings.ToLower(password)))]
+	return ok
+}
+
+// Check returns Rule Error if the password violates the policy.
+// Passwords containing given email are rejected, as well as passwords
+// containing its local part or username, if they're at least
+// minIdentityLength characters long.
+func (p *Policy) Check(password, email, username string) error {
+	if !utf8.ValidString(password) {
+		return &RuleError{Rule: RuleEncoding, Message: "must be valid UTF-8 text"}
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return &RuleError{Rule: RuleMinLength, Message: fmt.Sprintf("must be at least %d characters long", p.MinLength)}
+	}
+	if length > p.MaxLength {
+		return &RuleError{Rule: RuleMaxLength, Message: fmt.Sprintf("must be at most %d characters long", p.MaxLength)}
+	}
+
+	lower := strings.ToLower(password)
+
+	if email != "" {
+		email = strings.ToLower(email)
+		local := email
+		if i := strings.LastIndexByte(email, '@'); i > 0 {
+			local = email[:i]
+		}
+		if strings.Contains(lower, email) || (utf8.RuneCountInString(local) >= minIdentityLength && strings.Contains(lower, local)) {
+			return &RuleError{Rule: RuleEmail, Message: "must not contain the email"}
+		}
+	}
+
+	if utf8.RuneCountInString(username) >= minIdentityLength && strings.Contains(lower, strings.ToLower(username)) {
+		return &RuleError{Rule: RuleUsername, Message: "must not contain the username"}
+	}
+
+	if p.Blocked(password) {
+		return &RuleError{Rule: RuleBlocklist, Message: "is too common, choose another one"}
+	}
+
+	return nil
+}
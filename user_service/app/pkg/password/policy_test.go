@@ -0,0 +1,165 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	t.Parallel()
+
+	hash := sha1.Sum([]byte("Tr0ub4dor&3"))
+	blocklist := "# common passwords\n\niloveyou99\n" + hex.EncodeToString(hash[:]) + ":42\n"
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(blocklist), 0600))
+
+	policy, err := NewPolicy(8, 16, path)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		password     string
+		expectedRule string
+	}{
+		{
+			name:     "valid password",
+			password: "correct horse",
+		},
+		{
+			name:     "symbols and unicode",
+			password: "пароль-№1 ☕",
+		},
+		{
+			name:         "too short",
+			password:     "short",
+			expectedRule: RuleMinLength,
+		},
+		{
+			name:         "unicode counted in characters",
+			password:     "пароль",
+			expectedRule: RuleMinLength,
+		},
+		{
+			name:         "too long",
+			password:     "correct horse battery staple",
+			expectedRule: RuleMaxLength,
+		},
+		{
+			name:         "invalid utf-8",
+			password:     "password\xff\xfe",
+			expectedRule: RuleEncoding,
+		},
+		{
+			name:         "blocked password",
+			password:     "iloveyou99",
+			expectedRule: RuleBlocklist,
+		},
+		{
+			name:         "blocked ignoring case",
+			password:     "ILoveYou99",
+			expectedRule: RuleBlocklist,
+		},
+		{
+			name:     "similar to blocked",
+			password: "iloveyou98",
+		},
+		{
+			name:         "blocked hash",
+			password:     "Tr0ub4dor&3",
+			expectedRule: RuleBlocklist,
+		},
+		{
+			name:         "contains email",
+			password:     "my-JohnDoe-pass",
+			expectedRule: RuleEmail,
+		},
+		{
+			name:         "contains username",
+			password:     "jdoe-secret",
+			expectedRule: RuleUsername,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Check(tc.password, "johndoe@mail.com", "jdoe")
+			if tc.expectedRule == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var ruleErr *RuleError
+			if assert.True(t, errors.As(err, &ruleErr), err) {
+				assert.Equal(t, tc.expectedRule, ruleErr.Rule)
+				assert.Contains(t, ruleErr.Error(), tc.expectedRule)
+			}
+		})
+	}
+}
+
+func TestPolicy_CheckShortIdentity(t *testing.T) {
+	t.Parallel()
+
+	policy, err := NewPolicy(8, 32, "")
+	assert.NoError(t, err)
+
+	// Short local part and username are parts of too many passwords.
+	assert.NoError(t, policy.Check("totally-normal", "al@mail.com", "al"))
+	assert.NoError(t, policy.Check("bob-the-builder", "bob@mail.com", "bob"))
+
+	// The whole email is still rejected.
+	var ruleErr *RuleError
+	if assert.True(t, errors.As(policy.Check("al@mail.com-123", "al@mail.com", "al"), &ruleErr)) {
+		assert.Equal(t, RuleEmail, ruleErr.Rule)
+	}
+}
+
+func TestPolicy_Blocked(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("Password1\n"), 0600))
+
+	policy, err := NewPolicy(6, 64, path)
+	assert.NoError(t, err)
+
+	assert.True(t, policy.Blocked("password1"))
+	assert.True(t, policy.Blocked("PASSWORD1"))
+	assert.False(t, policy.Blocked("password2"))
+
+	err = policy.Check("Password1", "", "")
+	var ruleErr *RuleError
+	if assert.True(t, errors.As(err, &ruleErr)) {
+		assert.Equal(t, RuleBlocklist, ruleErr.Rule)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := NewPolicy(6, 64, "")
+	assert.NoError(t, err)
+	assert.NoError(t, policy.Check("qwerty", "", ""))
+
+	_, err = NewPolicy(0, 64, "")
+	assert.Error(t, err)
+
+	_, err = NewPolicy(10, 8, "")
+	assert.Error(t, err)
+
+	_, err = NewPolicy(6, 64, filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+
+	// Shipped blocklist must be readable.
+	policy, err = NewPolicy(6, 64, "../../config/password_blocklist.txt")
+	assert.NoError(t, err)
+	assert.True(t, policy.Blocked("qwerty123"))
+}
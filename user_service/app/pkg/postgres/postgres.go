@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// NewPostgresClient tries to connect to database with provided url.
+// If everything is OK, returns a new connection pool.
+// Returns an error if something went wrong.
+func NewPostgresClient(postgresURL string, maxConnections int) (*pgx.ConnPool, error) {
+	connConfig, err := pgx.ParseConnectionString(postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres url: %w", err)
+	}
+
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
+		ConnConfig:     connConfig,
+		MaxConnections: maxConnections,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to postgres: %w", err)
+	}
+
+	return pool, nil
+}
+
+// MissingTables returns those of given tables which don't exist
+// in the current schema. Nothing is changed.
+func MissingTables(ctx context.Context, pool *pgx.ConnPool, tables ...string) ([]string, error) {
+	query := `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = ANY($1)`
+
+	rows, err := pool.QueryEx(ctx, query, nil, tables)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tables: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool, len(tables))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, table := range tables {
+		if !existing[table] {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
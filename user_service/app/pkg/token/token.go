@@ -0,0 +1,252 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Type describes a purpose of the token.
+type Type string
+
+const (
+	// Access is a short-lived token used to access protected resources.
+	Access Type = "access"
+	// Refresh is a long-lived token used to obtain a new token pair.
+	Refresh Type = "refresh"
+	// Verification is used to confirm user email.
+	Verification Type = "verification"
+	// PasswordReset is used to set a new password without knowing the current one.
+	PasswordReset Type = "password_reset"
+	// TwoFactor is issued after password check when second factor is required.
+	// It's exchanged with one-time code for a token pair.
+	TwoFactor Type = "two_factor"
+	// EmailChange is sent to a new email to confirm the user owns it.
+	EmailChange Type = "email_change"
+	// ContentExport is issued to request content of the user from post
+	// service on personal data export. Only the content route accepts it.
+	ContentExport Type = "content_export"
+	// APIKey marks claims of requests authenticated with API key.
+	// API keys are not signed tokens, so they're never issued by Manager.
+	APIKey Type = "api_key"
+)
+
+var (
+	// ErrInvalidToken is used when token is malformed, has invalid signature
+	// or has unexpected type.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrExpiredToken is used when token lifetime is over.
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims describes a payload of the token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Type   Type   `json:"typ"`
+	Email  string `json:"email,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Family string `json:"fam,omitempty"`
+	Stamp  string `json:"stamp,omitempty"`
+	// Scopes are set only for claims of API keys.
+	Scopes []string `json:"scp,omitempty"`
+}
+
+// Identity describes the user tokens are issued for.
+type Identity struct {
+	UUID  string
+	Email string
+	Role  string
+}
+
+// Pair represents access and refresh tokens issued on login.
+type Pair struct {
+	AccessToken  string `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refreshToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	ExpiresIn    int64  `json:"expiresIn" example:"900"`
+} // @name TokenPair
+
+// Manager issues and parses signed tokens.
+type Manager struct {
+	issuer        string
+	accessSecret  []byte
+	refreshSecret []byte
+	ttl           map[Type]time.Duration
+}
+
+// NewManager returns a new Manager instance.
+// Tokens of each type expire after a duration specified in ttl.
+func NewManager(issuer, accessSecret, refreshSecret string, ttl map[Type]time.Duration) *Manager {
+	return &Manager{
+		issuer:        issuer,
+		accessSecret:  []byte(accessSecret),
+		refreshSecret: []byte(refreshSecret),
+		ttl:           ttl,
+	}
+}
+
+// NewPair issues a new access and refresh token pair for given user.
+// Refresh token gets given id and belongs to given token family,
+// so it can be tracked on the server side. Access token carries the
+// family too, so the caller's session is known. Returns an error on failure.
+func (m *Manager) NewPair(identity Identity, refreshID, family string) (*Pair, error) {
+	now := time.Now().UTC()
+
+	access, err := m.sign(m.accessSecret, &Claims{
+		RegisteredClaims: m.registeredClaims(identity.UUID, now, m.ttl[Access]),
+		Type:             Access,
+		Email:            identity.Email,
+		Role:             identity.Role,
+		Family:           family,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign access token: %w", err)
+	}
+
+	refreshClaims := m.registeredClaims(identity.UUID, now, m.ttl[Refresh])
+	refreshClaims.ID = refreshID
+
+	refresh, err := m.sign(m.refreshSecret, &Claims{
+		RegisteredClaims: refreshClaims,
+		Type:             Refresh,
+		Family:           family,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign refresh token: %w", err)
+	}
+
+	return &Pair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(m.ttl[Access] / time.Second),
+	}, nil
+}
+
+// NewAccessToken issues an access token for given user without refresh
+// token. It's used to call other services on behalf of the user.
+func (m *Manager) NewAccessToken(identity Identity) (string, error) {
+	signed, err := m.sign(m.accessSecret, &Claims{
+		RegisteredClaims: m.registeredClaims(identity.UUID, time.Now().UTC(), m.ttl[Access]),
+		Type:             Access,
+		Email:            identity.Email,
+		Role:             identity.Role,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot sign access token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// NewToken issues a single purpose token of given type, e.g. email
+// verification token, for the user with given uuid and email.
+// Stamp is an optional value the caller compares on parsing
+// to invalidate the token once user state changes.
+// Returns an error on failure.
+func (m *Manager) NewToken(typ Type, subject, email, stamp string) (string, error) {
+	signed, err := m.sign(m.accessSecret, &Claims{
+		RegisteredClaims: m.registeredClaims(subject, time.Now().UTC(), m.ttl[typ]),
+		Type:             typ,
+		Email:            email,
+		Stamp:            stamp,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot sign %s token: %w", typ, err)
+	}
+
+	return signed, nil
+}
+
+// Issuer returns the issuer of tokens.
+func (m *Manager) Issuer() string {
+	return m.issuer
+}
+
+// TTL returns a lifetime of tokens of given type.
+func (m *Manager) TTL(typ Type) time.Duration {
+	return m.ttl[typ]
+}
+
+// ParseAccess verifies given access token and returns its claims.
+func (m *Manager) ParseAccess(tokenString string) (*Claims, error) {
+	return Verify(tokenString, string(m.accessSecret), Access)
+}
+
+// ParseRefresh verifies given refresh token and returns its claims.
+func (m *Manager) ParseRefresh(tokenString string) (*Claims, error) {
+	return Verify(tokenString, string(m.refreshSecret), Refresh)
+}
+
+// Parse verifies given single purpose token of given type and returns its claims.
+func (m *Manager) Parse(tokenString string, typ Type) (*Claims, error) {
+	return Verify(tokenString, string(m.accessSecret), typ)
+}
+
+// Verify checks signature, lifetime and type of the token signed
+// with given secret. It's supposed to be used by other services to
+// authenticate requests without calling user service.
+// Returns ErrExpiredToken if token lifetime is over and
+// ErrInvalidToken if token cannot be trusted.
+func Verify(tokenString, secret string, typ Type) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != typ || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// registeredClaims returns standard claims for the token issued at given time.
+func (m *Manager) registeredClaims(subject string, now time.Time, ttl time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    m.issuer,
+		Subject:   subject,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// sign signs given claims with HS256 algorithm.
+func (m *Manager) sign(secret []byte, claims *Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// NewID returns a new random token identifier.
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate token id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash returns hex encoded SHA-256 hash of a random secret, like an API key,
+// a recovery code or an authorization code, to store it instead of the secret.
+// Such secrets have enough entropy to resist brute force, so a plain hash
+// is enough to protect them and, unlike password hashes, allows to find
+// the secret by its hash.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package token_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juicyluv/sueta/user_service/app/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	accessSecret  = "access-secret"
+	refreshSecret = "refresh-secret"
+)
+
+var identity = token.Identity{
+	UUID:  "6205151b67f8792099abb78e",
+	Email: "test@mail.com",
+	Role:  "admin",
+}
+
+func newManager(ttl time.Duration) *token.Manager {
+	return token.NewManager("sueta", accessSecret, refreshSecret, map[token.Type]time.Duration{
+		token.Access:       ttl,
+		token.Refresh:      ttl,
+		token.Verification: ttl,
+	})
+}
+
+func TestManager_NewPair(t *testing.T) {
+	t.Parallel()
+
+	m := newManager(time.Minute)
+
+	pair, err := m.NewPair(identity, "refresh-id", "family-id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), pair.ExpiresIn)
+
+	access, err := m.ParseAccess(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "6205151b67f8792099abb78e", access.Subject)
+	assert.Equal(t, "test@mail.com", access.Email)
+	assert.Equal(t, "admin", access.Role)
+	assert.Equal(t, "sueta", access.Issuer)
+	assert.Equal(t, "family-id", access.Family)
+
+	refresh, err := m.ParseRefresh(pair.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "6205151b67f8792099abb78e", refresh.Subject)
+	assert.Equal(t, "refresh-id", refresh.ID)
+	assert.Equal(t, "family-id", refresh.Family)
+}
+
+func TestManager_NewAccessToken(t *testing.T) {
+	t.Parallel()
+
+	m := newManager(time.Minute)
+
+	accessToken, err := m.NewAccessToken(identity)
+	assert.NoError(t, err)
+
+	claims, err := token.Verify(accessToken, accessSecret, token.Access)
+	assert.NoError(t, err)
+	assert.Equal(t, identity.UUID, claims.Subject)
+	assert.Equal(t, identity.Role, claims.Role)
+	assert.Empty(t, claims.Family)
+
+	_, err = m.ParseRefresh(accessToken)
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	m := newManager(time.Minute)
+	expired := newManager(-time.Minute)
+
+	pair, err := m.NewPair(identity, "refresh-id", "family-id")
+	assert.NoError(t, err)
+
+	expiredPair, err := expired.NewPair(identity, "refresh-id", "family-id")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		token         string
+		secret        string
+		typ           token.Type
+		expectedError error
+	}{
+		{
+			name:          "valid access token",
+			token:         pair.AccessToken,
+			secret:        accessSecret,
+			typ:           token.Access,
+			expectedError: nil,
+		},
+		{
+			name:          "wrong secret",
+			token:         pair.AccessToken,
+			secret:        "another-secret",
+			typ:           token.Access,
+			expectedError: token.ErrInvalidToken,
+		},
+		{
+			name:          "refresh token used as access token",
+			token:         pair.RefreshToken,
+			secret:        refreshSecret,
+			typ:           token.Access,
+			expectedError: token.ErrInvalidToken,
+		},
+		{
+			name:          "expired token",
+			token:         expiredPair.AccessToken,
+			secret:        accessSecret,
+			typ:           token.Access,
+			expectedError: token.ErrExpiredToken,
+		},
+		{
+			name:          "malformed token",
+			token:         "not.a.token",
+			secret:        accessSecret,
+			typ:           token.Access,
+			expectedError: token.ErrInvalidToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := token.Verify(tc.token, tc.secret, tc.typ)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestManager_NewToken(t *testing.T) {
+	t.Parallel()
+
+	m := newManager(time.Minute)
+
+	verification, err := m.NewToken(token.Verification, "6205151b67f8792099abb78e", "test@mail.com", "stamp")
+	assert.NoError(t, err)
+
+	claims, err := m.Parse(verification, token.Verification)
+	assert.NoError(t, err)
+	assert.Equal(t, "6205151b67f8792099abb78e", claims.Subject)
+	assert.Equal(t, "test@mail.com", claims.Email)
+	assert.Equal(t, "stamp", claims.Stamp)
+
+	_, err = m.ParseAccess(verification)
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	// SHA-256 of "secret".
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", token.Hash("secret"))
+	assert.NotEqual(t, token.Hash("secret"), token.Hash("Secret"))
+}
@@ -0,0 +1,80 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	// Test vectors of RFC 6238 for SHA1, truncated to 6 digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tc := range testCases {
+		code, err := Code(secret, Step(time.Unix(tc.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, code)
+	}
+
+	_, err := Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	secret, err := NewSecret()
+	assert.NoError(t, err)
+
+	now := time.Date(2022, 2, 10, 12, 0, 0, 0, time.UTC)
+	code, err := Code(secret, Step(now))
+	assert.NoError(t, err)
+
+	step, ok := Match(secret, code, now, 1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// Clock drift within skew is allowed.
+	_, ok = Match(secret, code, now.Add(Period), 1, 0)
+	assert.True(t, ok)
+
+	_, ok = Match(secret, code, now.Add(2*Period), 1, 0)
+	assert.False(t, ok)
+
+	// Used step can't be matched again.
+	_, ok = Match(secret, code, now, 1, step)
+	assert.False(t, ok)
+
+	_, ok = Match(secret, "12345", now, 1, 0)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	t.Parallel()
+
+	uri, err := url.Parse(URI("JBSWY3DPEHPK3PXP", "sueta", "test@mail.com"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/sueta:test@mail.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "sueta", uri.Query().Get("issuer"))
+}